@@ -0,0 +1,356 @@
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"baseful/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Outcomes recorded for an entry
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+const (
+	contextKey = "audit_entry"
+	flushedKey = "audit_flushed"
+)
+
+// MaxPageSize is the largest number of entries returned by one List call
+const MaxPageSize = 1000
+
+// Entry is a single audit log record
+type Entry struct {
+	ID         int64          `json:"id"`
+	CreatedAt  string         `json:"createdAt"`
+	ActorID    int            `json:"actorId"`
+	ActorEmail string         `json:"actorEmail"`
+	Action     string         `json:"action"`
+	TargetType string         `json:"targetType"`
+	TargetID   string         `json:"targetId"`
+	IP         string         `json:"ip"`
+	Outcome    string         `json:"outcome"`
+	Status     int            `json:"status"`
+	Details    map[string]any `json:"details,omitempty"`
+}
+
+// Filter narrows down audit log queries. Zero values match everything.
+type Filter struct {
+	ActorID    int
+	Actor      string // substring of the actor email
+	Action     string // action prefix, e.g. "database." or "token.rotate"
+	TargetType string
+	TargetID   string
+	Outcome    string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+	Offset     int
+}
+
+// Write appends an entry to the audit log
+func Write(e Entry) error {
+	var details any
+	if len(e.Details) > 0 {
+		encoded, err := json.Marshal(e.Details)
+		if err != nil {
+			return err
+		}
+		details = string(encoded)
+	}
+	_, err := db.DB.Exec(`
+		INSERT INTO audit_log (actor_id, actor_email, action, target_type, target_id, ip, outcome, status, details)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.ActorID, e.ActorEmail, e.Action, e.TargetType, e.TargetID, e.IP, e.Outcome, e.Status, details)
+	return err
+}
+
+// FromContext returns an entry pre-filled with the actor and client IP of a request.
+// Handlers use it for events that finish after the response, such as restores.
+func FromContext(c *gin.Context) Entry {
+	return Entry{
+		ActorID:    c.GetInt("user_id"),
+		ActorEmail: c.GetString("email"),
+		IP:         c.ClientIP(),
+	}
+}
+
+// Annotate names the action a handler performs. The middleware writes the entry
+// once the handler returns, with the outcome taken from the response status.
+// The returned copy can be passed to Complete by work that outlives the request.
+func Annotate(c *gin.Context, action, targetType, targetID string, details map[string]any) Entry {
+	e := FromContext(c)
+	e.Action = action
+	e.TargetType = targetType
+	e.TargetID = targetID
+	e.Details = details
+	c.Set(contextKey, &e)
+
+	annotated := e
+	annotated.Details = map[string]any{}
+	for k, v := range details {
+		annotated.Details[k] = v
+	}
+	return annotated
+}
+
+// Complete records the end of an action that continued in the background after
+// its request returned, as "<action>.completed"
+func Complete(e Entry, err error) {
+	e.Action += ".completed"
+	e.Outcome = OutcomeSuccess
+	if err != nil {
+		e.Outcome = OutcomeFailure
+		if e.Details == nil {
+			e.Details = map[string]any{}
+		}
+		e.Details["error"] = err.Error()
+	}
+	if werr := Write(e); werr != nil {
+		log.Printf("Failed to write audit log entry for %s: %v", e.Action, werr)
+	}
+}
+
+// AddDetail attaches a detail to the action annotated for this request
+func AddDetail(c *gin.Context, key string, value any) {
+	annotated, ok := c.Get(contextKey)
+	if !ok {
+		return
+	}
+	e := annotated.(*Entry)
+	if e.Details == nil {
+		e.Details = map[string]any{}
+	}
+	e.Details[key] = value
+}
+
+// Middleware records every annotated request and every state-changing API
+// request. It must run after AuthMiddleware and before access checks so denied
+// requests are recorded too.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if c.GetBool(flushedKey) {
+			return
+		}
+		route := c.FullPath()
+		var e Entry
+		if value, ok := c.Get(contextKey); ok {
+			e = *value.(*Entry)
+		} else {
+			switch c.Request.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return
+			}
+			if !strings.HasPrefix(route, "/api") {
+				return
+			}
+			e = FromContext(c)
+			e.Action = "api." + strings.ToLower(c.Request.Method)
+			e.TargetType, e.TargetID = targetFromRoute(c, route)
+		}
+		write(c, e, c.Writer.Status())
+	}
+}
+
+// Flush writes the action annotated for this request now instead of when the
+// handler returns, for requests that stay open such as terminal sessions.
+// The middleware does not record the request again.
+func Flush(c *gin.Context, status int) {
+	value, ok := c.Get(contextKey)
+	if !ok || c.GetBool(flushedKey) {
+		return
+	}
+	c.Set(flushedKey, true)
+	write(c, *value.(*Entry), status)
+}
+
+func write(c *gin.Context, e Entry, status int) {
+	if e.Details == nil {
+		e.Details = map[string]any{}
+	}
+	e.Details["method"] = c.Request.Method
+	e.Details["route"] = c.FullPath()
+	if tokenID := c.GetInt("api_token_id"); tokenID > 0 {
+		e.Details["apiTokenId"] = tokenID
+	}
+
+	e.Status = status
+	switch {
+	case e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden:
+		e.Outcome = OutcomeDenied
+	case e.Status >= 400:
+		e.Outcome = OutcomeFailure
+	default:
+		e.Outcome = OutcomeSuccess
+	}
+
+	if err := Write(e); err != nil {
+		log.Printf("Failed to write audit log entry for %s: %v", e.Action, err)
+	}
+}
+
+func targetFromRoute(c *gin.Context, route string) (string, string) {
+	switch {
+	case strings.HasPrefix(route, "/api/databases/:id"):
+		return "database", c.Param("id")
+	case strings.HasPrefix(route, "/api/projects/:id"):
+		return "project", c.Param("id")
+	case strings.HasPrefix(route, "/api/docker/containers/:id"):
+		return "container", c.Param("id")
+	}
+	return "route", route
+}
+
+// List returns the entries matching a filter, newest first, and the total number of matches
+func List(f Filter) ([]Entry, int, error) {
+	var conds []string
+	var args []any
+	if f.ActorID > 0 {
+		conds = append(conds, "actor_id = ?")
+		args = append(args, f.ActorID)
+	}
+	if f.Actor != "" {
+		conds = append(conds, "actor_email LIKE ? ESCAPE '\\'")
+		args = append(args, "%"+escapeLike(f.Actor)+"%")
+	}
+	if f.Action != "" {
+		conds = append(conds, "action LIKE ? ESCAPE '\\'")
+		args = append(args, escapeLike(f.Action)+"%")
+	}
+	if f.TargetType != "" {
+		conds = append(conds, "target_type = ?")
+		args = append(args, f.TargetType)
+	}
+	if f.TargetID != "" {
+		conds = append(conds, "target_id = ?")
+		args = append(args, f.TargetID)
+	}
+	if f.Outcome != "" {
+		conds = append(conds, "outcome = ?")
+		args = append(args, f.Outcome)
+	}
+	if !f.Since.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, f.Since.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if !f.Until.IsZero() {
+		conds = append(conds, "created_at < ?")
+		args = append(args, f.Until.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	var total int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM audit_log"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+	query := fmt.Sprintf(`
+		SELECT id, created_at, COALESCE(actor_id, 0), COALESCE(actor_email, ''), action,
+			COALESCE(target_type, ''), COALESCE(target_id, ''), COALESCE(ip, ''), outcome, COALESCE(status, 0), details
+		FROM audit_log%s
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, where)
+	rows, err := db.DB.Query(query, append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var details sql.NullString
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.ActorID, &e.ActorEmail, &e.Action,
+			&e.TargetType, &e.TargetID, &e.IP, &e.Outcome, &e.Status, &details); err != nil {
+			return nil, 0, err
+		}
+		if details.Valid && details.String != "" {
+			_ = json.Unmarshal([]byte(details.String), &e.Details)
+		}
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// maxExcerpt bounds the size of statements and commands stored in details
+const maxExcerpt = 2000
+
+// Excerpt shortens free-form input such as a SQL query before it is recorded
+func Excerpt(s string) string {
+	if len(s) <= maxExcerpt {
+		return s
+	}
+	// Cut at the start of a character so multi-byte ones are not split
+	end := maxExcerpt
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end] + "…"
+}
+
+// FilterFromQuery reads a filter from the query string: actor (user ID or
+// email), action, targetType, targetId, outcome, since and until (RFC 3339),
+// limit and offset
+func FilterFromQuery(c *gin.Context) (Filter, error) {
+	f := Filter{
+		Action:     c.Query("action"),
+		TargetType: c.Query("targetType"),
+		TargetID:   c.Query("targetId"),
+		Outcome:    c.Query("outcome"),
+	}
+	if actor := c.Query("actor"); actor != "" {
+		if id, err := strconv.Atoi(actor); err == nil {
+			f.ActorID = id
+		} else {
+			f.Actor = actor
+		}
+	}
+	for name, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if value := c.Query(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return f, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+			}
+			*dst = t
+		}
+	}
+	for name, dst := range map[string]*int{"limit": &f.Limit, "offset": &f.Offset} {
+		if value := c.Query(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return f, fmt.Errorf("%s must be a non-negative integer", name)
+			}
+			*dst = n
+		}
+	}
+	if f.Limit > MaxPageSize {
+		f.Limit = MaxPageSize
+	}
+	return f, nil
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"baseful/db"
+)
+
+// API token scopes. A token can only call routes covered by one of its
+// scopes, and never more than its user or service account may do.
+const (
+	ScopeProjectsRead   = "projects:read"
+	ScopeProjectsWrite  = "projects:write"
+	ScopeDatabasesRead  = "databases:read"
+	ScopeDatabasesWrite = "databases:write"
+	ScopeDatabasesQuery = "databases:query"
+	ScopeBackupsRead    = "backups:read"
+	ScopeBackupsWrite   = "backups:write"
+	ScopeTokensRead     = "tokens:read"
+	ScopeTokensRotate   = "tokens:rotate"
+	ScopeAdmin          = "admin"
+)
+
+// Scopes lists every scope with a short description
+var Scopes = []struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}{
+	{ScopeProjectsRead, "List projects, members and settings"},
+	{ScopeProjectsWrite, "Create and update projects, manage members and invitations"},
+	{ScopeDatabasesRead, "List databases, tables, metrics and branches"},
+	{ScopeDatabasesWrite, "Create, start, stop and delete databases and branches, apply schema changes"},
+	{ScopeDatabasesQuery, "Run SQL and edit table rows"},
+	{ScopeBackupsRead, "List backups and backup settings"},
+	{ScopeBackupsWrite, "Create and restore backups, change backup settings"},
+	{ScopeTokensRead, "Read connection strings and proxy tokens"},
+	{ScopeTokensRotate, "Rotate and revoke proxy tokens"},
+	{ScopeAdmin, "Instance administration (admins only)"},
+}
+
+// Token prefixes make leaked tokens easy to recognise and tell the middleware
+// that the bearer token is not a session JWT
+const (
+	PersonalAccessTokenPrefix = "bfp_"
+	ServiceAccountTokenPrefix = "bfs_"
+)
+
+// MaxAPITokenLifetime caps the expiry of new tokens
+const MaxAPITokenLifetime = 366 * 24 * time.Hour
+
+// scopeRoutePolicies lists the routes whose scope differs from the one derived
+// from their path in RequiredScope. An empty scope means any token may call it.
+var scopeRoutePolicies = map[string]string{
+	"GET /api/databases/:id/connection-string":                     ScopeTokensRead,
+	"GET /api/databases/:id/tokens":                                ScopeTokensRead,
+	"POST /api/databases/:id/tokens/rotate":                        ScopeTokensRotate,
+	"DELETE /api/databases/:id/tokens/:token_id":                   ScopeTokensRotate,
+	"POST /api/databases/:id/replicas/connection-string":           ScopeTokensRotate,
+	"POST /api/databases/:id/roles/:role/connection-string":        ScopeTokensRotate,
+	"PUT /api/databases/:id/tokens/:token_id/role":                 ScopeTokensRotate,
+	"POST /api/databases/:id/query":                                ScopeDatabasesQuery,
+	"POST /api/databases/:id/sql-assistant":                        ScopeDatabasesQuery,
+	"POST /api/databases/:id/tables/:tableName/query":              ScopeDatabasesQuery,
+	"POST /api/databases/:id/tables/:tableName/rows":               ScopeDatabasesQuery,
+	"PUT /api/databases/:id/tables/:tableName/rows":                ScopeDatabasesQuery,
+	"DELETE /api/databases/:id/tables/:tableName/rows":             ScopeDatabasesQuery,
+	"POST /api/databases/:id/schema/preview":                       ScopeDatabasesRead,
+	"POST /api/databases/:id/restore/file":                         ScopeBackupsWrite,
+	"POST /api/databases/:id/restore/connection":                   ScopeBackupsWrite,
+	"POST /api/databases/:id/backups/:backupId/download-decrypted": ScopeBackupsRead,
+	"GET /api/auth/me":                                             "",
+}
+
+// RequiredScope returns the scope an API token needs for a route, and false
+// when API tokens may not call it at all. Account, session and token
+// management stay limited to interactive sessions.
+func RequiredScope(method, route string) (string, bool) {
+	if scope, ok := scopeRoutePolicies[method+" "+route]; ok {
+		return scope, true
+	}
+	read := method == http.MethodGet
+
+	switch {
+	case strings.HasPrefix(route, "/api/databases/:id/backups"):
+		if read {
+			return ScopeBackupsRead, true
+		}
+		return ScopeBackupsWrite, true
+	case strings.HasPrefix(route, "/api/databases"):
+		if read {
+			return ScopeDatabasesRead, true
+		}
+		return ScopeDatabasesWrite, true
+	case strings.HasPrefix(route, "/api/projects/:id/service-accounts"):
+		return "", false
+	case route == "/api/projects/:id/databases":
+		return ScopeDatabasesRead, true
+	case strings.HasPrefix(route, "/api/projects"):
+		if read {
+			return ScopeProjectsRead, true
+		}
+		return ScopeProjectsWrite, true
+	case strings.HasPrefix(route, "/api/settings"), strings.HasPrefix(route, "/api/system"),
+		strings.HasPrefix(route, "/api/docker"), strings.HasPrefix(route, "/api/servers"),
+		strings.HasPrefix(route, "/api/audit"),
+		strings.HasPrefix(route, "/api/auth/whitelist"), strings.HasPrefix(route, "/api/auth/users"):
+		return ScopeAdmin, true
+	}
+	return "", false
+}
+
+// ValidateScopes checks requested scopes and removes duplicates
+func ValidateScopes(scopes []string, allowAdmin bool) ([]string, error) {
+	known := map[string]bool{}
+	for _, s := range Scopes {
+		known[s.Name] = true
+	}
+	seen := map[string]bool{}
+	result := []string{}
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if !known[scope] {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if scope == ScopeAdmin && !allowAdmin {
+			return nil, errors.New("the admin scope requires an instance admin")
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			result = append(result, scope)
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	return result, nil
+}
+
+// IsAPIToken reports whether a bearer token is an API token rather than a session JWT
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix) || strings.HasPrefix(token, ServiceAccountTokenPrefix)
+}
+
+// CreateAPIToken generates and stores a token. serviceAccountID is 0 for
+// personal access tokens. The secret is only returned here.
+func CreateAPIToken(userID, serviceAccountID, createdBy int, name string, scopes []string, expiresIn time.Duration) (string, *db.APIToken, error) {
+	prefix := PersonalAccessTokenPrefix
+	if serviceAccountID > 0 {
+		prefix = ServiceAccountTokenPrefix
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	secret := prefix + hex.EncodeToString(b)
+
+	var expiresAt *time.Time
+	if expiresIn > 0 {
+		t := time.Now().Add(expiresIn)
+		expiresAt = &t
+	}
+	if _, err := db.CreateAPIToken(userID, serviceAccountID, createdBy, name, secret[:len(prefix)+8], db.HashToken(secret), scopes, expiresAt); err != nil {
+		return "", nil, err
+	}
+	token, err := db.GetAPITokenByHash(db.HashToken(secret))
+	return secret, token, err
+}
+
+// ValidateAPIToken resolves an API token to its active record and user, and
+// records the use
+func ValidateAPIToken(secret, ip string) (*db.APIToken, *db.User, error) {
+	token, err := db.GetAPITokenByHash(db.HashToken(secret))
+	if err != nil {
+		return nil, nil, err
+	}
+	if token == nil || token.Revoked {
+		return nil, nil, errors.New("invalid API token")
+	}
+	if token.Expired {
+		return nil, nil, errors.New("API token has expired")
+	}
+	user, err := db.GetUserByID(token.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, errors.New("invalid API token")
+	}
+	if err := db.TouchAPIToken(token.ID, ip); err != nil {
+		log.Printf("Failed to update API token %d: %v", token.ID, err)
+	}
+	return token, user, nil
+}
+
+// HasScope reports whether a token was granted a scope
+func HasScope(token *db.APIToken, scope string) bool {
+	for _, s := range token.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"baseful/db"
+	"baseful/mail"
+)
+
+const (
+	// PasswordResetTTL is how long a password reset link works
+	PasswordResetTTL = time.Hour
+	// EmailVerificationTTL is how long an email verification link works
+	EmailVerificationTTL = 48 * time.Hour
+	// InvitationTTL is how long an invitation link works
+	InvitationTTL = 7 * 24 * time.Hour
+)
+
+// ErrInvalidEmailToken is returned for unknown, expired or used email links
+var ErrInvalidEmailToken = errors.New("this link is invalid or has expired")
+
+// issueEmailToken stores a new single-use token and returns it
+func issueEmailToken(userID int, email, purpose string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	if err := db.CreateEmailToken(userID, email, purpose, db.HashToken(token), time.Now().Add(ttl)); err != nil {
+		return "", fmt.Errorf("failed to store token: %w", err)
+	}
+	if _, err := db.CleanupEmailTokens(); err != nil {
+		log.Printf("Failed to clean up email tokens: %v", err)
+	}
+	return token, nil
+}
+
+// redeemEmailToken uses up a token and returns its record
+func redeemEmailToken(purpose, token string) (*db.EmailToken, error) {
+	t, err := db.UseEmailToken(purpose, db.HashToken(strings.TrimSpace(token)))
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrInvalidEmailToken
+	}
+	return t, nil
+}
+
+// PasswordResetLink issues a password reset token for a user and returns the
+// link to the reset page
+func PasswordResetLink(user *db.User, settings db.SMTPSettings) (string, error) {
+	token, err := issueEmailToken(user.ID, user.Email, db.EmailTokenPasswordReset, PasswordResetTTL)
+	if err != nil {
+		return "", err
+	}
+	return mail.Link(settings, "/reset-password", url.Values{"token": {token}}), nil
+}
+
+// SendPasswordReset emails a password reset link and returns the user it was
+// sent to. Addresses that cannot reset a password return no user and no
+// error, so callers can answer the same way whether or not an account exists.
+func SendPasswordReset(email string) (*db.User, error) {
+	settings := db.GetSMTPSettings()
+	if !settings.Configured() {
+		return nil, mail.ErrNotConfigured
+	}
+	user, err := db.GetUserByEmail(strings.TrimSpace(email))
+	if err != nil {
+		return nil, err
+	}
+	// Accounts without a password sign in through SSO or are service accounts
+	if user == nil || user.PasswordHash == "" {
+		return nil, nil
+	}
+	link, err := PasswordResetLink(user, settings)
+	if err != nil {
+		return user, err
+	}
+	return user, mail.Send(settings, mail.PasswordReset(user.Email, link, PasswordResetTTL))
+}
+
+// ResetPassword sets a new password with a password reset token. Every session
+// of the user is revoked and a login lockout is lifted. The email counts as
+// verified since the token was delivered to it.
+func ResetPassword(token, password string) (*db.User, error) {
+	t, err := redeemEmailToken(db.EmailTokenPasswordReset, token)
+	if err != nil {
+		return nil, err
+	}
+	user, err := db.GetUserByID(t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !strings.EqualFold(user.Email, t.Email) {
+		return nil, ErrInvalidEmailToken
+	}
+	if err := db.UpdateUserPassword(user.ID, password); err != nil {
+		return nil, err
+	}
+	if err := db.SetUserEmailVerified(user.ID, true); err != nil {
+		return nil, err
+	}
+	if _, err := db.RevokeUserSessions(user.ID, ""); err != nil {
+		return nil, err
+	}
+	UnlockLoginAccount(user.Email)
+	return user, nil
+}
+
+// SendEmailVerification emails a link that confirms a user's address
+func SendEmailVerification(user *db.User) error {
+	settings := db.GetSMTPSettings()
+	if !settings.Configured() {
+		return mail.ErrNotConfigured
+	}
+	token, err := issueEmailToken(user.ID, user.Email, db.EmailTokenVerification, EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	link := mail.Link(settings, "/verify-email", url.Values{"token": {token}})
+	return mail.Send(settings, mail.Verification(user.Email, link, EmailVerificationTTL))
+}
+
+// VerifyEmail confirms a user's address with a verification token
+func VerifyEmail(token string) (*db.User, error) {
+	t, err := redeemEmailToken(db.EmailTokenVerification, token)
+	if err != nil {
+		return nil, err
+	}
+	user, err := db.GetUserByID(t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !strings.EqualFold(user.Email, t.Email) {
+		return nil, ErrInvalidEmailToken
+	}
+	if err := db.SetUserEmailVerified(user.ID, true); err != nil {
+		return nil, err
+	}
+	user.EmailVerified = true
+	return user, nil
+}
+
+// SendInvitation emails a registration link to a whitelisted address. project
+// is empty for invitations to the instance.
+func SendInvitation(email, inviter, project string) error {
+	settings := db.GetSMTPSettings()
+	if !settings.Configured() {
+		return mail.ErrNotConfigured
+	}
+	email = strings.TrimSpace(email)
+	token, err := issueEmailToken(0, email, db.EmailTokenInvitation, InvitationTTL)
+	if err != nil {
+		return err
+	}
+	link := mail.Link(settings, "/register", url.Values{"invite": {token}, "email": {email}})
+	return mail.Send(settings, mail.Invitation(email, link, inviter, project, InvitationTTL))
+}
+
+// RedeemInvitation uses up an invitation token sent to email. Registering
+// through the link proves the address, so no verification email is needed.
+func RedeemInvitation(token, email string) bool {
+	t, err := redeemEmailToken(db.EmailTokenInvitation, token)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(t.Email, strings.TrimSpace(email))
+}
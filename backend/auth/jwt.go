@@ -17,8 +17,10 @@ type JWTClaims struct {
 	Email      string `json:"email,omitempty"`
 	IsAdmin    bool   `json:"is_admin,omitempty"`
 	TokenID    string `json:"token_id,omitempty"`
-	Purpose    string `json:"purpose"` // "db_proxy" or "user_session"
-	Type       string `json:"type"`    // Legacy field, mapping to Purpose
+	Purpose    string `json:"purpose"`             // "db_proxy" or "user_session"
+	Type       string `json:"type"`                // Legacy field, mapping to Purpose
+	MFASetup   bool   `json:"mfa_setup,omitempty"` // Session may only enroll a second factor
+	Target     string `json:"target,omitempty"`    // "replica" routes proxy connections to read replicas
 	jwt.RegisteredClaims
 }
 
@@ -65,6 +67,16 @@ func GenerateJWT(databaseID int, userID int, tokenID string) (string, error) {
 
 // GenerateJWTWithTimestamps generates a deterministic JWT for a token identity and timestamp pair.
 func GenerateJWTWithTimestamps(databaseID int, userID int, tokenID string, issuedAt time.Time, expiresAt time.Time) (string, error) {
+	return generateProxyJWT(databaseID, userID, tokenID, "", issuedAt, expiresAt)
+}
+
+// GenerateReplicaJWT generates a proxy token whose connections are routed to
+// the read replicas of a database
+func GenerateReplicaJWT(databaseID int, tokenID string, issuedAt time.Time, expiresAt time.Time) (string, error) {
+	return generateProxyJWT(databaseID, 0, tokenID, "replica", issuedAt, expiresAt)
+}
+
+func generateProxyJWT(databaseID int, userID int, tokenID, target string, issuedAt time.Time, expiresAt time.Time) (string, error) {
 	secret := GetJWTSecret()
 	issuedAt = issuedAt.UTC()
 	expiresAt = expiresAt.UTC()
@@ -75,6 +87,7 @@ func GenerateJWTWithTimestamps(databaseID int, userID int, tokenID string, issue
 		TokenID:    tokenID,
 		Purpose:    "db_proxy",
 		Type:       "database_access",
+		Target:     target,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(issuedAt),
@@ -88,22 +101,24 @@ func GenerateJWTWithTimestamps(databaseID int, userID int, tokenID string, issue
 	return token.SignedString([]byte(secret))
 }
 
-// GenerateUserJWT generates a session token for a user
-func GenerateUserJWT(userID int, email string, isAdmin bool) (string, error) {
+// GenerateUserJWT generates a short-lived access token for a user session.
+// The session ID is carried as the token ID so the session can be revoked.
+func GenerateUserJWT(userID int, email string, isAdmin bool, sessionID string) (string, error) {
 	secret := GetJWTSecret()
 
-	// Session expires in 7 days
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
+	now := time.Now()
+	expiresAt := now.Add(AccessTokenTTL)
 
 	claims := JWTClaims{
 		UserID:  userID,
 		Email:   email,
 		IsAdmin: isAdmin,
+		TokenID: sessionID,
 		Purpose: "user_session",
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "baseful",
 			Subject:   fmt.Sprintf("user_%d", userID),
 		},
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"baseful/db"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mfaPendingTTL bounds the time between the password and the second factor
+const mfaPendingTTL = 5 * time.Minute
+
+// maxMFAAttempts is how many wrong codes one pending login may submit
+const maxMFAAttempts = 5
+
+// ErrMFATokenUsed is returned for the token of a pending login that already
+// started a session
+var ErrMFATokenUsed = errors.New("login was already completed, please log in again")
+
+// mfaSetupPaths are the only routes a session that still has to enroll a second factor may use
+var mfaSetupPaths = map[string]bool{
+	"/api/auth/me":                           true,
+	"/api/auth/mfa":                          true,
+	"/api/auth/mfa/totp/setup":               true,
+	"/api/auth/mfa/totp/enable":              true,
+	"/api/auth/mfa/webauthn/register/begin":  true,
+	"/api/auth/mfa/webauthn/register/finish": true,
+}
+
+var (
+	mfaAttemptsMu sync.Mutex
+	mfaAttempts   = map[string]int{}
+)
+
+// MFARequired reports whether a user must use a second factor, either because
+// an admin required it for them or for the whole instance
+func MFARequired(m db.UserMFA) bool {
+	return m.Required || db.IsMFARequiredInstanceWide()
+}
+
+// GenerateMFAPendingJWT issues the short-lived token that proves the password
+// step of a login succeeded. It is only accepted by the second factor endpoints.
+func GenerateMFAPendingJWT(userID int, email string) (string, error) {
+	tokenID, err := GenerateTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := JWTClaims{
+		UserID:  userID,
+		Email:   email,
+		TokenID: tokenID,
+		Purpose: "mfa_pending",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(mfaPendingTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "baseful",
+			Subject:   fmt.Sprintf("user_%d", userID),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(GetJWTSecret()))
+}
+
+// ValidateMFAPendingJWT validates a token issued by GenerateMFAPendingJWT that
+// has not used up its attempts or completed its login already
+func ValidateMFAPendingJWT(tokenString string) (*JWTClaims, error) {
+	claims, err := ValidateJWT(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "mfa_pending" || claims.TokenID == "" {
+		return nil, fmt.Errorf("invalid token type")
+	}
+	used, err := db.IsMFAPendingTokenUsed(claims.TokenID)
+	if err != nil {
+		return nil, err
+	}
+	if used {
+		return nil, ErrMFATokenUsed
+	}
+
+	mfaAttemptsMu.Lock()
+	defer mfaAttemptsMu.Unlock()
+	if mfaAttempts[claims.TokenID] >= maxMFAAttempts {
+		return nil, fmt.Errorf("too many attempts, please log in again")
+	}
+	return claims, nil
+}
+
+// ConsumeMFAPendingJWT marks a pending login as completed so its token cannot
+// start another session
+func ConsumeMFAPendingJWT(claims *JWTClaims) error {
+	used, err := db.UseMFAPendingToken(claims.TokenID, claims.ExpiresAt.Time)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrMFATokenUsed
+	}
+	return nil
+}
+
+// RecordMFAFailure counts a wrong second factor for a pending login
+func RecordMFAFailure(claims *JWTClaims) {
+	mfaAttemptsMu.Lock()
+	defer mfaAttemptsMu.Unlock()
+	mfaAttempts[claims.TokenID]++
+	// Entries outlive their token by far at this size; start over rather than track expiry
+	if len(mfaAttempts) > 10000 {
+		mfaAttempts = map[string]int{claims.TokenID: mfaAttempts[claims.TokenID]}
+	}
+}
+
+// GenerateMFASetupJWT issues a session that can only enroll a second factor.
+// It is used when two-factor authentication is required but not set up yet.
+func GenerateMFASetupJWT(userID int, email string, isAdmin bool) (string, error) {
+	now := time.Now()
+	claims := JWTClaims{
+		UserID:   userID,
+		Email:    email,
+		IsAdmin:  isAdmin,
+		Purpose:  "user_session",
+		MFASetup: true,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(30 * time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "baseful",
+			Subject:   fmt.Sprintf("user_%d", userID),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(GetJWTSecret()))
+}
+
+// VerifySecondFactorCode checks a TOTP code, or else a recovery code, and
+// consumes it. It reports which kind matched, or "" when none did.
+func VerifySecondFactorCode(userID int, code string) (string, error) {
+	m, err := db.GetUserMFA(userID)
+	if err != nil {
+		return "", err
+	}
+	if m.TOTPEnabled {
+		if step, ok := ValidateTOTP(m.TOTPSecret, code, time.Now()); ok {
+			fresh, err := db.UseTOTPCounter(userID, step)
+			if err != nil || !fresh {
+				return "", err
+			}
+			return "totp", nil
+		}
+	}
+	used, err := db.UseRecoveryCode(userID, code)
+	if err != nil || !used {
+		return "", err
+	}
+	return "recovery_code", nil
+}
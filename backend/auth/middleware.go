@@ -17,7 +17,13 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		// 2. Skip Auth for public API endpoints
-		publicPaths := []string{"/api/auth/login", "/api/auth/register", "/api/auth/status", "/api/hello"}
+		publicPaths := []string{
+			"/api/auth/login", "/api/auth/register", "/api/auth/status", "/api/hello",
+			"/api/auth/oidc/login", "/api/auth/oidc/callback",
+			"/api/auth/login/mfa", "/api/auth/login/webauthn/begin", "/api/auth/login/webauthn/finish",
+			"/api/auth/passkey/begin", "/api/auth/passkey/finish", "/api/auth/refresh",
+			"/api/auth/password/forgot", "/api/auth/password/reset", "/api/auth/email/verify", "/api/auth/email/resend",
+		}
 		for _, path := range publicPaths {
 			if c.Request.URL.Path == path {
 				c.Next()
@@ -26,6 +32,11 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			if token := webSocketToken(c.Request); token != "" {
+				authHeader = "Bearer " + token
+			}
+		}
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
 			c.Abort()
@@ -40,6 +51,11 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := parts[1]
+		if IsAPIToken(tokenString) {
+			authenticateAPIToken(c, tokenString)
+			return
+		}
+
 		claims, err := ValidateJWT(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
@@ -54,15 +70,90 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Sessions issued before a required second factor is enrolled can only enroll one
+		if claims.MFASetup && !mfaSetupPaths[c.Request.URL.Path] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication must be set up first", "mfaSetupRequired": true})
+			c.Abort()
+			return
+		}
+
+		// Regular sessions are checked against the server-side record so logout
+		// and revocation take effect immediately. Enrollment sessions are short
+		// lived and cannot do anything else.
+		email, isAdmin := claims.Email, claims.IsAdmin
+		if !claims.MFASetup {
+			user, err := ValidateSession(claims, c.ClientIP())
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has expired or was revoked"})
+				c.Abort()
+				return
+			}
+			email, isAdmin = user.Email, user.IsAdmin
+			c.Set("session_id", claims.TokenID)
+		}
+
 		// Store user info in context
 		c.Set("user_id", claims.UserID)
-		c.Set("email", claims.Email)
-		c.Set("is_admin", claims.IsAdmin)
+		c.Set("email", email)
+		c.Set("is_admin", isAdmin)
+		c.Set("mfa_setup", claims.MFASetup)
 
 		c.Next()
 	}
 }
 
+// WebSocketProtocol is the subprotocol the server accepts for WebSocket
+// endpoints. Browsers cannot set headers on WebSocket requests, so clients
+// offer it together with "bearer.<token>" to authenticate.
+const WebSocketProtocol = "baseful"
+
+// webSocketToken returns the token offered as a subprotocol in a WebSocket
+// handshake. The server only ever echoes WebSocketProtocol back.
+func webSocketToken(r *http.Request) string {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return ""
+	}
+	for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(value, ",") {
+			if token, ok := strings.CutPrefix(strings.TrimSpace(protocol), "bearer."); ok {
+				return token
+			}
+		}
+	}
+	return ""
+}
+
+// authenticateAPIToken handles requests made with a personal access token or a
+// service account token. The token acts as its user, limited to its scopes.
+func authenticateAPIToken(c *gin.Context, secret string) {
+	token, user, err := ValidateAPIToken(secret, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired API token"})
+		c.Abort()
+		return
+	}
+
+	scope, allowed := RequiredScope(c.Request.Method, c.FullPath())
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint is not available to API tokens"})
+		c.Abort()
+		return
+	}
+	if scope != "" && !HasScope(token, scope) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API token is missing the " + scope + " scope"})
+		c.Abort()
+		return
+	}
+
+	c.Set("user_id", user.ID)
+	c.Set("email", user.Email)
+	c.Set("is_admin", user.IsAdmin)
+	c.Set("mfa_setup", false)
+	c.Set("api_token_id", token.ID)
+
+	c.Next()
+}
+
 // AdminOnly middleware restricts access to admins
 func AdminOnly() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -0,0 +1,435 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"baseful/db"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrSSODisabled is returned when single sign-on is used but not configured
+var ErrSSODisabled = errors.New("single sign-on is not configured")
+
+// oidcStateTTL bounds how long a user may take at the identity provider
+const oidcStateTTL = 10 * time.Minute
+
+// OIDCIdentity is what the identity provider asserted about a user
+type OIDCIdentity struct {
+	Subject   string
+	Email     string
+	FirstName string
+	LastName  string
+	Groups    []string
+}
+
+type oidcProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcLogin struct {
+	verifier  string
+	nonce     string
+	returnTo  string
+	createdAt time.Time
+}
+
+var (
+	oidcHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+	oidcLoginsMu sync.Mutex
+	oidcLogins   = map[string]oidcLogin{}
+
+	oidcMetadataMu    sync.Mutex
+	oidcMetadataCache = map[string]*oidcProviderMetadata{}
+)
+
+// ValidateSSOSettings checks that an enabled configuration can be used
+func ValidateSSOSettings(settings db.SSOSettings) error {
+	if !settings.Enabled {
+		return nil
+	}
+	if settings.Issuer == "" || settings.ClientID == "" {
+		return fmt.Errorf("issuer and clientId are required")
+	}
+	if u, err := url.Parse(settings.Issuer); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return fmt.Errorf("issuer must be an http(s) URL")
+	}
+	for _, mapping := range settings.RoleMappings {
+		if mapping.Group == "" || mapping.ProjectID <= 0 || !db.IsValidRole(mapping.Role) {
+			return fmt.Errorf("role mappings need a group, a projectId and a valid role")
+		}
+	}
+	return nil
+}
+
+// StartOIDCLogin returns the authorization URL the browser is sent to. The
+// returned state must also be bound to the browser, e.g. in a cookie.
+func StartOIDCLogin(ctx context.Context, settings db.SSOSettings, redirectURL, returnTo string) (string, string, error) {
+	if !settings.Enabled {
+		return "", "", ErrSSODisabled
+	}
+	metadata, err := discoverOIDC(ctx, settings.Issuer)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomURLToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomURLToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomURLToken()
+	if err != nil {
+		return "", "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	scopes := settings.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {settings.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	oidcLoginsMu.Lock()
+	for key, login := range oidcLogins {
+		if time.Since(login.createdAt) > oidcStateTTL {
+			delete(oidcLogins, key)
+		}
+	}
+	oidcLogins[state] = oidcLogin{verifier: verifier, nonce: nonce, returnTo: returnTo, createdAt: time.Now()}
+	oidcLoginsMu.Unlock()
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + params.Encode(), state, nil
+}
+
+// FinishOIDCLogin exchanges an authorization code and verifies the ID token.
+// It returns the identity and the path the login was started from.
+func FinishOIDCLogin(ctx context.Context, settings db.SSOSettings, redirectURL, state, code string) (*OIDCIdentity, string, error) {
+	if !settings.Enabled {
+		return nil, "", ErrSSODisabled
+	}
+
+	oidcLoginsMu.Lock()
+	login, ok := oidcLogins[state]
+	delete(oidcLogins, state)
+	oidcLoginsMu.Unlock()
+	if !ok || time.Since(login.createdAt) > oidcStateTTL {
+		return nil, "", fmt.Errorf("login request expired, please try again")
+	}
+
+	metadata, err := discoverOIDC(ctx, settings.Issuer)
+	if err != nil {
+		return nil, "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {settings.ClientID},
+		"code_verifier": {login.verifier},
+	}
+	if settings.ClientSecret != "" {
+		form.Set("client_secret", settings.ClientSecret)
+	}
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if err := doOIDCRequest(req, &tokens); err != nil {
+		if tokens.Error != "" {
+			return nil, "", fmt.Errorf("token exchange failed: %s", strings.TrimSpace(tokens.Error+" "+tokens.Description))
+		}
+		return nil, "", fmt.Errorf("token exchange failed: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, "", fmt.Errorf("identity provider returned no ID token")
+	}
+
+	claims, err := verifyIDToken(ctx, metadata, settings.ClientID, tokens.IDToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != login.nonce {
+		return nil, "", fmt.Errorf("ID token nonce mismatch")
+	}
+
+	// Some providers only put profile claims in the userinfo response
+	if _, hasEmail := claims["email"]; !hasEmail && metadata.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadata.UserinfoEndpoint, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		var userinfo map[string]any
+		if err := doOIDCRequest(req, &userinfo); err != nil {
+			return nil, "", fmt.Errorf("userinfo request failed: %w", err)
+		}
+		if sub, _ := userinfo["sub"].(string); sub != claims["sub"] {
+			return nil, "", fmt.Errorf("userinfo subject mismatch")
+		}
+		for key, value := range userinfo {
+			if _, exists := claims[key]; !exists {
+				claims[key] = value
+			}
+		}
+	}
+
+	identity, err := identityFromClaims(claims, settings.GroupsClaim)
+	if err != nil {
+		return nil, "", err
+	}
+	return identity, login.returnTo, nil
+}
+
+func identityFromClaims(claims jwt.MapClaims, groupsClaim string) (*OIDCIdentity, error) {
+	subject, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	if subject == "" || email == "" {
+		return nil, fmt.Errorf("identity provider did not return a subject and an email address")
+	}
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		return nil, fmt.Errorf("email address %s is not verified", email)
+	}
+
+	identity := &OIDCIdentity{
+		Subject: subject,
+		Email:   email,
+	}
+	identity.FirstName, _ = claims["given_name"].(string)
+	identity.LastName, _ = claims["family_name"].(string)
+	if identity.FirstName == "" {
+		if name, _ := claims["name"].(string); name != "" {
+			first, last, _ := strings.Cut(name, " ")
+			identity.FirstName, identity.LastName = first, last
+		}
+	}
+
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	// Nested claims such as Keycloak's realm_access.roles use a dotted path
+	var value any = map[string]any(claims)
+	for _, part := range strings.Split(groupsClaim, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			value = nil
+			break
+		}
+		value = object[part]
+	}
+	switch groups := value.(type) {
+	case []any:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	case string:
+		identity.Groups = strings.Fields(strings.ReplaceAll(groups, ",", " "))
+	}
+	return identity, nil
+}
+
+func verifyIDToken(ctx context.Context, metadata *oidcProviderMetadata, clientID, rawToken string) (jwt.MapClaims, error) {
+	keys, err := fetchJWKS(ctx, metadata.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := keys[kid]; ok {
+			return key, nil
+		}
+		if kid == "" && len(keys) == 1 {
+			for _, key := range keys {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(metadata.Issuer),
+		jwt.WithAudience(clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	return claims, nil
+}
+
+func discoverOIDC(ctx context.Context, issuer string) (*oidcProviderMetadata, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	oidcMetadataMu.Lock()
+	cached := oidcMetadataCache[issuer]
+	oidcMetadataMu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var metadata oidcProviderMetadata
+	if err := doOIDCRequest(req, &metadata); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", metadata.Issuer, issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document is missing endpoints")
+	}
+
+	oidcMetadataMu.Lock()
+	oidcMetadataCache[issuer] = &metadata
+	oidcMetadataMu.Unlock()
+	return &metadata, nil
+}
+
+// ResetOIDCCache forgets discovered provider metadata, e.g. after the settings change
+func ResetOIDCCache() {
+	oidcMetadataMu.Lock()
+	oidcMetadataCache = map[string]*oidcProviderMetadata{}
+	oidcMetadataMu.Unlock()
+}
+
+// fetchJWKS loads the provider's signing keys. They are fetched on every login
+// so key rotation at the provider needs no cache invalidation.
+func fetchJWKS(ctx context.Context, jwksURI string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := doOIDCRequest(req, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("identity provider published no usable signing keys")
+	}
+	return keys, nil
+}
+
+func doOIDCRequest(req *http.Request, out interface{}) error {
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	decodeErr := json.Unmarshal(body, out)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return decodeErr
+}
+
+func randomURLToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// TrustedProxies returns the reverse proxies whose forwarding headers are
+// honoured, from TRUSTED_PROXIES (comma-separated IPs or CIDRs). Defaults to
+// loopback.
+func TrustedProxies() []string {
+	v := os.Getenv("TRUSTED_PROXIES")
+	if v == "" {
+		return []string{"127.0.0.1", "::1"}
+	}
+	var proxies []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
+
+// fromTrustedProxy reports whether a request was sent directly by one of the
+// trusted proxies, so its X-Forwarded-* headers can be believed
+func fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, p := range TrustedProxies() {
+		if _, network, err := net.ParseCIDR(p); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if trusted := net.ParseIP(p); trusted != nil && trusted.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
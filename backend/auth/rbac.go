@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"baseful/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// projectRoutePolicies lists the routes whose required role differs from the
+// default: viewer for GET requests and admin for everything else
+var projectRoutePolicies = map[string]string{
+	"GET /api/databases/:id/connection-string":              db.RoleDeveloper,
+	"GET /api/databases/:id/tokens":                         db.RoleDeveloper,
+	"POST /api/databases/:id/replicas/connection-string":    db.RoleDeveloper,
+	"POST /api/databases/:id/roles/:role/connection-string": db.RoleDeveloper,
+	"GET /api/databases/:id/logs":                           db.RoleDeveloper,
+	"GET /api/databases/:id/branches/:branchId/logs":        db.RoleDeveloper,
+	"GET /api/databases/:id/backups/settings":               db.RoleAdmin,
+	"GET /api/projects/:id/llm-settings":                    db.RoleAdmin,
+	"GET /api/projects/:id/invitations":                     db.RoleAdmin,
+	"GET /api/projects/:id/service-accounts":                db.RoleAdmin,
+	"POST /api/databases/:id/query":                         db.RoleDeveloper,
+	"POST /api/databases/:id/sql-assistant":                 db.RoleDeveloper,
+	"POST /api/databases/:id/tables/:tableName/query":       db.RoleViewer,
+	"POST /api/databases/:id/tables/:tableName/rows":        db.RoleDeveloper,
+	"PUT /api/databases/:id/tables/:tableName/rows":         db.RoleDeveloper,
+	"DELETE /api/databases/:id/tables/:tableName/rows":      db.RoleDeveloper,
+	"POST /api/databases/:id/schema/preview":                db.RoleViewer,
+	"POST /api/databases/:id/schema/apply":                  db.RoleDeveloper,
+	"POST /api/databases/:id/branches":                      db.RoleDeveloper,
+	"POST /api/databases/:id/branches/:branchId/:action":    db.RoleDeveloper,
+	"POST /api/databases/:id/backups/manual":                db.RoleDeveloper,
+}
+
+// RequiredProjectRole returns the minimum project role for a request
+func RequiredProjectRole(method, route, action string) string {
+	// Deleting a database is reserved to owners, deleting a branch to admins
+	if action == "delete" {
+		switch route {
+		case "/api/databases/:id/:action":
+			return db.RoleOwner
+		case "/api/databases/:id/branches/:branchId/:action":
+			return db.RoleAdmin
+		}
+	}
+	if role, ok := projectRoutePolicies[method+" "+route]; ok {
+		return role
+	}
+	if method == http.MethodGet {
+		return db.RoleViewer
+	}
+	return db.RoleAdmin
+}
+
+// ProjectAccess enforces project roles on /api/databases/:id and /api/projects/:id
+// routes. It must run after AuthMiddleware. Instance admins act as project owners;
+// databases outside any project are only accessible to instance admins.
+func ProjectAccess() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+
+		var projectID int
+		switch {
+		case strings.HasPrefix(route, "/api/databases/:id"):
+			id, err := db.GetDatabaseProjectID(c.Param("id"))
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Database not found"})
+				c.Abort()
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve project"})
+				c.Abort()
+				return
+			}
+			projectID = id
+		case strings.HasPrefix(route, "/api/projects/:id"):
+			id, err := strconv.Atoi(c.Param("id"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+				c.Abort()
+				return
+			}
+			projectID = id
+		default:
+			c.Next()
+			return
+		}
+
+		role := db.RoleOwner
+		if !c.GetBool("is_admin") {
+			var err error
+			role, err = db.GetProjectRole(projectID, c.GetInt("user_id"))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check project access"})
+				c.Abort()
+				return
+			}
+			if role == "" || projectID == 0 {
+				c.JSON(http.StatusForbidden, gin.H{"error": "You do not have access to this project"})
+				c.Abort()
+				return
+			}
+		}
+
+		required := RequiredProjectRole(c.Request.Method, route, c.Param("action"))
+		if !db.RoleAtLeast(role, required) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This action requires the " + required + " role"})
+			c.Abort()
+			return
+		}
+
+		c.Set("project_id", projectID)
+		c.Set("project_role", role)
+		c.Next()
+	}
+}
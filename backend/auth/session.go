@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"baseful/db"
+)
+
+const (
+	// AccessTokenTTL is how long an access token is valid. Revoking a session
+	// takes effect immediately, this only bounds how often clients refresh.
+	AccessTokenTTL = 15 * time.Minute
+	// RefreshTokenTTL is how long a session stays alive without being used
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown, expired or revoked refresh tokens
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token
+	// is presented again. The session is revoked because the token leaked.
+	ErrRefreshTokenReused = errors.New("refresh token was already used, session revoked")
+)
+
+// Session holds the tokens returned to a client that logged in
+type Session struct {
+	ID           string
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time // Expiry of the access token
+}
+
+// StartSession records a new session for a user and issues its tokens
+func StartSession(user *db.User, userAgent, ip string) (*Session, error) {
+	id, err := GenerateTokenID()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := newRefreshToken(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.CreateUserSession(id, user.ID, db.HashToken(refreshToken), userAgent, ip, time.Now().Add(RefreshTokenTTL)); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	if _, err := db.CleanupUserSessions(); err != nil {
+		log.Printf("Failed to clean up sessions: %v", err)
+	}
+	return issueAccessToken(user, id, refreshToken)
+}
+
+// RefreshSession exchanges a refresh token for a new access token and a new
+// refresh token. The presented refresh token stops working.
+func RefreshSession(refreshToken, ip string) (*Session, *db.User, error) {
+	id, _, ok := strings.Cut(refreshToken, ".")
+	if !ok || id == "" {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	session, err := db.GetUserSession(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if session == nil || session.Revoked {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	hash := db.HashToken(refreshToken)
+	if hash != session.RefreshTokenHash {
+		if hash != session.PreviousRefreshTokenHash {
+			return nil, nil, ErrInvalidRefreshToken
+		}
+		// Another tab may have rotated the token a moment ago; only treat an
+		// old token as stolen once that window has passed
+		if session.RecentlyRotated {
+			return nil, nil, ErrInvalidRefreshToken
+		}
+		if err := db.RevokeUserSession(session.UserID, session.ID); err != nil {
+			log.Printf("Failed to revoke session %s after refresh token reuse: %v", session.ID, err)
+		}
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	user, err := db.GetUserByID(session.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	newToken, err := newRefreshToken(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	rotated, err := db.RotateUserSessionRefreshToken(id, hash, db.HashToken(newToken), ip, time.Now().Add(RefreshTokenTTL))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	if !rotated {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	s, err := issueAccessToken(user, id, newToken)
+	return s, user, err
+}
+
+// ValidateSession checks that the session of an access token is still active
+// and records the activity. It returns the session's user with current
+// email and admin rights.
+func ValidateSession(claims *JWTClaims, ip string) (*db.User, error) {
+	if claims.TokenID == "" {
+		return nil, errors.New("token is not bound to a session")
+	}
+	session, err := db.GetActiveUserSession(claims.TokenID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.UserID != claims.UserID {
+		return nil, errors.New("session was revoked or has expired")
+	}
+	user, err := db.GetUserByID(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user no longer exists")
+	}
+	if err := db.TouchUserSession(session.ID, ip); err != nil {
+		log.Printf("Failed to update session %s: %v", session.ID, err)
+	}
+	return user, nil
+}
+
+// DescribeUserAgent returns a short "Browser on OS" label for a User-Agent header
+func DescribeUserAgent(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"}, {"Safari/", "Safari"}, {"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	platform := ""
+	for _, o := range []struct{ token, name string }{
+		{"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Android", "Android"},
+		{"Windows", "Windows"}, {"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			platform = o.name
+			break
+		}
+	}
+	if platform == "" {
+		return browser
+	}
+	return browser + " on " + platform
+}
+
+func issueAccessToken(user *db.User, sessionID, refreshToken string) (*Session, error) {
+	accessToken, err := GenerateUserJWT(user.ID, user.Email, user.IsAdmin, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		ID:           sessionID,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    time.Now().Add(AccessTokenTTL),
+	}, nil
+}
+
+// newRefreshToken returns "<session id>.<secret>" so the session can be
+// looked up without scanning every hash
+func newRefreshToken(sessionID string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return sessionID + "." + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"baseful/db"
+)
+
+// ErrSSONotAllowed is returned when an identity may not sign in to this instance
+var ErrSSONotAllowed = errors.New("this account is not allowed to sign in")
+
+// ProvisionSSOUser finds or creates the user for an identity and applies the
+// configured group mappings. Sign-in is allowed for whitelisted emails and for
+// emails in the allowed domains; without allowed domains, existing users may
+// also sign in. The first user of a fresh instance becomes admin, as with
+// registration.
+func ProvisionSSOUser(settings db.SSOSettings, identity *OIDCIdentity) (*db.User, error) {
+	subject := strings.TrimSuffix(settings.Issuer, "/") + "#" + identity.Subject
+
+	user, err := db.GetUserByOIDCSubject(subject)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		// Link an existing account with the same email on first SSO sign-in
+		user, err = db.GetUserByEmail(identity.Email)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	whitelisted, err := db.IsEmailWhitelisted(identity.Email)
+	if err != nil {
+		return nil, err
+	}
+	hasUser, err := db.HasAnyUser()
+	if err != nil {
+		return nil, err
+	}
+	allowed := whitelisted || !hasUser
+	if len(settings.AllowedDomains) > 0 {
+		allowed = allowed || domainAllowed(identity.Email, settings.AllowedDomains)
+	} else {
+		allowed = allowed || user != nil
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%s: %w", identity.Email, ErrSSONotAllowed)
+	}
+
+	isAdmin := !hasUser || hasAnyGroup(identity.Groups, settings.AdminGroups)
+	if user == nil {
+		id, err := db.CreateSSOUser(identity.Email, identity.FirstName, identity.LastName, subject, isAdmin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create user: %w", err)
+		}
+		if err := db.AcceptProjectInvitations(id, identity.Email); err != nil {
+			return nil, fmt.Errorf("failed to accept invitations: %w", err)
+		}
+		if user, err = db.GetUserByEmail(identity.Email); err != nil || user == nil {
+			return nil, fmt.Errorf("failed to load created user: %w", err)
+		}
+	} else {
+		if err := db.LinkUserOIDCSubject(user.ID, subject); errors.Is(err, db.ErrSubjectMismatch) {
+			return nil, fmt.Errorf("%s is linked to another identity: %w", identity.Email, ErrSSONotAllowed)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to link user: %w", err)
+		}
+		// Admin groups are authoritative once configured, but the last admin is never demoted
+		if len(settings.AdminGroups) > 0 && isAdmin != user.IsAdmin {
+			demote := user.IsAdmin && !isAdmin
+			admins, err := db.CountAdmins()
+			if err != nil {
+				return nil, err
+			}
+			if !demote || admins > 1 {
+				if err := db.SetUserAdmin(user.ID, isAdmin); err != nil {
+					return nil, fmt.Errorf("failed to update admin rights: %w", err)
+				}
+				user.IsAdmin = isAdmin
+			}
+		}
+	}
+
+	if err := applySSORoleMappings(settings.RoleMappings, identity.Groups, user.ID); err != nil {
+		return nil, fmt.Errorf("failed to apply role mappings: %w", err)
+	}
+	return user, nil
+}
+
+// applySSORoleMappings raises project roles to what the user's groups grant.
+// Roles granted by hand are never lowered.
+func applySSORoleMappings(mappings []db.SSORoleMapping, groups []string, userID int) error {
+	granted := map[int]string{}
+	for _, mapping := range mappings {
+		if !hasAnyGroup(groups, []string{mapping.Group}) {
+			continue
+		}
+		if current, ok := granted[mapping.ProjectID]; !ok || !db.RoleAtLeast(current, mapping.Role) {
+			granted[mapping.ProjectID] = mapping.Role
+		}
+	}
+
+	for projectID, role := range granted {
+		var exists int
+		if err := db.DB.QueryRow("SELECT COUNT(*) FROM projects WHERE id = ?", projectID).Scan(&exists); err != nil {
+			return err
+		}
+		if exists == 0 {
+			continue
+		}
+		current, err := db.GetProjectRole(projectID, userID)
+		if err != nil {
+			return err
+		}
+		if current != "" && db.RoleAtLeast(current, role) {
+			continue
+		}
+		if err := db.SetProjectMember(projectID, userID, role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func domainAllowed(email string, domains []string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range domains {
+		if strings.ToLower(strings.TrimPrefix(allowed, "@")) == domain {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyGroup(groups, wanted []string) bool {
+	for _, group := range groups {
+		for _, w := range wanted {
+			if strings.EqualFold(group, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ThrottlePolicy controls how failed attempts slow down and lock out a key
+type ThrottlePolicy struct {
+	FreeAttempts int           // Failures allowed before delays start
+	BaseDelay    time.Duration // First delay, doubled with every further failure
+	MaxDelay     time.Duration
+	LockoutAfter int // Failures that lock the key out
+	LockoutFor   time.Duration
+	Window       time.Duration // Failures are forgotten after this long without one
+}
+
+// Throttle tracks failed authentication attempts per key, e.g. per client IP
+// or per account, with exponential backoff and temporary lockouts. State is
+// kept in memory, so a restart clears it.
+type Throttle struct {
+	policy  ThrottlePolicy
+	mu      sync.Mutex
+	entries map[string]*throttleEntry
+}
+
+type throttleEntry struct {
+	failures    int
+	lastFailure time.Time
+	blockedTill time.Time
+	locked      bool
+}
+
+// ThrottleState describes a key that is currently slowed down or locked out
+type ThrottleState struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"lastFailure"`
+	BlockedTill time.Time `json:"blockedUntil"`
+	Locked      bool      `json:"locked"`
+}
+
+// maxThrottleEntries bounds memory use when many distinct keys fail
+const maxThrottleEntries = 100000
+
+// NewThrottle returns an empty throttle with a policy
+func NewThrottle(policy ThrottlePolicy) *Throttle {
+	return &Throttle{policy: policy, entries: map[string]*throttleEntry{}}
+}
+
+// Wait returns how long the key has to wait before its next attempt, and
+// whether it is locked out. Zero means it may try now.
+func (t *Throttle) Wait(key string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.entry(key, time.Now())
+	if e == nil {
+		return 0, false
+	}
+	wait := time.Until(e.blockedTill)
+	if wait <= 0 {
+		return 0, false
+	}
+	return wait, e.locked
+}
+
+// Fail records a failed attempt. It returns the delay before the next attempt
+// and whether this failure locked the key out.
+func (t *Throttle) Fail(key string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	e := t.entry(key, now)
+	if e == nil {
+		if len(t.entries) >= maxThrottleEntries {
+			t.prune(now)
+		}
+		e = &throttleEntry{}
+		t.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+
+	lockedNow := false
+	switch {
+	case t.policy.LockoutAfter > 0 && e.failures >= t.policy.LockoutAfter:
+		lockedNow = !e.locked
+		e.locked = true
+		e.blockedTill = now.Add(t.policy.LockoutFor)
+	case e.failures > t.policy.FreeAttempts:
+		delay := t.policy.MaxDelay
+		if shift := e.failures - t.policy.FreeAttempts - 1; shift < 30 {
+			delay = min(t.policy.BaseDelay<<shift, t.policy.MaxDelay)
+		}
+		e.blockedTill = now.Add(delay)
+	}
+	return max(time.Until(e.blockedTill), 0), lockedNow
+}
+
+// Reset forgets the failures of a key, e.g. after a successful login or when
+// an admin unlocks it. It reports whether the key was tracked.
+func (t *Throttle) Reset(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.entries[key]
+	delete(t.entries, key)
+	return ok
+}
+
+// Blocked lists the keys that currently have to wait, locked out keys first
+func (t *Throttle) Blocked() []ThrottleState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	states := []ThrottleState{}
+	for key, e := range t.entries {
+		if e.blockedTill.After(now) {
+			states = append(states, ThrottleState{
+				Key: key, Failures: e.failures, LastFailure: e.lastFailure,
+				BlockedTill: e.blockedTill, Locked: e.locked,
+			})
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Locked != states[j].Locked {
+			return states[i].Locked
+		}
+		return states[i].LastFailure.After(states[j].LastFailure)
+	})
+	return states
+}
+
+// entry returns the live entry for a key, dropping it once it has expired
+func (t *Throttle) entry(key string, now time.Time) *throttleEntry {
+	e, ok := t.entries[key]
+	if !ok {
+		return nil
+	}
+	if t.expired(e, now) {
+		delete(t.entries, key)
+		return nil
+	}
+	return e
+}
+
+// expired reports whether an entry no longer blocks and its failures are
+// old enough to forget. A lockout that ran out starts over from zero.
+func (t *Throttle) expired(e *throttleEntry, now time.Time) bool {
+	if e.blockedTill.After(now) {
+		return false
+	}
+	return e.locked || now.Sub(e.lastFailure) > t.policy.Window
+}
+
+func (t *Throttle) prune(now time.Time) {
+	for key, e := range t.entries {
+		if t.expired(e, now) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// Login attempts are limited per client IP and per account. The account
+// limit stops slow attacks spread over many IPs; the IP limit stops one
+// client from trying many accounts. Unknown emails are tracked like real
+// accounts so responses do not reveal which accounts exist.
+var (
+	loginIPThrottle = NewThrottle(ThrottlePolicy{
+		FreeAttempts: 10, BaseDelay: time.Second, MaxDelay: 5 * time.Minute,
+		LockoutAfter: 50, LockoutFor: time.Hour, Window: time.Hour,
+	})
+	loginAccountThrottle = NewThrottle(ThrottlePolicy{
+		FreeAttempts: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Minute,
+		LockoutAfter: 10, LockoutFor: 15 * time.Minute, Window: time.Hour,
+	})
+)
+
+// LoginAccountKey normalises an email for login throttling
+func LoginAccountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// LoginWait returns how long a login for an account from an IP has to wait,
+// and whether the IP or the account is locked out. An empty email only checks
+// the IP, for logins whose account is not known yet.
+func LoginWait(ip, email string) (time.Duration, bool) {
+	ipWait, ipLocked := loginIPThrottle.Wait(ip)
+	if email == "" {
+		return ipWait, ipLocked
+	}
+	accountWait, accountLocked := loginAccountThrottle.Wait(LoginAccountKey(email))
+	if accountWait > ipWait {
+		return accountWait, accountLocked
+	}
+	return ipWait, ipLocked
+}
+
+// LoginFailure records a failed login. It reports which of the IP and the
+// account this failure locked out. An empty email only counts against the IP.
+func LoginFailure(ip, email string) (ipLocked, accountLocked bool) {
+	_, ipLocked = loginIPThrottle.Fail(ip)
+	if email != "" {
+		_, accountLocked = loginAccountThrottle.Fail(LoginAccountKey(email))
+	}
+	return ipLocked, accountLocked
+}
+
+// LoginSuccess clears the failures of an account. The IP keeps its count so
+// logging into an own account does not reset guesses against others.
+func LoginSuccess(email string) {
+	loginAccountThrottle.Reset(LoginAccountKey(email))
+}
+
+// LoginLockouts lists the IPs and accounts that are throttled or locked out
+func LoginLockouts() (ips, accounts []ThrottleState) {
+	return loginIPThrottle.Blocked(), loginAccountThrottle.Blocked()
+}
+
+// UnlockLoginAccount clears the failures of an account
+func UnlockLoginAccount(email string) bool {
+	return loginAccountThrottle.Reset(LoginAccountKey(email))
+}
+
+// UnlockLoginIP clears the failures of a client IP
+func UnlockLoginIP(ip string) bool {
+	return loginIPThrottle.Reset(ip)
+}
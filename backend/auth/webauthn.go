@@ -0,0 +1,326 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"baseful/db"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// webauthnCeremonyTTL bounds how long a browser may take to answer a challenge
+const webauthnCeremonyTTL = 5 * time.Minute
+
+type webauthnCeremony struct {
+	session   webauthn.SessionData
+	userID    int
+	kind      string
+	createdAt time.Time
+}
+
+var (
+	webauthnCeremoniesMu sync.Mutex
+	webauthnCeremonies   = map[string]webauthnCeremony{}
+)
+
+// webauthnUser adapts a user and their stored credentials to the WebAuthn library
+type webauthnUser struct {
+	user        *db.User
+	credentials []webauthn.Credential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte {
+	return []byte("baseful-user-" + strconv.Itoa(u.user.ID))
+}
+
+func (u *webauthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webauthnUser) WebAuthnDisplayName() string {
+	if name := strings.TrimSpace(u.user.FirstName + " " + u.user.LastName); name != "" {
+		return name
+	}
+	return u.user.Email
+}
+
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func loadWebAuthnUser(user *db.User) (*webauthnUser, error) {
+	stored, err := db.ListWebAuthnCredentials(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	u := &webauthnUser{user: user}
+	for _, cred := range stored {
+		var credential webauthn.Credential
+		if err := json.Unmarshal([]byte(cred.Data), &credential); err != nil {
+			return nil, fmt.Errorf("failed to decode credential %d: %w", cred.ID, err)
+		}
+		u.credentials = append(u.credentials, credential)
+	}
+	return u, nil
+}
+
+// newWebAuthn configures the relying party. WEBAUTHN_RP_ID and WEBAUTHN_ORIGINS
+// override the values derived from the request, which is needed when the
+// dashboard is reached through a proxy that rewrites the host. X-Forwarded-*
+// headers are only read from TRUSTED_PROXIES, as anyone can set them.
+func newWebAuthn(r *http.Request) (*webauthn.WebAuthn, error) {
+	forwarded := fromTrustedProxy(r)
+	scheme := "http"
+	if r.TLS != nil || (forwarded && r.Header.Get("X-Forwarded-Proto") == "https") {
+		scheme = "https"
+	}
+	host := r.Host
+	if forwardedHost := r.Header.Get("X-Forwarded-Host"); forwarded && forwardedHost != "" {
+		host = forwardedHost
+	}
+
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			rpID = h
+		}
+	}
+	origins := []string{scheme + "://" + host}
+	if configured := os.Getenv("WEBAUTHN_ORIGINS"); configured != "" {
+		origins = strings.Split(configured, ",")
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: "Baseful",
+		RPOrigins:     origins,
+	})
+}
+
+func storeWebAuthnCeremony(kind string, userID int, session *webauthn.SessionData) (string, error) {
+	id, err := randomURLToken()
+	if err != nil {
+		return "", err
+	}
+	webauthnCeremoniesMu.Lock()
+	defer webauthnCeremoniesMu.Unlock()
+	for key, ceremony := range webauthnCeremonies {
+		if time.Since(ceremony.createdAt) > webauthnCeremonyTTL {
+			delete(webauthnCeremonies, key)
+		}
+	}
+	webauthnCeremonies[id] = webauthnCeremony{session: *session, userID: userID, kind: kind, createdAt: time.Now()}
+	return id, nil
+}
+
+func takeWebAuthnCeremony(id, kind string, userID int) (webauthn.SessionData, error) {
+	webauthnCeremoniesMu.Lock()
+	ceremony, ok := webauthnCeremonies[id]
+	delete(webauthnCeremonies, id)
+	webauthnCeremoniesMu.Unlock()
+	if !ok || ceremony.kind != kind || ceremony.userID != userID || time.Since(ceremony.createdAt) > webauthnCeremonyTTL {
+		return webauthn.SessionData{}, fmt.Errorf("security key request expired, please try again")
+	}
+	return ceremony.session, nil
+}
+
+// BeginWebAuthnRegistration starts registering a security key or passkey. It
+// returns the ceremony ID the browser sends back with its answer, and the
+// options for navigator.credentials.create.
+func BeginWebAuthnRegistration(r *http.Request, user *db.User) (string, *protocol.CredentialCreation, error) {
+	w, err := newWebAuthn(r)
+	if err != nil {
+		return "", nil, err
+	}
+	u, err := loadWebAuthnUser(user)
+	if err != nil {
+		return "", nil, err
+	}
+
+	options, session, err := w.BeginRegistration(u,
+		webauthn.WithExclusions(webauthn.Credentials(u.credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := storeWebAuthnCeremony("register", user.ID, session)
+	return id, options, err
+}
+
+// FinishWebAuthnRegistration verifies the browser's answer and stores the credential
+func FinishWebAuthnRegistration(r *http.Request, user *db.User, ceremonyID, name string, response []byte) error {
+	session, err := takeWebAuthnCeremony(ceremonyID, "register", user.ID)
+	if err != nil {
+		return err
+	}
+	w, err := newWebAuthn(r)
+	if err != nil {
+		return err
+	}
+	u, err := loadWebAuthnUser(user)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return fmt.Errorf("invalid security key response: %w", err)
+	}
+	credential, err := w.CreateCredential(u, session, parsed)
+	if err != nil {
+		return fmt.Errorf("security key verification failed: %w", err)
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		name = "Security key"
+	}
+	return db.AddWebAuthnCredential(user.ID, base64.RawURLEncoding.EncodeToString(credential.ID), name, string(data))
+}
+
+// BeginWebAuthnLogin starts a second factor check with one of the user's credentials
+func BeginWebAuthnLogin(r *http.Request, user *db.User) (string, *protocol.CredentialAssertion, error) {
+	w, err := newWebAuthn(r)
+	if err != nil {
+		return "", nil, err
+	}
+	u, err := loadWebAuthnUser(user)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(u.credentials) == 0 {
+		return "", nil, fmt.Errorf("no security keys are registered")
+	}
+
+	options, session, err := w.BeginLogin(u)
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := storeWebAuthnCeremony("login", user.ID, session)
+	return id, options, err
+}
+
+// FinishWebAuthnLogin verifies the browser's answer to a second factor check
+func FinishWebAuthnLogin(r *http.Request, user *db.User, ceremonyID string, response []byte) error {
+	session, err := takeWebAuthnCeremony(ceremonyID, "login", user.ID)
+	if err != nil {
+		return err
+	}
+	w, err := newWebAuthn(r)
+	if err != nil {
+		return err
+	}
+	u, err := loadWebAuthnUser(user)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return fmt.Errorf("invalid security key response: %w", err)
+	}
+	credential, err := w.ValidateLogin(u, session, parsed)
+	if err != nil {
+		return fmt.Errorf("security key verification failed: %w", err)
+	}
+	return recordWebAuthnUse(credential)
+}
+
+// BeginPasskeyLogin starts a passwordless login with a discoverable credential
+func BeginPasskeyLogin(r *http.Request) (string, *protocol.CredentialAssertion, error) {
+	w, err := newWebAuthn(r)
+	if err != nil {
+		return "", nil, err
+	}
+	options, session, err := w.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := storeWebAuthnCeremony("passkey", 0, session)
+	return id, options, err
+}
+
+// FinishPasskeyLogin verifies a passkey assertion and returns its owner. The
+// passkey must have verified the user (PIN or biometrics), which makes it a
+// complete second factor on its own. When verification fails after the
+// passkey was looked up, its owner is returned with the error so the failure
+// counts against the account.
+func FinishPasskeyLogin(r *http.Request, ceremonyID string, response []byte) (*db.User, error) {
+	session, err := takeWebAuthnCeremony(ceremonyID, "passkey", 0)
+	if err != nil {
+		return nil, err
+	}
+	w, err := newWebAuthn(r)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("invalid passkey response: %w", err)
+	}
+
+	var owner *db.User
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := db.GetWebAuthnCredentialOwner(base64.RawURLEncoding.EncodeToString(rawID))
+		if err != nil {
+			return nil, err
+		}
+		if userID == 0 {
+			return nil, fmt.Errorf("unknown passkey")
+		}
+		user, err := db.GetUserByID(userID)
+		if err != nil || user == nil {
+			return nil, fmt.Errorf("unknown passkey")
+		}
+		u, err := loadWebAuthnUser(user)
+		if err != nil {
+			return nil, err
+		}
+		if string(u.WebAuthnID()) != string(userHandle) {
+			return nil, fmt.Errorf("passkey does not belong to this account")
+		}
+		owner = user
+		return u, nil
+	}
+
+	_, credential, err := w.ValidatePasskeyLogin(handler, session, parsed)
+	if err != nil {
+		return owner, fmt.Errorf("passkey verification failed: %w", err)
+	}
+	if !credential.Flags.UserVerified {
+		return owner, fmt.Errorf("passkey did not verify the user")
+	}
+	if err := recordWebAuthnUse(credential); err != nil {
+		return owner, err
+	}
+	return owner, nil
+}
+
+// recordWebAuthnUse stores the new signature counter. A counter that went
+// backwards means the authenticator may have been cloned, so the login fails.
+func recordWebAuthnUse(credential *webauthn.Credential) error {
+	if credential.Authenticator.CloneWarning {
+		return fmt.Errorf("security key signature counter went backwards, it may have been cloned")
+	}
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+	return db.UpdateWebAuthnCredentialUse(base64.RawURLEncoding.EncodeToString(credential.ID), string(data))
+}
@@ -11,9 +11,11 @@ import (
 	"time"
 
 	"baseful/db"
+	"baseful/docker"
+	"baseful/secrets"
+	"baseful/suspend"
 
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -83,6 +85,9 @@ func GetBackupSettings(databaseID int) (*BackupSettings, error) {
 	if err != nil {
 		return nil, err
 	}
+	if s.SecretKey, err = secrets.Decrypt(s.SecretKey); err != nil {
+		return nil, fmt.Errorf("failed to decrypt backup secret key: %w", err)
+	}
 	return &s, nil
 }
 
@@ -93,7 +98,12 @@ func SaveBackupSettings(s *BackupSettings) error {
 		}
 	}
 
-	_, err := db.DB.Exec(`
+	secretKey, err := secrets.Encrypt(s.SecretKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.DB.Exec(`
 		INSERT INTO backup_settings (database_id, enabled, provider, endpoint, region, bucket, access_key, secret_key, path_prefix, encryption_enabled, encryption_public_key, updated_at)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
 		ON CONFLICT(database_id) DO UPDATE SET
@@ -108,7 +118,7 @@ func SaveBackupSettings(s *BackupSettings) error {
 			encryption_enabled=excluded.encryption_enabled,
 			encryption_public_key=excluded.encryption_public_key,
 			updated_at=CURRENT_TIMESTAMP
-	`, s.DatabaseID, s.Enabled, s.Provider, s.Endpoint, s.Region, s.Bucket, s.AccessKey, s.SecretKey, s.PathPrefix, s.EncryptionEnabled, s.EncryptionPublicKey)
+	`, s.DatabaseID, s.Enabled, s.Provider, s.Endpoint, s.Region, s.Bucket, s.AccessKey, secretKey, s.PathPrefix, s.EncryptionEnabled, s.EncryptionPublicKey)
 	return err
 }
 
@@ -186,17 +196,36 @@ func ListBackups(databaseID int) ([]Backup, error) {
 	return backups, nil
 }
 
+// wake starts a sleeping database so its container can run pg_dump or psql
+func wake(databaseID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), suspend.WakeTimeout)
+	defer cancel()
+	if err := suspend.Wake(ctx, databaseID); err != nil {
+		return fmt.Errorf("failed to wake database: %w", err)
+	}
+	return nil
+}
+
 func PerformBackup(databaseID int) error {
+	_, err := BackupNow(databaseID)
+	return err
+}
+
+// BackupNow runs a backup synchronously and returns the ID of its backup record
+func BackupNow(databaseID int) (int, error) {
 	settings, err := GetBackupSettings(databaseID)
 	if err != nil {
-		return fmt.Errorf("failed to get settings: %w", err)
+		return 0, fmt.Errorf("failed to get settings: %w", err)
 	}
 
 	// 1. Get Database Info
 	var dbName, containerID string
 	err = db.DB.QueryRow("SELECT name, container_id FROM databases WHERE id = ?", databaseID).Scan(&dbName, &containerID)
 	if err != nil {
-		return fmt.Errorf("database not found: %w", err)
+		return 0, fmt.Errorf("database not found: %w", err)
+	}
+	if err := wake(databaseID); err != nil {
+		return 0, err
 	}
 
 	// 2. Prepare S3 Client
@@ -210,14 +239,14 @@ func PerformBackup(databaseID int) error {
 		BucketLookup: minio.BucketLookupPath,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to create s3 client: %w", err)
+		return 0, fmt.Errorf("failed to create s3 client: %w", err)
 	}
 
 	// 3. Prepare Docker Execution
 	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := docker.NewDatabaseClient(databaseID)
 	if err != nil {
-		return fmt.Errorf("failed to create docker client: %w", err)
+		return 0, err
 	}
 	defer cli.Close()
 
@@ -225,7 +254,7 @@ func PerformBackup(databaseID int) error {
 	filename := fmt.Sprintf("%s_%s.sql", dbName, time.Now().Format("20060102_150405"))
 	isEncrypted := settings.EncryptionEnabled && strings.TrimSpace(settings.EncryptionPublicKey) != ""
 	if settings.EncryptionEnabled && strings.TrimSpace(settings.EncryptionPublicKey) == "" {
-		return fmt.Errorf("backup encryption is enabled but no public key is configured")
+		return 0, fmt.Errorf("backup encryption is enabled but no public key is configured")
 	}
 	if isEncrypted {
 		filename += ".gpg"
@@ -242,7 +271,7 @@ func PerformBackup(databaseID int) error {
 		VALUES (?, ?, ?, ?, 'pending', CURRENT_TIMESTAMP)
 	`, databaseID, filename, objectName, isEncrypted)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	backupID, _ := res.LastInsertId()
 
@@ -266,13 +295,13 @@ func PerformBackup(databaseID int) error {
 	execID, err := cli.ContainerExecCreate(ctx, containerID, execConfig)
 	if err != nil {
 		updateStatus("failed", "Docker exec create failed: "+err.Error(), 0, "")
-		return err
+		return 0, err
 	}
 
 	resp, err := cli.ContainerExecAttach(ctx, execID.ID, container.ExecStartOptions{})
 	if err != nil {
 		updateStatus("failed", "Docker exec attach failed: "+err.Error(), 0, "")
-		return err
+		return 0, err
 	}
 	defer resp.Close()
 
@@ -324,14 +353,14 @@ func PerformBackup(databaseID int) error {
 
 	if uploadErr != nil {
 		updateStatus("failed", "S3 Upload failed: "+uploadErr.Error(), 0, "")
-		return uploadErr
+		return 0, uploadErr
 	}
 
 	// 7. Success
 	s3Url := fmt.Sprintf("%s/%s/%s", settings.Endpoint, settings.Bucket, objectName)
 	updateStatus("completed", "", uploadInfo.Size, s3Url)
 
-	return nil
+	return int(backupID), nil
 }
 
 func getBackupObjectReader(databaseID int, backupID int) (*Backup, io.ReadCloser, error) {
@@ -468,12 +497,15 @@ func RestoreFromFile(databaseID int, fileContent io.Reader) error {
 	if err != nil {
 		return fmt.Errorf("database not found: %w", err)
 	}
+	if err := wake(databaseID); err != nil {
+		return err
+	}
 
 	// 2. Docker Client
 	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := docker.NewDatabaseClient(databaseID)
 	if err != nil {
-		return fmt.Errorf("failed to create docker client: %w", err)
+		return err
 	}
 	defer cli.Close()
 
@@ -578,12 +610,15 @@ func RestoreFromConnection(databaseID int, connectionString string) error {
 	if err != nil {
 		return fmt.Errorf("database not found: %w", err)
 	}
+	if err := wake(databaseID); err != nil {
+		return err
+	}
 
 	// 2. Docker Client
 	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := docker.NewDatabaseClient(databaseID)
 	if err != nil {
-		return fmt.Errorf("failed to create docker client: %w", err)
+		return err
 	}
 	defer cli.Close()
 
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"baseful/auth"
+	"baseful/db"
+	"baseful/secrets"
+)
+
+// runCommand runs a maintenance subcommand such as `baseful reset-password`.
+// It reports false when the arguments do not name a command so the server
+// starts as usual.
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	var err error
+	switch args[0] {
+	case "generate-master-key":
+		var key string
+		if key, err = secrets.GenerateKey(); err == nil {
+			fmt.Println(key)
+		}
+	case "rotate-master-key":
+		err = rotateMasterKey()
+	case "reset-password":
+		err = resetPassword(args[1:])
+	default:
+		return false
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// rotateMasterKey re-encrypts every stored secret with a new master key. With
+// a key file the new key is generated here; with MASTER_KEY the operator sets
+// the new key and lists the old one in MASTER_KEY_PREVIOUS before running it.
+// Running servers keep the keys they loaded at startup, so it refuses to run
+// until they are stopped.
+func rotateMasterKey() error {
+	unlock, err := db.LockExclusive()
+	if errors.Is(err, db.ErrServerRunning) {
+		return fmt.Errorf("%w; stop the server first, then run rotate-master-key again", err)
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := db.InitDB(); err != nil {
+		return err
+	}
+
+	if secrets.UsesKeyFile() {
+		id, err := secrets.AddKeyToFile()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Generated master key %s\n", id)
+	} else if os.Getenv("MASTER_KEY_PREVIOUS") == "" {
+		return fmt.Errorf("set MASTER_KEY to the new key and MASTER_KEY_PREVIOUS to the old one")
+	}
+
+	count, err := db.EncryptStoredSecrets(true)
+	if err != nil {
+		return fmt.Errorf("failed to re-encrypt secrets (previous keys are still configured): %w", err)
+	}
+	fmt.Printf("Re-encrypted %d stored secrets\n", count)
+
+	if secrets.UsesKeyFile() {
+		if err := secrets.RetireOldKeys(); err != nil {
+			return err
+		}
+		fmt.Println("Removed retired keys from the master key file")
+	} else {
+		fmt.Println("All secrets use the new key; MASTER_KEY_PREVIOUS can now be removed")
+	}
+	fmt.Println("Start the server again to use the new key")
+	return nil
+}
+
+// resetPassword prints a single-use password reset link for an account. It is
+// the recovery path for a locked out admin: only someone with shell access to
+// the server can run it, and nothing changes until the link is used.
+func resetPassword(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: baseful reset-password <email>")
+	}
+	if err := db.InitDB(); err != nil {
+		return err
+	}
+
+	user, err := db.GetUserByEmail(args[0])
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("no user with email %s", args[0])
+	}
+	link, err := auth.PasswordResetLink(user, db.GetSMTPSettings())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Password reset link for %s (valid for %s, works once):\n%s\n", user.Email, auth.PasswordResetTTL, link)
+	return nil
+}
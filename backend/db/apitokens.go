@@ -0,0 +1,273 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// APIToken is a personal access token or a service account token. Only the
+// hash of the secret is stored; the prefix identifies it in listings.
+type APIToken struct {
+	ID               int      `json:"id"`
+	UserID           int      `json:"-"`
+	ServiceAccountID int      `json:"serviceAccountId,omitempty"`
+	CreatedBy        int      `json:"createdBy"`
+	Name             string   `json:"name"`
+	Prefix           string   `json:"prefix"`
+	Scopes           []string `json:"scopes"`
+	ExpiresAt        string   `json:"expiresAt"`
+	LastUsedAt       string   `json:"lastUsedAt"`
+	LastUsedIP       string   `json:"lastUsedIp"`
+	CreatedAt        string   `json:"createdAt"`
+	Expired          bool     `json:"expired"`
+	Revoked          bool     `json:"-"`
+}
+
+// ServiceAccount is a non-human member of a project that authenticates with API tokens
+type ServiceAccount struct {
+	ID          int        `json:"id"`
+	ProjectID   int        `json:"projectId"`
+	UserID      int        `json:"userId"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Role        string     `json:"role"`
+	CreatedBy   int        `json:"createdBy"`
+	CreatedAt   string     `json:"createdAt"`
+	Tokens      []APIToken `json:"tokens"`
+}
+
+const apiTokenColumns = `id, user_id, COALESCE(service_account_id, 0), COALESCE(created_by, 0), name, token_prefix, scopes,
+	COALESCE(strftime('%Y-%m-%dT%H:%M:%SZ', expires_at), ''), COALESCE(strftime('%Y-%m-%dT%H:%M:%SZ', last_used_at), ''),
+	COALESCE(last_used_ip, ''), created_at,
+	COALESCE(expires_at <= datetime('now'), 0), revoked_at IS NOT NULL`
+
+func scanAPIToken(row interface{ Scan(...any) error }) (*APIToken, error) {
+	var t APIToken
+	var scopes string
+	err := row.Scan(&t.ID, &t.UserID, &t.ServiceAccountID, &t.CreatedBy, &t.Name, &t.Prefix, &scopes,
+		&t.ExpiresAt, &t.LastUsedAt, &t.LastUsedIP, &t.CreatedAt, &t.Expired, &t.Revoked)
+	if err != nil {
+		return nil, err
+	}
+	t.Scopes = splitList(scopes)
+	return &t, nil
+}
+
+// CreateAPIToken stores a new token. expiresAt may be nil for tokens that do not expire.
+func CreateAPIToken(userID, serviceAccountID, createdBy int, name, prefix, tokenHash string, scopes []string, expiresAt *time.Time) (int, error) {
+	var expires any
+	if expiresAt != nil {
+		expires = sqliteTime(*expiresAt)
+	}
+	var account any
+	if serviceAccountID > 0 {
+		account = serviceAccountID
+	}
+	result, err := DB.Exec(`
+		INSERT INTO api_tokens (user_id, service_account_id, created_by, name, token_prefix, token_hash, scopes, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, userID, account, createdBy, name, prefix, tokenHash, strings.Join(scopes, ","), expires)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create API token: %w", err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id), nil
+}
+
+// GetAPITokenByHash returns the token with the given secret hash, or nil
+func GetAPITokenByHash(tokenHash string) (*APIToken, error) {
+	t, err := scanAPIToken(DB.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?", tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+// TouchAPIToken records a use of a token. Writes are limited to one a minute per token.
+func TouchAPIToken(id int, ip string) error {
+	_, err := DB.Exec(`
+		UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = ?
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < datetime('now', '-1 minute'))
+	`, ip, id)
+	return err
+}
+
+// ListPersonalAccessTokens returns the unrevoked personal access tokens of a user
+func ListPersonalAccessTokens(userID int) ([]APIToken, error) {
+	return listAPITokens("WHERE user_id = ? AND service_account_id IS NULL AND revoked_at IS NULL", userID)
+}
+
+// ListServiceAccountTokens returns the unrevoked tokens of a service account
+func ListServiceAccountTokens(serviceAccountID int) ([]APIToken, error) {
+	return listAPITokens("WHERE service_account_id = ? AND revoked_at IS NULL", serviceAccountID)
+}
+
+func listAPITokens(where string, args ...any) ([]APIToken, error) {
+	rows, err := DB.Query("SELECT "+apiTokenColumns+" FROM api_tokens "+where+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokePersonalAccessToken revokes one of a user's personal access tokens. It
+// returns sql.ErrNoRows when the user has no such token.
+func RevokePersonalAccessToken(userID, id int) error {
+	return revokeAPITokens("id = ? AND user_id = ? AND service_account_id IS NULL", id, userID)
+}
+
+// RevokeServiceAccountToken revokes one token of a service account. It
+// returns sql.ErrNoRows when the account has no such token.
+func RevokeServiceAccountToken(serviceAccountID, id int) error {
+	return revokeAPITokens("id = ? AND service_account_id = ?", id, serviceAccountID)
+}
+
+func revokeAPITokens(where string, args ...any) error {
+	result, err := DB.Exec("UPDATE api_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE revoked_at IS NULL AND "+where, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateServiceAccount creates a service account and its backing user, and
+// adds it to the project with the given role. The backing user has no
+// password, so nobody can log in as it.
+func CreateServiceAccount(projectID int, name, description, role string, createdBy int, email string) (*ServiceAccount, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"INSERT INTO users (email, password_hash, first_name, last_name, is_admin, avatar_url) VALUES (?, '', ?, 'Service account', 0, '')",
+		email, name,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create service account user: %w", err)
+	}
+	userID, _ := result.LastInsertId()
+
+	result, err = tx.Exec(
+		"INSERT INTO service_accounts (project_id, user_id, name, description, created_by) VALUES (?, ?, ?, ?, ?)",
+		projectID, userID, name, description, createdBy,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create service account: %w", err)
+	}
+	id, _ := result.LastInsertId()
+
+	if _, err := tx.Exec(
+		"INSERT INTO project_members (project_id, user_id, role) VALUES (?, ?, ?)",
+		projectID, userID, role,
+	); err != nil {
+		return nil, fmt.Errorf("failed to add service account to project: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetServiceAccount(projectID, int(id))
+}
+
+// GetServiceAccount returns a service account of a project, or nil
+func GetServiceAccount(projectID, id int) (*ServiceAccount, error) {
+	accounts, err := listServiceAccounts("WHERE sa.project_id = ? AND sa.id = ?", projectID, id)
+	if err != nil || len(accounts) == 0 {
+		return nil, err
+	}
+	return &accounts[0], nil
+}
+
+// ListServiceAccounts returns the service accounts of a project with their tokens
+func ListServiceAccounts(projectID int) ([]ServiceAccount, error) {
+	return listServiceAccounts("WHERE sa.project_id = ?", projectID)
+}
+
+func listServiceAccounts(where string, args ...any) ([]ServiceAccount, error) {
+	rows, err := DB.Query(`
+		SELECT sa.id, sa.project_id, sa.user_id, sa.name, COALESCE(sa.description, ''), COALESCE(m.role, ''),
+			COALESCE(sa.created_by, 0), sa.created_at
+		FROM service_accounts sa
+		LEFT JOIN project_members m ON m.project_id = sa.project_id AND m.user_id = sa.user_id
+		`+where+`
+		ORDER BY sa.created_at
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	accounts := []ServiceAccount{}
+	for rows.Next() {
+		var a ServiceAccount
+		if err := rows.Scan(&a.ID, &a.ProjectID, &a.UserID, &a.Name, &a.Description, &a.Role, &a.CreatedBy, &a.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range accounts {
+		tokens, err := ListServiceAccountTokens(accounts[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		accounts[i].Tokens = tokens
+	}
+	return accounts, nil
+}
+
+// DeleteServiceAccount revokes the tokens of a service account, removes it
+// from its project and deletes it. The backing user is kept so audit entries
+// still resolve, but it can no longer authenticate.
+func DeleteServiceAccount(projectID, id int) error {
+	account, err := GetServiceAccount(projectID, id)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		return sql.ErrNoRows
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE api_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL", account.UserID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM project_members WHERE user_id = ?", account.UserID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM service_accounts WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IsServiceAccountUser reports whether a user is the backing user of a service account
+func IsServiceAccountUser(userID int) (bool, error) {
+	var exists bool
+	err := DB.QueryRow("SELECT EXISTS (SELECT 1 FROM service_accounts WHERE user_id = ?)", userID).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,61 @@
+package db
+
+import "database/sql"
+
+// ConfigChange is an entry in the history of a database's Postgres settings
+type ConfigChange struct {
+	ID         int    `json:"id"`
+	DatabaseID int    `json:"databaseId"`
+	Name       string `json:"name"`
+	OldValue   string `json:"oldValue"`
+	// NewValue is nil when the setting was reset to its default
+	NewValue  *string `json:"newValue"`
+	ChangedBy string  `json:"changedBy"`
+	CreatedAt string  `json:"createdAt"`
+}
+
+// RecordConfigChanges adds settings changed together to the history of a
+// database
+func RecordConfigChanges(databaseID int, changes []ConfigChange, changedBy string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, change := range changes {
+		_, err := tx.Exec("INSERT INTO database_config_changes (database_id, name, old_value, new_value, changed_by) VALUES (?, ?, ?, ?, ?)",
+			databaseID, change.Name, change.OldValue, change.NewValue, changedBy)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListConfigChanges returns the most recent settings changes of a database,
+// newest first, optionally only those of one setting
+func ListConfigChanges(databaseID int, name string, limit int) ([]ConfigChange, error) {
+	rows, err := DB.Query(`SELECT id, database_id, name, COALESCE(old_value, ''), new_value, COALESCE(changed_by, ''), created_at
+		FROM database_config_changes
+		WHERE database_id = ? AND (? = '' OR name = ?)
+		ORDER BY id DESC LIMIT ?`, databaseID, name, name, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []ConfigChange{}
+	for rows.Next() {
+		var c ConfigChange
+		var newValue sql.NullString
+		if err := rows.Scan(&c.ID, &c.DatabaseID, &c.Name, &c.OldValue, &newValue, &c.ChangedBy, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		if newValue.Valid {
+			c.NewValue = &newValue.String
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 
+	"baseful/secrets"
+
 	_ "modernc.org/sqlite"
 )
 
@@ -214,10 +216,251 @@ func InitDB() error {
 	DB.Exec("ALTER TABLE users ADD COLUMN last_name TEXT")
 	DB.Exec("ALTER TABLE users ADD COLUMN avatar_url TEXT")
 	DB.Exec("ALTER TABLE users ADD COLUMN openrouter_api_key TEXT")
+	DB.Exec("ALTER TABLE users ADD COLUMN oidc_subject TEXT")
+	DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc_subject ON users(oidc_subject) WHERE oidc_subject IS NOT NULL")
+	DB.Exec("ALTER TABLE users ADD COLUMN totp_secret TEXT")
+	DB.Exec("ALTER TABLE users ADD COLUMN totp_enabled BOOLEAN DEFAULT 0")
+	DB.Exec("ALTER TABLE users ADD COLUMN totp_last_counter INTEGER DEFAULT 0")
+	DB.Exec("ALTER TABLE users ADD COLUMN mfa_required BOOLEAN DEFAULT 0")
+	// Accounts that existed before email verification count as verified
+	DB.Exec("ALTER TABLE users ADD COLUMN email_verified BOOLEAN DEFAULT 1")
 	DB.Exec(`CREATE TABLE IF NOT EXISTS whitelisted_emails (
         email TEXT PRIMARY KEY,
         created_at DATETIME DEFAULT CURRENT_TIMESTAMP
     )`)
+	DB.Exec(`CREATE TABLE IF NOT EXISTS project_members (
+        project_id INTEGER NOT NULL,
+        user_id INTEGER NOT NULL,
+        role TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (project_id, user_id),
+        FOREIGN KEY (project_id) REFERENCES projects(id),
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    )`)
+	DB.Exec(`CREATE TABLE IF NOT EXISTS project_invitations (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        project_id INTEGER NOT NULL,
+        email TEXT NOT NULL,
+        role TEXT NOT NULL,
+        invited_by INTEGER,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE (project_id, email),
+        FOREIGN KEY (project_id) REFERENCES projects(id)
+    )`)
+	if err := MigrateProjectMembers(); err != nil {
+		fmt.Printf("Warning: Failed to migrate project members: %v\n", err)
+	}
+	DB.Exec(`CREATE TABLE IF NOT EXISTS project_llm_settings (
+        project_id INTEGER PRIMARY KEY,
+        provider TEXT NOT NULL,
+        base_url TEXT,
+        model TEXT,
+        api_key TEXT,
+        updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (project_id) REFERENCES projects(id)
+    )`)
+
+	// Two-factor authentication
+	DB.Exec(`CREATE TABLE IF NOT EXISTS user_recovery_codes (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        code_hash TEXT NOT NULL,
+        used_at DATETIME,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    )`)
+	DB.Exec(`CREATE TABLE IF NOT EXISTS webauthn_credentials (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        credential_id TEXT UNIQUE NOT NULL,
+        name TEXT,
+        data TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        last_used_at DATETIME,
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    )`)
+
+	// Logins waiting for a second factor whose token already started a session
+	DB.Exec(`CREATE TABLE IF NOT EXISTS used_mfa_tokens (
+        token_id TEXT PRIMARY KEY,
+        expires_at DATETIME NOT NULL
+    )`)
+
+	// Server-side login sessions; the refresh token rotates on every use
+	DB.Exec(`CREATE TABLE IF NOT EXISTS user_sessions (
+        id TEXT PRIMARY KEY,
+        user_id INTEGER NOT NULL,
+        refresh_token_hash TEXT NOT NULL,
+        previous_refresh_token_hash TEXT,
+        user_agent TEXT,
+        ip TEXT,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        last_seen_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        rotated_at DATETIME,
+        expires_at DATETIME NOT NULL,
+        revoked_at DATETIME,
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    )`)
+	DB.Exec("CREATE INDEX IF NOT EXISTS idx_user_sessions_user ON user_sessions(user_id)")
+
+	// API tokens for automation. Personal access tokens act as their user;
+	// service account tokens act as the account's backing user, which is a
+	// member of exactly one project.
+	DB.Exec(`CREATE TABLE IF NOT EXISTS service_accounts (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        project_id INTEGER NOT NULL,
+        user_id INTEGER UNIQUE NOT NULL,
+        name TEXT NOT NULL,
+        description TEXT,
+        created_by INTEGER,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (project_id) REFERENCES projects(id),
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    )`)
+	DB.Exec(`CREATE TABLE IF NOT EXISTS api_tokens (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        service_account_id INTEGER,
+        created_by INTEGER,
+        name TEXT NOT NULL,
+        token_prefix TEXT NOT NULL,
+        token_hash TEXT UNIQUE NOT NULL,
+        scopes TEXT NOT NULL,
+        expires_at DATETIME,
+        last_used_at DATETIME,
+        last_used_ip TEXT,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        revoked_at DATETIME,
+        FOREIGN KEY (user_id) REFERENCES users(id),
+        FOREIGN KEY (service_account_id) REFERENCES service_accounts(id)
+    )`)
+	DB.Exec("CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(user_id)")
+
+	// Single-use tokens sent by email: password resets, email verification
+	// and invitations. Invitation tokens have no user yet.
+	DB.Exec(`CREATE TABLE IF NOT EXISTS email_tokens (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER,
+        email TEXT NOT NULL,
+        purpose TEXT NOT NULL,
+        token_hash TEXT UNIQUE NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        expires_at DATETIME NOT NULL,
+        used_at DATETIME
+    )`)
+	DB.Exec("CREATE INDEX IF NOT EXISTS idx_email_tokens_email ON email_tokens(email, purpose)")
+
+	// Audit log; the triggers keep it append-only
+	DB.Exec(`CREATE TABLE IF NOT EXISTS audit_log (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        actor_id INTEGER,
+        actor_email TEXT,
+        action TEXT NOT NULL,
+        target_type TEXT,
+        target_id TEXT,
+        ip TEXT,
+        outcome TEXT NOT NULL,
+        status INTEGER,
+        details TEXT
+    )`)
+	DB.Exec(`CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at)`)
+	DB.Exec(`CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action)`)
+	DB.Exec(`CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+    BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`)
+	DB.Exec(`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+    BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`)
+
+	// Remote Docker hosts, reached over SSH or over TCP with TLS client certificates
+	DB.Exec("ALTER TABLE servers ADD COLUMN transport TEXT DEFAULT 'ssh'")
+	DB.Exec("ALTER TABLE servers ADD COLUMN docker_socket TEXT")
+	DB.Exec("ALTER TABLE servers ADD COLUMN ssh_private_key TEXT")
+	DB.Exec("ALTER TABLE servers ADD COLUMN ssh_host_key TEXT")
+	DB.Exec("ALTER TABLE servers ADD COLUMN tls_ca_cert TEXT")
+	DB.Exec("ALTER TABLE servers ADD COLUMN tls_cert TEXT")
+	DB.Exec("ALTER TABLE servers ADD COLUMN tls_key TEXT")
+	DB.Exec("ALTER TABLE servers ADD COLUMN public_host TEXT")
+
+	// Streaming replication read replicas. status follows the container like
+	// branches do; replication_state and the lag are sampled from the primary.
+	DB.Exec(`CREATE TABLE IF NOT EXISTS replicas (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        database_id INTEGER NOT NULL,
+        name TEXT NOT NULL,
+        container_id TEXT,
+        host TEXT,
+        port INTEGER DEFAULT 5432,
+        mapped_port INTEGER,
+        status TEXT DEFAULT 'running',
+        replication_state TEXT DEFAULT '',
+        lag_bytes INTEGER DEFAULT 0,
+        lag_seconds REAL DEFAULT 0,
+        lag_checked_at DATETIME,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (database_id) REFERENCES databases(id)
+    )`)
+	DB.Exec("ALTER TABLE databases ADD COLUMN replication_password TEXT")
+	DB.Exec("ALTER TABLE database_tokens ADD COLUMN target TEXT DEFAULT ''")
+
+	// High-availability mode keeps a standby replica that is promoted when the
+	// primary fails. ha_suspended pauses health checks while the database is
+	// stopped on purpose; database_events records failovers and their causes.
+	DB.Exec("ALTER TABLE replicas ADD COLUMN role TEXT DEFAULT 'read'")
+	DB.Exec("ALTER TABLE databases ADD COLUMN ha_enabled INTEGER DEFAULT 0")
+	DB.Exec("ALTER TABLE databases ADD COLUMN ha_suspended INTEGER DEFAULT 0")
+	DB.Exec(`CREATE TABLE IF NOT EXISTS database_events (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        database_id INTEGER NOT NULL,
+        type TEXT NOT NULL,
+        message TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    )`)
+	DB.Exec("CREATE INDEX IF NOT EXISTS idx_database_events_database ON database_events(database_id, created_at)")
+
+	// Scale-to-zero: databases idle for auto_suspend_minutes are put to sleep
+	// (0 keeps them running)
+	DB.Exec("ALTER TABLE databases ADD COLUMN auto_suspend_minutes INTEGER DEFAULT 0")
+	DB.Exec("ALTER TABLE databases ADD COLUMN suspended_at DATETIME")
+
+	// History of Postgres settings changed through the config API; a NULL
+	// new_value means the setting was reset to its default
+	DB.Exec(`CREATE TABLE IF NOT EXISTS database_config_changes (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        database_id INTEGER NOT NULL,
+        name TEXT NOT NULL,
+        old_value TEXT,
+        new_value TEXT,
+        changed_by TEXT,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    )`)
+	DB.Exec("CREATE INDEX IF NOT EXISTS idx_database_config_changes_database ON database_config_changes(database_id, created_at)")
+
+	// Image a database was created from; branches use the same one. Rows of
+	// earlier versions used postgres:<version>.
+	DB.Exec("ALTER TABLE databases ADD COLUMN flavor TEXT DEFAULT 'postgres'")
+	DB.Exec("ALTER TABLE databases ADD COLUMN image TEXT")
+
+	// Postgres roles created through the API keep their password here, so
+	// proxy tokens bound to a role can log in as it
+	DB.Exec(`CREATE TABLE IF NOT EXISTS database_roles (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        database_id INTEGER NOT NULL,
+        name TEXT NOT NULL,
+        password TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE(database_id, name)
+    )`)
+	DB.Exec("ALTER TABLE database_tokens ADD COLUMN role TEXT DEFAULT ''")
+
+	// Encrypt secrets stored in plaintext by earlier versions
+	if err := secrets.Init(); err != nil {
+		return fmt.Errorf("failed to load master key: %w", err)
+	}
+	if n, err := EncryptStoredSecrets(false); err != nil {
+		return fmt.Errorf("failed to encrypt stored secrets: %w", err)
+	} else if n > 0 {
+		fmt.Printf("Encrypted %d stored secrets\n", n)
+	}
 
 	return nil
 }
@@ -231,23 +474,33 @@ type DatabaseInfo struct {
 	MappedPort int
 	Password   string
 	Type       string
+	Status     string
+	// ServerHost is the address of the remote server the database runs on,
+	// where it is reached on MappedPort. Empty for local databases.
+	ServerHost string
 }
 
 // GetDatabaseByID returns database connection information for a given ID
 func GetDatabaseByID(databaseID int) (*DatabaseInfo, error) {
 	var dbInfo DatabaseInfo
 	err := DB.QueryRow(`
-		SELECT id, name, host, port, mapped_port, password, type
-		FROM databases
-		WHERE id = ?
+		SELECT d.id, d.name, d.host, d.port, d.mapped_port, d.password, d.type, COALESCE(d.status, ''),
+			COALESCE(NULLIF(s.public_host, ''), s.host, '')
+		FROM databases d
+		LEFT JOIN servers s ON s.id = d.server_id
+		WHERE d.id = ?
 	`, databaseID).Scan(
 		&dbInfo.ID, &dbInfo.Name, &dbInfo.Host,
-		&dbInfo.Port, &dbInfo.MappedPort, &dbInfo.Password, &dbInfo.Type,
+		&dbInfo.Port, &dbInfo.MappedPort, &dbInfo.Password, &dbInfo.Type, &dbInfo.Status,
+		&dbInfo.ServerHost,
 	)
 
 	if err != nil {
 		return nil, fmt.Errorf("database not found: %w", err)
 	}
+	if dbInfo.Password, err = secrets.Decrypt(dbInfo.Password); err != nil {
+		return nil, fmt.Errorf("failed to decrypt database password: %w", err)
+	}
 
 	return &dbInfo, nil
 }
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Purposes of tokens sent by email
+const (
+	EmailTokenPasswordReset = "password_reset"
+	EmailTokenVerification  = "email_verification"
+	EmailTokenInvitation    = "invitation"
+)
+
+// EmailToken is a single-use token that was sent to an email address
+type EmailToken struct {
+	ID      int
+	UserID  int // 0 for invitations
+	Email   string
+	Purpose string
+}
+
+// CreateEmailToken stores a token. Unused tokens with the same purpose for the
+// address stop working, so only the latest email is valid.
+func CreateEmailToken(userID int, email, purpose, tokenHash string, expiresAt time.Time) error {
+	if err := InvalidateEmailTokens(email, purpose); err != nil {
+		return err
+	}
+	var user interface{}
+	if userID > 0 {
+		user = userID
+	}
+	_, err := DB.Exec(
+		"INSERT INTO email_tokens (user_id, email, purpose, token_hash, expires_at) VALUES (?, ?, ?, ?, ?)",
+		user, strings.TrimSpace(email), purpose, tokenHash, sqliteTime(expiresAt),
+	)
+	return err
+}
+
+// UseEmailToken marks a valid token as used and returns it. It returns nil
+// for unknown, expired or already used tokens.
+func UseEmailToken(purpose, tokenHash string) (*EmailToken, error) {
+	var t EmailToken
+	err := DB.QueryRow(`
+		SELECT id, COALESCE(user_id, 0), email, purpose FROM email_tokens
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > datetime('now')
+	`, tokenHash, purpose).Scan(&t.ID, &t.UserID, &t.Email, &t.Purpose)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The used_at check makes concurrent redemptions of the same token fail
+	result, err := DB.Exec("UPDATE email_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = ? AND used_at IS NULL", t.ID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+// InvalidateEmailTokens stops every unused token with a purpose for an address
+func InvalidateEmailTokens(email, purpose string) error {
+	_, err := DB.Exec(
+		"UPDATE email_tokens SET used_at = CURRENT_TIMESTAMP WHERE email = ? AND purpose = ? AND used_at IS NULL",
+		strings.TrimSpace(email), purpose,
+	)
+	return err
+}
+
+// CleanupEmailTokens deletes tokens that expired more than a week ago
+func CleanupEmailTokens() (int64, error) {
+	result, err := DB.Exec("DELETE FROM email_tokens WHERE expires_at < datetime('now', '-7 days')")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// SetUserEmailVerified records whether a user confirmed their email address
+func SetUserEmailVerified(id int, verified bool) error {
+	_, err := DB.Exec("UPDATE users SET email_verified = ? WHERE id = ?", verified, id)
+	return err
+}
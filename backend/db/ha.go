@@ -0,0 +1,151 @@
+package db
+
+// Database event types recorded by the high-availability monitor
+const (
+	EventPrimaryUnhealthy = "primary_unhealthy"
+	EventPrimaryRecovered = "primary_recovered"
+	EventFailover         = "failover"
+	EventFailoverFailed   = "failover_failed"
+	EventStandbyCreated   = "standby_created"
+	EventStandbyFailed    = "standby_failed"
+	EventReplicasDetached = "replicas_detached"
+)
+
+// DatabaseEvent is an entry in the history of a database
+type DatabaseEvent struct {
+	ID         int    `json:"id"`
+	DatabaseID int    `json:"databaseId"`
+	Type       string `json:"type"`
+	Message    string `json:"message"`
+	CreatedAt  string `json:"createdAt"`
+}
+
+// HAStatus describes the high-availability mode of a database
+type HAStatus struct {
+	Enabled   bool     `json:"enabled"`
+	Suspended bool     `json:"suspended"`
+	Standby   *Replica `json:"standby"`
+}
+
+// HADatabases returns the databases whose primaries are health-checked: HA
+// mode is on and the database was not stopped on purpose
+func HADatabases() ([]int, error) {
+	rows, err := DB.Query("SELECT id FROM databases WHERE COALESCE(ha_enabled, 0) = 1 AND COALESCE(ha_suspended, 0) = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetHAStatus returns the high-availability mode of a database
+func GetHAStatus(databaseID int) (*HAStatus, error) {
+	var status HAStatus
+	err := DB.QueryRow("SELECT COALESCE(ha_enabled, 0), COALESCE(ha_suspended, 0) FROM databases WHERE id = ?",
+		databaseID).Scan(&status.Enabled, &status.Suspended)
+	if err != nil {
+		return nil, err
+	}
+	if status.Standby, err = GetStandby(databaseID); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// SetHAEnabled turns high-availability mode of a database on or off
+func SetHAEnabled(databaseID int, enabled bool) error {
+	_, err := DB.Exec("UPDATE databases SET ha_enabled = ? WHERE id = ?", enabled, databaseID)
+	return err
+}
+
+// SetHASuspended pauses or resumes health checks of a database, e.g. while
+// it is stopped on purpose
+func SetHASuspended(databaseID int, suspended bool) error {
+	_, err := DB.Exec("UPDATE databases SET ha_suspended = ? WHERE id = ?", suspended, databaseID)
+	return err
+}
+
+// PromoteReplica makes a replica the primary of its database: the database
+// row takes over its container, and the replica row goes away
+func PromoteReplica(r *Replica) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE databases SET container_id = ?, host = ?, port = ?, mapped_port = ?, status = 'active' WHERE id = ?",
+		r.ContainerID, r.Host, r.Port, r.MappedPort, r.DatabaseID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM replicas WHERE id = ?", r.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordEvent adds an entry to the history of a database
+func RecordEvent(databaseID int, eventType, message string) error {
+	_, err := DB.Exec("INSERT INTO database_events (database_id, type, message) VALUES (?, ?, ?)",
+		databaseID, eventType, message)
+	return err
+}
+
+// ListEvents returns the most recent events of a database, newest first
+func ListEvents(databaseID, limit int) ([]DatabaseEvent, error) {
+	rows, err := DB.Query(`SELECT id, database_id, type, message, created_at FROM database_events
+		WHERE database_id = ? ORDER BY id DESC LIMIT ?`, databaseID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []DatabaseEvent{}
+	for rows.Next() {
+		var e DatabaseEvent
+		if err := rows.Scan(&e.ID, &e.DatabaseID, &e.Type, &e.Message, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// AlertRecipients returns who is told about events of a database: the owners
+// and admins of its project, or the instance admins for databases outside
+// projects. Service accounts are left out.
+func AlertRecipients(databaseID int) ([]string, error) {
+	rows, err := DB.Query(`
+		SELECT u.email FROM databases d
+		JOIN project_members m ON m.project_id = d.project_id AND m.role IN (?, ?)
+		JOIN users u ON u.id = m.user_id
+		WHERE d.id = ? AND NOT EXISTS (SELECT 1 FROM service_accounts sa WHERE sa.user_id = u.id)
+		UNION
+		SELECT u.email FROM databases d, users u
+		WHERE d.id = ? AND COALESCE(d.project_id, 0) = 0 AND u.is_admin = 1
+	`, RoleOwner, RoleAdmin, databaseID, databaseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []string{}
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+	return emails, rows.Err()
+}
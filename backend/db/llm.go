@@ -0,0 +1,82 @@
+package db
+
+import (
+	"database/sql"
+
+	"baseful/secrets"
+)
+
+// LLMSettings configures the language model used by the SQL assistant
+type LLMSettings struct {
+	Provider string `json:"provider"`
+	BaseURL  string `json:"baseUrl"`
+	Model    string `json:"model"`
+	APIKey   string `json:"-"`
+}
+
+// GetInstanceLLMSettings returns the instance-wide LLM defaults from the settings table
+func GetInstanceLLMSettings() LLMSettings {
+	provider, _ := GetSetting("llm_provider")
+	baseURL, _ := GetSetting("llm_base_url")
+	model, _ := GetSetting("llm_model")
+	apiKey, _ := GetSecretSetting("llm_api_key")
+	return LLMSettings{Provider: provider, BaseURL: baseURL, Model: model, APIKey: apiKey}
+}
+
+// UpdateInstanceLLMSettings stores the instance-wide LLM defaults
+func UpdateInstanceLLMSettings(settings LLMSettings) error {
+	values := map[string]string{
+		"llm_provider": settings.Provider,
+		"llm_base_url": settings.BaseURL,
+		"llm_model":    settings.Model,
+	}
+	for key, value := range values {
+		if err := UpdateSetting(key, value); err != nil {
+			return err
+		}
+	}
+	return UpdateSecretSetting("llm_api_key", settings.APIKey)
+}
+
+// GetProjectLLMSettings returns a project's LLM override, or nil if it has none
+func GetProjectLLMSettings(projectID int) (*LLMSettings, error) {
+	var settings LLMSettings
+	var baseURL, model, apiKey sql.NullString
+	err := DB.QueryRow(
+		"SELECT provider, base_url, model, api_key FROM project_llm_settings WHERE project_id = ?",
+		projectID,
+	).Scan(&settings.Provider, &baseURL, &model, &apiKey)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	settings.BaseURL = baseURL.String
+	settings.Model = model.String
+	settings.APIKey, err = secrets.Decrypt(apiKey.String)
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// UpdateProjectLLMSettings creates or replaces a project's LLM override
+func UpdateProjectLLMSettings(projectID int, settings LLMSettings) error {
+	apiKey, err := secrets.Encrypt(settings.APIKey)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(`
+		INSERT OR REPLACE INTO project_llm_settings (project_id, provider, base_url, model, api_key, updated_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`, projectID, settings.Provider, settings.BaseURL, settings.Model, apiKey)
+	return err
+}
+
+// DeleteProjectLLMSettings removes a project's LLM override so it uses the instance defaults
+func DeleteProjectLLMSettings(projectID int) error {
+	_, err := DB.Exec("DELETE FROM project_llm_settings WHERE project_id = ?", projectID)
+	return err
+}
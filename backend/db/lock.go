@@ -0,0 +1,70 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrServerRunning is returned by LockExclusive while a server uses the database
+var ErrServerRunning = errors.New("a Baseful server is using the database")
+
+var serverLock *os.File
+
+// LockShared marks the database as in use for as long as the process runs.
+// Servers and standalone proxies take the lock shared; maintenance commands
+// that cannot run next to them take it with LockExclusive. It waits while
+// such a command holds the lock.
+//
+// With DB_READ_ONLY the data directory may be mounted read-only, so the lock
+// file is opened read-only and skipped when the server has not created it.
+func LockShared() error {
+	path := lockPath()
+	var f *os.File
+	var err error
+	if os.Getenv("DB_READ_ONLY") == "true" {
+		f, err = os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+	} else {
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	if err := lockFile(f, false, true); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	serverLock = f
+	return nil
+}
+
+// LockExclusive takes the database lock when no server holds it, and returns
+// ErrServerRunning otherwise. Call the returned function to release it.
+func LockExclusive() (func(), error) {
+	path := lockPath()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	err = lockFile(f, true, false)
+	if errors.Is(err, errLocked) {
+		f.Close()
+		return nil, ErrServerRunning
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return func() { f.Close() }, nil
+}
+
+func lockPath() string {
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "./data.db"
+	}
+	return dbPath + ".lock"
+}
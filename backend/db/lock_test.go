@@ -0,0 +1,60 @@
+//go:build unix
+
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func releaseServerLock(t *testing.T) {
+	t.Cleanup(func() {
+		if serverLock != nil {
+			serverLock.Close()
+			serverLock = nil
+		}
+	})
+}
+
+func TestLockSharedReadOnlyWithoutLockFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DB_PATH", filepath.Join(dir, "data.db"))
+	t.Setenv("DB_READ_ONLY", "true")
+	releaseServerLock(t)
+
+	if err := LockShared(); err != nil {
+		t.Fatalf("LockShared() = %v, want nil", err)
+	}
+	if _, err := os.Stat(lockPath()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("lock file was created in read-only mode: %v", err)
+	}
+}
+
+func TestLockSharedReadOnlyBlocksRotation(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DB_PATH", filepath.Join(dir, "data.db"))
+	if err := os.WriteFile(lockPath(), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DB_READ_ONLY", "true")
+	releaseServerLock(t)
+
+	if err := LockShared(); err != nil {
+		t.Fatalf("LockShared() = %v, want nil", err)
+	}
+	if _, err := LockExclusive(); !errors.Is(err, ErrServerRunning) {
+		t.Fatalf("LockExclusive() = %v, want ErrServerRunning", err)
+	}
+}
+
+func TestLockExclusiveWithoutServer(t *testing.T) {
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "data.db"))
+
+	unlock, err := LockExclusive()
+	if err != nil {
+		t.Fatalf("LockExclusive() = %v, want nil", err)
+	}
+	unlock()
+}
@@ -0,0 +1,222 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Project roles, from most to least privileged
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleDeveloper = "developer"
+	RoleViewer    = "viewer"
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleDeveloper: 2, RoleAdmin: 3, RoleOwner: 4}
+
+// IsValidRole reports whether role is a known project role
+func IsValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+// RoleAtLeast reports whether role grants at least the privileges of required
+func RoleAtLeast(role, required string) bool {
+	return roleRank[role] > 0 && roleRank[role] >= roleRank[required]
+}
+
+// ProjectMember is a user's membership in a project
+type ProjectMember struct {
+	UserID         int    `json:"userId"`
+	Email          string `json:"email"`
+	FirstName      string `json:"firstName"`
+	LastName       string `json:"lastName"`
+	Role           string `json:"role"`
+	CreatedAt      string `json:"createdAt"`
+	ServiceAccount bool   `json:"serviceAccount"` // Backing user of a service account
+}
+
+// ProjectInvitation is a pending invitation for an email that has no account yet
+type ProjectInvitation struct {
+	ID        int    `json:"id"`
+	ProjectID int    `json:"projectId"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	InvitedBy int    `json:"invitedBy"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// MigrateProjectMembers gives existing users access to projects created before
+// memberships existed, so upgrading does not lock anyone out. It runs once.
+func MigrateProjectMembers() error {
+	if done, _ := GetSetting("project_members_migrated"); done == "true" {
+		return nil
+	}
+	_, err := DB.Exec(`
+		INSERT OR IGNORE INTO project_members (project_id, user_id, role)
+		SELECT p.id, u.id, CASE WHEN u.is_admin THEN 'owner' ELSE 'developer' END
+		FROM projects p CROSS JOIN users u
+		WHERE NOT EXISTS (SELECT 1 FROM project_members m WHERE m.project_id = p.id)
+	`)
+	if err != nil {
+		return err
+	}
+	return UpdateSetting("project_members_migrated", "true")
+}
+
+// GetProjectRole returns a user's role in a project, or "" if they are not a member
+func GetProjectRole(projectID, userID int) (string, error) {
+	var role string
+	err := DB.QueryRow(
+		"SELECT role FROM project_members WHERE project_id = ? AND user_id = ?",
+		projectID, userID,
+	).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// GetUserProjectRoles returns the user's role in every project they belong to
+func GetUserProjectRoles(userID int) (map[int]string, error) {
+	rows, err := DB.Query("SELECT project_id, role FROM project_members WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := map[int]string{}
+	for rows.Next() {
+		var projectID int
+		var role string
+		if err := rows.Scan(&projectID, &role); err != nil {
+			return nil, err
+		}
+		roles[projectID] = role
+	}
+	return roles, rows.Err()
+}
+
+// GetDatabaseProjectID returns the project a database belongs to (0 for none)
+func GetDatabaseProjectID(databaseID string) (int, error) {
+	var projectID sql.NullInt64
+	err := DB.QueryRow("SELECT project_id FROM databases WHERE id = ?", databaseID).Scan(&projectID)
+	if err != nil {
+		return 0, err
+	}
+	return int(projectID.Int64), nil
+}
+
+// ListProjectMembers returns the members of a project
+func ListProjectMembers(projectID int) ([]ProjectMember, error) {
+	rows, err := DB.Query(`
+		SELECT u.id, u.email, COALESCE(u.first_name, ''), COALESCE(u.last_name, ''), m.role, m.created_at,
+			EXISTS (SELECT 1 FROM service_accounts sa WHERE sa.user_id = u.id)
+		FROM project_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.project_id = ?
+		ORDER BY m.created_at
+	`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []ProjectMember{}
+	for rows.Next() {
+		var m ProjectMember
+		if err := rows.Scan(&m.UserID, &m.Email, &m.FirstName, &m.LastName, &m.Role, &m.CreatedAt, &m.ServiceAccount); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// SetProjectMember adds a user to a project or changes their role
+func SetProjectMember(projectID, userID int, role string) error {
+	_, err := DB.Exec(`
+		INSERT INTO project_members (project_id, user_id, role) VALUES (?, ?, ?)
+		ON CONFLICT(project_id, user_id) DO UPDATE SET role = excluded.role
+	`, projectID, userID, role)
+	return err
+}
+
+// RemoveProjectMember removes a user from a project
+func RemoveProjectMember(projectID, userID int) error {
+	_, err := DB.Exec("DELETE FROM project_members WHERE project_id = ? AND user_id = ?", projectID, userID)
+	return err
+}
+
+// CountProjectOwners returns how many owners a project has
+func CountProjectOwners(projectID int) (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM project_members WHERE project_id = ? AND role = 'owner'", projectID).Scan(&count)
+	return count, err
+}
+
+// CreateProjectInvitation records a pending invitation and whitelists the email so it can register
+func CreateProjectInvitation(projectID int, email, role string, invitedBy int) error {
+	email = strings.TrimSpace(email)
+	if err := AddEmailToWhitelist(email); err != nil {
+		return err
+	}
+	_, err := DB.Exec(`
+		INSERT INTO project_invitations (project_id, email, role, invited_by) VALUES (?, ?, ?, ?)
+		ON CONFLICT(project_id, email) DO UPDATE SET role = excluded.role, invited_by = excluded.invited_by
+	`, projectID, email, role, invitedBy)
+	return err
+}
+
+// ListProjectInvitations returns the pending invitations of a project
+func ListProjectInvitations(projectID int) ([]ProjectInvitation, error) {
+	rows, err := DB.Query(`
+		SELECT id, project_id, email, role, COALESCE(invited_by, 0), created_at
+		FROM project_invitations WHERE project_id = ? ORDER BY created_at DESC
+	`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []ProjectInvitation{}
+	for rows.Next() {
+		var inv ProjectInvitation
+		if err := rows.Scan(&inv.ID, &inv.ProjectID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+// DeleteProjectInvitation cancels a pending invitation
+func DeleteProjectInvitation(projectID, invitationID int) (bool, error) {
+	result, err := DB.Exec("DELETE FROM project_invitations WHERE id = ? AND project_id = ?", invitationID, projectID)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// AcceptProjectInvitations turns every pending invitation for an email into a membership
+func AcceptProjectInvitations(userID int, email string) error {
+	email = strings.TrimSpace(email)
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT OR IGNORE INTO project_members (project_id, user_id, role)
+		SELECT project_id, ?, role FROM project_invitations WHERE LOWER(email) = LOWER(?)
+	`, userID, email); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM project_invitations WHERE LOWER(email) = LOWER(?)", email); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,242 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"baseful/secrets"
+)
+
+// UserMFA is a user's second factor enrollment
+type UserMFA struct {
+	TOTPSecret      string `json:"-"`
+	TOTPEnabled     bool   `json:"totpEnabled"`
+	TOTPLastCounter int64  `json:"-"`
+	Required        bool   `json:"required"`
+	RecoveryCodes   int    `json:"recoveryCodesRemaining"`
+	WebAuthnCount   int    `json:"webauthnCredentials"`
+}
+
+// Enrolled reports whether the user has at least one second factor
+func (m UserMFA) Enrolled() bool {
+	return m.TOTPEnabled || m.WebAuthnCount > 0
+}
+
+// WebAuthnCredential is a registered security key or passkey. Data holds the
+// credential as serialized by the WebAuthn library.
+type WebAuthnCredential struct {
+	ID           int    `json:"id"`
+	UserID       int    `json:"-"`
+	CredentialID string `json:"credentialId"`
+	Name         string `json:"name"`
+	Data         string `json:"-"`
+	CreatedAt    string `json:"createdAt"`
+	LastUsedAt   string `json:"lastUsedAt"`
+}
+
+// GetUserMFA returns a user's second factor enrollment
+func GetUserMFA(userID int) (UserMFA, error) {
+	var m UserMFA
+	var secret sql.NullString
+	err := DB.QueryRow(`
+		SELECT totp_secret, COALESCE(totp_enabled, 0), COALESCE(totp_last_counter, 0), COALESCE(mfa_required, 0),
+			(SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = users.id AND used_at IS NULL),
+			(SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = users.id)
+		FROM users WHERE id = ?
+	`, userID).Scan(&secret, &m.TOTPEnabled, &m.TOTPLastCounter, &m.Required, &m.RecoveryCodes, &m.WebAuthnCount)
+	if err != nil {
+		return m, err
+	}
+	m.TOTPSecret, err = secrets.Decrypt(secret.String)
+	return m, err
+}
+
+// SetUserTOTPSecret stores a new, not yet confirmed TOTP secret
+func SetUserTOTPSecret(userID int, secret string) error {
+	secret, err := secrets.Encrypt(secret)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(
+		"UPDATE users SET totp_secret = ?, totp_enabled = 0, totp_last_counter = 0 WHERE id = ?",
+		secret, userID,
+	)
+	return err
+}
+
+// EnableUserTOTP marks the stored TOTP secret as confirmed
+func EnableUserTOTP(userID int, counter int64) error {
+	_, err := DB.Exec("UPDATE users SET totp_enabled = 1, totp_last_counter = ? WHERE id = ?", counter, userID)
+	return err
+}
+
+// UseTOTPCounter records the time step of an accepted code so it cannot be
+// replayed. It reports false when that step or a later one was already used.
+func UseTOTPCounter(userID int, counter int64) (bool, error) {
+	result, err := DB.Exec(
+		"UPDATE users SET totp_last_counter = ? WHERE id = ? AND COALESCE(totp_last_counter, 0) < ?",
+		counter, userID, counter,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// UseMFAPendingToken marks the token of a login waiting for its second factor
+// as used once the login completes. It reports false when the token was used
+// already, e.g. by a replayed request.
+func UseMFAPendingToken(tokenID string, expiresAt time.Time) (bool, error) {
+	if _, err := DB.Exec("DELETE FROM used_mfa_tokens WHERE expires_at < datetime('now')"); err != nil {
+		return false, err
+	}
+	result, err := DB.Exec("INSERT OR IGNORE INTO used_mfa_tokens (token_id, expires_at) VALUES (?, ?)",
+		tokenID, sqliteTime(expiresAt))
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// IsMFAPendingTokenUsed reports whether the token of a pending login already
+// completed it
+func IsMFAPendingTokenUsed(tokenID string) (bool, error) {
+	var exists bool
+	err := DB.QueryRow("SELECT EXISTS (SELECT 1 FROM used_mfa_tokens WHERE token_id = ?)", tokenID).Scan(&exists)
+	return exists, err
+}
+
+// DisableUserTOTP removes a user's TOTP secret and recovery codes
+func DisableUserTOTP(userID int) error {
+	if _, err := DB.Exec("UPDATE users SET totp_secret = NULL, totp_enabled = 0, totp_last_counter = 0 WHERE id = ?", userID); err != nil {
+		return err
+	}
+	_, err := DB.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID)
+	return err
+}
+
+// SetUserMFARequired enforces or relaxes two-factor authentication for a user
+func SetUserMFARequired(userID int, required bool) error {
+	_, err := DB.Exec("UPDATE users SET mfa_required = ? WHERE id = ?", required, userID)
+	return err
+}
+
+// ResetUserMFA removes every second factor of a user, e.g. after a lost device
+func ResetUserMFA(userID int) error {
+	if err := DisableUserTOTP(userID); err != nil {
+		return err
+	}
+	_, err := DB.Exec("DELETE FROM webauthn_credentials WHERE user_id = ?", userID)
+	return err
+}
+
+// IsMFARequiredInstanceWide reports whether admins require two-factor authentication for everyone
+func IsMFARequiredInstanceWide() bool {
+	value, _ := GetSetting("mfa_required")
+	return value == "true"
+}
+
+// ReplaceRecoveryCodes invalidates a user's recovery codes and stores new ones
+func ReplaceRecoveryCodes(userID int, codes []string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec(
+			"INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?, ?)",
+			userID, HashToken(normalizeRecoveryCode(code)),
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode consumes a recovery code. It reports false when the code is
+// unknown or was already used.
+func UseRecoveryCode(userID int, code string) (bool, error) {
+	result, err := DB.Exec(
+		"UPDATE user_recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		userID, HashToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// ListWebAuthnCredentials returns the security keys and passkeys of a user
+func ListWebAuthnCredentials(userID int) ([]WebAuthnCredential, error) {
+	rows, err := DB.Query(`
+		SELECT id, user_id, credential_id, COALESCE(name, ''), data, created_at, COALESCE(last_used_at, '')
+		FROM webauthn_credentials WHERE user_id = ? ORDER BY id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []WebAuthnCredential{}
+	for rows.Next() {
+		var cred WebAuthnCredential
+		if err := rows.Scan(&cred.ID, &cred.UserID, &cred.CredentialID, &cred.Name, &cred.Data, &cred.CreatedAt, &cred.LastUsedAt); err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, cred)
+	}
+	return credentials, rows.Err()
+}
+
+// GetWebAuthnCredentialOwner returns the user a credential ID belongs to, or 0
+func GetWebAuthnCredentialOwner(credentialID string) (int, error) {
+	var userID int
+	err := DB.QueryRow("SELECT user_id FROM webauthn_credentials WHERE credential_id = ?", credentialID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return userID, err
+}
+
+// AddWebAuthnCredential stores a newly registered credential
+func AddWebAuthnCredential(userID int, credentialID, name, data string) error {
+	_, err := DB.Exec(
+		"INSERT INTO webauthn_credentials (user_id, credential_id, name, data) VALUES (?, ?, ?, ?)",
+		userID, credentialID, name, data,
+	)
+	return err
+}
+
+// UpdateWebAuthnCredentialUse stores the credential state after a successful assertion
+func UpdateWebAuthnCredentialUse(credentialID, data string) error {
+	_, err := DB.Exec(
+		"UPDATE webauthn_credentials SET data = ?, last_used_at = CURRENT_TIMESTAMP WHERE credential_id = ?",
+		data, credentialID,
+	)
+	return err
+}
+
+// DeleteWebAuthnCredential removes one of a user's credentials
+func DeleteWebAuthnCredential(userID, id int) error {
+	result, err := DB.Exec("DELETE FROM webauthn_credentials WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"baseful/secrets"
+)
+
+// Replication states sampled from pg_stat_replication on the primary; an
+// empty state means the replica has not connected yet
+const (
+	ReplicationStreaming    = "streaming"
+	ReplicationCatchup      = "catchup"
+	ReplicationDisconnected = "disconnected"
+)
+
+// MaxReplicaLagBytes is how far a replica may fall behind the primary before
+// the proxy stops routing reads to it
+const MaxReplicaLagBytes = 16 << 20
+
+// Replica roles. Read replicas serve read-only sessions; the standby of a
+// database in high-availability mode is kept for failover instead.
+const (
+	ReplicaRoleRead    = "read"
+	ReplicaRoleStandby = "standby"
+)
+
+// TokenTargetReplica marks database tokens whose connections are routed to
+// read replicas
+const TokenTargetReplica = "replica"
+
+// Replica is a hot standby of a database, streaming WAL from its primary
+type Replica struct {
+	ID               int     `json:"id"`
+	DatabaseID       int     `json:"databaseId"`
+	Name             string  `json:"name"`
+	Role             string  `json:"role"`
+	ContainerID      string  `json:"containerId"`
+	Host             string  `json:"host"`
+	Port             int     `json:"port"`
+	MappedPort       int     `json:"mappedPort"`
+	Status           string  `json:"status"`
+	ReplicationState string  `json:"replicationState"`
+	LagBytes         int64   `json:"lagBytes"`
+	LagSeconds       float64 `json:"lagSeconds"`
+	LagCheckedAt     string  `json:"lagCheckedAt"`
+	CreatedAt        string  `json:"createdAt"`
+}
+
+// SlotName returns the replication slot that holds WAL for the replica
+func (r *Replica) SlotName() string {
+	return fmt.Sprintf("baseful_replica_%d", r.ID)
+}
+
+const replicaColumns = `id, database_id, name, COALESCE(role, 'read'), COALESCE(container_id, ''), COALESCE(host, ''), COALESCE(port, 5432),
+	COALESCE(mapped_port, 0), COALESCE(status, ''), COALESCE(replication_state, ''), COALESCE(lag_bytes, 0),
+	COALESCE(lag_seconds, 0), COALESCE(lag_checked_at, ''), created_at`
+
+func scanReplica(row interface{ Scan(...any) error }) (*Replica, error) {
+	var r Replica
+	err := row.Scan(&r.ID, &r.DatabaseID, &r.Name, &r.Role, &r.ContainerID, &r.Host, &r.Port,
+		&r.MappedPort, &r.Status, &r.ReplicationState, &r.LagBytes,
+		&r.LagSeconds, &r.LagCheckedAt, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func queryReplicas(query string, args ...any) ([]Replica, error) {
+	rows, err := DB.Query("SELECT "+replicaColumns+" FROM replicas "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	replicas := []Replica{}
+	for rows.Next() {
+		r, err := scanReplica(rows)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, *r)
+	}
+	return replicas, rows.Err()
+}
+
+// ListReplicas returns the replicas of a database
+func ListReplicas(databaseID int) ([]Replica, error) {
+	return queryReplicas("WHERE database_id = ? ORDER BY id", databaseID)
+}
+
+// HealthyReplicas returns the read replicas of a database that reads can be
+// routed to: running, streaming, recently sampled and not too far behind
+func HealthyReplicas(databaseID int) ([]Replica, error) {
+	return queryReplicas(`WHERE database_id = ? AND COALESCE(role, 'read') = ? AND status = 'running'
+		AND replication_state = ? AND lag_bytes <= ? AND lag_checked_at >= datetime('now', '-2 minutes') ORDER BY id`,
+		databaseID, ReplicaRoleRead, ReplicationStreaming, MaxReplicaLagBytes)
+}
+
+// GetStandby returns the standby of a database, or nil if it has none
+func GetStandby(databaseID int) (*Replica, error) {
+	r, err := scanReplica(DB.QueryRow("SELECT "+replicaColumns+" FROM replicas WHERE database_id = ? AND role = ? ORDER BY id LIMIT 1",
+		databaseID, ReplicaRoleStandby))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// GetReplica returns a replica of a database, or nil if it does not exist
+func GetReplica(databaseID, id int) (*Replica, error) {
+	r, err := scanReplica(DB.QueryRow("SELECT "+replicaColumns+" FROM replicas WHERE id = ? AND database_id = ?", id, databaseID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// CreateReplica stores a replica before its container exists, so its ID
+// can name the replication slot
+func CreateReplica(r *Replica) error {
+	if r.Role == "" {
+		r.Role = ReplicaRoleRead
+	}
+	result, err := DB.Exec("INSERT INTO replicas (database_id, name, role, port, status) VALUES (?, ?, ?, ?, ?)",
+		r.DatabaseID, r.Name, r.Role, r.Port, r.Status)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	r.ID = int(id)
+	return nil
+}
+
+// UpdateReplicaContainer records the container a replica runs in
+func UpdateReplicaContainer(r *Replica) error {
+	_, err := DB.Exec("UPDATE replicas SET container_id = ?, host = ?, mapped_port = ?, status = ? WHERE id = ?",
+		r.ContainerID, r.Host, r.MappedPort, r.Status, r.ID)
+	return err
+}
+
+// UpdateReplicaLag records a replication sample taken on the primary
+func UpdateReplicaLag(id int, state string, lagBytes int64, lagSeconds float64) error {
+	_, err := DB.Exec(`UPDATE replicas SET replication_state = ?, lag_bytes = ?, lag_seconds = ?,
+		lag_checked_at = CURRENT_TIMESTAMP WHERE id = ?`, state, lagBytes, lagSeconds, id)
+	return err
+}
+
+// DeleteReplica removes a replica
+func DeleteReplica(id int) error {
+	_, err := DB.Exec("DELETE FROM replicas WHERE id = ?", id)
+	return err
+}
+
+// GetReplicationPassword returns the password of the role replicas of a
+// database log in with, or "" if none was created yet
+func GetReplicationPassword(databaseID int) (string, error) {
+	var password string
+	err := DB.QueryRow("SELECT COALESCE(replication_password, '') FROM databases WHERE id = ?", databaseID).Scan(&password)
+	if err != nil {
+		return "", err
+	}
+	return secrets.Decrypt(password)
+}
+
+// SetReplicationPassword stores the replication role password of a database
+func SetReplicationPassword(databaseID int, password string) error {
+	encrypted, err := secrets.Encrypt(password)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec("UPDATE databases SET replication_password = ? WHERE id = ?", encrypted, databaseID)
+	return err
+}
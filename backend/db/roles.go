@@ -0,0 +1,84 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"baseful/secrets"
+)
+
+// SaveRolePassword stores the password of a Postgres role created or
+// changed through the API
+func SaveRolePassword(databaseID int, name, password string) error {
+	encrypted, err := secrets.Encrypt(password)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(`INSERT INTO database_roles (database_id, name, password) VALUES (?, ?, ?)
+		ON CONFLICT(database_id, name) DO UPDATE SET password = excluded.password`,
+		databaseID, name, encrypted)
+	return err
+}
+
+// GetRolePassword returns the stored password of a Postgres role, or
+// sql.ErrNoRows when Baseful does not know it
+func GetRolePassword(databaseID int, name string) (string, error) {
+	var password string
+	err := DB.QueryRow("SELECT password FROM database_roles WHERE database_id = ? AND name = ?",
+		databaseID, name).Scan(&password)
+	if err != nil {
+		return "", err
+	}
+	return secrets.Decrypt(password)
+}
+
+// StoredRoles returns the names of the roles of a database whose password
+// Baseful knows
+func StoredRoles(databaseID int) (map[string]bool, error) {
+	rows, err := DB.Query("SELECT name FROM database_roles WHERE database_id = ?", databaseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names[name] = true
+	}
+	return names, rows.Err()
+}
+
+// DeleteRole forgets a dropped role and revokes the tokens bound to it
+func DeleteRole(databaseID int, name string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM database_roles WHERE database_id = ? AND name = ?", databaseID, name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE database_tokens SET revoked = 1 WHERE database_id = ? AND role = ?", databaseID, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetTokenRole binds a proxy token to a role, or back to postgres when role
+// is empty. Its connections log in as that role from then on.
+func SetTokenRole(databaseID int, tokenID, role string) error {
+	result, err := DB.Exec("UPDATE database_tokens SET role = ? WHERE database_id = ? AND token_id = ?",
+		role, databaseID, tokenID)
+	if err != nil {
+		return fmt.Errorf("failed to bind token: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
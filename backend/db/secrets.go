@@ -0,0 +1,129 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"baseful/secrets"
+)
+
+// secretColumns lists the columns that hold encrypted values, with the
+// column that identifies their rows
+var secretColumns = []struct {
+	table, key, column string
+}{
+	{"databases", "id", "password"},
+	{"backup_settings", "database_id", "secret_key"},
+	{"users", "id", "openrouter_api_key"},
+	{"users", "id", "totp_secret"},
+	{"project_llm_settings", "project_id", "api_key"},
+	{"servers", "id", "ssh_private_key"},
+	{"servers", "id", "tls_key"},
+	{"databases", "id", "replication_password"},
+	{"database_roles", "id", "password"},
+}
+
+// secretSettings lists the settings that hold encrypted values
+var secretSettings = []string{"llm_api_key", "sso_client_secret", "smtp_password"}
+
+// GetSecretSetting returns the decrypted value of an encrypted setting
+func GetSecretSetting(key string) (string, error) {
+	value, err := GetSetting(key)
+	if err != nil {
+		return "", err
+	}
+	return secrets.Decrypt(value)
+}
+
+// UpdateSecretSetting encrypts and stores a setting
+func UpdateSecretSetting(key, value string) error {
+	encrypted, err := secrets.Encrypt(value)
+	if err != nil {
+		return err
+	}
+	return UpdateSetting(key, encrypted)
+}
+
+// EncryptStoredSecrets encrypts every secret that is not yet encrypted with
+// the current master key: plaintext rows written before encryption existed,
+// and with rotate also rows encrypted with a previous key. It returns how many
+// values were rewritten.
+func EncryptStoredSecrets(rotate bool) (int, error) {
+	count := 0
+	for _, sc := range secretColumns {
+		rows, err := DB.Query(fmt.Sprintf(
+			"SELECT %s, %s FROM %s WHERE %s IS NOT NULL AND %s != ''",
+			sc.key, sc.column, sc.table, sc.column, sc.column,
+		))
+		if err != nil {
+			return count, fmt.Errorf("failed to read %s.%s: %w", sc.table, sc.column, err)
+		}
+		type row struct {
+			key   any
+			value string
+		}
+		var pending []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.key, &r.value); err != nil {
+				rows.Close()
+				return count, err
+			}
+			if needsEncryption(r.value, rotate) {
+				pending = append(pending, r)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return count, err
+		}
+
+		for _, r := range pending {
+			encrypted, err := reencrypt(r.value)
+			if err != nil {
+				return count, fmt.Errorf("%s.%s (%s %v): %w", sc.table, sc.column, sc.key, r.key, err)
+			}
+			if _, err := DB.Exec(
+				fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ? AND %s = ?", sc.table, sc.column, sc.key, sc.column),
+				encrypted, r.key, r.value,
+			); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+
+	for _, key := range secretSettings {
+		value, err := GetSetting(key)
+		if err == sql.ErrNoRows || value == "" || !needsEncryption(value, rotate) {
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		encrypted, err := reencrypt(value)
+		if err != nil {
+			return count, fmt.Errorf("setting %s: %w", key, err)
+		}
+		if err := UpdateSetting(key, encrypted); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func needsEncryption(value string, rotate bool) bool {
+	if !secrets.IsEncrypted(value) {
+		return true
+	}
+	return rotate && !secrets.IsCurrent(value)
+}
+
+func reencrypt(value string) (string, error) {
+	plaintext, err := secrets.Decrypt(value)
+	if err != nil {
+		return "", err
+	}
+	return secrets.Encrypt(plaintext)
+}
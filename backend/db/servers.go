@@ -0,0 +1,154 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"baseful/secrets"
+)
+
+// Docker host transports
+const (
+	ServerTransportSSH = "ssh"
+	ServerTransportTLS = "tls"
+)
+
+// DefaultDockerSocket is the daemon socket used on SSH hosts
+const DefaultDockerSocket = "/var/run/docker.sock"
+
+// Server is a remote Docker host. SSH hosts are reached as Username with
+// SSHPrivateKey and must present SSHHostKey, pinned when the server is
+// registered. TLS hosts expose the Docker API on Port and require a client
+// certificate. Databases on a server are reached on PublicHost, or Host when
+// it is empty.
+type Server struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Host          string `json:"host"`
+	Port          int    `json:"port"`
+	Username      string `json:"username"`
+	Transport     string `json:"transport"`
+	DockerSocket  string `json:"dockerSocket"`
+	SSHPrivateKey string `json:"-"`
+	SSHHostKey    string `json:"sshHostKey"`
+	TLSCACert     string `json:"tlsCaCert"`
+	TLSCert       string `json:"tlsCert"`
+	TLSKey        string `json:"-"`
+	PublicHost    string `json:"publicHost"`
+	CreatedAt     string `json:"createdAt"`
+}
+
+// DatabaseHost returns the address database ports on this server are reached at
+func (s *Server) DatabaseHost() string {
+	if s.PublicHost != "" {
+		return s.PublicHost
+	}
+	return s.Host
+}
+
+const serverColumns = `id, name, host, COALESCE(port, 0), COALESCE(username, ''), COALESCE(transport, 'ssh'),
+	COALESCE(docker_socket, ''), COALESCE(ssh_private_key, ''), COALESCE(ssh_host_key, ''),
+	COALESCE(tls_ca_cert, ''), COALESCE(tls_cert, ''), COALESCE(tls_key, ''),
+	COALESCE(public_host, ''), created_at`
+
+func scanServer(row interface{ Scan(...any) error }) (*Server, error) {
+	var s Server
+	err := row.Scan(&s.ID, &s.Name, &s.Host, &s.Port, &s.Username, &s.Transport,
+		&s.DockerSocket, &s.SSHPrivateKey, &s.SSHHostKey,
+		&s.TLSCACert, &s.TLSCert, &s.TLSKey, &s.PublicHost, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if s.SSHPrivateKey, err = secrets.Decrypt(s.SSHPrivateKey); err != nil {
+		return nil, fmt.Errorf("failed to decrypt SSH key of server %d: %w", s.ID, err)
+	}
+	if s.TLSKey, err = secrets.Decrypt(s.TLSKey); err != nil {
+		return nil, fmt.Errorf("failed to decrypt TLS key of server %d: %w", s.ID, err)
+	}
+	if s.DockerSocket == "" && s.Transport == ServerTransportSSH {
+		s.DockerSocket = DefaultDockerSocket
+	}
+	return &s, nil
+}
+
+// ListServers returns every registered Docker host
+func ListServers() ([]Server, error) {
+	rows, err := DB.Query("SELECT " + serverColumns + " FROM servers ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	servers := []Server{}
+	for rows.Next() {
+		s, err := scanServer(rows)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, *s)
+	}
+	return servers, rows.Err()
+}
+
+// GetServer returns a Docker host, or nil if it does not exist
+func GetServer(id int) (*Server, error) {
+	s, err := scanServer(DB.QueryRow("SELECT "+serverColumns+" FROM servers WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return s, err
+}
+
+// CreateServer stores a Docker host, encrypting its keys
+func CreateServer(s *Server) error {
+	sshKey, err := secrets.Encrypt(s.SSHPrivateKey)
+	if err != nil {
+		return err
+	}
+	tlsKey, err := secrets.Encrypt(s.TLSKey)
+	if err != nil {
+		return err
+	}
+	result, err := DB.Exec(`
+		INSERT INTO servers (name, host, port, username, transport, docker_socket, ssh_private_key,
+			ssh_host_key, tls_ca_cert, tls_cert, tls_key, public_host)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.Name, s.Host, s.Port, s.Username, s.Transport, s.DockerSocket, sshKey,
+		s.SSHHostKey, s.TLSCACert, s.TLSCert, tlsKey, s.PublicHost)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	s.ID = int(id)
+	return nil
+}
+
+// UpdateServerHostKey pins a new SSH host key, e.g. after the host was reinstalled
+func UpdateServerHostKey(id int, hostKey string) error {
+	_, err := DB.Exec("UPDATE servers SET ssh_host_key = ? WHERE id = ?", hostKey, id)
+	return err
+}
+
+// CountServerDatabases returns how many databases run on a server
+func CountServerDatabases(id int) (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM databases WHERE server_id = ?", id).Scan(&count)
+	return count, err
+}
+
+// DeleteServer removes a Docker host
+func DeleteServer(id int) error {
+	_, err := DB.Exec("DELETE FROM servers WHERE id = ?", id)
+	return err
+}
+
+// GetDatabaseServerID returns the server a database runs on; 0 is the local
+// Docker daemon
+func GetDatabaseServerID(databaseID int) (int, error) {
+	var serverID int
+	err := DB.QueryRow("SELECT COALESCE(server_id, 0) FROM databases WHERE id = ?", databaseID).Scan(&serverID)
+	return serverID, err
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UserSession is a login session of a user. The access tokens issued for it
+// carry its ID and stop working as soon as it is revoked.
+type UserSession struct {
+	ID                       string `json:"id"`
+	UserID                   int    `json:"-"`
+	RefreshTokenHash         string `json:"-"`
+	PreviousRefreshTokenHash string `json:"-"`
+	UserAgent                string `json:"userAgent"`
+	IP                       string `json:"ip"`
+	CreatedAt                string `json:"createdAt"`
+	LastSeenAt               string `json:"lastSeenAt"`
+	ExpiresAt                string `json:"expiresAt"`
+	Revoked                  bool   `json:"-"`
+	RecentlyRotated          bool   `json:"-"` // Refresh token was rotated in the last 30 seconds
+}
+
+const userSessionColumns = `id, user_id, refresh_token_hash, COALESCE(previous_refresh_token_hash, ''),
+	COALESCE(user_agent, ''), COALESCE(ip, ''), created_at, last_seen_at, expires_at, revoked_at IS NOT NULL,
+	COALESCE(rotated_at > datetime('now', '-30 seconds'), 0)`
+
+func scanUserSession(row interface{ Scan(...any) error }) (*UserSession, error) {
+	var s UserSession
+	err := row.Scan(&s.ID, &s.UserID, &s.RefreshTokenHash, &s.PreviousRefreshTokenHash,
+		&s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &s.Revoked, &s.RecentlyRotated)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// CreateUserSession stores a new session
+func CreateUserSession(id string, userID int, refreshTokenHash, userAgent, ip string, expiresAt time.Time) error {
+	_, err := DB.Exec(
+		"INSERT INTO user_sessions (id, user_id, refresh_token_hash, user_agent, ip, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		id, userID, refreshTokenHash, userAgent, ip, sqliteTime(expiresAt),
+	)
+	return err
+}
+
+// GetUserSession returns a session, or nil if it does not exist
+func GetUserSession(id string) (*UserSession, error) {
+	s, err := scanUserSession(DB.QueryRow("SELECT "+userSessionColumns+" FROM user_sessions WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return s, err
+}
+
+// GetActiveUserSession returns a session that is neither revoked nor expired, or nil
+func GetActiveUserSession(id string) (*UserSession, error) {
+	s, err := scanUserSession(DB.QueryRow(
+		"SELECT "+userSessionColumns+" FROM user_sessions WHERE id = ? AND revoked_at IS NULL AND expires_at > datetime('now')",
+		id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return s, err
+}
+
+// RotateUserSessionRefreshToken replaces the refresh token of an active
+// session. It reports false when currentHash is no longer the session's
+// refresh token, e.g. because a concurrent request already rotated it.
+func RotateUserSessionRefreshToken(id, currentHash, newHash, ip string, expiresAt time.Time) (bool, error) {
+	result, err := DB.Exec(`
+		UPDATE user_sessions
+		SET refresh_token_hash = ?, previous_refresh_token_hash = refresh_token_hash,
+			ip = ?, last_seen_at = CURRENT_TIMESTAMP, rotated_at = CURRENT_TIMESTAMP, expires_at = ?
+		WHERE id = ? AND refresh_token_hash = ? AND revoked_at IS NULL AND expires_at > datetime('now')
+	`, newHash, ip, sqliteTime(expiresAt), id, currentHash)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// TouchUserSession records activity on a session. Writes are limited to one a
+// minute per session.
+func TouchUserSession(id, ip string) error {
+	_, err := DB.Exec(
+		"UPDATE user_sessions SET last_seen_at = CURRENT_TIMESTAMP, ip = ? WHERE id = ? AND last_seen_at < datetime('now', '-1 minute')",
+		ip, id,
+	)
+	return err
+}
+
+// ListUserSessions returns the active sessions of a user, most recently used first
+func ListUserSessions(userID int) ([]UserSession, error) {
+	rows, err := DB.Query(
+		"SELECT "+userSessionColumns+" FROM user_sessions WHERE user_id = ? AND revoked_at IS NULL AND expires_at > datetime('now') ORDER BY last_seen_at DESC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []UserSession{}
+	for rows.Next() {
+		s, err := scanUserSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeUserSession revokes one session of a user. It returns sql.ErrNoRows
+// when the user has no such active session.
+func RevokeUserSession(userID int, id string) error {
+	result, err := DB.Exec(
+		"UPDATE user_sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		id, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes every session of a user except exceptID, which
+// may be empty, and returns how many were revoked
+func RevokeUserSessions(userID int, exceptID string) (int64, error) {
+	result, err := DB.Exec(
+		"UPDATE user_sessions SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND id != ? AND revoked_at IS NULL",
+		userID, exceptID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// CleanupUserSessions deletes sessions that expired or were revoked more than
+// a week ago
+func CleanupUserSessions() (int64, error) {
+	result, err := DB.Exec(`
+		DELETE FROM user_sessions
+		WHERE expires_at < datetime('now', '-7 days') OR revoked_at < datetime('now', '-7 days')
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// sqliteTime formats a time like CURRENT_TIMESTAMP so it compares correctly
+// with datetime('now')
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
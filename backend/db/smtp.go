@@ -0,0 +1,85 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SMTP connection security modes
+const (
+	SMTPSecurityStartTLS = "starttls"
+	SMTPSecurityTLS      = "tls"
+	SMTPSecurityNone     = "none"
+)
+
+// SMTPSettings configures the mail server used for password resets,
+// email verification and invitations
+type SMTPSettings struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"-"`
+	From     string `json:"from"`
+	FromName string `json:"fromName"`
+	Security string `json:"security"`
+	// AppURL is the address of this instance used in links. Without it the
+	// configured domain is used.
+	AppURL string `json:"appUrl"`
+}
+
+// Configured reports whether enough settings are present to send mail
+func (s SMTPSettings) Configured() bool {
+	return s.Host != "" && s.From != ""
+}
+
+// GetSMTPSettings returns the mail server configuration from the settings table
+func GetSMTPSettings() SMTPSettings {
+	host, _ := GetSetting("smtp_host")
+	port, _ := GetSetting("smtp_port")
+	username, _ := GetSetting("smtp_username")
+	password, _ := GetSecretSetting("smtp_password")
+	from, _ := GetSetting("smtp_from")
+	fromName, _ := GetSetting("smtp_from_name")
+	security, _ := GetSetting("smtp_security")
+	appURL, _ := GetSetting("smtp_app_url")
+
+	settings := SMTPSettings{
+		Host:     host,
+		Username: username,
+		Password: password,
+		From:     from,
+		FromName: fromName,
+		Security: security,
+		AppURL:   appURL,
+	}
+	settings.Port, _ = strconv.Atoi(port)
+	if settings.Security == "" {
+		settings.Security = SMTPSecurityStartTLS
+	}
+	if settings.Port == 0 {
+		settings.Port = 587
+		if settings.Security == SMTPSecurityTLS {
+			settings.Port = 465
+		}
+	}
+	return settings
+}
+
+// UpdateSMTPSettings stores the mail server configuration
+func UpdateSMTPSettings(settings SMTPSettings) error {
+	values := map[string]string{
+		"smtp_host":      strings.TrimSpace(settings.Host),
+		"smtp_port":      strconv.Itoa(settings.Port),
+		"smtp_username":  settings.Username,
+		"smtp_from":      strings.TrimSpace(settings.From),
+		"smtp_from_name": settings.FromName,
+		"smtp_security":  settings.Security,
+		"smtp_app_url":   strings.TrimSuffix(strings.TrimSpace(settings.AppURL), "/"),
+	}
+	for key, value := range values {
+		if err := UpdateSetting(key, value); err != nil {
+			return err
+		}
+	}
+	return UpdateSecretSetting("smtp_password", settings.Password)
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// SSOSettings configures OpenID Connect single sign-on
+type SSOSettings struct {
+	Enabled        bool             `json:"enabled"`
+	Label          string           `json:"label"`
+	Issuer         string           `json:"issuer"`
+	ClientID       string           `json:"clientId"`
+	ClientSecret   string           `json:"-"`
+	RedirectURL    string           `json:"redirectUrl"`
+	Scopes         []string         `json:"scopes"`
+	AllowedDomains []string         `json:"allowedDomains"`
+	GroupsClaim    string           `json:"groupsClaim"`
+	AdminGroups    []string         `json:"adminGroups"`
+	RoleMappings   []SSORoleMapping `json:"roleMappings"`
+}
+
+// SSORoleMapping grants a project role to members of an identity provider group
+type SSORoleMapping struct {
+	Group     string `json:"group"`
+	ProjectID int    `json:"projectId"`
+	Role      string `json:"role"`
+}
+
+// GetSSOSettings returns the single sign-on configuration from the settings table
+func GetSSOSettings() SSOSettings {
+	enabled, _ := GetSetting("sso_enabled")
+	label, _ := GetSetting("sso_label")
+	issuer, _ := GetSetting("sso_issuer")
+	clientID, _ := GetSetting("sso_client_id")
+	clientSecret, _ := GetSecretSetting("sso_client_secret")
+	redirectURL, _ := GetSetting("sso_redirect_url")
+	scopes, _ := GetSetting("sso_scopes")
+	domains, _ := GetSetting("sso_allowed_domains")
+	groupsClaim, _ := GetSetting("sso_groups_claim")
+	adminGroups, _ := GetSetting("sso_admin_groups")
+	mappings, _ := GetSetting("sso_role_mappings")
+
+	settings := SSOSettings{
+		Enabled:        enabled == "true",
+		Label:          label,
+		Issuer:         issuer,
+		ClientID:       clientID,
+		ClientSecret:   clientSecret,
+		RedirectURL:    redirectURL,
+		Scopes:         splitList(scopes),
+		AllowedDomains: splitList(domains),
+		GroupsClaim:    groupsClaim,
+		AdminGroups:    splitList(adminGroups),
+		RoleMappings:   []SSORoleMapping{},
+	}
+	if mappings != "" {
+		_ = json.Unmarshal([]byte(mappings), &settings.RoleMappings)
+	}
+	return settings
+}
+
+// UpdateSSOSettings stores the single sign-on configuration
+func UpdateSSOSettings(settings SSOSettings) error {
+	mappings, err := json.Marshal(settings.RoleMappings)
+	if err != nil {
+		return err
+	}
+	enabled := "false"
+	if settings.Enabled {
+		enabled = "true"
+	}
+	values := map[string]string{
+		"sso_enabled":         enabled,
+		"sso_label":           settings.Label,
+		"sso_issuer":          settings.Issuer,
+		"sso_client_id":       settings.ClientID,
+		"sso_redirect_url":    settings.RedirectURL,
+		"sso_scopes":          strings.Join(settings.Scopes, ","),
+		"sso_allowed_domains": strings.Join(settings.AllowedDomains, ","),
+		"sso_groups_claim":    settings.GroupsClaim,
+		"sso_admin_groups":    strings.Join(settings.AdminGroups, ","),
+		"sso_role_mappings":   string(mappings),
+	}
+	for key, value := range values {
+		if err := UpdateSetting(key, value); err != nil {
+			return err
+		}
+	}
+	return UpdateSecretSetting("sso_client_secret", settings.ClientSecret)
+}
+
+// GetUserByOIDCSubject retrieves the user linked to an identity provider subject
+func GetUserByOIDCSubject(subject string) (*User, error) {
+	var email string
+	err := DB.QueryRow("SELECT email FROM users WHERE oidc_subject = ?", subject).Scan(&email)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return GetUserByEmail(email)
+}
+
+// ErrSubjectMismatch is returned when a user is already linked to another identity
+var ErrSubjectMismatch = errors.New("user is linked to a different identity")
+
+// LinkUserOIDCSubject links a user to an identity provider subject. A user that
+// is already linked to another subject is left unchanged.
+func LinkUserOIDCSubject(id int, subject string) error {
+	result, err := DB.Exec(
+		"UPDATE users SET oidc_subject = ? WHERE id = ? AND (oidc_subject IS NULL OR oidc_subject = '' OR oidc_subject = ?)",
+		subject, id, subject,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrSubjectMismatch
+	}
+	return nil
+}
+
+// CreateSSOUser creates a user that signs in through the identity provider.
+// The empty password hash never matches, so password login stays disabled.
+func CreateSSOUser(email, firstName, lastName, subject string, isAdmin bool) (int, error) {
+	result, err := DB.Exec(
+		"INSERT INTO users (email, password_hash, first_name, last_name, is_admin, avatar_url, oidc_subject) VALUES (?, '', ?, ?, ?, '', ?)",
+		email, firstName, lastName, isAdmin, subject,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, _ := result.LastInsertId()
+	return int(id), nil
+}
+
+// SetUserAdmin grants or removes instance admin rights
+func SetUserAdmin(id int, isAdmin bool) error {
+	_, err := DB.Exec("UPDATE users SET is_admin = ? WHERE id = ?", isAdmin, id)
+	return err
+}
+
+// CountAdmins returns the number of instance admins
+func CountAdmins() (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM users WHERE is_admin = 1").Scan(&count)
+	return count, err
+}
+
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,82 @@
+package db
+
+// StatusSleeping marks a database whose container was stopped because it sat
+// idle. Unlike a stopped database, it is started again on its next connection.
+const StatusSleeping = "sleeping"
+
+// Bounds of the auto-suspend delay, in minutes
+const (
+	MinAutoSuspendMinutes = 5
+	MaxAutoSuspendMinutes = 7 * 24 * 60
+)
+
+// AutoSuspendPolicy is how long a database may sit idle before it sleeps
+type AutoSuspendPolicy struct {
+	DatabaseID int `json:"databaseId"`
+	// Minutes without proxy activity before the database is suspended; 0
+	// keeps it running
+	Minutes     int    `json:"minutes"`
+	Status      string `json:"status"`
+	SuspendedAt string `json:"suspendedAt"`
+}
+
+// GetAutoSuspendPolicy returns the auto-suspend policy of a database
+func GetAutoSuspendPolicy(databaseID int) (*AutoSuspendPolicy, error) {
+	policy := AutoSuspendPolicy{DatabaseID: databaseID}
+	err := DB.QueryRow(`SELECT COALESCE(auto_suspend_minutes, 0), COALESCE(status, ''), COALESCE(suspended_at, '')
+		FROM databases WHERE id = ?`, databaseID).Scan(&policy.Minutes, &policy.Status, &policy.SuspendedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// SetAutoSuspendMinutes sets how long a database may sit idle before it sleeps
+func SetAutoSuspendMinutes(databaseID, minutes int) error {
+	_, err := DB.Exec("UPDATE databases SET auto_suspend_minutes = ? WHERE id = ?", minutes, databaseID)
+	return err
+}
+
+// AutoSuspendCandidates returns the policies of running databases that may be
+// suspended. Databases with replicas, including HA standbys, keep running.
+func AutoSuspendCandidates() ([]AutoSuspendPolicy, error) {
+	rows, err := DB.Query(`
+		SELECT d.id, d.auto_suspend_minutes FROM databases d
+		WHERE COALESCE(d.auto_suspend_minutes, 0) > 0 AND d.status = 'active' AND COALESCE(d.ha_enabled, 0) = 0
+			AND NOT EXISTS (SELECT 1 FROM replicas r WHERE r.database_id = d.id)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []AutoSuspendPolicy{}
+	for rows.Next() {
+		p := AutoSuspendPolicy{Status: "active"}
+		if err := rows.Scan(&p.DatabaseID, &p.Minutes); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+// MarkSleeping records that an active database is being suspended. It
+// reports false when the database is no longer active.
+func MarkSleeping(databaseID int) (bool, error) {
+	result, err := DB.Exec("UPDATE databases SET status = ?, suspended_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'active'",
+		StatusSleeping, databaseID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// MarkAwake records that a sleeping database runs again, on a possibly new
+// published port. The reconciler may have seen the container start already.
+func MarkAwake(databaseID, mappedPort int) error {
+	_, err := DB.Exec("UPDATE databases SET status = 'active', mapped_port = ?, suspended_at = NULL WHERE id = ? AND status IN (?, 'active')",
+		mappedPort, databaseID, StatusSleeping)
+	return err
+}
@@ -18,6 +18,8 @@ type TokenRecord struct {
 	ExpiresAt  time.Time
 	CreatedAt  time.Time
 	Revoked    bool
+	// Role the token's connections log in as; empty for postgres
+	Role string
 }
 
 // TokenInfo represents token information returned to the API
@@ -27,6 +29,8 @@ type TokenInfo struct {
 	CreatedAt time.Time `json:"createdAt"`
 	ExpiresAt time.Time `json:"expiresAt"`
 	Revoked   bool      `json:"revoked"`
+	Target    string    `json:"target"`
+	Role      string    `json:"role"`
 }
 
 // DatabaseTokensHasIssuedAt returns true when the migration has added issued_at.
@@ -62,17 +66,32 @@ func HashToken(token string) string {
 
 // CreateToken creates a new token record for a database
 func CreateToken(databaseID int, tokenID string, tokenHash string, issuedAt time.Time, expiresAt time.Time) (int, error) {
+	return createToken(databaseID, tokenID, "", "", tokenHash, issuedAt, expiresAt)
+}
+
+// CreateReplicaToken creates a token record whose connections go to read replicas
+func CreateReplicaToken(databaseID int, tokenID string, tokenHash string, issuedAt time.Time, expiresAt time.Time) (int, error) {
+	return createToken(databaseID, tokenID, TokenTargetReplica, "", tokenHash, issuedAt, expiresAt)
+}
+
+// CreateRoleToken creates a token record whose connections log in as a
+// Postgres role instead of postgres
+func CreateRoleToken(databaseID int, tokenID, role string, tokenHash string, issuedAt time.Time, expiresAt time.Time) (int, error) {
+	return createToken(databaseID, tokenID, "", role, tokenHash, issuedAt, expiresAt)
+}
+
+func createToken(databaseID int, tokenID, target, role, tokenHash string, issuedAt time.Time, expiresAt time.Time) (int, error) {
 	var result sql.Result
 	var err error
 	if DatabaseTokensHasIssuedAt() {
 		result, err = DB.Exec(
-			"INSERT INTO database_tokens (database_id, token_id, token_hash, issued_at, expires_at) VALUES (?, ?, ?, ?, ?)",
-			databaseID, tokenID, tokenHash, issuedAt, expiresAt,
+			"INSERT INTO database_tokens (database_id, token_id, token_hash, issued_at, expires_at, target, role) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			databaseID, tokenID, tokenHash, issuedAt, expiresAt, target, role,
 		)
 	} else {
 		result, err = DB.Exec(
-			"INSERT INTO database_tokens (database_id, token_id, token_hash, expires_at) VALUES (?, ?, ?, ?)",
-			databaseID, tokenID, tokenHash, expiresAt,
+			"INSERT INTO database_tokens (database_id, token_id, token_hash, expires_at, target, role) VALUES (?, ?, ?, ?, ?, ?)",
+			databaseID, tokenID, tokenHash, expiresAt, target, role,
 		)
 	}
 	if err != nil {
@@ -87,7 +106,8 @@ func CreateToken(databaseID int, tokenID string, tokenHash string, issuedAt time
 	return int(id), nil
 }
 
-// GetActiveTokenForDatabase returns the active (non-revoked) token for a database
+// GetActiveTokenForDatabase returns the active (non-revoked) primary token
+// for a database, which logs in as postgres
 func GetActiveTokenForDatabase(databaseID int) (*TokenRecord, error) {
 	var token TokenRecord
 	var err error
@@ -95,7 +115,7 @@ func GetActiveTokenForDatabase(databaseID int) (*TokenRecord, error) {
 		err = DB.QueryRow(`
 			SELECT id, database_id, token_id, token_hash, issued_at, expires_at, created_at, revoked
 			FROM database_tokens
-			WHERE database_id = ? AND revoked = 0 AND expires_at > datetime('now')
+			WHERE database_id = ? AND revoked = 0 AND expires_at > datetime('now') AND COALESCE(target, '') = '' AND COALESCE(role, '') = ''
 			ORDER BY created_at DESC
 			LIMIT 1
 		`, databaseID).Scan(
@@ -106,7 +126,7 @@ func GetActiveTokenForDatabase(databaseID int) (*TokenRecord, error) {
 		err = DB.QueryRow(`
 			SELECT id, database_id, token_id, token_hash, expires_at, created_at, revoked
 			FROM database_tokens
-			WHERE database_id = ? AND revoked = 0 AND expires_at > datetime('now')
+			WHERE database_id = ? AND revoked = 0 AND expires_at > datetime('now') AND COALESCE(target, '') = '' AND COALESCE(role, '') = ''
 			ORDER BY created_at DESC
 			LIMIT 1
 		`, databaseID).Scan(
@@ -129,21 +149,21 @@ func GetTokenByID(tokenID string) (*TokenRecord, error) {
 	var err error
 	if DatabaseTokensHasIssuedAt() {
 		err = DB.QueryRow(`
-			SELECT id, database_id, token_id, token_hash, issued_at, expires_at, created_at, revoked
+			SELECT id, database_id, token_id, token_hash, issued_at, expires_at, created_at, revoked, COALESCE(role, '')
 			FROM database_tokens
 			WHERE token_id = ?
 		`, tokenID).Scan(
 			&token.ID, &token.DatabaseID, &token.TokenID,
-			&token.TokenHash, &token.IssuedAt, &token.ExpiresAt, &token.CreatedAt, &token.Revoked,
+			&token.TokenHash, &token.IssuedAt, &token.ExpiresAt, &token.CreatedAt, &token.Revoked, &token.Role,
 		)
 	} else {
 		err = DB.QueryRow(`
-			SELECT id, database_id, token_id, token_hash, expires_at, created_at, revoked
+			SELECT id, database_id, token_id, token_hash, expires_at, created_at, revoked, COALESCE(role, '')
 			FROM database_tokens
 			WHERE token_id = ?
 		`, tokenID).Scan(
 			&token.ID, &token.DatabaseID, &token.TokenID,
-			&token.TokenHash, &token.ExpiresAt, &token.CreatedAt, &token.Revoked,
+			&token.TokenHash, &token.ExpiresAt, &token.CreatedAt, &token.Revoked, &token.Role,
 		)
 		token.IssuedAt = token.CreatedAt
 	}
@@ -158,7 +178,7 @@ func GetTokenByID(tokenID string) (*TokenRecord, error) {
 // GetTokensForDatabase returns all tokens for a database
 func GetTokensForDatabase(databaseID int) ([]TokenInfo, error) {
 	rows, err := DB.Query(`
-		SELECT id, token_id, created_at, expires_at, revoked
+		SELECT id, token_id, created_at, expires_at, revoked, COALESCE(target, ''), COALESCE(role, '')
 		FROM database_tokens
 		WHERE database_id = ?
 		ORDER BY created_at DESC
@@ -171,7 +191,7 @@ func GetTokensForDatabase(databaseID int) ([]TokenInfo, error) {
 	var tokens []TokenInfo
 	for rows.Next() {
 		var token TokenInfo
-		if err := rows.Scan(&token.ID, &token.TokenID, &token.CreatedAt, &token.ExpiresAt, &token.Revoked); err != nil {
+		if err := rows.Scan(&token.ID, &token.TokenID, &token.CreatedAt, &token.ExpiresAt, &token.Revoked, &token.Target, &token.Role); err != nil {
 			return nil, fmt.Errorf("failed to scan token: %w", err)
 		}
 		tokens = append(tokens, token)
@@ -3,18 +3,21 @@ package db
 import (
 	"database/sql"
 
+	"baseful/secrets"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	ID           int    `json:"id"`
-	Email        string `json:"email"`
-	PasswordHash string `json:"-"`
-	FirstName    string `json:"firstName"`
-	LastName     string `json:"lastName"`
-	IsAdmin      bool   `json:"isAdmin"`
-	AvatarURL    string `json:"avatarUrl"`
-	CreatedAt    string `json:"createdAt"`
+	ID            int    `json:"id"`
+	Email         string `json:"email"`
+	PasswordHash  string `json:"-"`
+	FirstName     string `json:"firstName"`
+	LastName      string `json:"lastName"`
+	IsAdmin       bool   `json:"isAdmin"`
+	EmailVerified bool   `json:"emailVerified"`
+	AvatarURL     string `json:"avatarUrl"`
+	CreatedAt     string `json:"createdAt"`
 }
 
 // CreateUser creates a new user in the database
@@ -40,9 +43,9 @@ func CreateUser(email, password, firstName, lastName string, isAdmin bool) (int,
 func GetUserByEmail(email string) (*User, error) {
 	var user User
 	err := DB.QueryRow(
-		"SELECT id, email, password_hash, COALESCE(first_name, ''), COALESCE(last_name, ''), is_admin, COALESCE(avatar_url, ''), created_at FROM users WHERE email = ?",
+		"SELECT id, email, password_hash, COALESCE(first_name, ''), COALESCE(last_name, ''), is_admin, COALESCE(email_verified, 1), COALESCE(avatar_url, ''), created_at FROM users WHERE email = ?",
 		email,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.FirstName, &user.LastName, &user.IsAdmin, &user.AvatarURL, &user.CreatedAt)
+	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.FirstName, &user.LastName, &user.IsAdmin, &user.EmailVerified, &user.AvatarURL, &user.CreatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -54,6 +57,19 @@ func GetUserByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
+// GetUserByID retrieves a user by ID
+func GetUserByID(id int) (*User, error) {
+	var email string
+	err := DB.QueryRow("SELECT email FROM users WHERE id = ?", id).Scan(&email)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return GetUserByEmail(email)
+}
+
 // UpdateUser updates a user's information
 func UpdateUser(id int, email, firstName, lastName string) error {
 	_, err := DB.Exec(
@@ -88,7 +104,11 @@ func UpdateUserAvatar(id int, avatarURL string) error {
 
 // UpdateUserOpenRouterAPIKey stores or clears a user's OpenRouter API key
 func UpdateUserOpenRouterAPIKey(id int, apiKey string) error {
-	_, err := DB.Exec(
+	apiKey, err := secrets.Encrypt(apiKey)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(
 		"UPDATE users SET openrouter_api_key = ? WHERE id = ?",
 		apiKey, id,
 	)
@@ -108,7 +128,7 @@ func GetUserOpenRouterAPIKey(id int) (string, error) {
 	if !apiKey.Valid {
 		return "", nil
 	}
-	return apiKey.String, nil
+	return secrets.Decrypt(apiKey.String)
 }
 
 // CheckPasswordHash compares a password with a hash
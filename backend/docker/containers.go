@@ -1,11 +1,15 @@
 package docker
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"log"
 	"strings"
 	"time"
 
+	"baseful/db"
+
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
@@ -20,14 +24,40 @@ type ContainerInfo struct {
 	IP      string            `json:"ip"`
 	Labels  map[string]string `json:"labels"`
 	Created int64             `json:"created"`
+	// Server the container runs on; 0 is the local Docker daemon
+	ServerID   int    `json:"serverId"`
+	ServerName string `json:"serverName"`
 }
 
-// ListContainers returns a list of all containers on the Baseful network
+// ListContainers returns the Baseful containers of the local daemon and of
+// every registered server. Unreachable servers are skipped.
 func ListContainers() ([]ContainerInfo, error) {
+	result, err := listServerContainers(LocalServer, "local")
+	if err != nil {
+		return nil, err
+	}
+
+	servers, err := db.ListServers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list servers: %w", err)
+	}
+	for _, server := range servers {
+		containers, err := listServerContainers(server.ID, server.Name)
+		if err != nil {
+			log.Printf("Failed to list containers on server %s: %v", server.Name, err)
+			continue
+		}
+		result = append(result, containers...)
+	}
+	return result, nil
+}
+
+// listServerContainers returns the containers on the Baseful network of one server
+func listServerContainers(serverID int, serverName string) ([]ContainerInfo, error) {
 	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := NewClient(serverID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create docker client: %w", err)
+		return nil, err
 	}
 	defer cli.Close()
 
@@ -53,21 +83,18 @@ func ListContainers() ([]ContainerInfo, error) {
 		}
 
 		// Also check for containers managed by baseful even if not on network
-		isBaseful := false
-		if c.Labels["managed-by"] == "baseful" || strings.Contains(inspect.Name, "baseful") {
-			isBaseful = true
-		}
-
-		if isBaseful || ip != "" {
+		if isManaged(c.Labels) || ip != "" {
 			result = append(result, ContainerInfo{
-				ID:      c.ID,
-				Names:   c.Names,
-				Image:   c.Image,
-				Status:  c.Status,
-				State:   c.State,
-				IP:      ip,
-				Labels:  c.Labels,
-				Created: c.Created,
+				ID:         c.ID,
+				Names:      c.Names,
+				Image:      c.Image,
+				Status:     c.Status,
+				State:      c.State,
+				IP:         ip,
+				Labels:     c.Labels,
+				Created:    c.Created,
+				ServerID:   serverID,
+				ServerName: serverName,
 			})
 		}
 	}
@@ -75,6 +102,12 @@ func ListContainers() ([]ContainerInfo, error) {
 	return result, nil
 }
 
+// isManaged reports whether a container was created by Baseful. Only the
+// label counts: the control plane itself runs in a container named baseful.
+func isManaged(labels map[string]string) bool {
+	return labels["managed-by"] == "baseful"
+}
+
 // ExecResult contains the output and the new current working directory
 type ExecResult struct {
 	Output string `json:"output"`
@@ -82,13 +115,13 @@ type ExecResult struct {
 }
 
 // ExecCommand executes a command, returns output and the NEW current working directory
-func ExecCommand(containerID string, rawCommand string, currentCwd string) (ExecResult, error) {
+func ExecCommand(serverID int, containerID string, rawCommand string, currentCwd string) (ExecResult, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := NewClient(serverID)
 	if err != nil {
-		return ExecResult{}, fmt.Errorf("failed to create docker client: %w", err)
+		return ExecResult{}, err
 	}
 	defer cli.Close()
 
@@ -150,3 +183,32 @@ func ExecCommand(containerID string, rawCommand string, currentCwd string) (Exec
 
 	return ExecResult{Output: finalStdout + finalStderr, Cwd: currentCwd}, nil
 }
+
+// ExecOutput runs a command in a container and returns its standard output.
+// It fails with the standard error when the command exits non-zero.
+func ExecOutput(ctx context.Context, cli *client.Client, containerID string, opts container.ExecOptions) (string, error) {
+	opts.AttachStdout = true
+	opts.AttachStderr = true
+	execID, err := cli.ContainerExecCreate(ctx, containerID, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to create exec: %w", err)
+	}
+	resp, err := cli.ContainerExecAttach(ctx, execID.ID, container.ExecStartOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to attach exec: %w", err)
+	}
+	defer resp.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, resp.Reader); err != nil {
+		return "", fmt.Errorf("failed to read exec output: %w", err)
+	}
+	inspect, err := cli.ContainerExecInspect(ctx, execID.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect exec: %w", err)
+	}
+	if inspect.ExitCode != 0 {
+		return stdout.String(), fmt.Errorf("%s exited with %d: %s", opts.Cmd[0], inspect.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,279 @@
+package docker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"baseful/db"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"golang.org/x/crypto/ssh"
+)
+
+// LocalServer is the server ID of the Docker daemon Baseful runs next to
+const LocalServer = 0
+
+// ErrServerNotFound is returned for databases on a server that was removed
+var ErrServerNotFound = errors.New("server not found")
+
+// NewClient returns a Docker client for a server. The local daemon is
+// configured from the environment; remote hosts are reached over SSH or
+// over TCP with TLS client certificates.
+func NewClient(serverID int) (*client.Client, error) {
+	if serverID == LocalServer {
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create docker client: %w", err)
+		}
+		return cli, nil
+	}
+
+	server, err := db.GetServer(serverID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server %d: %w", serverID, err)
+	}
+	if server == nil {
+		return nil, ErrServerNotFound
+	}
+	return NewServerClient(server)
+}
+
+// NewServerClient returns a Docker client for a stored server record
+func NewServerClient(server *db.Server) (*client.Client, error) {
+	return newServerClient(server, func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialSSHSocket(server)
+	})
+}
+
+// newServerClient builds a client for a server. SSH hosts are reached
+// through dial, which opens a stream to the remote Docker socket.
+func newServerClient(server *db.Server, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (*client.Client, error) {
+	var opts []client.Opt
+	switch server.Transport {
+	case db.ServerTransportSSH:
+		opts = []client.Opt{
+			client.WithHTTPClient(&http.Client{Transport: &http.Transport{DialContext: dial}}),
+			client.WithHost("http://docker.example.com"),
+			client.WithDialContext(dial),
+		}
+	case db.ServerTransportTLS:
+		tlsConfig, err := serverTLSConfig(server)
+		if err != nil {
+			return nil, err
+		}
+		opts = []client.Opt{
+			client.WithHost("tcp://" + net.JoinHostPort(server.Host, strconv.Itoa(server.Port))),
+			client.WithHTTPClient(&http.Client{Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+				Proxy:           http.ProxyFromEnvironment,
+			}}),
+		}
+	default:
+		return nil, fmt.Errorf("unknown transport %q", server.Transport)
+	}
+
+	cli, err := client.NewClientWithOpts(append(opts, client.WithAPIVersionNegotiation())...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create docker client for %s: %w", server.Name, err)
+	}
+	return cli, nil
+}
+
+// TestServer connects to a server that is not stored yet and returns the
+// version of its Docker daemon
+func TestServer(ctx context.Context, server *db.Server) (string, error) {
+	var conn *ssh.Client
+	if server.Transport == db.ServerTransportSSH {
+		var err error
+		if conn, err = newSSHConnection(server); err != nil {
+			return "", err
+		}
+		defer conn.Close()
+	}
+	cli, err := newServerClient(server, func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return conn.Dial("unix", server.DockerSocket)
+	})
+	if err != nil {
+		return "", err
+	}
+	defer cli.Close()
+
+	version, err := cli.ServerVersion(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach Docker on %s: %w", server.Name, err)
+	}
+	return version.Version, nil
+}
+
+// NewDatabaseClient returns a Docker client for the server a database runs on
+func NewDatabaseClient(databaseID int) (*client.Client, error) {
+	serverID, err := db.GetDatabaseServerID(databaseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find database %d: %w", databaseID, err)
+	}
+	return NewClient(serverID)
+}
+
+func serverTLSConfig(server *db.Server) (*tls.Config, error) {
+	cert, err := tls.X509KeyPair([]byte(server.TLSCert), []byte(server.TLSKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid client certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(server.TLSCACert)) {
+		return nil, errors.New("invalid CA certificate")
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// SSH connections are shared by every client of a server and reopened when
+// they drop. Each Docker API connection is a stream over them.
+var (
+	sshMu      sync.Mutex
+	sshClients = map[int]*ssh.Client{}
+)
+
+func dialSSHSocket(server *db.Server) (net.Conn, error) {
+	conn, err := sshConnection(server)
+	if err != nil {
+		return nil, err
+	}
+	socket, err := conn.Dial("unix", server.DockerSocket)
+	if err == nil {
+		return socket, nil
+	}
+
+	// The cached connection may have died silently; retry once on a new one
+	dropSSHConnection(server.ID, conn)
+	if conn, err = sshConnection(server); err != nil {
+		return nil, err
+	}
+	socket, err = conn.Dial("unix", server.DockerSocket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the Docker socket on %s: %w", server.Name, err)
+	}
+	return socket, nil
+}
+
+func sshConnection(server *db.Server) (*ssh.Client, error) {
+	sshMu.Lock()
+	defer sshMu.Unlock()
+	if conn, ok := sshClients[server.ID]; ok {
+		return conn, nil
+	}
+	conn, err := newSSHConnection(server)
+	if err != nil {
+		return nil, err
+	}
+	sshClients[server.ID] = conn
+	go func() {
+		conn.Wait()
+		dropSSHConnection(server.ID, conn)
+	}()
+	return conn, nil
+}
+
+// newSSHConnection logs into a server with its key, accepting only the
+// pinned host key
+func newSSHConnection(server *db.Server) (*ssh.Client, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(server.SSHPrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH private key: %w", err)
+	}
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(server.SSHHostKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH host key: %w", err)
+	}
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(server.Host, strconv.Itoa(server.Port)), &ssh.ClientConfig{
+		User:            server.Username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+		Timeout:         10 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s over SSH: %w", server.Name, err)
+	}
+	return conn, nil
+}
+
+func dropSSHConnection(serverID int, conn *ssh.Client) {
+	sshMu.Lock()
+	if sshClients[serverID] == conn {
+		delete(sshClients, serverID)
+	}
+	sshMu.Unlock()
+	conn.Close()
+}
+
+// CloseServer drops the cached connection of a server, e.g. after it was
+// removed or its host key changed
+func CloseServer(serverID int) {
+	sshMu.Lock()
+	conn, ok := sshClients[serverID]
+	sshMu.Unlock()
+	if ok {
+		dropSSHConnection(serverID, conn)
+	}
+}
+
+// FetchSSHHostKey connects to an SSH server and returns its host key in
+// authorized_keys format, to be pinned when the server is registered
+func FetchSSHHostKey(host string, port int) (string, error) {
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User: "baseful",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			// Abort the handshake; only the key was wanted
+			return errHostKeyFetched
+		},
+		Timeout: 10 * time.Second,
+	}
+	_, err := ssh.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)), config)
+	if hostKey == nil {
+		return "", fmt.Errorf("failed to read the SSH host key: %w", err)
+	}
+	return string(ssh.MarshalAuthorizedKey(hostKey)), nil
+}
+
+var errHostKeyFetched = errors.New("host key fetched")
+
+// SSHFingerprint returns the SHA256 fingerprint of a host key in
+// authorized_keys format, as shown by ssh-keygen -l
+func SSHFingerprint(hostKey string) string {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(key)
+}
+
+// MappedPort returns the host port the daemon bound to a container port,
+// for containers created without an explicit host port
+func MappedPort(ctx context.Context, cli *client.Client, containerID, port string) (int, error) {
+	inspect, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if inspect.NetworkSettings != nil {
+		for _, binding := range inspect.NetworkSettings.Ports[nat.Port(port)] {
+			if p, err := strconv.Atoi(binding.HostPort); err == nil && p > 0 {
+				return p, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("container has no host port for %s", port)
+}
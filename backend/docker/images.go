@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FlavorPostgres is the official postgres image
+const FlavorPostgres = "postgres"
+
+// Flavor is a Postgres image with extensions preinstalled
+type Flavor struct {
+	Name        string `json:"name"`
+	Label       string `json:"label"`
+	Description string `json:"description"`
+	// Extensions the image adds over the official one
+	Extensions []string `json:"extensions"`
+	// Versions lists the supported major versions, newest last; empty
+	// means any tag of the image
+	Versions []string `json:"versions"`
+
+	repository string
+	// tag returns the image tag of a major version
+	tag func(major string) string
+}
+
+// Flavors lists the images databases can be created from
+var Flavors = []Flavor{
+	{
+		Name:        FlavorPostgres,
+		Label:       "PostgreSQL",
+		Description: "The official PostgreSQL image",
+		Extensions:  []string{},
+		Versions:    []string{},
+		repository:  "postgres",
+		tag:         func(version string) string { return version },
+	},
+	{
+		Name:        "pgvector",
+		Label:       "pgvector",
+		Description: "Vector similarity search for embeddings",
+		Extensions:  []string{"vector"},
+		Versions:    []string{"13", "14", "15", "16", "17", "18"},
+		repository:  "pgvector/pgvector",
+		tag:         func(major string) string { return "pg" + major },
+	},
+	{
+		Name:        "postgis",
+		Label:       "PostGIS",
+		Description: "Geographic objects and spatial queries",
+		Extensions:  []string{"postgis", "postgis_topology", "postgis_tiger_geocoder", "fuzzystrmatch"},
+		Versions:    []string{"13", "14", "15", "16", "17", "18"},
+		repository:  "postgis/postgis",
+		tag: func(major string) string {
+			if major == "18" {
+				return "18-3.6"
+			}
+			return major + "-3.5"
+		},
+	},
+	{
+		Name:        "timescaledb",
+		Label:       "TimescaleDB",
+		Description: "Time-series tables with automatic partitioning and compression",
+		Extensions:  []string{"timescaledb"},
+		Versions:    []string{"15", "16", "17"},
+		repository:  "timescale/timescaledb",
+		tag:         func(major string) string { return "latest-pg" + major },
+	},
+}
+
+// FlavorImage returns the image of a flavor and Postgres version. Flavors
+// other than the official image are published per major version; an empty
+// version picks the newest.
+func FlavorImage(flavor, version string) (string, error) {
+	if flavor == "" {
+		flavor = FlavorPostgres
+	}
+	for _, f := range Flavors {
+		if f.Name != flavor {
+			continue
+		}
+		if len(f.Versions) == 0 {
+			if version == "" {
+				version = "latest"
+			}
+			return f.repository + ":" + f.tag(version), nil
+		}
+
+		major, _, _ := strings.Cut(version, ".")
+		if major == "" || major == "latest" {
+			major = f.Versions[len(f.Versions)-1]
+		}
+		for _, v := range f.Versions {
+			if v == major {
+				return f.repository + ":" + f.tag(major), nil
+			}
+		}
+		return "", fmt.Errorf("%s is available for Postgres %s", f.Label, strings.Join(f.Versions, ", "))
+	}
+	return "", fmt.Errorf("unknown image flavor %q", flavor)
+}
@@ -0,0 +1,195 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// LogLine is one line of container output. Level is the Postgres severity
+// (LOG, ERROR, ...) when the line is a server log message, empty otherwise.
+type LogLine struct {
+	Time    time.Time `json:"time"`
+	Stream  string    `json:"stream"`
+	Level   string    `json:"level,omitempty"`
+	Message string    `json:"message"`
+}
+
+// LogOptions selects container log lines. Since and Until accept RFC 3339
+// timestamps, Unix timestamps or durations relative to now such as "15m".
+// MinLevel drops Postgres messages below a severity, along with their
+// DETAIL/STATEMENT/HINT/CONTEXT lines.
+type LogOptions struct {
+	Follow   bool
+	Since    string
+	Until    string
+	Tail     string
+	MinLevel string
+}
+
+// logSeverity orders the Postgres message levels. Detail lines are ranked
+// like the message they belong to.
+var logSeverity = map[string]int{
+	"DEBUG5": 0, "DEBUG4": 0, "DEBUG3": 0, "DEBUG2": 0, "DEBUG1": 0,
+	"INFO": 1, "NOTICE": 2, "WARNING": 3, "LOG": 4, "ERROR": 5, "FATAL": 6, "PANIC": 7,
+}
+
+var logDetailLevels = map[string]bool{"DETAIL": true, "HINT": true, "STATEMENT": true, "CONTEXT": true, "QUERY": true, "LOCATION": true}
+
+// postgresLogLevel matches the severity after the log_line_prefix, e.g.
+// "2024-05-01 12:00:00.000 UTC [42] ERROR:  relation ... does not exist".
+// Postgres always puts two spaces after the severity.
+var postgresLogLevel = regexp.MustCompile(`^(?:.*?\s)?([A-Z][A-Z0-9]+):  `)
+
+// ValidLogLevel reports whether a level can be used as a minimum severity
+func ValidLogLevel(level string) bool {
+	_, ok := logSeverity[strings.ToUpper(level)]
+	return ok
+}
+
+// ParseLogLevel returns the Postgres severity of a log line, or "" when the
+// line is not a server log message (e.g. initdb output)
+func ParseLogLevel(message string) string {
+	m := postgresLogLevel.FindStringSubmatch(message)
+	if m == nil {
+		return ""
+	}
+	if _, ok := logSeverity[m[1]]; ok || logDetailLevels[m[1]] {
+		return m[1]
+	}
+	return ""
+}
+
+// StreamLogs calls fn for every log line of a container until the selection is
+// exhausted, or, when following, until ctx is cancelled or fn returns an error
+func StreamLogs(ctx context.Context, serverID int, containerID string, opts LogOptions, fn func(LogLine) error) error {
+	cli, err := NewClient(serverID)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	inspect, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	tail := opts.Tail
+	if tail == "" {
+		tail = "all"
+	}
+	reader, err := cli.ContainerLogs(ctx, containerID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: true,
+		Follow:     opts.Follow,
+		Since:      opts.Since,
+		Until:      opts.Until,
+		Tail:       tail,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read logs: %w", err)
+	}
+	defer reader.Close()
+
+	filter := &logFilter{min: -1, fn: fn}
+	if opts.MinLevel != "" {
+		filter.min = logSeverity[strings.ToUpper(opts.MinLevel)]
+	}
+	stdout := &logLineWriter{stream: "stdout", filter: filter}
+	stderr := &logLineWriter{stream: "stderr", filter: filter}
+
+	// Containers with a TTY send one raw stream instead of multiplexed frames
+	if inspect.Config != nil && inspect.Config.Tty {
+		_, err = io.Copy(stdout, reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, reader)
+	}
+	if err == nil {
+		err = stdout.flush()
+	}
+	if err == nil {
+		err = stderr.flush()
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// logFilter applies the minimum level, remembering the severity of the last
+// message so its detail lines follow it
+type logFilter struct {
+	min  int
+	last int
+	fn   func(LogLine) error
+}
+
+func (f *logFilter) emit(line LogLine) error {
+	if f.min >= 0 {
+		switch {
+		case line.Level == "":
+		case logDetailLevels[line.Level]:
+			if f.last < f.min {
+				return nil
+			}
+		default:
+			f.last = logSeverity[line.Level]
+			if f.last < f.min {
+				return nil
+			}
+		}
+	}
+	return f.fn(line)
+}
+
+// logLineWriter splits one output stream into timestamped lines
+type logLineWriter struct {
+	stream  string
+	filter  *logFilter
+	partial []byte
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := string(bytes.TrimRight(w.partial[:i], "\r"))
+		w.partial = w.partial[i+1:]
+		if err := w.emit(line); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (w *logLineWriter) flush() error {
+	if len(w.partial) == 0 {
+		return nil
+	}
+	line := string(w.partial)
+	w.partial = nil
+	return w.emit(line)
+}
+
+// emit splits off the timestamp Docker prefixes every line with
+func (w *logLineWriter) emit(raw string) error {
+	line := LogLine{Stream: w.stream, Message: raw}
+	if ts, message, ok := strings.Cut(raw, " "); ok {
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			line.Time = t
+			line.Message = message
+		}
+	}
+	line.Level = ParseLogLevel(line.Message)
+	return w.filter.emit(line)
+}
@@ -22,10 +22,15 @@ func GetDockerNetworkName() string {
 
 // EnsureNetwork creates the Docker network if it doesn't exist
 func EnsureNetwork() error {
+	return EnsureServerNetwork(LocalServer)
+}
+
+// EnsureServerNetwork creates the Docker network on a server if it doesn't exist
+func EnsureServerNetwork(serverID int) error {
 	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := NewClient(serverID)
 	if err != nil {
-		return fmt.Errorf("failed to create docker client: %w", err)
+		return err
 	}
 	defer cli.Close()
 
@@ -0,0 +1,114 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// ErrNotManaged is returned for containers Baseful did not create
+var ErrNotManaged = errors.New("container is not managed by Baseful")
+
+// defaultShell starts bash when the image has it and falls back to sh
+var defaultShell = []string{"/bin/sh", "-c", "if command -v bash >/dev/null 2>&1; then exec bash; else exec sh; fi"}
+
+// Terminal is an interactive exec session with a TTY attached. Reads return
+// the raw terminal output, writes go to the process's stdin.
+type Terminal struct {
+	cli    *client.Client
+	execID string
+	conn   types.HijackedResponse
+}
+
+// OpenTerminal starts cmd (or a shell when empty) in a Baseful-managed
+// container with a TTY of the given size
+func OpenTerminal(ctx context.Context, serverID int, containerID string, cmd []string, rows, cols uint) (*Terminal, error) {
+	cli, err := NewClient(serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	inspect, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		cli.Close()
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if !isManaged(inspect.Config.Labels) {
+		cli.Close()
+		return nil, ErrNotManaged
+	}
+	if inspect.State == nil || !inspect.State.Running {
+		cli.Close()
+		return nil, fmt.Errorf("container is not running")
+	}
+
+	if len(cmd) == 0 {
+		cmd = defaultShell
+	}
+	execConfig := container.ExecOptions{
+		Cmd:          cmd,
+		Env:          []string{"TERM=xterm-256color"},
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          true,
+	}
+	if rows > 0 && cols > 0 {
+		execConfig.ConsoleSize = &[2]uint{rows, cols}
+	}
+
+	execID, err := cli.ContainerExecCreate(ctx, inspect.ID, execConfig)
+	if err != nil {
+		cli.Close()
+		return nil, fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	conn, err := cli.ContainerExecAttach(ctx, execID.ID, container.ExecAttachOptions{
+		Tty:         true,
+		ConsoleSize: execConfig.ConsoleSize,
+	})
+	if err != nil {
+		cli.Close()
+		return nil, fmt.Errorf("failed to attach exec: %w", err)
+	}
+
+	return &Terminal{cli: cli, execID: execID.ID, conn: conn}, nil
+}
+
+// Read reads terminal output. With a TTY stdout and stderr arrive merged and
+// unframed, so no demultiplexing is needed.
+func (t *Terminal) Read(p []byte) (int, error) {
+	return t.conn.Reader.Read(p)
+}
+
+// Write sends input to the process
+func (t *Terminal) Write(p []byte) (int, error) {
+	return t.conn.Conn.Write(p)
+}
+
+// Resize changes the TTY size
+func (t *Terminal) Resize(ctx context.Context, rows, cols uint) error {
+	return t.cli.ContainerExecResize(ctx, t.execID, container.ResizeOptions{Height: rows, Width: cols})
+}
+
+// ExitCode returns the exit code of the process, or -1 while it still runs
+func (t *Terminal) ExitCode(ctx context.Context) (int, error) {
+	inspect, err := t.cli.ContainerExecInspect(ctx, t.execID)
+	if err != nil {
+		return -1, err
+	}
+	if inspect.Running {
+		return -1, nil
+	}
+	return inspect.ExitCode, nil
+}
+
+// Close detaches from the process. A shell exits once its stdin closes.
+func (t *Terminal) Close() error {
+	t.conn.Close()
+	return t.cli.Close()
+}
@@ -0,0 +1,422 @@
+// Package failover runs databases in high-availability mode: a primary with a
+// standby replica on the same server. The backend health-checks every HA
+// primary and promotes its standby when the primary stops answering, then
+// points the database row at the new primary so the proxy sends new clients
+// there. A new standby is created once the new primary is healthy.
+package failover
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"baseful/audit"
+	"baseful/db"
+	"baseful/docker"
+	"baseful/mail"
+	"baseful/pg"
+	"baseful/replication"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	// CheckInterval is how often HA primaries are health-checked
+	CheckInterval = 5 * time.Second
+	// FailureThreshold is how many checks in a row must fail before failover
+	FailureThreshold = 3
+	// standbyRetry is how long a failed standby creation waits to be retried
+	standbyRetry = 5 * time.Minute
+)
+
+var (
+	// ErrNoStandby is returned when a database without a standby fails over
+	ErrNoStandby = errors.New("the database has no standby")
+	// ErrStandbyNotReady is returned when the standby is not streaming or too far behind
+	ErrStandbyNotReady = errors.New("the standby is not streaming or too far behind to be promoted")
+	// ErrBusy is returned while a failover or standby creation of the database runs
+	ErrBusy = errors.New("a failover or standby creation of this database is in progress")
+)
+
+// monitor keeps the health check state of HA databases
+type monitor struct {
+	mu            sync.Mutex
+	busy          map[int]bool
+	failures      map[int]int
+	standbyFailed map[int]time.Time
+}
+
+var state = &monitor{
+	busy:          map[int]bool{},
+	failures:      map[int]int{},
+	standbyFailed: map[int]time.Time{},
+}
+
+// lock reserves a database for a health check, failover or standby change
+func (m *monitor) lock(databaseID int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.busy[databaseID] {
+		return false
+	}
+	m.busy[databaseID] = true
+	return true
+}
+
+func (m *monitor) unlock(databaseID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.busy, databaseID)
+}
+
+// Start health-checks HA databases in the background until ctx is cancelled
+func Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checkAll(ctx)
+			}
+		}
+	}()
+}
+
+func checkAll(ctx context.Context) {
+	ids, err := db.HADatabases()
+	if err != nil {
+		log.Printf("Failover: failed to list HA databases: %v", err)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			check(ctx, id)
+		}(id)
+	}
+	wg.Wait()
+}
+
+// check health-checks one primary and fails over once FailureThreshold
+// checks in a row failed. Later failed checks retry the failover quietly.
+func check(ctx context.Context, databaseID int) {
+	if !state.lock(databaseID) {
+		return
+	}
+	defer state.unlock(databaseID)
+
+	err := healthCheck(ctx, databaseID)
+	state.mu.Lock()
+	failures := state.failures[databaseID]
+	if err == nil {
+		delete(state.failures, databaseID)
+	} else {
+		failures++
+		state.failures[databaseID] = failures
+	}
+	state.mu.Unlock()
+
+	if err == nil {
+		if failures >= FailureThreshold {
+			record(databaseID, db.EventPrimaryRecovered, "The primary answers health checks again")
+		}
+		ensureStandby(ctx, databaseID)
+		return
+	}
+	if failures < FailureThreshold {
+		return
+	}
+
+	reason := fmt.Sprintf("the primary failed %d health checks in a row (%v)", failures, err)
+	if failures == FailureThreshold {
+		record(databaseID, db.EventPrimaryUnhealthy, "The primary is unhealthy: "+reason)
+	}
+	failoverCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	if err := failover(failoverCtx, databaseID, reason); err != nil {
+		log.Printf("Failover of database %d failed: %v", databaseID, err)
+		if failures == FailureThreshold {
+			message := fmt.Sprintf("Automatic failover failed: %v. The database stays unavailable until its primary recovers.", err)
+			record(databaseID, db.EventFailoverFailed, message)
+			alert(databaseID, message)
+		}
+		return
+	}
+	state.mu.Lock()
+	delete(state.failures, databaseID)
+	state.mu.Unlock()
+}
+
+// healthCheck connects to the primary and runs a trivial query
+func healthCheck(ctx context.Context, databaseID int) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	conn, err := pg.Connect(ctx, databaseID)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	var one int
+	return conn.QueryRow(ctx, "SELECT 1").Scan(&one)
+}
+
+// Failover promotes the standby of a database right away, e.g. for a planned
+// switchover. reason is recorded with the event.
+func Failover(ctx context.Context, databaseID int, reason string) error {
+	if !state.lock(databaseID) {
+		return ErrBusy
+	}
+	defer state.unlock(databaseID)
+	return failover(ctx, databaseID, reason)
+}
+
+// failover fences the primary, promotes the standby and records it as the
+// new primary. The old primary is stopped and kept from restarting so it
+// cannot take writes next to the new one; it stays behind as an orphaned
+// container that the drift report lists for inspection or removal.
+func failover(ctx context.Context, databaseID int, reason string) error {
+	standby, err := db.GetStandby(databaseID)
+	if err != nil {
+		return err
+	}
+	if standby == nil || standby.ContainerID == "" {
+		return ErrNoStandby
+	}
+	if standby.Status != "running" || standby.ReplicationState != db.ReplicationStreaming ||
+		standby.LagBytes > db.MaxReplicaLagBytes {
+		return ErrStandbyNotReady
+	}
+
+	var oldContainerID string
+	var serverID int
+	err = db.DB.QueryRow("SELECT COALESCE(container_id, ''), COALESCE(server_id, 0) FROM databases WHERE id = ?",
+		databaseID).Scan(&oldContainerID, &serverID)
+	if err != nil {
+		return err
+	}
+
+	fence(ctx, serverID, oldContainerID)
+	if err := replication.Promote(ctx, standby); err != nil {
+		return err
+	}
+	if err := db.PromoteReplica(standby); err != nil {
+		return fmt.Errorf("failed to record the new primary: %w", err)
+	}
+
+	message := fmt.Sprintf("Promoted standby %s to primary because %s", standby.Name, reason)
+	log.Printf("Database %d: %s", databaseID, message)
+	record(databaseID, db.EventFailover, message)
+	if err := audit.Write(audit.Entry{
+		Action:     "database.failover",
+		TargetType: "database",
+		TargetID:   strconv.Itoa(databaseID),
+		Outcome:    audit.OutcomeSuccess,
+		Details: map[string]any{
+			"standby":        standby.Name,
+			"reason":         reason,
+			"oldContainerId": oldContainerID,
+		},
+	}); err != nil {
+		log.Printf("Failed to write audit log entry for database.failover: %v", err)
+	}
+	alert(databaseID, message+". New connections go to the new primary; a new standby is created next.")
+
+	if err := replication.Follow(ctx, databaseID); err != nil {
+		record(databaseID, db.EventReplicasDetached, fmt.Sprintf("Some read replicas could not follow the new primary: %v", err))
+	}
+	return nil
+}
+
+// fence stops the old primary and turns off its restart policy so Docker
+// does not bring it back. Failures are logged: a primary on an unreachable
+// server cannot be fenced.
+func fence(ctx context.Context, serverID int, containerID string) {
+	if containerID == "" {
+		return
+	}
+	cli, err := docker.NewClient(serverID)
+	if err != nil {
+		log.Printf("Failed to fence old primary %s: %v", containerID, err)
+		return
+	}
+	defer cli.Close()
+
+	_, err = cli.ContainerUpdate(ctx, containerID, container.UpdateConfig{
+		RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyDisabled},
+	})
+	if err != nil {
+		log.Printf("Failed to disable restarts of old primary %s: %v", containerID, err)
+	}
+	timeout := 10
+	if err := cli.ContainerStop(ctx, containerID, container.StopOptions{Timeout: &timeout}); err != nil {
+		log.Printf("Failed to stop old primary %s: %v", containerID, err)
+	}
+}
+
+// ensureStandby creates a standby for an HA database without one, e.g. after
+// a failover promoted the previous one
+func ensureStandby(ctx context.Context, databaseID int) {
+	standby, err := db.GetStandby(databaseID)
+	if err != nil || standby != nil {
+		return
+	}
+	state.mu.Lock()
+	failedAt, failedBefore := state.standbyFailed[databaseID]
+	state.mu.Unlock()
+	if failedBefore && time.Since(failedAt) < standbyRetry {
+		return
+	}
+
+	createCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+	standby, err = createStandby(createCtx, databaseID)
+	state.mu.Lock()
+	if err != nil {
+		state.standbyFailed[databaseID] = time.Now()
+	} else {
+		delete(state.standbyFailed, databaseID)
+	}
+	state.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Failed to create a standby for database %d: %v", databaseID, err)
+		// Only the first failure of a streak is recorded; retries just log
+		if !failedBefore {
+			message := fmt.Sprintf("Failed to create a standby, retrying every %d minutes: %v", int(standbyRetry.Minutes()), err)
+			record(databaseID, db.EventStandbyFailed, message)
+			alert(databaseID, message)
+		}
+		return
+	}
+	record(databaseID, db.EventStandbyCreated, fmt.Sprintf("Created standby %s", standby.Name))
+}
+
+func createStandby(ctx context.Context, databaseID int) (*db.Replica, error) {
+	random := make([]byte, 3)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	return replication.Create(ctx, databaseID, "standby-"+hex.EncodeToString(random), db.ReplicaRoleStandby)
+}
+
+// Enable turns on high-availability mode, creating the standby first
+func Enable(ctx context.Context, databaseID int) (*db.Replica, error) {
+	if !state.lock(databaseID) {
+		return nil, ErrBusy
+	}
+	defer state.unlock(databaseID)
+
+	standby, err := db.GetStandby(databaseID)
+	if err != nil {
+		return nil, err
+	}
+	if standby == nil {
+		if standby, err = createStandby(ctx, databaseID); err != nil {
+			return nil, err
+		}
+		record(databaseID, db.EventStandbyCreated, fmt.Sprintf("Created standby %s", standby.Name))
+	}
+	if err := db.SetHASuspended(databaseID, false); err != nil {
+		return nil, err
+	}
+	if err := db.SetHAEnabled(databaseID, true); err != nil {
+		return nil, err
+	}
+	return standby, nil
+}
+
+// readyTimeout bounds how long Resume waits for a restarted primary
+const readyTimeout = 2 * time.Minute
+
+// Resume turns health checks of a database back on after it was started or
+// restarted on purpose. It waits for the primary to answer first, so a
+// primary that is still starting up is not failed over, and forgets the
+// failures seen before the restart. A primary that does not come back within
+// readyTimeout is handed to the monitor anyway.
+func Resume(ctx context.Context, databaseID int) {
+	if status, err := db.GetHAStatus(databaseID); err == nil && status.Enabled {
+		ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+		defer cancel()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+	wait:
+		for healthCheck(ctx, databaseID) != nil {
+			select {
+			case <-ctx.Done():
+				log.Printf("Failover: database %d did not answer within %s of its restart", databaseID, readyTimeout)
+				break wait
+			case <-ticker.C:
+			}
+		}
+	}
+
+	state.mu.Lock()
+	delete(state.failures, databaseID)
+	state.mu.Unlock()
+	if err := db.SetHASuspended(databaseID, false); err != nil {
+		log.Printf("Failover: failed to resume health checks of database %d: %v", databaseID, err)
+	}
+}
+
+// Disable turns off high-availability mode and removes the standby
+func Disable(ctx context.Context, databaseID int) error {
+	if !state.lock(databaseID) {
+		return ErrBusy
+	}
+	defer state.unlock(databaseID)
+
+	if err := db.SetHAEnabled(databaseID, false); err != nil {
+		return err
+	}
+	state.mu.Lock()
+	delete(state.failures, databaseID)
+	delete(state.standbyFailed, databaseID)
+	state.mu.Unlock()
+
+	standby, err := db.GetStandby(databaseID)
+	if err != nil || standby == nil {
+		return err
+	}
+	return replication.Remove(ctx, standby)
+}
+
+func record(databaseID int, eventType, message string) {
+	if err := db.RecordEvent(databaseID, eventType, message); err != nil {
+		log.Printf("Failed to record %s event of database %d: %v", eventType, databaseID, err)
+	}
+}
+
+// alert emails an event to the people responsible for the database. Nothing
+// is sent when SMTP is not configured.
+func alert(databaseID int, event string) {
+	settings := db.GetSMTPSettings()
+	if !settings.Configured() {
+		return
+	}
+	var name string
+	if err := db.DB.QueryRow("SELECT name FROM databases WHERE id = ?", databaseID).Scan(&name); err != nil {
+		return
+	}
+	recipients, err := db.AlertRecipients(databaseID)
+	if err != nil {
+		log.Printf("Failed to look up alert recipients of database %d: %v", databaseID, err)
+		return
+	}
+	link := mail.Link(settings, fmt.Sprintf("/db/%d/overview", databaseID), nil)
+	for _, to := range recipients {
+		if err := mail.Send(settings, mail.DatabaseAlert(to, name, event, link)); err != nil {
+			log.Printf("Failed to send alert for database %d to %s: %v", databaseID, to, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const anthropicVersion = "2023-06-01"
+
+// anthropicProvider talks to the Anthropic Messages API
+type anthropicProvider struct {
+	cfg Config
+}
+
+func (p *anthropicProvider) Complete(ctx context.Context, req Request) (string, error) {
+	type messagesRequest struct {
+		Model       string    `json:"model"`
+		System      string    `json:"system,omitempty"`
+		Messages    []Message `json:"messages"`
+		Temperature float64   `json:"temperature"`
+		MaxTokens   int       `json:"max_tokens"`
+	}
+	type messagesResponse struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+
+	maxTokens := req.MaxTokens
+	if maxTokens <= 0 {
+		// max_tokens is mandatory for this API
+		maxTokens = 1024
+	}
+
+	var lastErr error
+	for _, model := range p.cfg.Models {
+		bodyBytes, err := json.Marshal(messagesRequest{
+			Model:       model,
+			System:      req.System,
+			Messages:    req.Messages,
+			Temperature: req.Temperature,
+			MaxTokens:   maxTokens,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", p.cfg.BaseURL+"/messages", bytes.NewReader(bodyBytes))
+		if err != nil {
+			return "", err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("x-api-key", p.cfg.APIKey)
+		httpReq.Header.Set("anthropic-version", anthropicVersion)
+
+		res, err := httpClient.Do(httpReq)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		rawBody, _ := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			lastErr = fmt.Errorf("model %s failed: %s", model, strings.TrimSpace(string(rawBody)))
+			continue
+		}
+
+		var parsed messagesResponse
+		if err := json.Unmarshal(rawBody, &parsed); err != nil {
+			lastErr = err
+			continue
+		}
+		var text strings.Builder
+		for _, block := range parsed.Content {
+			if block.Type == "text" {
+				text.WriteString(block.Text)
+			}
+		}
+		if strings.TrimSpace(text.String()) == "" {
+			lastErr = fmt.Errorf("model %s returned no content", model)
+			continue
+		}
+		return text.String(), nil
+	}
+
+	return "", lastErr
+}
@@ -0,0 +1,89 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// openAIProvider talks to the chat completions API, which OpenAI, OpenRouter
+// and most self-hosted model servers implement
+type openAIProvider struct {
+	cfg Config
+}
+
+func (p *openAIProvider) Complete(ctx context.Context, req Request) (string, error) {
+	type chatRequest struct {
+		Model       string    `json:"model"`
+		Messages    []Message `json:"messages"`
+		Temperature float64   `json:"temperature"`
+		MaxTokens   int       `json:"max_tokens,omitempty"`
+	}
+	type chatResponse struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+
+	messages := req.Messages
+	if req.System != "" {
+		messages = append([]Message{{Role: "system", Content: req.System}}, messages...)
+	}
+
+	var lastErr error
+	for _, model := range p.cfg.Models {
+		bodyBytes, err := json.Marshal(chatRequest{
+			Model:       model,
+			Messages:    messages,
+			Temperature: req.Temperature,
+			MaxTokens:   req.MaxTokens,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", p.cfg.BaseURL+"/chat/completions", bytes.NewReader(bodyBytes))
+		if err != nil {
+			return "", err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		if p.cfg.APIKey != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+p.cfg.APIKey)
+		}
+		if p.cfg.Provider == ProviderOpenRouter {
+			httpReq.Header.Set("HTTP-Referer", "https://baseful.local")
+			httpReq.Header.Set("X-Title", "Baseful SQL Assistant")
+		}
+
+		res, err := httpClient.Do(httpReq)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		rawBody, _ := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			lastErr = fmt.Errorf("model %s failed: %s", model, strings.TrimSpace(string(rawBody)))
+			continue
+		}
+
+		var parsed chatResponse
+		if err := json.Unmarshal(rawBody, &parsed); err != nil {
+			lastErr = err
+			continue
+		}
+		if len(parsed.Choices) == 0 || strings.TrimSpace(parsed.Choices[0].Message.Content) == "" {
+			lastErr = fmt.Errorf("model %s returned no content", model)
+			continue
+		}
+		return parsed.Choices[0].Message.Content, nil
+	}
+
+	return "", lastErr
+}
@@ -0,0 +1,180 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"baseful/db"
+)
+
+// Supported providers
+const (
+	ProviderOpenRouter       = "openrouter"
+	ProviderOpenAI           = "openai"
+	ProviderAnthropic        = "anthropic"
+	ProviderOpenAICompatible = "openai_compatible"
+)
+
+// ErrNotConfigured is returned when no usable provider configuration exists
+var ErrNotConfigured = errors.New("LLM provider not configured")
+
+// ProviderInfo describes a provider for settings screens
+type ProviderInfo struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	DefaultBaseURL string `json:"defaultBaseUrl"`
+	DefaultModel   string `json:"defaultModel"`
+	RequiresAPIKey bool   `json:"requiresApiKey"`
+}
+
+// Providers lists the supported providers
+var Providers = []ProviderInfo{
+	{ID: ProviderOpenRouter, Name: "OpenRouter", DefaultBaseURL: "https://openrouter.ai/api/v1", DefaultModel: "google/gemini-3.1-flash-lite-preview,deepseek/deepseek-v3.2", RequiresAPIKey: true},
+	{ID: ProviderOpenAI, Name: "OpenAI", DefaultBaseURL: "https://api.openai.com/v1", DefaultModel: "gpt-4o-mini", RequiresAPIKey: true},
+	{ID: ProviderAnthropic, Name: "Anthropic", DefaultBaseURL: "https://api.anthropic.com/v1", DefaultModel: "claude-3-5-haiku-latest", RequiresAPIKey: true},
+	{ID: ProviderOpenAICompatible, Name: "OpenAI-compatible (self-hosted)", RequiresAPIKey: false},
+}
+
+// Message is a single chat message
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Request is a provider independent completion request
+type Request struct {
+	System      string
+	Messages    []Message
+	Temperature float64
+	MaxTokens   int
+}
+
+// Provider generates completions from a language model
+type Provider interface {
+	Complete(ctx context.Context, req Request) (string, error)
+}
+
+// Config is a resolved provider configuration. Models are tried in order.
+type Config struct {
+	Provider string
+	BaseURL  string
+	APIKey   string
+	Models   []string
+}
+
+var httpClient = &http.Client{Timeout: 60 * time.Second}
+
+// New creates a provider from a resolved configuration
+func New(cfg Config) (Provider, error) {
+	if len(cfg.Models) == 0 {
+		return nil, fmt.Errorf("no model configured for %s: %w", cfg.Provider, ErrNotConfigured)
+	}
+	switch cfg.Provider {
+	case ProviderAnthropic:
+		return &anthropicProvider{cfg: cfg}, nil
+	case ProviderOpenRouter, ProviderOpenAI, ProviderOpenAICompatible:
+		return &openAIProvider{cfg: cfg}, nil
+	}
+	return nil, fmt.Errorf("unknown provider %q", cfg.Provider)
+}
+
+// Validate checks settings before they are stored
+func Validate(settings db.LLMSettings) error {
+	info, ok := providerInfo(settings.Provider)
+	if !ok {
+		return fmt.Errorf("unknown provider %q", settings.Provider)
+	}
+	if settings.BaseURL == "" && info.DefaultBaseURL == "" {
+		return fmt.Errorf("a base URL is required for %s", info.Name)
+	}
+	if settings.BaseURL != "" {
+		u, err := url.Parse(settings.BaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("base URL must be an http or https URL")
+		}
+	}
+	if settings.Model == "" && info.DefaultModel == "" {
+		return fmt.Errorf("a model is required for %s", info.Name)
+	}
+	return nil
+}
+
+// Resolve builds the provider configuration for a project. A project override
+// takes precedence over the instance defaults; without either, OpenRouter is
+// used with the user's personal key.
+func Resolve(projectID, userID int) (Config, error) {
+	instance := db.GetInstanceLLMSettings()
+	settings := instance
+
+	project, err := db.GetProjectLLMSettings(projectID)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to load project LLM settings: %w", err)
+	}
+	if project != nil {
+		settings = *project
+		// Reuse the instance key when the project only changes the model
+		if settings.APIKey == "" && settings.Provider == instance.Provider && settings.BaseURL == instance.BaseURL {
+			settings.APIKey = instance.APIKey
+		}
+	}
+	if settings.Provider == "" {
+		settings.Provider = ProviderOpenRouter
+	}
+
+	info, ok := providerInfo(settings.Provider)
+	if !ok {
+		return Config{}, fmt.Errorf("unknown provider %q: %w", settings.Provider, ErrNotConfigured)
+	}
+
+	cfg := Config{
+		Provider: settings.Provider,
+		BaseURL:  strings.TrimRight(settings.BaseURL, "/"),
+		APIKey:   strings.TrimSpace(settings.APIKey),
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = info.DefaultBaseURL
+	}
+	if cfg.BaseURL == "" {
+		return Config{}, fmt.Errorf("no base URL configured for %s: %w", info.Name, ErrNotConfigured)
+	}
+
+	if cfg.APIKey == "" && cfg.Provider == ProviderOpenRouter {
+		userKey, err := db.GetUserOpenRouterAPIKey(userID)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to read OpenRouter API key: %w", err)
+		}
+		cfg.APIKey = strings.TrimSpace(userKey)
+	}
+	if cfg.APIKey == "" && info.RequiresAPIKey {
+		return Config{}, fmt.Errorf("no API key configured for %s: %w", info.Name, ErrNotConfigured)
+	}
+
+	model := settings.Model
+	if model == "" {
+		model = info.DefaultModel
+	}
+	for _, m := range strings.Split(model, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			cfg.Models = append(cfg.Models, m)
+		}
+	}
+	if len(cfg.Models) == 0 {
+		return Config{}, fmt.Errorf("no model configured for %s: %w", info.Name, ErrNotConfigured)
+	}
+
+	return cfg, nil
+}
+
+func providerInfo(id string) (ProviderInfo, bool) {
+	for _, info := range Providers {
+		if info.ID == id {
+			return info, true
+		}
+	}
+	return ProviderInfo{}, false
+}
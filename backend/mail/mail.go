@@ -0,0 +1,177 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"baseful/db"
+)
+
+// ErrNotConfigured is returned when no SMTP server is configured
+var ErrNotConfigured = errors.New("SMTP is not configured")
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+const (
+	dialTimeout = 15 * time.Second
+	sendTimeout = time.Minute
+)
+
+// Send delivers a message through the configured SMTP server
+func Send(settings db.SMTPSettings, msg Message) error {
+	if !settings.Configured() {
+		return ErrNotConfigured
+	}
+	to, err := netmail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+	from, err := netmail.ParseAddress(settings.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+	from.Name = settings.FromName
+
+	addr := net.JoinHostPort(settings.Host, strconv.Itoa(settings.Port))
+	tlsConfig := &tls.Config{ServerName: settings.Host, MinVersion: tls.VersionTLS12}
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var conn net.Conn
+	if settings.Security == db.SMTPSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(sendTimeout))
+
+	client, err := smtp.NewClient(conn, settings.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	defer client.Close()
+
+	if settings.Security == db.SMTPSecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+	// PlainAuth refuses to send credentials over an unencrypted connection
+	// to anything but localhost
+	if settings.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", settings.Username, settings.Password, settings.Host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return fmt.Errorf("sender rejected: %w", err)
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("recipient rejected: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	data, err := buildMessage(from, to, msg)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return client.Quit()
+}
+
+func buildMessage(from, to *netmail.Address, msg Message) ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	var buf bytes.Buffer
+	headers := [][2]string{
+		{"From", from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", h[0], h[1])
+	}
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// AppURL returns the address of this instance used in links: the configured
+// app URL, the configured domain, or the public IP and port.
+func AppURL(settings db.SMTPSettings) string {
+	if settings.AppURL != "" {
+		return settings.AppURL
+	}
+	if domain, _ := db.GetSetting("domain_name"); domain != "" {
+		if ssl, _ := db.GetSetting("domain_ssl_enabled"); ssl == "true" {
+			return "https://" + domain
+		}
+		return "http://" + domain
+	}
+	host := os.Getenv("PUBLIC_IP")
+	if host == "" {
+		host = "localhost"
+	}
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// Link returns an absolute link to a frontend page
+func Link(settings db.SMTPSettings, path string, query url.Values) string {
+	link := AppURL(settings) + path
+	if len(query) > 0 {
+		link += "?" + query.Encode()
+	}
+	return link
+}
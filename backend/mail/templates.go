@@ -0,0 +1,95 @@
+package mail
+
+import (
+	"fmt"
+	"time"
+)
+
+// PasswordReset is sent when someone asks to reset a forgotten password
+func PasswordReset(to, link string, validFor time.Duration) Message {
+	return Message{
+		To:      to,
+		Subject: "Reset your Baseful password",
+		Body: fmt.Sprintf(`Someone asked to reset the password of your Baseful account.
+
+Choose a new password here:
+%s
+
+The link works once and expires in %s. If you did not ask for this, ignore this email; your password stays the same.
+`, link, describeDuration(validFor)),
+	}
+}
+
+// Verification asks a new user to confirm their email address
+func Verification(to, link string, validFor time.Duration) Message {
+	return Message{
+		To:      to,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf(`Welcome to Baseful!
+
+Confirm your email address to activate your account:
+%s
+
+The link expires in %s. If you did not create an account, ignore this email.
+`, link, describeDuration(validFor)),
+	}
+}
+
+// Invitation invites someone to create an account. project is empty for
+// invitations to the instance rather than to a project.
+func Invitation(to, link, inviter, project string, validFor time.Duration) Message {
+	target := "Baseful"
+	if project != "" {
+		target = fmt.Sprintf("the %s project on Baseful", project)
+	}
+	if inviter == "" {
+		inviter = "An administrator"
+	}
+	return Message{
+		To:      to,
+		Subject: fmt.Sprintf("You have been invited to %s", target),
+		Body: fmt.Sprintf(`%s invited you to %s.
+
+Create your account here:
+%s
+
+The link expires in %s.
+`, inviter, target, link, describeDuration(validFor)),
+	}
+}
+
+// DatabaseAlert tells project owners and admins about an event that needs
+// their attention, such as a failover
+func DatabaseAlert(to, database, event, link string) Message {
+	return Message{
+		To:      to,
+		Subject: fmt.Sprintf("Baseful alert for %s", database),
+		Body: fmt.Sprintf(`%s
+
+Open the database in Baseful:
+%s
+`, event, link),
+	}
+}
+
+// Test is sent to check the SMTP settings
+func Test(to string) Message {
+	return Message{
+		To:      to,
+		Subject: "Baseful test email",
+		Body:    "Your SMTP settings work. Baseful can now send password resets, verification emails and invitations.\n",
+	}
+}
+
+func describeDuration(d time.Duration) string {
+	if d >= 48*time.Hour {
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+	if d >= 2*time.Hour {
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	if d >= time.Hour {
+		return "1 hour"
+	}
+	return fmt.Sprintf("%d minutes", int(d.Minutes()))
+}
@@ -4,18 +4,25 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
 	"encoding/base64"
+	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
+	netmail "net/mail"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
@@ -24,14 +31,25 @@ import (
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5"
 	"github.com/joho/godotenv"
 
+	"baseful/audit"
 	"baseful/auth"
 	"baseful/backups"
 	"baseful/db"
 	"baseful/docker"
+	"baseful/failover"
+	"baseful/llm"
+	"baseful/mail"
 	"baseful/metrics"
+	"baseful/pg"
 	"baseful/proxy"
+	"baseful/reconcile"
+	"baseful/replication"
+	"baseful/secrets"
+	"baseful/suspend"
 	"baseful/system"
 )
 
@@ -89,6 +107,178 @@ func sanitizeSQLResponse(raw string) string {
 	return sqlText
 }
 
+// decodeJSONBody binds a JSON request body, keeping numbers exact for row values
+func decodeJSONBody(c *gin.Context, v any) error {
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// connectActiveDatabase opens a direct connection to the database in the :id route
+// parameter, writing the error response and returning false if it is unavailable
+func connectActiveDatabase(c *gin.Context) (*pgx.Conn, bool) {
+	databaseID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid database ID"})
+		return nil, false
+	}
+
+	var status string
+	if err := db.DB.QueryRow("SELECT status FROM databases WHERE id = ?", databaseID).Scan(&status); err != nil {
+		c.JSON(404, gin.H{"error": "Database not found"})
+		return nil, false
+	}
+	// Sleeping databases are woken up by pg.Connect
+	if status != "active" && status != db.StatusSleeping {
+		c.JSON(400, gin.H{"error": "Database is not running"})
+		return nil, false
+	}
+
+	conn, err := pg.Connect(c.Request.Context(), databaseID)
+	if err != nil {
+		c.JSON(502, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return conn, true
+}
+
+// tableQueryFromParams builds a table query from URL parameters. Besides the
+// JSON-encoded filter and "col:dir,..." sort, it accepts the older
+// filterCol/filterOp/filterVal and sortBy/sortOrder parameters.
+func tableQueryFromParams(c *gin.Context) (pg.TableQuery, error) {
+	var query pg.TableQuery
+	var err error
+
+	if query.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "100")); err != nil {
+		return query, fmt.Errorf("invalid limit")
+	}
+	if query.Offset, err = strconv.Atoi(c.DefaultQuery("offset", "0")); err != nil {
+		return query, fmt.Errorf("invalid offset")
+	}
+	query.Keyset = c.Query("pagination") == "keyset"
+	query.Cursor = c.Query("cursor")
+
+	var filters []pg.Filter
+	if raw := c.Query("filter"); raw != "" {
+		var filter pg.Filter
+		decoder := json.NewDecoder(strings.NewReader(raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(&filter); err != nil {
+			return query, fmt.Errorf("invalid filter: %v", err)
+		}
+		filters = append(filters, filter)
+	}
+	if filterCol, filterVal := c.Query("filterCol"), c.Query("filterVal"); filterCol != "" && filterVal != "" {
+		switch c.Query("filterOp") {
+		case "equals":
+			filters = append(filters, pg.Filter{Column: filterCol, Op: "eq", Value: filterVal, AsText: true})
+		case "contains":
+			filters = append(filters, pg.Filter{Column: filterCol, Op: "contains", Value: filterVal})
+		}
+	}
+	if len(filters) == 1 {
+		query.Filter = &filters[0]
+	} else if len(filters) > 1 {
+		query.Filter = &pg.Filter{And: filters}
+	}
+
+	if sort := c.Query("sort"); sort != "" {
+		query.Sort = pg.ParseSort(sort)
+	} else if sortBy := c.Query("sortBy"); sortBy != "" {
+		query.Sort = []pg.SortKey{{Column: sortBy, Direction: c.DefaultQuery("sortOrder", "asc")}}
+	}
+
+	return query, nil
+}
+
+// respondTableData runs a table query and writes the table browser response
+func respondTableData(c *gin.Context, query pg.TableQuery) {
+	tableName := c.Param("tableName")
+	schema := c.DefaultQuery("schema", "public")
+
+	conn, ok := connectActiveDatabase(c)
+	if !ok {
+		return
+	}
+	defer conn.Close(context.Background())
+	ctx := c.Request.Context()
+
+	// The legacy sortBy parameter defaults to "id" in older clients, so an
+	// unknown column there falls back to the default order instead of failing
+	if len(query.Sort) == 1 && c.Query("sort") == "" && c.Query("sortBy") != "" {
+		columns, err := pg.GetColumns(ctx, conn, schema, tableName)
+		if err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		known := false
+		for _, col := range columns {
+			known = known || col.Name == query.Sort[0].Column
+		}
+		if !known {
+			query.Sort = nil
+		}
+	}
+
+	result, err := pg.QueryTable(ctx, conn, schema, tableName, query)
+	if err != nil {
+		c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	metadata, err := pg.GetTableMetadata(ctx, conn, schema, tableName)
+	if err != nil {
+		c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	// nullable stays "YES"/"NO" as in information_schema for existing clients
+	columns := []gin.H{}
+	for _, col := range result.Columns {
+		nullable := "NO"
+		if col.Nullable {
+			nullable = "YES"
+		}
+		columns = append(columns, gin.H{
+			"name":         col.Name,
+			"type":         col.Type,
+			"nullable":     nullable,
+			"default":      col.Default,
+			"isPrimaryKey": col.IsPrimaryKey,
+			"isGenerated":  col.IsGenerated,
+		})
+	}
+
+	relations := []gin.H{}
+	for _, fk := range metadata.ForeignKeys {
+		for i, column := range fk.Columns {
+			if i >= len(fk.ReferencedColumns) {
+				break
+			}
+			relations = append(relations, gin.H{
+				"sourceColumn":     column,
+				"referencedSchema": fk.ReferencedSchema,
+				"referencedTable":  fk.ReferencedTable,
+				"referencedColumn": fk.ReferencedColumns[i],
+			})
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"name":        tableName,
+		"schema":      schema,
+		"columns":     columns,
+		"relations":   relations,
+		"indexes":     metadata.Indexes,
+		"constraints": metadata.Constraints,
+		"foreignKeys": metadata.ForeignKeys,
+		"rows":        result.Rows,
+		"count":       len(result.Rows),
+		"totalCount":  result.TotalCount,
+		"nextCursor":  result.NextCursor,
+	})
+}
+
 func fetchSchemaSummary(ctx context.Context, cli *client.Client, containerID, dbName string) (string, error) {
 	schemaCmd := []string{"psql", "-U", "postgres", "-d", dbName, "-t", "-A", "-F", "|", "-c",
 		"SELECT table_name, column_name, data_type FROM information_schema.columns WHERE table_schema = 'public' ORDER BY table_name, ordinal_position"}
@@ -147,92 +337,459 @@ func fetchSchemaSummary(ctx context.Context, cli *client.Client, containerID, db
 	return summary, nil
 }
 
-func requestSQLFromOpenRouter(apiKey, systemPrompt, userPrompt string) (string, error) {
-	type message struct {
-		Role    string `json:"role"`
-		Content string `json:"content"`
-	}
-	type openRouterRequest struct {
-		Model       string    `json:"model"`
-		Messages    []message `json:"messages"`
-		Temperature float64   `json:"temperature"`
-		MaxTokens   int       `json:"max_tokens"`
-	}
-	type openRouterResponse struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
-	}
-
-	models := []string{
-		"google/gemini-3.1-flash-lite-preview",
-		"deepseek/deepseek-v3.2",
-	}
-	httpClient := &http.Client{Timeout: 30 * time.Second}
-
-	var lastErr error
-	for _, model := range models {
-		reqBody := openRouterRequest{
-			Model: model,
-			Messages: []message{
-				{Role: "system", Content: systemPrompt},
-				{Role: "user", Content: userPrompt},
-			},
-			Temperature: 0.1,
-			MaxTokens:   800,
-		}
+const sqlGeneratorPrompt = "You are a PostgreSQL SQL generator. Return ONLY executable SQL and nothing else. " +
+	"Do not use markdown fences. Do not add explanations. Use only PostgreSQL syntax. " +
+	"If the request is ambiguous, return a safe SELECT query asking for clarification as a string column."
 
-		bodyBytes, err := json.Marshal(reqBody)
-		if err != nil {
-			return "", err
-		}
+// sqlConfirmationHash identifies the exact SQL a user confirmed for execution
+func sqlConfirmationHash(sqlText string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(sqlText)))
+	return hex.EncodeToString(sum[:])
+}
 
-		req, err := http.NewRequest("POST", "https://openrouter.ai/api/v1/chat/completions", bytes.NewBuffer(bodyBytes))
-		if err != nil {
-			return "", err
+// splitIndexSuggestions separates the CREATE INDEX statements listed after the
+// INDEXES: marker from the rest of an optimization answer
+func splitIndexSuggestions(answer string) (string, []string) {
+	indexes := []string{}
+	advice := answer
+	if idx := strings.LastIndex(answer, "INDEXES:"); idx >= 0 {
+		advice = answer[:idx]
+		for _, line := range strings.Split(answer[idx+len("INDEXES:"):], "\n") {
+			line = strings.TrimSpace(strings.Trim(strings.TrimSpace(line), "`"))
+			upper := strings.ToUpper(line)
+			if strings.HasPrefix(upper, "CREATE INDEX") || strings.HasPrefix(upper, "CREATE UNIQUE INDEX") {
+				indexes = append(indexes, line)
+			}
 		}
-		req.Header.Set("Authorization", "Bearer "+apiKey)
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("HTTP-Referer", "https://baseful.local")
-		req.Header.Set("X-Title", "Baseful SQL Assistant")
+	}
+	return strings.TrimSpace(advice), indexes
+}
 
-		res, err := httpClient.Do(req)
-		if err != nil {
-			lastErr = err
+// completeForProject sends a prompt to the LLM provider configured for a project
+func completeForProject(ctx context.Context, projectID, userID int, systemPrompt, userPrompt string, maxTokens int) (string, error) {
+	cfg, err := llm.Resolve(projectID, userID)
+	if err != nil {
+		return "", err
+	}
+	provider, err := llm.New(cfg)
+	if err != nil {
+		return "", err
+	}
+	return provider.Complete(ctx, llm.Request{
+		System:      systemPrompt,
+		Messages:    []llm.Message{{Role: "user", Content: userPrompt}},
+		Temperature: 0.1,
+		MaxTokens:   maxTokens,
+	})
+}
+
+// llmSettingsResponse renders LLM settings without exposing the API key
+func llmSettingsResponse(settings db.LLMSettings) gin.H {
+	return gin.H{
+		"provider":   settings.Provider,
+		"baseUrl":    settings.BaseURL,
+		"model":      settings.Model,
+		"configured": strings.TrimSpace(settings.APIKey) != "",
+		"maskedKey":  maskAPIKey(settings.APIKey),
+	}
+}
+
+// loginThrottled answers a login attempt that has to wait because of earlier
+// failures from the same IP or for the same account
+func loginThrottled(c *gin.Context, email string, wait time.Duration, locked bool) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if err := audit.Write(audit.Entry{
+		ActorEmail: email, Action: "auth.login", TargetType: "user", IP: c.ClientIP(),
+		Outcome: audit.OutcomeDenied, Status: 429,
+		Details: map[string]any{"reason": "throttled", "locked": locked, "retryAfter": seconds},
+	}); err != nil {
+		log.Printf("Failed to write audit log entry: %v", err)
+	}
+	message := fmt.Sprintf("Too many failed login attempts. Try again in %s.", wait.Round(time.Second))
+	if locked {
+		message = fmt.Sprintf("Too many failed login attempts. Login is locked for %s; an administrator can unlock it.", wait.Round(time.Second))
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(429, gin.H{"error": message, "retryAfter": seconds, "locked": locked})
+}
+
+// loginFailed records a failed login attempt and audits it, plus a lockout
+// entry when this failure locked out the IP or the account
+func loginFailed(c *gin.Context, email string, user *db.User, reason string) {
+	ipLocked, accountLocked := auth.LoginFailure(c.ClientIP(), email)
+	entry := audit.Entry{
+		ActorEmail: email, Action: "auth.login", TargetType: "user", IP: c.ClientIP(),
+		Outcome: audit.OutcomeDenied, Status: 401, Details: map[string]any{"reason": reason},
+	}
+	if user != nil {
+		entry.ActorID, entry.TargetID = user.ID, strconv.Itoa(user.ID)
+	}
+	if err := audit.Write(entry); err != nil {
+		log.Printf("Failed to write audit log entry: %v", err)
+	}
+	for _, lockout := range []struct {
+		locked     bool
+		targetType string
+		targetID   string
+	}{
+		{ipLocked, "ip", c.ClientIP()},
+		{accountLocked, "account", auth.LoginAccountKey(email)},
+	} {
+		if !lockout.locked {
 			continue
 		}
+		if err := audit.Write(audit.Entry{
+			ActorEmail: email, Action: "auth.lockout", TargetType: lockout.targetType, TargetID: lockout.targetID,
+			IP: c.ClientIP(), Outcome: audit.OutcomeDenied, Status: 429,
+		}); err != nil {
+			log.Printf("Failed to write audit log entry: %v", err)
+		}
+	}
+}
 
-		rawBody, _ := io.ReadAll(res.Body)
-		_ = res.Body.Close()
-		if res.StatusCode < 200 || res.StatusCode >= 300 {
-			lastErr = fmt.Errorf("model %s failed: %s", model, strings.TrimSpace(string(rawBody)))
-			continue
+// sessionResponse is the body returned by every endpoint that completes a login
+func sessionResponse(session *auth.Session, user *db.User) gin.H {
+	response := gin.H{
+		"token": session.AccessToken,
+		"user": gin.H{
+			"id":        user.ID,
+			"email":     user.Email,
+			"firstName": user.FirstName,
+			"lastName":  user.LastName,
+			"isAdmin":   user.IsAdmin,
+		},
+	}
+	if session.RefreshToken != "" {
+		response["refreshToken"] = session.RefreshToken
+		response["expiresAt"] = session.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	return response
+}
+
+// startSession records a new server-side session and returns the login response
+func startSession(c *gin.Context, user *db.User) (gin.H, error) {
+	session, err := auth.StartSession(user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		return nil, err
+	}
+	// Failures are only forgotten once the whole login, second factor included, succeeded
+	auth.LoginSuccess(user.Email)
+	return sessionResponse(session, user), nil
+}
+
+// bindAPITokenRequest reads the name, scopes and lifetime of a new API token.
+// It writes the error response itself and reports false on invalid input.
+func bindAPITokenRequest(c *gin.Context, allowAdmin bool) (string, []string, time.Duration, bool) {
+	var req struct {
+		Name          string   `json:"name" binding:"required"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expiresInDays"` // 0 for a token that does not expire
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(400, gin.H{"error": "Token name is required"})
+		return "", nil, 0, false
+	}
+	scopes, err := auth.ValidateScopes(req.Scopes, allowAdmin)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return "", nil, 0, false
+	}
+	ttl := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	if req.ExpiresInDays < 0 || ttl > auth.MaxAPITokenLifetime {
+		c.JSON(400, gin.H{"error": "expiresInDays must be between 0 (no expiry) and 366"})
+		return "", nil, 0, false
+	}
+	return strings.TrimSpace(req.Name), scopes, ttl, true
+}
+
+// mfaStatus describes a user's second factors for the account settings
+func mfaStatus(userID int) (gin.H, error) {
+	m, err := db.GetUserMFA(userID)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := db.ListWebAuthnCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"totpEnabled":            m.TOTPEnabled,
+		"recoveryCodesRemaining": m.RecoveryCodes,
+		"webauthnCredentials":    credentials,
+		"required":               auth.MFARequired(m),
+		"requiredByAdmin":        m.Required,
+		"requiredInstanceWide":   db.IsMFARequiredInstanceWide(),
+	}, nil
+}
+
+// ssoRedirectURL returns the callback URL registered at the identity provider.
+// Without an explicit setting it is derived from the request.
+func ssoRedirectURL(c *gin.Context, settings db.SSOSettings) string {
+	if settings.RedirectURL != "" {
+		return settings.RedirectURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/api/auth/oidc/callback", scheme, c.Request.Host)
+}
+
+// ssoFinish sends the browser back to the login page, which reads the session
+// token or the error from the URL fragment so neither reaches server logs
+func ssoFinish(c *gin.Context, values url.Values) {
+	c.Redirect(http.StatusFound, "/login#"+values.Encode())
+}
+
+// databaseClient returns a Docker client for the server hosting the database
+// of an :id route parameter
+func databaseClient(id string) (*client.Client, error) {
+	databaseID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database ID %q", id)
+	}
+	return docker.NewDatabaseClient(databaseID)
+}
+
+// validReplicaName reports whether a replica name can be used in container
+// and host names: lowercase letters, digits and dashes
+func validReplicaName(name string) bool {
+	if name == "" || len(name) > 32 || strings.HasPrefix(name, "-") || strings.HasSuffix(name, "-") {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
 		}
+	}
+	return true
+}
 
-		var parsed openRouterResponse
-		if err := json.Unmarshal(rawBody, &parsed); err != nil {
-			lastErr = err
-			continue
+// reservedRole reports whether a Postgres role is used by Baseful itself and
+// cannot be changed through the roles API
+func reservedRole(name string) bool {
+	return name == "postgres" || name == replication.ReplicatorRole || strings.HasPrefix(name, "pg_")
+}
+
+// configValue converts a setting value from a JSON request body to the text
+// Postgres expects; null resets the setting to its default
+func configValue(v any) (*string, error) {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		s = v
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		s = "off"
+		if v {
+			s = "on"
+		}
+	default:
+		return nil, fmt.Errorf("expected a string, number, boolean or null")
+	}
+	return &s, nil
+}
+
+// streamContainerLogs answers a log request for a database or branch container.
+// Without ?follow it returns the selected lines as JSON; with ?follow=true it
+// streams them as server-sent "log" events until the client disconnects.
+func streamContainerLogs(c *gin.Context, serverID int, containerID string) {
+	opts := docker.LogOptions{
+		Follow:   c.Query("follow") == "true",
+		Since:    c.Query("since"),
+		Until:    c.Query("until"),
+		Tail:     c.DefaultQuery("tail", "500"),
+		MinLevel: c.Query("level"),
+	}
+	if opts.Tail != "all" {
+		if n, err := strconv.Atoi(opts.Tail); err != nil || n < 0 {
+			c.JSON(400, gin.H{"error": "tail must be a number or all"})
+			return
 		}
-		if len(parsed.Choices) == 0 {
-			lastErr = fmt.Errorf("model %s returned no choices", model)
-			continue
+	}
+	if opts.MinLevel != "" && !docker.ValidLogLevel(opts.MinLevel) {
+		c.JSON(400, gin.H{"error": "Invalid log level"})
+		return
+	}
+	if containerID == "" {
+		c.JSON(400, gin.H{"error": "Database has no container"})
+		return
+	}
+
+	if !opts.Follow {
+		lines := []docker.LogLine{}
+		err := docker.StreamLogs(c.Request.Context(), serverID, containerID, opts, func(line docker.LogLine) error {
+			lines = append(lines, line)
+			return nil
+		})
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
 		}
+		c.JSON(200, gin.H{"lines": lines})
+		return
+	}
 
-		sqlText := sanitizeSQLResponse(parsed.Choices[0].Message.Content)
-		if strings.TrimSpace(sqlText) == "" {
-			lastErr = fmt.Errorf("model %s returned empty SQL", model)
-			continue
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	lines := make(chan docker.LogLine)
+	done := make(chan error, 1)
+	go func() {
+		done <- docker.StreamLogs(ctx, serverID, containerID, opts, func(line docker.LogLine) error {
+			select {
+			case lines <- line:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(200)
+	c.Writer.Flush()
+
+	// Comments keep idle streams open through proxies
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case line := <-lines:
+			c.SSEvent("log", line)
+			c.Writer.Flush()
+		case <-heartbeat.C:
+			io.WriteString(c.Writer, ": keepalive\n\n")
+			c.Writer.Flush()
+		case err := <-done:
+			if err != nil {
+				c.SSEvent("error", gin.H{"error": err.Error()})
+			}
+			c.SSEvent("end", gin.H{})
+			c.Writer.Flush()
+			return
+		case <-ctx.Done():
+			return
 		}
-		return sqlText, nil
 	}
+}
+
+// terminalUpgrader accepts WebSocket terminals from any origin. Requests are
+// authenticated with a token offered as a subprotocol, never with cookies,
+// so other sites cannot open a terminal on behalf of a signed-in admin.
+var terminalUpgrader = websocket.Upgrader{
+	Subprotocols: []string{auth.WebSocketProtocol},
+	CheckOrigin:  func(r *http.Request) bool { return true },
+}
+
+// terminalMessage is a JSON control message in a terminal session. Terminal
+// bytes travel as binary frames in both directions; text frames carry
+// "input" and "resize" from the client and "exit" from the server.
+type terminalMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data,omitempty"`
+	Rows uint   `json:"rows,omitempty"`
+	Cols uint   `json:"cols,omitempty"`
+	Code *int   `json:"code,omitempty"`
+}
+
+// pumpTerminal connects a WebSocket to a container terminal until the process
+// exits or the client disconnects. It returns the exit code, -1 if unknown.
+func pumpTerminal(ws *websocket.Conn, term *docker.Terminal) int {
+	var writeMu sync.Mutex
+	write := func(messageType int, data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return ws.WriteMessage(messageType, data)
+	}
+
+	// Clients that stop answering pings are dropped
+	const pongWait = 60 * time.Second
+	ws.SetReadDeadline(time.Now().Add(pongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := term.Read(buf)
+			if n > 0 {
+				if werr := write(websocket.BinaryMessage, buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	inputDone := make(chan struct{})
+	go func() {
+		defer close(inputDone)
+		for {
+			messageType, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if messageType == websocket.BinaryMessage {
+				if _, err := term.Write(data); err != nil {
+					return
+				}
+				continue
+			}
+			var msg terminalMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				continue
+			}
+			switch msg.Type {
+			case "input":
+				if _, err := term.Write([]byte(msg.Data)); err != nil {
+					return
+				}
+			case "resize":
+				if msg.Rows > 0 && msg.Cols > 0 {
+					if err := term.Resize(context.Background(), msg.Rows, msg.Cols); err != nil {
+						log.Printf("Failed to resize terminal: %v", err)
+					}
+				}
+			}
+		}
+	}()
 
-	if lastErr != nil {
-		return "", lastErr
+	ping := time.NewTicker(pongWait / 2)
+	defer ping.Stop()
+	for {
+		select {
+		case <-outputDone:
+			code, err := term.ExitCode(context.Background())
+			if err != nil {
+				log.Printf("Failed to read terminal exit code: %v", err)
+			}
+			if data, err := json.Marshal(terminalMessage{Type: "exit", Code: &code}); err == nil {
+				write(websocket.TextMessage, data)
+			}
+			ws.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, "process exited"),
+				time.Now().Add(time.Second))
+			return code
+		case <-inputDone:
+			return -1
+		case <-ping.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return -1
+			}
+		}
 	}
-	return "", fmt.Errorf("failed to generate SQL")
 }
 
 func main() {
@@ -244,6 +801,17 @@ func main() {
 		}
 	}
 
+	// Maintenance commands such as `baseful rotate-master-key` exit when done
+	if runCommand(os.Args[1:]) {
+		return
+	}
+
+	// Keep maintenance commands such as rotate-master-key from running
+	// while this process uses the database
+	if err := db.LockShared(); err != nil {
+		panic(err)
+	}
+
 	// Initialize database
 	if err := db.InitDB(); err != nil {
 		panic(err)
@@ -273,6 +841,14 @@ func main() {
 		fmt.Printf("Warning: Failed to create Docker network: %v\n", err)
 	}
 
+	// Keep database and branch statuses in sync with their containers
+	fmt.Println("Initializing container reconciler...")
+	reconcile.Start(context.Background())
+
+	// Health-check databases in high-availability mode and fail over
+	fmt.Println("Initializing failover monitor...")
+	failover.Start(context.Background())
+
 	fmt.Println("Initializing PostgreSQL Proxy (Background mode)...")
 	go func() {
 		if err := proxy.Run(); err != nil {
@@ -297,6 +873,12 @@ func main() {
 
 	r := gin.Default()
 
+	// Login throttling keys on the client IP, so X-Forwarded-For is only
+	// honoured from proxies we trust
+	if err := r.SetTrustedProxies(auth.TrustedProxies()); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// ========== AUTH ENDPOINTS (Unprotected) ==========
 
 	// Auth status - tell if there's an admin user
@@ -306,71 +888,397 @@ func main() {
 			c.JSON(500, gin.H{"error": "Failed to check users"})
 			return
 		}
-		c.JSON(200, gin.H{"initialized": hasUser})
+		sso := db.GetSSOSettings()
+		label := sso.Label
+		if label == "" {
+			label = "Single sign-on"
+		}
+		c.JSON(200, gin.H{
+			"initialized":   hasUser,
+			"sso":           gin.H{"enabled": sso.Enabled, "label": label},
+			"passwordReset": db.GetSMTPSettings().Configured(),
+		})
 	})
 
-	// Login endpoint
-	r.POST("/api/auth/login", func(c *gin.Context) {
-		var req struct {
-			Email    string `json:"email" binding:"required"`
-			Password string `json:"password" binding:"required"`
+	// Start an OIDC login: redirects to the identity provider (authorization code + PKCE)
+	r.GET("/api/auth/oidc/login", func(c *gin.Context) {
+		settings := db.GetSSOSettings()
+		returnTo := c.Query("returnTo")
+		if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+			returnTo = "/"
 		}
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(400, gin.H{"error": "Email and password are required"})
+
+		authURL, state, err := auth.StartOIDCLogin(c.Request.Context(), settings, ssoRedirectURL(c, settings), returnTo)
+		if err != nil {
+			ssoFinish(c, url.Values{"sso_error": {err.Error()}})
 			return
 		}
 
-		user, err := db.GetUserByEmail(req.Email)
+		// Binding the state to the browser prevents login CSRF
+		secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie("baseful_oidc_state", state, 600, "/api/auth/oidc", "", secure, true)
+		c.Redirect(http.StatusFound, authURL)
+	})
+
+	// OIDC callback: verifies the ID token, provisions the user and issues a session
+	r.GET("/api/auth/oidc/callback", func(c *gin.Context) {
+		settings := db.GetSSOSettings()
+		entry := audit.Entry{Action: "auth.sso_login", TargetType: "user", IP: c.ClientIP()}
+		fail := func(status int, message string, details map[string]any) {
+			entry.Outcome = audit.OutcomeDenied
+			entry.Status = status
+			entry.Details = details
+			if entry.Details == nil {
+				entry.Details = map[string]any{}
+			}
+			entry.Details["error"] = message
+			if err := audit.Write(entry); err != nil {
+				log.Printf("Failed to write audit log entry: %v", err)
+			}
+			ssoFinish(c, url.Values{"sso_error": {message}})
+		}
+
+		if idpError := c.Query("error"); idpError != "" {
+			fail(401, "Identity provider error: "+idpError+" "+c.Query("error_description"), nil)
+			return
+		}
+		state := c.Query("state")
+		cookie, _ := c.Cookie("baseful_oidc_state")
+		c.SetCookie("baseful_oidc_state", "", -1, "/api/auth/oidc", "", false, true)
+		if state == "" || cookie != state {
+			fail(401, "Login request does not match this browser, please try again", nil)
+			return
+		}
+
+		identity, returnTo, err := auth.FinishOIDCLogin(c.Request.Context(), settings, ssoRedirectURL(c, settings), state, c.Query("code"))
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Internal server error"})
+			fail(401, err.Error(), nil)
 			return
 		}
+		entry.ActorEmail = identity.Email
 
-		if user == nil || !db.CheckPasswordHash(req.Password, user.PasswordHash) {
-			c.JSON(401, gin.H{"error": "Invalid email or password"})
+		user, err := auth.ProvisionSSOUser(settings, identity)
+		if errors.Is(err, auth.ErrSSONotAllowed) {
+			fail(403, err.Error(), map[string]any{"groups": identity.Groups})
+			return
+		}
+		if err != nil {
+			log.Printf("SSO provisioning failed for %s: %v", identity.Email, err)
+			fail(500, "Failed to sign in", nil)
 			return
 		}
 
-		token, err := auth.GenerateUserJWT(user.ID, user.Email, user.IsAdmin)
+		// Second factors are left to the identity provider for SSO sign-ins
+		session, err := auth.StartSession(user, c.Request.UserAgent(), c.ClientIP())
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to generate session"})
+			fail(500, "Failed to generate session", nil)
 			return
 		}
 
-		c.JSON(200, gin.H{
-			"token": token,
-			"user": gin.H{
-				"id":        user.ID,
-				"email":     user.Email,
-				"firstName": user.FirstName,
-				"lastName":  user.LastName,
-				"isAdmin":   user.IsAdmin,
-			},
+		entry.ActorID = user.ID
+		entry.TargetID = strconv.Itoa(user.ID)
+		entry.Outcome = audit.OutcomeSuccess
+		entry.Status = 200
+		entry.Details = map[string]any{"groups": identity.Groups, "isAdmin": user.IsAdmin}
+		if err := audit.Write(entry); err != nil {
+			log.Printf("Failed to write audit log entry: %v", err)
+		}
+		ssoFinish(c, url.Values{
+			"sso_token":         {session.AccessToken},
+			"sso_refresh_token": {session.RefreshToken},
+			"return_to":         {returnTo},
 		})
 	})
 
-	// Register endpoint (First User or Whitelisted)
-	r.POST("/api/auth/register", func(c *gin.Context) {
+	// Login endpoint
+	r.POST("/api/auth/login", func(c *gin.Context) {
 		var req struct {
-			Email     string `json:"email" binding:"required"`
-			Password  string `json:"password" binding:"required"`
-			FirstName string `json:"firstName" binding:"required"`
-			LastName  string `json:"lastName" binding:"required"`
+			Email    string `json:"email" binding:"required"`
+			Password string `json:"password" binding:"required"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(400, gin.H{"error": "Missing required fields"})
+			c.JSON(400, gin.H{"error": "Email and password are required"})
 			return
 		}
 
-		// Check if any users exist
-		hasUser, err := db.HasAnyUser()
+		if wait, locked := auth.LoginWait(c.ClientIP(), req.Email); wait > 0 {
+			loginThrottled(c, req.Email, wait, locked)
+			return
+		}
+
+		user, err := db.GetUserByEmail(req.Email)
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Internal server error"})
 			return
 		}
 
-		isAdmin := false
-		if !hasUser {
+		if user == nil || !db.CheckPasswordHash(req.Password, user.PasswordHash) {
+			loginFailed(c, req.Email, user, "invalid_credentials")
+			c.JSON(401, gin.H{"error": "Invalid email or password"})
+			return
+		}
+		if !user.EmailVerified {
+			c.JSON(403, gin.H{"error": "Confirm your email address before logging in", "emailVerificationRequired": true})
+			return
+		}
+
+		// A second factor, when enrolled, is checked before any session is issued
+		mfa, err := db.GetUserMFA(user.ID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+		if mfa.Enrolled() {
+			mfaToken, err := auth.GenerateMFAPendingJWT(user.ID, user.Email)
+			if err != nil {
+				c.JSON(500, gin.H{"error": "Failed to generate session"})
+				return
+			}
+			methods := []string{}
+			if mfa.TOTPEnabled {
+				methods = append(methods, "totp")
+			}
+			if mfa.WebAuthnCount > 0 {
+				methods = append(methods, "webauthn")
+			}
+			if mfa.RecoveryCodes > 0 {
+				methods = append(methods, "recovery_code")
+			}
+			c.JSON(200, gin.H{"mfaRequired": true, "mfaToken": mfaToken, "methods": methods})
+			return
+		}
+		if auth.MFARequired(mfa) {
+			token, err := auth.GenerateMFASetupJWT(user.ID, user.Email, user.IsAdmin)
+			if err != nil {
+				c.JSON(500, gin.H{"error": "Failed to generate session"})
+				return
+			}
+			response := sessionResponse(&auth.Session{AccessToken: token}, user)
+			response["mfaSetupRequired"] = true
+			c.JSON(200, response)
+			return
+		}
+
+		response, err := startSession(c, user)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to generate session"})
+			return
+		}
+
+		c.JSON(200, response)
+	})
+
+	// pendingMFAUser resolves the user of a login waiting for its second factor
+	pendingMFAUser := func(c *gin.Context, mfaToken string) (*auth.JWTClaims, *db.User, bool) {
+		claims, err := auth.ValidateMFAPendingJWT(mfaToken)
+		if err != nil {
+			c.JSON(401, gin.H{"error": "Login expired, please log in again"})
+			return nil, nil, false
+		}
+		user, err := db.GetUserByID(claims.UserID)
+		if err != nil || user == nil {
+			c.JSON(401, gin.H{"error": "Login expired, please log in again"})
+			return nil, nil, false
+		}
+		if wait, locked := auth.LoginWait(c.ClientIP(), user.Email); wait > 0 {
+			loginThrottled(c, user.Email, wait, locked)
+			return nil, nil, false
+		}
+		return claims, user, true
+	}
+
+	// completeMFALogin issues the session once the second factor checked out.
+	// claims is the pending login, which cannot start another session after
+	// this; passkey logins have none.
+	completeMFALogin := func(c *gin.Context, claims *auth.JWTClaims, user *db.User, method string) {
+		if claims != nil {
+			if err := auth.ConsumeMFAPendingJWT(claims); errors.Is(err, auth.ErrMFATokenUsed) {
+				c.JSON(401, gin.H{"error": "Login expired, please log in again"})
+				return
+			} else if err != nil {
+				c.JSON(500, gin.H{"error": "Failed to generate session"})
+				return
+			}
+		}
+		response, err := startSession(c, user)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to generate session"})
+			return
+		}
+		if err := audit.Write(audit.Entry{
+			ActorID: user.ID, ActorEmail: user.Email, Action: "auth.mfa_login", TargetType: "user",
+			TargetID: strconv.Itoa(user.ID), IP: c.ClientIP(), Outcome: audit.OutcomeSuccess, Status: 200,
+			Details: map[string]any{"method": method},
+		}); err != nil {
+			log.Printf("Failed to write audit log entry: %v", err)
+		}
+		c.JSON(200, response)
+	}
+
+	// failMFALogin counts a wrong second factor against the pending login
+	failMFALogin := func(c *gin.Context, claims *auth.JWTClaims, user *db.User, method, message string) {
+		auth.RecordMFAFailure(claims)
+		if err := audit.Write(audit.Entry{
+			ActorID: user.ID, ActorEmail: user.Email, Action: "auth.mfa_login", TargetType: "user",
+			TargetID: strconv.Itoa(user.ID), IP: c.ClientIP(), Outcome: audit.OutcomeDenied, Status: 401,
+			Details: map[string]any{"method": method},
+		}); err != nil {
+			log.Printf("Failed to write audit log entry: %v", err)
+		}
+		// Wrong codes count towards the login lockout like wrong passwords
+		loginFailed(c, user.Email, user, "invalid_"+method)
+		c.JSON(401, gin.H{"error": message})
+	}
+
+	// Second login step: a TOTP code or a recovery code
+	r.POST("/api/auth/login/mfa", func(c *gin.Context) {
+		var req struct {
+			MFAToken string `json:"mfaToken" binding:"required"`
+			Code     string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "mfaToken and code are required"})
+			return
+		}
+		claims, user, ok := pendingMFAUser(c, req.MFAToken)
+		if !ok {
+			return
+		}
+
+		method, err := auth.VerifySecondFactorCode(user.ID, req.Code)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to verify code"})
+			return
+		}
+		if method == "" {
+			failMFALogin(c, claims, user, "code", "Invalid authentication code")
+			return
+		}
+		completeMFALogin(c, claims, user, method)
+	})
+
+	// Second login step with a security key: fetch the challenge
+	r.POST("/api/auth/login/webauthn/begin", func(c *gin.Context) {
+		var req struct {
+			MFAToken string `json:"mfaToken" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "mfaToken is required"})
+			return
+		}
+		_, user, ok := pendingMFAUser(c, req.MFAToken)
+		if !ok {
+			return
+		}
+
+		ceremonyID, options, err := auth.BeginWebAuthnLogin(c.Request, user)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"ceremonyId": ceremonyID, "options": options})
+	})
+
+	// Second login step with a security key: verify the signed challenge
+	r.POST("/api/auth/login/webauthn/finish", func(c *gin.Context) {
+		var req struct {
+			MFAToken   string          `json:"mfaToken" binding:"required"`
+			CeremonyID string          `json:"ceremonyId" binding:"required"`
+			Credential json.RawMessage `json:"credential" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "mfaToken, ceremonyId and credential are required"})
+			return
+		}
+		claims, user, ok := pendingMFAUser(c, req.MFAToken)
+		if !ok {
+			return
+		}
+
+		if err := auth.FinishWebAuthnLogin(c.Request, user, req.CeremonyID, req.Credential); err != nil {
+			failMFALogin(c, claims, user, "webauthn", err.Error())
+			return
+		}
+		completeMFALogin(c, claims, user, "webauthn")
+	})
+
+	// Passwordless login with a passkey: fetch the challenge
+	r.POST("/api/auth/passkey/begin", func(c *gin.Context) {
+		ceremonyID, options, err := auth.BeginPasskeyLogin(c.Request)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"ceremonyId": ceremonyID, "options": options})
+	})
+
+	// Passwordless login with a passkey: verify the signed challenge
+	r.POST("/api/auth/passkey/finish", func(c *gin.Context) {
+		var req struct {
+			CeremonyID string          `json:"ceremonyId" binding:"required"`
+			Credential json.RawMessage `json:"credential" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "ceremonyId and credential are required"})
+			return
+		}
+
+		// The account is only known once the passkey is looked up
+		if wait, locked := auth.LoginWait(c.ClientIP(), ""); wait > 0 {
+			loginThrottled(c, "", wait, locked)
+			return
+		}
+
+		user, err := auth.FinishPasskeyLogin(c.Request, req.CeremonyID, req.Credential)
+		if err != nil {
+			entry := audit.Entry{
+				Action: "auth.passkey_login", TargetType: "user", IP: c.ClientIP(),
+				Outcome: audit.OutcomeDenied, Status: 401, Details: map[string]any{"error": err.Error()},
+			}
+			email := ""
+			if user != nil {
+				email = user.Email
+				entry.ActorID, entry.ActorEmail, entry.TargetID = user.ID, user.Email, strconv.Itoa(user.ID)
+			}
+			if werr := audit.Write(entry); werr != nil {
+				log.Printf("Failed to write audit log entry: %v", werr)
+			}
+			loginFailed(c, email, user, "invalid_passkey")
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		}
+		if wait, locked := auth.LoginWait(c.ClientIP(), user.Email); wait > 0 {
+			loginThrottled(c, user.Email, wait, locked)
+			return
+		}
+		completeMFALogin(c, nil, user, "passkey")
+	})
+
+	// Register endpoint (First User or Whitelisted)
+	r.POST("/api/auth/register", func(c *gin.Context) {
+		var req struct {
+			Email     string `json:"email" binding:"required"`
+			Password  string `json:"password" binding:"required"`
+			FirstName string `json:"firstName" binding:"required"`
+			LastName  string `json:"lastName" binding:"required"`
+			// InviteToken comes from an invitation email and proves the address
+			InviteToken string `json:"inviteToken"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Missing required fields"})
+			return
+		}
+
+		// Check if any users exist
+		hasUser, err := db.HasAnyUser()
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		isAdmin := false
+		if !hasUser {
 			// First user is Admin
 			isAdmin = true
 		} else {
@@ -392,28 +1300,205 @@ func main() {
 			c.JSON(500, gin.H{"error": "Failed to create user"})
 			return
 		}
+		if err := db.AcceptProjectInvitations(userID, req.Email); err != nil {
+			log.Printf("Failed to accept project invitations for %s: %v", req.Email, err)
+		}
 
-		token, _ := auth.GenerateUserJWT(userID, req.Email, isAdmin)
-		c.JSON(201, gin.H{
-			"token": token,
-			"user": gin.H{
-				"id":        userID,
-				"email":     req.Email,
-				"firstName": req.FirstName,
-				"lastName":  req.LastName,
-				"isAdmin":   isAdmin,
-			},
-		})
+		user := &db.User{ID: userID, Email: req.Email, FirstName: req.FirstName, LastName: req.LastName, IsAdmin: isAdmin, EmailVerified: true}
+
+		// With mail set up, new accounts confirm their address before they can
+		// log in, unless they registered through an invitation link. The first
+		// admin is exempt so setting up an instance never needs SMTP.
+		if !isAdmin && db.GetSMTPSettings().Configured() && (req.InviteToken == "" || !auth.RedeemInvitation(req.InviteToken, req.Email)) {
+			if err := db.SetUserEmailVerified(userID, false); err != nil {
+				c.JSON(500, gin.H{"error": "Failed to create user"})
+				return
+			}
+			if err := auth.SendEmailVerification(user); err != nil {
+				log.Printf("Failed to send verification email to %s: %v", req.Email, err)
+			}
+			c.JSON(201, gin.H{"verificationRequired": true, "message": "Check your inbox to confirm your email address"})
+			return
+		}
+
+		if db.IsMFARequiredInstanceWide() {
+			token, _ := auth.GenerateMFASetupJWT(userID, req.Email, isAdmin)
+			response := sessionResponse(&auth.Session{AccessToken: token}, user)
+			response["mfaSetupRequired"] = true
+			c.JSON(201, response)
+			return
+		}
+
+		response, err := startSession(c, user)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to generate session"})
+			return
+		}
+		c.JSON(201, response)
+	})
+
+	// Exchange a refresh token for new tokens. Refresh tokens rotate: each one
+	// works once, and presenting a used one again revokes the session.
+	r.POST("/api/auth/refresh", func(c *gin.Context) {
+		var req struct {
+			RefreshToken string `json:"refreshToken" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "refreshToken is required"})
+			return
+		}
+
+		session, user, err := auth.RefreshSession(req.RefreshToken, c.ClientIP())
+		if errors.Is(err, auth.ErrRefreshTokenReused) {
+			sessionID, _, _ := strings.Cut(req.RefreshToken, ".")
+			if werr := audit.Write(audit.Entry{
+				Action: "auth.refresh_token_reuse", TargetType: "session", TargetID: sessionID, IP: c.ClientIP(),
+				Outcome: audit.OutcomeDenied, Status: 401,
+			}); werr != nil {
+				log.Printf("Failed to write audit log entry: %v", werr)
+			}
+		}
+		if errors.Is(err, auth.ErrRefreshTokenReused) || errors.Is(err, auth.ErrInvalidRefreshToken) {
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to refresh session: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to refresh session"})
+			return
+		}
+		c.JSON(200, sessionResponse(session, user))
+	})
+
+	// ========== PASSWORD RESET & EMAIL VERIFICATION ==========
+	// Without SMTP, a forgotten password is recovered on the server with
+	// `baseful reset-password <email>`.
+
+	// Request a password reset link. The answer does not reveal whether the
+	// account exists, and the email is sent in the background so timing doesn't either.
+	r.POST("/api/auth/password/forgot", func(c *gin.Context) {
+		var req struct {
+			Email string `json:"email" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Email is required"})
+			return
+		}
+		if !db.GetSMTPSettings().Configured() {
+			c.JSON(503, gin.H{"error": "Password reset by email is not set up. Ask an administrator for help."})
+			return
+		}
+
+		ip := c.ClientIP()
+		go func() {
+			user, err := auth.SendPasswordReset(req.Email)
+			if user == nil && err == nil {
+				return
+			}
+			entry := audit.Entry{
+				ActorEmail: req.Email, Action: "auth.password_reset_request", TargetType: "user",
+				IP: ip, Outcome: audit.OutcomeSuccess, Status: 200,
+			}
+			if user != nil {
+				entry.ActorID, entry.TargetID = user.ID, strconv.Itoa(user.ID)
+			}
+			if err != nil {
+				log.Printf("Failed to send password reset email: %v", err)
+				entry.Outcome, entry.Status = audit.OutcomeFailure, 500
+				entry.Details = map[string]any{"error": err.Error()}
+			}
+			if werr := audit.Write(entry); werr != nil {
+				log.Printf("Failed to write audit log entry: %v", werr)
+			}
+		}()
+		c.JSON(200, gin.H{"message": "If an account exists for this email, a reset link is on its way"})
+	})
+
+	// Set a new password with the token from a reset email. Signs out every session.
+	r.POST("/api/auth/password/reset", func(c *gin.Context) {
+		var req struct {
+			Token    string `json:"token" binding:"required"`
+			Password string `json:"password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Token and password are required"})
+			return
+		}
+
+		user, err := auth.ResetPassword(req.Token, req.Password)
+		if errors.Is(err, auth.ErrInvalidEmailToken) {
+			if werr := audit.Write(audit.Entry{
+				Action: "auth.password_reset", TargetType: "user", IP: c.ClientIP(),
+				Outcome: audit.OutcomeDenied, Status: 400,
+			}); werr != nil {
+				log.Printf("Failed to write audit log entry: %v", werr)
+			}
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to reset password: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to reset password"})
+			return
+		}
+		if werr := audit.Write(audit.Entry{
+			ActorID: user.ID, ActorEmail: user.Email, Action: "auth.password_reset", TargetType: "user",
+			TargetID: strconv.Itoa(user.ID), IP: c.ClientIP(), Outcome: audit.OutcomeSuccess, Status: 200,
+		}); werr != nil {
+			log.Printf("Failed to write audit log entry: %v", werr)
+		}
+		c.JSON(200, gin.H{"message": "Password updated. You can now log in."})
 	})
 
-	// Debug endpoint to reset admin (Only for dev/retry)
-	r.POST("/api/debug/reset-admin", func(c *gin.Context) {
-		_, err := db.DB.Exec("DELETE FROM users")
+	// Confirm an email address with the token from a verification email
+	r.POST("/api/auth/email/verify", func(c *gin.Context) {
+		var req struct {
+			Token string `json:"token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Token is required"})
+			return
+		}
+
+		user, err := auth.VerifyEmail(req.Token)
+		if errors.Is(err, auth.ErrInvalidEmailToken) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to reset users"})
+			log.Printf("Failed to verify email: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to verify email"})
+			return
+		}
+		if werr := audit.Write(audit.Entry{
+			ActorID: user.ID, ActorEmail: user.Email, Action: "auth.email_verify", TargetType: "user",
+			TargetID: strconv.Itoa(user.ID), IP: c.ClientIP(), Outcome: audit.OutcomeSuccess, Status: 200,
+		}); werr != nil {
+			log.Printf("Failed to write audit log entry: %v", werr)
+		}
+		c.JSON(200, gin.H{"message": "Email confirmed. You can now log in.", "email": user.Email})
+	})
+
+	// Send a new verification email. Like the password reset, the answer does
+	// not reveal whether the account exists.
+	r.POST("/api/auth/email/resend", func(c *gin.Context) {
+		var req struct {
+			Email string `json:"email" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Email is required"})
 			return
 		}
-		c.JSON(200, gin.H{"message": "All users deleted. You can now setup a new admin."})
+		go func() {
+			user, err := db.GetUserByEmail(strings.TrimSpace(req.Email))
+			if err != nil || user == nil || user.EmailVerified {
+				return
+			}
+			if err := auth.SendEmailVerification(user); err != nil {
+				log.Printf("Failed to send verification email: %v", err)
+			}
+		}()
+		c.JSON(200, gin.H{"message": "If this account is waiting for confirmation, a new email is on its way"})
 	})
 
 	// ========== STATIC ASSETS & PUBLIC ROUTES ==========
@@ -436,6 +1521,8 @@ func main() {
 	}
 
 	r.Use(auth.AuthMiddleware())
+	r.Use(audit.Middleware())
+	r.Use(auth.ProjectAccess())
 
 	// Profile endpoint
 	r.GET("/api/auth/me", func(c *gin.Context) {
@@ -505,742 +1592,2090 @@ func main() {
 			return
 		}
 
-		c.JSON(200, gin.H{"message": "Password updated successfully"})
+		// Sign out every other device; whoever knew the old password may be using one
+		revoked, err := db.RevokeUserSessions(user.ID, c.GetString("session_id"))
+		if err != nil {
+			log.Printf("Failed to revoke sessions of user %d: %v", user.ID, err)
+		}
+		audit.Annotate(c, "auth.password_change", "user", strconv.Itoa(user.ID), map[string]any{"revokedSessions": revoked})
+
+		c.JSON(200, gin.H{"message": "Password updated successfully", "revokedSessions": revoked})
 	})
 
-	// Upload avatar
-	r.POST("/api/auth/avatar", func(c *gin.Context) {
-		userID := c.MustGet("user_id").(int)
-		log.Printf("Received avatar upload request from user ID: %d\n", userID)
+	// ========== SESSIONS ==========
 
-		file, err := c.FormFile("avatar")
-		if err != nil {
-			log.Printf("Error getting form file: %v\n", err)
-			c.JSON(400, gin.H{"error": "No avatar file uploaded or invalid format"})
+	// Sign out: revoke the current session
+	r.POST("/api/auth/logout", func(c *gin.Context) {
+		sessionID := c.GetString("session_id")
+		if sessionID == "" {
+			c.JSON(200, gin.H{"message": "Logged out"})
 			return
 		}
-
-		// Create uploads directory if it doesn't exist
-		uploadDir := "./uploads/avatars"
-		if err := os.MkdirAll(uploadDir, 0755); err != nil {
-			log.Printf("Error creating directory: %v\n", err)
-			c.JSON(500, gin.H{"error": "Failed to create upload directory"})
+		audit.Annotate(c, "auth.logout", "session", sessionID, nil)
+		if err := db.RevokeUserSession(c.GetInt("user_id"), sessionID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			c.JSON(500, gin.H{"error": "Failed to log out"})
 			return
 		}
+		c.JSON(200, gin.H{"message": "Logged out"})
+	})
 
-		filename := fmt.Sprintf("%d_%d_%s", userID, time.Now().Unix(), file.Filename)
-		filepath := fmt.Sprintf("%s/%s", uploadDir, filename)
-		log.Printf("Saving avatar to: %s\n", filepath)
+	// List the active sessions of the current user
+	r.GET("/api/auth/sessions", func(c *gin.Context) {
+		sessions, err := db.ListUserSessions(c.GetInt("user_id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to load sessions"})
+			return
+		}
+		current := c.GetString("session_id")
+		result := make([]gin.H, 0, len(sessions))
+		for _, s := range sessions {
+			result = append(result, gin.H{
+				"id":         s.ID,
+				"device":     auth.DescribeUserAgent(s.UserAgent),
+				"userAgent":  s.UserAgent,
+				"ip":         s.IP,
+				"createdAt":  s.CreatedAt,
+				"lastSeenAt": s.LastSeenAt,
+				"expiresAt":  s.ExpiresAt,
+				"current":    s.ID == current,
+			})
+		}
+		c.JSON(200, result)
+	})
 
-		if err := c.SaveUploadedFile(file, filepath); err != nil {
-			log.Printf("Error saving file: %v\n", err)
-			c.JSON(500, gin.H{"error": "Failed to save avatar"})
+	// Revoke one session of the current user
+	r.DELETE("/api/auth/sessions/:sessionId", func(c *gin.Context) {
+		sessionID := c.Param("sessionId")
+		audit.Annotate(c, "auth.session_revoke", "session", sessionID, nil)
+		err := db.RevokeUserSession(c.GetInt("user_id"), sessionID)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(404, gin.H{"error": "Session not found"})
 			return
 		}
-
-		avatarURL := fmt.Sprintf("/uploads/avatars/%s", filename)
-		if err := db.UpdateUserAvatar(userID, avatarURL); err != nil {
-			log.Printf("Error updating DB: %v\n", err)
-			c.JSON(500, gin.H{"error": "Failed to update user avatar"})
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to revoke session"})
 			return
 		}
-
-		log.Printf("Avatar uploaded successfully: %s\n", avatarURL)
-		c.JSON(200, gin.H{"avatarUrl": avatarURL})
+		c.JSON(200, gin.H{"message": "Session revoked"})
 	})
 
-	// OpenRouter API key status
-	r.GET("/api/auth/openrouter-key", func(c *gin.Context) {
-		userID := c.MustGet("user_id").(int)
-		apiKey, err := db.GetUserOpenRouterAPIKey(userID)
+	// Revoke all sessions of the current user. With keepCurrent=true the
+	// session making the request stays signed in.
+	r.DELETE("/api/auth/sessions", func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		keep := ""
+		if c.Query("keepCurrent") == "true" {
+			keep = c.GetString("session_id")
+		}
+		revoked, err := db.RevokeUserSessions(userID, keep)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to load OpenRouter key status"})
+			c.JSON(500, gin.H{"error": "Failed to revoke sessions"})
 			return
 		}
-
-		trimmed := strings.TrimSpace(apiKey)
-		c.JSON(200, gin.H{
-			"configured": len(trimmed) > 0,
-			"maskedKey":  maskAPIKey(trimmed),
-		})
+		audit.Annotate(c, "auth.session_revoke_all", "user", strconv.Itoa(userID), map[string]any{"revoked": revoked, "keepCurrent": keep != ""})
+		c.JSON(200, gin.H{"message": "Sessions revoked", "revoked": revoked})
 	})
 
-	// Set or clear OpenRouter API key
-	r.PUT("/api/auth/openrouter-key", func(c *gin.Context) {
-		userID := c.MustGet("user_id").(int)
-		var req struct {
-			APIKey string `json:"apiKey"`
+	// Sign a user out everywhere (Admin only)
+	r.DELETE("/api/auth/users/:id/sessions", auth.AdminOnly(), func(c *gin.Context) {
+		userID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid user ID"})
+			return
 		}
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(400, gin.H{"error": "Invalid request"})
+		revoked, err := db.RevokeUserSessions(userID, "")
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to revoke sessions"})
 			return
 		}
+		audit.Annotate(c, "user.session_revoke_all", "user", c.Param("id"), map[string]any{"revoked": revoked})
+		c.JSON(200, gin.H{"message": "Sessions revoked", "revoked": revoked})
+	})
 
-		apiKey := strings.TrimSpace(req.APIKey)
-		if err := db.UpdateUserOpenRouterAPIKey(userID, apiKey); err != nil {
-			c.JSON(500, gin.H{"error": "Failed to save OpenRouter API key"})
+	// ========== PERSONAL ACCESS TOKENS ==========
+
+	// Scopes that can be granted to API tokens
+	r.GET("/api/auth/tokens/scopes", func(c *gin.Context) {
+		c.JSON(200, auth.Scopes)
+	})
+
+	// List the personal access tokens of the current user
+	r.GET("/api/auth/tokens", func(c *gin.Context) {
+		tokens, err := db.ListPersonalAccessTokens(c.GetInt("user_id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to load tokens"})
 			return
 		}
-
-		c.JSON(200, gin.H{
-			"configured": len(apiKey) > 0,
-			"maskedKey":  maskAPIKey(apiKey),
-		})
+		c.JSON(200, tokens)
 	})
 
-	// Whitelist management (Admin only)
-	r.GET("/api/auth/whitelist", auth.AdminOnly(), func(c *gin.Context) {
-		emails, err := db.GetWhitelistedEmails()
+	// Create a personal access token. The secret is only returned once.
+	r.POST("/api/auth/tokens", func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		name, scopes, ttl, ok := bindAPITokenRequest(c, c.GetBool("is_admin"))
+		if !ok {
+			return
+		}
+
+		secret, token, err := auth.CreateAPIToken(userID, 0, userID, name, scopes, ttl)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to query whitelist"})
+			c.JSON(500, gin.H{"error": "Failed to create token"})
 			return
 		}
-		c.JSON(200, emails)
+		audit.Annotate(c, "api_token.create", "api_token", strconv.Itoa(token.ID), map[string]any{"name": name, "scopes": scopes, "expiresAt": token.ExpiresAt})
+		c.JSON(201, gin.H{"token": secret, "apiToken": token})
 	})
 
-	r.POST("/api/auth/whitelist", auth.AdminOnly(), func(c *gin.Context) {
-		var req struct {
-			Email string `json:"email" binding:"required"`
+	// Revoke one of the current user's personal access tokens
+	r.DELETE("/api/auth/tokens/:tokenId", func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("tokenId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid token ID"})
+			return
 		}
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(400, gin.H{"error": "Email is required"})
+		audit.Annotate(c, "api_token.revoke", "api_token", c.Param("tokenId"), nil)
+		err = db.RevokePersonalAccessToken(c.GetInt("user_id"), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(404, gin.H{"error": "Token not found"})
 			return
 		}
-		if err := db.AddEmailToWhitelist(req.Email); err != nil {
-			c.JSON(500, gin.H{"error": "Failed to add email to whitelist"})
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to revoke token"})
 			return
 		}
-		c.JSON(200, gin.H{"message": "Email added to whitelist"})
+		c.JSON(200, gin.H{"message": "Token revoked"})
 	})
 
-	r.DELETE("/api/auth/whitelist/:email", auth.AdminOnly(), func(c *gin.Context) {
-		email := c.Param("email")
-		if err := db.RemoveEmailFromWhitelist(email); err != nil {
-			c.JSON(500, gin.H{"error": "Failed to remove email from whitelist"})
+	// ========== TWO-FACTOR AUTHENTICATION ==========
+
+	// Second factor status of the current user
+	r.GET("/api/auth/mfa", func(c *gin.Context) {
+		status, err := mfaStatus(c.GetInt("user_id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to load two-factor settings"})
 			return
 		}
-		c.JSON(200, gin.H{"message": "Email removed from whitelist"})
+		c.JSON(200, status)
 	})
 
-	r.GET("/api/hello", func(c *gin.Context) {
-		c.JSON(200, gin.H{"message": "Baseful API is running"})
-	})
+	// finishMFAEnrollment upgrades a session that was limited to enrollment
+	finishMFAEnrollment := func(c *gin.Context, response gin.H) {
+		if !c.GetBool("mfa_setup") {
+			return
+		}
+		user, err := db.GetUserByID(c.GetInt("user_id"))
+		if err != nil || user == nil {
+			return
+		}
+		if session, err := startSession(c, user); err == nil {
+			for k, v := range session {
+				response[k] = v
+			}
+		}
+	}
 
-	r.GET("/api/system/update-status", func(c *gin.Context) {
-		c.JSON(200, system.GetUpdateStatus())
-	})
+	// Start TOTP enrollment: returns a new secret to add to an authenticator app
+	r.POST("/api/auth/mfa/totp/setup", func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		m, err := db.GetUserMFA(userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to load two-factor settings"})
+			return
+		}
+		if m.TOTPEnabled {
+			c.JSON(409, gin.H{"error": "An authenticator app is already set up. Disable it first to replace it."})
+			return
+		}
 
-	// Web Server Endpoints
-	r.GET("/api/system/webserver/status", func(c *gin.Context) {
-		info, err := system.GetDomainInfo()
+		secret, err := auth.GenerateTOTPSecret()
 		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+			c.JSON(500, gin.H{"error": "Failed to generate secret"})
 			return
 		}
-		c.JSON(200, info)
+		if err := db.SetUserTOTPSecret(userID, secret); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to store secret"})
+			return
+		}
+		c.JSON(200, gin.H{"secret": secret, "otpauthUri": auth.TOTPURI(secret, c.GetString("email"))})
 	})
 
-	r.POST("/api/system/webserver/domain", func(c *gin.Context) {
+	// Confirm TOTP enrollment with a first code. Returns the recovery codes, once.
+	r.POST("/api/auth/mfa/totp/enable", func(c *gin.Context) {
 		var req struct {
-			Domain string `json:"domain"`
+			Code string `json:"code" binding:"required"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+			c.JSON(400, gin.H{"error": "Code is required"})
 			return
 		}
-		if err := system.SaveDomain(req.Domain); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+		userID := c.GetInt("user_id")
+		m, err := db.GetUserMFA(userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to load two-factor settings"})
 			return
 		}
-		c.JSON(200, gin.H{"message": "Domain saved"})
-	})
-
-	r.GET("/api/system/webserver/check-dns", func(c *gin.Context) {
-		domain, _ := db.GetSetting("domain_name")
-		if domain == "" {
-			c.JSON(400, gin.H{"error": "No domain configured"})
+		if m.TOTPEnabled || m.TOTPSecret == "" {
+			c.JSON(409, gin.H{"error": "Start the authenticator app setup first"})
 			return
 		}
-		propagated, err := system.CheckPropagation(domain)
-		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+		step, ok := auth.ValidateTOTP(m.TOTPSecret, req.Code, time.Now())
+		if !ok {
+			c.JSON(400, gin.H{"error": "Invalid authentication code"})
 			return
 		}
-		c.JSON(200, gin.H{"propagated": propagated})
-	})
+		audit.Annotate(c, "auth.mfa_enroll", "user", strconv.Itoa(userID), map[string]any{"method": "totp"})
 
-	r.POST("/api/system/webserver/provision-ssl", func(c *gin.Context) {
-		domain, _ := db.GetSetting("domain_name")
-		if domain == "" {
-			c.JSON(400, gin.H{"error": "No domain configured"})
+		codes, err := auth.GenerateRecoveryCodes(10)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to generate recovery codes"})
 			return
 		}
-		if err := system.ProvisionSSL(domain); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+		if err := db.EnableUserTOTP(userID, step); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to enable two-factor authentication"})
+			return
+		}
+		if err := db.ReplaceRecoveryCodes(userID, codes); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to store recovery codes"})
 			return
 		}
-		c.JSON(200, gin.H{"message": "SSL provisioned successfully"})
-	})
 
-	// Get monitoring settings
-	r.GET("/api/settings", func(c *gin.Context) {
-		enabled, _ := db.GetSetting("metrics_enabled")
-		rateStr, _ := db.GetSetting("metrics_sample_rate")
-		rate, _ := strconv.Atoi(rateStr)
-		c.JSON(200, gin.H{
-			"metrics_enabled":     enabled == "true",
-			"metrics_sample_rate": rate,
-		})
+		response := gin.H{"message": "Two-factor authentication enabled", "recoveryCodes": codes}
+		finishMFAEnrollment(c, response)
+		c.JSON(200, response)
 	})
 
-	// Update monitoring settings
-	r.POST("/api/settings", func(c *gin.Context) {
+	// Disable TOTP; requires a current code or a recovery code
+	r.DELETE("/api/auth/mfa/totp", func(c *gin.Context) {
 		var req struct {
-			MetricsEnabled    bool   `json:"metrics_enabled"`
-			MetricsSampleRate string `json:"metrics_sample_rate"`
+			Code string `json:"code" binding:"required"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+			c.JSON(400, gin.H{"error": "Code is required"})
 			return
 		}
+		userID := c.GetInt("user_id")
+		audit.Annotate(c, "auth.mfa_disable", "user", strconv.Itoa(userID), map[string]any{"method": "totp"})
 
-		enabledStr := "false"
-		if req.MetricsEnabled {
-			enabledStr = "true"
+		m, err := db.GetUserMFA(userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to load two-factor settings"})
+			return
+		}
+		if !m.TOTPEnabled {
+			c.JSON(404, gin.H{"error": "No authenticator app is set up"})
+			return
+		}
+		if auth.MFARequired(m) && m.WebAuthnCount == 0 {
+			c.JSON(409, gin.H{"error": "Two-factor authentication is required. Add a security key before removing the authenticator app."})
+			return
+		}
+		method, err := auth.VerifySecondFactorCode(userID, req.Code)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to verify code"})
+			return
+		}
+		if method == "" {
+			c.JSON(401, gin.H{"error": "Invalid authentication code"})
+			return
 		}
 
-		_ = db.UpdateSetting("metrics_enabled", enabledStr)
-		_ = db.UpdateSetting("metrics_sample_rate", req.MetricsSampleRate)
-
-		c.JSON(200, gin.H{"status": "ok"})
-	})
-
-	r.POST("/api/system/update-check", func(c *gin.Context) {
-		system.CheckForUpdates()
-		c.JSON(200, system.GetUpdateStatus())
+		if err := db.DisableUserTOTP(userID); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to disable two-factor authentication"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "Authenticator app removed"})
 	})
 
-	r.POST("/api/system/update", func(c *gin.Context) {
-		if err := system.RunUpdate(); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+	// Replace the recovery codes; requires a current code
+	r.POST("/api/auth/mfa/recovery-codes", func(c *gin.Context) {
+		var req struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Code is required"})
 			return
 		}
-		c.JSON(200, gin.H{"message": "Update initiated successfully. The system will restart in a few moments."})
-	})
+		userID := c.GetInt("user_id")
+		audit.Annotate(c, "auth.mfa_recovery_codes", "user", strconv.Itoa(userID), nil)
 
-	// ========== BACKUPS API ==========
-	r.GET("/api/databases/:id/backups", func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.Atoi(idStr)
+		m, err := db.GetUserMFA(userID)
 		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			c.JSON(500, gin.H{"error": "Failed to load two-factor settings"})
 			return
 		}
-		list, err := backups.ListBackups(id)
+		if !m.Enrolled() {
+			c.JSON(409, gin.H{"error": "Set up two-factor authentication first"})
+			return
+		}
+		method, err := auth.VerifySecondFactorCode(userID, req.Code)
 		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+			c.JSON(500, gin.H{"error": "Failed to verify code"})
 			return
 		}
-		// If list is nil (empty), return empty array
-		if list == nil {
-			list = []backups.Backup{}
+		if method == "" {
+			c.JSON(401, gin.H{"error": "Invalid authentication code"})
+			return
 		}
-		c.JSON(200, list)
-	})
 
-	r.GET("/api/databases/:id/backups/settings", func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.Atoi(idStr)
+		codes, err := auth.GenerateRecoveryCodes(10)
 		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			c.JSON(500, gin.H{"error": "Failed to generate recovery codes"})
 			return
 		}
-		settings, err := backups.GetBackupSettings(id)
-		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+		if err := db.ReplaceRecoveryCodes(userID, codes); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to store recovery codes"})
 			return
 		}
-		c.JSON(200, settings)
+		c.JSON(200, gin.H{"recoveryCodes": codes})
 	})
 
-	r.POST("/api/databases/:id/backups/settings", func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.Atoi(idStr)
-		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid database ID"})
-			return
-		}
-		var settings backups.BackupSettings
-		if err := c.BindJSON(&settings); err != nil {
-			c.JSON(400, gin.H{"error": "Invalid JSON"})
+	// Start registering a security key or passkey
+	r.POST("/api/auth/mfa/webauthn/register/begin", func(c *gin.Context) {
+		user, err := db.GetUserByID(c.GetInt("user_id"))
+		if err != nil || user == nil {
+			c.JSON(404, gin.H{"error": "User not found"})
 			return
 		}
-		settings.DatabaseID = id
-		if err := backups.SaveBackupSettings(&settings); err != nil {
+		ceremonyID, options, err := auth.BeginWebAuthnRegistration(c.Request, user)
+		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(200, gin.H{"message": "Settings saved"})
+		c.JSON(200, gin.H{"ceremonyId": ceremonyID, "options": options})
 	})
 
-	r.POST("/api/databases/:id/backups/manual", func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.Atoi(idStr)
-		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid database ID"})
+	// Finish registering a security key or passkey. Recovery codes are issued
+	// with the first second factor.
+	r.POST("/api/auth/mfa/webauthn/register/finish", func(c *gin.Context) {
+		var req struct {
+			CeremonyID string          `json:"ceremonyId" binding:"required"`
+			Name       string          `json:"name"`
+			Credential json.RawMessage `json:"credential" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "ceremonyId and credential are required"})
 			return
 		}
-		go func() {
-			fmt.Printf("Starting manual backup for DB %d...\n", id)
-			if err := backups.PerformBackup(id); err != nil {
-				fmt.Printf("Backup failed for DB %d: %v\n", id, err)
-			} else {
-				fmt.Printf("Backup completed for DB %d\n", id)
-			}
-		}()
-		c.JSON(200, gin.H{"message": "Backup started"})
-	})
+		user, err := db.GetUserByID(c.GetInt("user_id"))
+		if err != nil || user == nil {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		audit.Annotate(c, "auth.mfa_enroll", "user", strconv.Itoa(user.ID), map[string]any{"method": "webauthn", "name": req.Name})
 
-	r.POST("/api/databases/:id/backups/:backupId/restore", func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.Atoi(idStr)
+		m, err := db.GetUserMFA(user.ID)
 		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			c.JSON(500, gin.H{"error": "Failed to load two-factor settings"})
 			return
 		}
-		backupIdStr := c.Param("backupId")
-		backupId, err := strconv.Atoi(backupIdStr)
-		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid backup ID"})
+		if err := auth.FinishWebAuthnRegistration(c.Request, user, req.CeremonyID, req.Name, req.Credential); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
 
-		go func() {
-			fmt.Printf("Starting restore for DB %d from backup %d...\n", id, backupId)
-			if err := backups.RestoreBackup(id, backupId); err != nil {
-				fmt.Printf("Restore failed for DB %d: %v\n", id, backupId)
-			} else {
-				fmt.Printf("Restore completed for DB %d\n", id)
+		response := gin.H{"message": "Security key registered"}
+		if m.RecoveryCodes == 0 {
+			codes, err := auth.GenerateRecoveryCodes(10)
+			if err == nil {
+				err = db.ReplaceRecoveryCodes(user.ID, codes)
 			}
-		}()
-		c.JSON(200, gin.H{"message": "Restore started"})
+			if err != nil {
+				c.JSON(500, gin.H{"error": "Failed to store recovery codes"})
+				return
+			}
+			response["recoveryCodes"] = codes
+		}
+		finishMFAEnrollment(c, response)
+		c.JSON(200, response)
 	})
 
-	r.POST("/api/databases/:id/backups/:backupId/download-decrypted", func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.Atoi(idStr)
+	// Remove a security key or passkey
+	r.DELETE("/api/auth/mfa/webauthn/:credentialId", func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		id, err := strconv.Atoi(c.Param("credentialId"))
 		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			c.JSON(400, gin.H{"error": "Invalid credential ID"})
 			return
 		}
-		backupIdStr := c.Param("backupId")
-		backupId, err := strconv.Atoi(backupIdStr)
+		audit.Annotate(c, "auth.mfa_disable", "user", strconv.Itoa(userID), map[string]any{"method": "webauthn", "credentialId": id})
+
+		m, err := db.GetUserMFA(userID)
 		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid backup ID"})
+			c.JSON(500, gin.H{"error": "Failed to load two-factor settings"})
+			return
+		}
+		if auth.MFARequired(m) && !m.TOTPEnabled && m.WebAuthnCount <= 1 {
+			c.JSON(409, gin.H{"error": "Two-factor authentication is required. Set up another second factor before removing this one."})
+			return
+		}
+		if err := db.DeleteWebAuthnCredential(userID, id); err != nil {
+			c.JSON(404, gin.H{"error": "Security key not found"})
 			return
 		}
+		c.JSON(200, gin.H{"message": "Security key removed"})
+	})
 
+	// Instance-wide two-factor enforcement (Admin only)
+	r.GET("/api/settings/mfa", auth.AdminOnly(), func(c *gin.Context) {
+		c.JSON(200, gin.H{"required": db.IsMFARequiredInstanceWide()})
+	})
+
+	r.PUT("/api/settings/mfa", auth.AdminOnly(), func(c *gin.Context) {
 		var req struct {
-			PrivateKey string `json:"private_key"`
-			Passphrase string `json:"passphrase"`
+			Required bool `json:"required"`
 		}
-		_ = c.ShouldBindJSON(&req)
-
-		reader, filename, _, err := backups.GetBackupDownloadReader(id, backupId, req.PrivateKey, req.Passphrase)
-		if err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
 			return
 		}
-		defer reader.Close()
-
-		c.Header("Content-Type", "application/sql")
-		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-		if _, err := io.Copy(c.Writer, reader); err != nil {
-			c.JSON(500, gin.H{"error": "Failed to stream backup"})
+		audit.Annotate(c, "settings.mfa", "instance", "", map[string]any{"required": req.Required})
+		if err := db.UpdateSetting("mfa_required", strconv.FormatBool(req.Required)); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to save setting"})
 			return
 		}
+		c.JSON(200, gin.H{"required": req.Required})
 	})
 
-	r.POST("/api/databases/:id/backups/:backupId/restore-with-key", func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.Atoi(idStr)
-		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid database ID"})
-			return
-		}
-		backupIdStr := c.Param("backupId")
-		backupId, err := strconv.Atoi(backupIdStr)
+	// Require two-factor authentication for one user (Admin only)
+	r.PUT("/api/auth/users/:id/mfa", auth.AdminOnly(), func(c *gin.Context) {
+		userID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid backup ID"})
+			c.JSON(400, gin.H{"error": "Invalid user ID"})
 			return
 		}
-
 		var req struct {
-			PrivateKey string `json:"private_key"`
-			Passphrase string `json:"passphrase"`
+			Required bool `json:"required"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(400, gin.H{"error": "Invalid request body"})
+			c.JSON(400, gin.H{"error": "Invalid request"})
 			return
 		}
-		if strings.TrimSpace(req.PrivateKey) == "" {
-			c.JSON(400, gin.H{"error": "private_key is required"})
+		audit.Annotate(c, "user.mfa_required", "user", c.Param("id"), map[string]any{"required": req.Required})
+		if user, err := db.GetUserByID(userID); err != nil || user == nil {
+			c.JSON(404, gin.H{"error": "User not found"})
 			return
 		}
-
-		// Validate key/decryption upfront so the client gets immediate feedback for wrong keys.
-		reader, _, _, err := backups.GetBackupDownloadReader(id, backupId, req.PrivateKey, req.Passphrase)
+		if err := db.SetUserMFARequired(userID, req.Required); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to update user"})
+			return
+		}
+		status, err := mfaStatus(userID)
 		if err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+			c.JSON(500, gin.H{"error": "Failed to load two-factor settings"})
 			return
 		}
-		_ = reader.Close()
-
-		go func() {
-			fmt.Printf("Starting encrypted restore for DB %d from backup %d...\n", id, backupId)
-			if err := backups.RestoreBackupWithPrivateKey(id, backupId, req.PrivateKey, req.Passphrase); err != nil {
-				fmt.Printf("Encrypted restore failed for DB %d backup %d: %v\n", id, backupId, err)
-			} else {
-				fmt.Printf("Encrypted restore completed for DB %d backup %d\n", id, backupId)
-			}
-		}()
-		c.JSON(200, gin.H{"message": "Restore started"})
+		c.JSON(200, status)
 	})
 
-	// Restore from uploaded file
-	r.POST("/api/databases/:id/restore/file", func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.Atoi(idStr)
+	// Remove every second factor of a user who lost their device (Admin only)
+	r.DELETE("/api/auth/users/:id/mfa", auth.AdminOnly(), func(c *gin.Context) {
+		userID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			c.JSON(400, gin.H{"error": "Invalid user ID"})
 			return
 		}
-
-		file, header, err := c.Request.FormFile("file")
-		if err != nil {
-			c.JSON(400, gin.H{"error": "No file uploaded"})
+		audit.Annotate(c, "user.mfa_reset", "user", c.Param("id"), nil)
+		if user, err := db.GetUserByID(userID); err != nil || user == nil {
+			c.JSON(404, gin.H{"error": "User not found"})
 			return
 		}
-		defer file.Close()
-
-		fmt.Printf("Starting restore for DB %d from uploaded file: %s\n", id, header.Filename)
+		if err := db.ResetUserMFA(userID); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to reset two-factor authentication"})
+			return
+		}
+		// The lost device may still be signed in
+		if _, err := db.RevokeUserSessions(userID, ""); err != nil {
+			log.Printf("Failed to revoke sessions of user %d: %v", userID, err)
+		}
+		c.JSON(200, gin.H{"message": "Two-factor authentication reset"})
+	})
 
-		go func() {
-			if err := backups.RestoreFromFile(id, file); err != nil {
-				fmt.Printf("Restore from file failed for DB %d: %v\n", id, err)
-			} else {
-				fmt.Printf("Restore from file completed for DB %d\n", id)
-			}
-		}()
-		c.JSON(200, gin.H{"message": "Restore started"})
+	// List IPs and accounts slowed down or locked out after failed logins, and
+	// clients the database proxy turned away after failed token checks (Admin only)
+	r.GET("/api/auth/lockouts", auth.AdminOnly(), func(c *gin.Context) {
+		ips, accounts := auth.LoginLockouts()
+		c.JSON(200, gin.H{"ips": ips, "accounts": accounts, "proxy": proxy.AuthLockouts()})
 	})
 
-	// Restore from external connection
-	r.POST("/api/databases/:id/restore/connection", func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.Atoi(idStr)
-		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid database ID"})
+	// Unlock an account after failed logins (Admin only)
+	r.DELETE("/api/auth/lockouts/accounts/:email", auth.AdminOnly(), func(c *gin.Context) {
+		email := auth.LoginAccountKey(c.Param("email"))
+		audit.Annotate(c, "auth.unlock", "account", email, nil)
+		if !auth.UnlockLoginAccount(email) {
+			c.JSON(404, gin.H{"error": "Account is not locked out"})
 			return
 		}
+		c.JSON(200, gin.H{"message": "Account unlocked"})
+	})
 
-		var req struct {
-			ConnectionString string `json:"connection_string"`
-		}
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(400, gin.H{"error": "Invalid request body"})
+	// Unlock a client IP for both the login and the database proxy (Admin only)
+	r.DELETE("/api/auth/lockouts/ips/:ip", auth.AdminOnly(), func(c *gin.Context) {
+		ip := c.Param("ip")
+		audit.Annotate(c, "auth.unlock", "ip", ip, nil)
+		login := auth.UnlockLoginIP(ip)
+		dbProxy := proxy.UnlockClient(ip)
+		if !login && !dbProxy {
+			c.JSON(404, gin.H{"error": "IP is not locked out"})
 			return
 		}
+		c.JSON(200, gin.H{"message": "IP unlocked"})
+	})
 
-		if req.ConnectionString == "" {
-			c.JSON(400, gin.H{"error": "Connection string is required"})
+	// Unlock a user's account after failed logins (Admin only)
+	r.DELETE("/api/auth/users/:id/lockout", auth.AdminOnly(), func(c *gin.Context) {
+		userID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid user ID"})
 			return
 		}
-
-		fmt.Printf("Starting restore for DB %d from external connection\n", id)
-
-		go func() {
-			if err := backups.RestoreFromConnection(id, req.ConnectionString); err != nil {
-				fmt.Printf("Restore from connection failed for DB %d: %v\n", id, err)
-			} else {
-				fmt.Printf("Restore from connection completed for DB %d\n", id)
-			}
-		}()
-		c.JSON(200, gin.H{"message": "Restore started"})
+		audit.Annotate(c, "auth.unlock", "user", c.Param("id"), nil)
+		user, err := db.GetUserByID(userID)
+		if err != nil || user == nil {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		auth.UnlockLoginAccount(user.Email)
+		c.JSON(200, gin.H{"message": "Account unlocked"})
 	})
 
-	// ========== PROJECTS API ==========
-
-	// Get all projects
-	r.GET("/api/projects", func(c *gin.Context) {
-		rows, err := db.DB.Query("SELECT id, name, description, created_at FROM projects ORDER BY created_at DESC")
+	// Mark a user's email address as confirmed, e.g. when the verification email
+	// never arrived (Admin only)
+	r.POST("/api/auth/users/:id/verify-email", auth.AdminOnly(), func(c *gin.Context) {
+		userID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to query projects: " + err.Error()})
+			c.JSON(400, gin.H{"error": "Invalid user ID"})
 			return
 		}
-		defer rows.Close()
-
-		projects := []map[string]interface{}{}
-		for rows.Next() {
-			var id int
-			var name, description, createdAt string
-			if err := rows.Scan(&id, &name, &description, &createdAt); err != nil {
-				c.JSON(500, gin.H{"error": "Failed to scan project: " + err.Error()})
-				return
-			}
-
-			projects = append(projects, map[string]interface{}{
-				"id":          id,
-				"name":        name,
-				"description": description,
-				"created_at":  createdAt,
-			})
+		audit.Annotate(c, "user.email_verify", "user", c.Param("id"), nil)
+		if user, err := db.GetUserByID(userID); err != nil || user == nil {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
 		}
-
-		c.JSON(http.StatusOK, projects)
+		if err := db.SetUserEmailVerified(userID, true); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to verify email"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "Email marked as verified"})
 	})
 
-	// Create project
-	r.POST("/api/projects", func(c *gin.Context) {
-		var req struct {
-			Name        string `json:"name"`
-			Description string `json:"description"`
+	// Upload avatar
+	r.POST("/api/auth/avatar", func(c *gin.Context) {
+		userID := c.MustGet("user_id").(int)
+		log.Printf("Received avatar upload request from user ID: %d\n", userID)
+
+		file, err := c.FormFile("avatar")
+		if err != nil {
+			log.Printf("Error getting form file: %v\n", err)
+			c.JSON(400, gin.H{"error": "No avatar file uploaded or invalid format"})
+			return
 		}
-		if err := c.BindJSON(&req); err != nil {
-			c.JSON(400, gin.H{"error": "Invalid request"})
+
+		// Create uploads directory if it doesn't exist
+		uploadDir := "./uploads/avatars"
+		if err := os.MkdirAll(uploadDir, 0755); err != nil {
+			log.Printf("Error creating directory: %v\n", err)
+			c.JSON(500, gin.H{"error": "Failed to create upload directory"})
 			return
 		}
 
-		if req.Name == "" {
-			c.JSON(400, gin.H{"error": "Project name is required"})
+		filename := fmt.Sprintf("%d_%d_%s", userID, time.Now().Unix(), file.Filename)
+		filepath := fmt.Sprintf("%s/%s", uploadDir, filename)
+		log.Printf("Saving avatar to: %s\n", filepath)
+
+		if err := c.SaveUploadedFile(file, filepath); err != nil {
+			log.Printf("Error saving file: %v\n", err)
+			c.JSON(500, gin.H{"error": "Failed to save avatar"})
 			return
 		}
 
-		result, err := db.DB.Exec(
-			"INSERT INTO projects (name, description) VALUES (?, ?)",
-			req.Name, req.Description,
-		)
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to create project: " + err.Error()})
+		avatarURL := fmt.Sprintf("/uploads/avatars/%s", filename)
+		if err := db.UpdateUserAvatar(userID, avatarURL); err != nil {
+			log.Printf("Error updating DB: %v\n", err)
+			c.JSON(500, gin.H{"error": "Failed to update user avatar"})
 			return
 		}
 
-		id, _ := result.LastInsertId()
-		c.JSON(201, gin.H{
-			"id":          id,
-			"name":        req.Name,
-			"description": req.Description,
-		})
+		log.Printf("Avatar uploaded successfully: %s\n", avatarURL)
+		c.JSON(200, gin.H{"avatarUrl": avatarURL})
 	})
 
-	// Get single project by ID
-	r.GET("/api/projects/:id", func(c *gin.Context) {
-		id := c.Param("id")
-		var projectID int
-		var name, description, createdAt string
-
-		err := db.DB.QueryRow(
-			"SELECT id, name, description, created_at FROM projects WHERE id = ?",
-			id,
-		).Scan(&projectID, &name, &description, &createdAt)
-
+	// OpenRouter API key status
+	r.GET("/api/auth/openrouter-key", func(c *gin.Context) {
+		userID := c.MustGet("user_id").(int)
+		apiKey, err := db.GetUserOpenRouterAPIKey(userID)
 		if err != nil {
-			c.JSON(404, gin.H{"error": "Project not found"})
+			c.JSON(500, gin.H{"error": "Failed to load OpenRouter key status"})
 			return
 		}
 
+		trimmed := strings.TrimSpace(apiKey)
 		c.JSON(200, gin.H{
-			"id":          projectID,
-			"name":        name,
-			"description": description,
-			"created_at":  createdAt,
+			"configured": len(trimmed) > 0,
+			"maskedKey":  maskAPIKey(trimmed),
 		})
 	})
 
-	// Update project
-	r.PUT("/api/projects/:id", func(c *gin.Context) {
-		id := c.Param("id")
-
+	// Set or clear OpenRouter API key
+	r.PUT("/api/auth/openrouter-key", func(c *gin.Context) {
+		userID := c.MustGet("user_id").(int)
 		var req struct {
-			Name string `json:"name"`
+			APIKey string `json:"apiKey"`
 		}
-		if err := c.BindJSON(&req); err != nil {
+		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(400, gin.H{"error": "Invalid request"})
 			return
 		}
 
-		if strings.TrimSpace(req.Name) == "" {
-			c.JSON(400, gin.H{"error": "Project name is required"})
+		apiKey := strings.TrimSpace(req.APIKey)
+		if err := db.UpdateUserOpenRouterAPIKey(userID, apiKey); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to save OpenRouter API key"})
 			return
 		}
 
-		result, err := db.DB.Exec(
-			"UPDATE projects SET name = ? WHERE id = ?",
-			strings.TrimSpace(req.Name), id,
-		)
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to update project: " + err.Error()})
-			return
-		}
+		c.JSON(200, gin.H{
+			"configured": len(apiKey) > 0,
+			"maskedKey":  maskAPIKey(apiKey),
+		})
+	})
 
-		rowsAffected, err := result.RowsAffected()
-		if err != nil || rowsAffected == 0 {
-			c.JSON(404, gin.H{"error": "Project not found"})
+	// Whitelist management (Admin only)
+	r.GET("/api/auth/whitelist", auth.AdminOnly(), func(c *gin.Context) {
+		emails, err := db.GetWhitelistedEmails()
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to query whitelist"})
 			return
 		}
+		c.JSON(200, emails)
+	})
 
-		c.JSON(200, gin.H{
-			"message": "Project updated",
-		})
+	r.POST("/api/auth/whitelist", auth.AdminOnly(), func(c *gin.Context) {
+		var req struct {
+			Email string `json:"email" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Email is required"})
+			return
+		}
+		if err := db.AddEmailToWhitelist(req.Email); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to add email to whitelist"})
+			return
+		}
+
+		// Invite the address by email when mail is set up
+		invitationSent := false
+		if db.GetSMTPSettings().Configured() {
+			if err := auth.SendInvitation(req.Email, c.GetString("email"), ""); err != nil {
+				log.Printf("Failed to send invitation to %s: %v", req.Email, err)
+			} else {
+				invitationSent = true
+			}
+		}
+		c.JSON(200, gin.H{"message": "Email added to whitelist", "invitationSent": invitationSent})
 	})
 
-	// Get databases for a project
-	r.GET("/api/projects/:id/databases", func(c *gin.Context) {
-		id := c.Param("id")
-		rows, err := db.DB.Query("SELECT id, name, type, host, port, status FROM databases WHERE project_id = ?", id)
+	// Send the invitation email to a whitelisted address again (Admin only)
+	r.POST("/api/auth/whitelist/:email/invite", auth.AdminOnly(), func(c *gin.Context) {
+		email := c.Param("email")
+		whitelisted, err := db.IsEmailWhitelisted(email)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to query databases: " + err.Error()})
+			c.JSON(500, gin.H{"error": "Failed to query whitelist"})
 			return
 		}
-		defer rows.Close()
-
-		databases := []map[string]interface{}{}
-		for rows.Next() {
-			var dbID, port int
-			var name, dbType, host, status string
-			if err := rows.Scan(&dbID, &name, &dbType, &host, &port, &status); err != nil {
-				c.JSON(500, gin.H{"error": "Failed to scan database: " + err.Error()})
+		if !whitelisted {
+			c.JSON(404, gin.H{"error": "Email is not whitelisted"})
+			return
+		}
+		if err := auth.SendInvitation(email, c.GetString("email"), ""); err != nil {
+			if errors.Is(err, mail.ErrNotConfigured) {
+				c.JSON(400, gin.H{"error": "SMTP is not configured"})
 				return
 			}
+			c.JSON(502, gin.H{"error": "Failed to send invitation: " + err.Error()})
+			return
+		}
+		audit.Annotate(c, "auth.invitation_send", "email", email, nil)
+		c.JSON(200, gin.H{"message": "Invitation sent"})
+	})
 
-			databases = append(databases, map[string]interface{}{
-				"id":     dbID,
-				"name":   name,
-				"type":   dbType,
-				"host":   host,
-				"port":   port,
-				"status": status,
-			})
+	r.DELETE("/api/auth/whitelist/:email", auth.AdminOnly(), func(c *gin.Context) {
+		email := c.Param("email")
+		if err := db.RemoveEmailFromWhitelist(email); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to remove email from whitelist"})
+			return
 		}
+		c.JSON(200, gin.H{"message": "Email removed from whitelist"})
+	})
 
-		c.JSON(http.StatusOK, databases)
+	r.GET("/api/hello", func(c *gin.Context) {
+		c.JSON(200, gin.H{"message": "Baseful API is running"})
 	})
 
-	// ========== DATABASES API ==========
+	r.GET("/api/system/update-status", func(c *gin.Context) {
+		c.JSON(200, system.GetUpdateStatus())
+	})
 
-	// Get all databases
-	r.GET("/api/databases", func(c *gin.Context) {
-		rows, err := db.DB.Query("SELECT id, name, type, host, port, status, project_id FROM databases")
+	// Web Server Endpoints
+	r.GET("/api/system/webserver/status", func(c *gin.Context) {
+		info, err := system.GetDomainInfo()
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to query databases: " + err.Error()})
+			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		defer rows.Close()
+		c.JSON(200, info)
+	})
 
-		databases := []map[string]interface{}{}
-		for rows.Next() {
-			var id, port, projectID int
-			var name, dbType, host, status string
-			if err := rows.Scan(&id, &name, &dbType, &host, &port, &status, &projectID); err != nil {
-				c.JSON(500, gin.H{"error": "Failed to scan database: " + err.Error()})
-				return
-			}
+	r.POST("/api/system/webserver/domain", func(c *gin.Context) {
+		var req struct {
+			Domain string `json:"domain"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := system.SaveDomain(req.Domain); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"message": "Domain saved"})
+	})
 
-			databases = append(databases, map[string]interface{}{
-				"id":        id,
-				"name":      name,
-				"type":      dbType,
-				"host":      host,
-				"port":      port,
-				"status":    status,
-				"projectId": projectID,
-			})
+	r.GET("/api/system/webserver/check-dns", func(c *gin.Context) {
+		domain, _ := db.GetSetting("domain_name")
+		if domain == "" {
+			c.JSON(400, gin.H{"error": "No domain configured"})
+			return
+		}
+		propagated, err := system.CheckPropagation(domain)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"propagated": propagated})
+	})
+
+	r.POST("/api/system/webserver/provision-ssl", func(c *gin.Context) {
+		domain, _ := db.GetSetting("domain_name")
+		if domain == "" {
+			c.JSON(400, gin.H{"error": "No domain configured"})
+			return
 		}
+		if err := system.ProvisionSSL(domain); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"message": "SSL provisioned successfully"})
+	})
 
-		c.JSON(http.StatusOK, databases)
+	// Get monitoring settings
+	r.GET("/api/settings", func(c *gin.Context) {
+		enabled, _ := db.GetSetting("metrics_enabled")
+		rateStr, _ := db.GetSetting("metrics_sample_rate")
+		rate, _ := strconv.Atoi(rateStr)
+		c.JSON(200, gin.H{
+			"metrics_enabled":     enabled == "true",
+			"metrics_sample_rate": rate,
+		})
 	})
 
-	// Create database (Streaming for progress)
-	r.POST("/api/databases", func(c *gin.Context) {
+	// Update monitoring settings
+	r.POST("/api/settings", func(c *gin.Context) {
 		var req struct {
-			Name         string  `json:"name"`
-			Type         string  `json:"type"`
-			Version      string  `json:"version"`
-			ProjectID    int     `json:"projectId"`
-			MaxCPU       float64 `json:"maxCpu"`
-			MaxRAMMB     int     `json:"maxRamMb"`
-			MaxStorageMB int     `json:"maxStorageMb"`
+			MetricsEnabled    bool   `json:"metrics_enabled"`
+			MetricsSampleRate string `json:"metrics_sample_rate"`
 		}
-		if err := c.BindJSON(&req); err != nil {
-			c.JSON(400, gin.H{"error": "Invalid request"})
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
 
-		// Set defaults if not provided
-		if req.MaxCPU == 0 {
-			req.MaxCPU = 1.0
+		enabledStr := "false"
+		if req.MetricsEnabled {
+			enabledStr = "true"
 		}
-		if req.MaxRAMMB == 0 {
-			req.MaxRAMMB = 512
+
+		_ = db.UpdateSetting("metrics_enabled", enabledStr)
+		_ = db.UpdateSetting("metrics_sample_rate", req.MetricsSampleRate)
+
+		c.JSON(200, gin.H{"status": "ok"})
+	})
+
+	// LLM provider defaults for the SQL assistant
+	r.GET("/api/settings/llm", func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"settings":  llmSettingsResponse(db.GetInstanceLLMSettings()),
+			"providers": llm.Providers,
+		})
+	})
+
+	// Update LLM provider defaults (Admin only). Omitting apiKey keeps the stored key.
+	r.PUT("/api/settings/llm", auth.AdminOnly(), func(c *gin.Context) {
+		var req struct {
+			Provider string  `json:"provider"`
+			BaseURL  string  `json:"baseUrl"`
+			Model    string  `json:"model"`
+			APIKey   *string `json:"apiKey"`
 		}
-		if req.MaxStorageMB == 0 {
-			req.MaxStorageMB = 1024
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
 		}
 
-		if req.Type != "postgresql" {
-			c.JSON(400, gin.H{"error": "Only postgresql is supported for now"})
+		settings := db.GetInstanceLLMSettings()
+		settings.Provider = strings.TrimSpace(req.Provider)
+		settings.BaseURL = strings.TrimSpace(req.BaseURL)
+		settings.Model = strings.TrimSpace(req.Model)
+		if req.APIKey != nil {
+			settings.APIKey = strings.TrimSpace(*req.APIKey)
+		}
+		if err := llm.Validate(settings); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
 
-		// Validate project exists
-		if req.ProjectID > 0 {
-			var count int
-			err := db.DB.QueryRow("SELECT COUNT(*) FROM projects WHERE id = ?", req.ProjectID).Scan(&count)
-			if err != nil || count == 0 {
-				c.JSON(400, gin.H{"error": "Invalid project ID"})
-				return
-			}
+		if err := db.UpdateInstanceLLMSettings(settings); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to save LLM settings"})
+			return
 		}
 
-		c.Header("Content-Type", "application/x-ndjson")
-		c.Header("Cache-Control", "no-cache")
-		c.Header("Connection", "keep-alive")
+		c.JSON(200, gin.H{"settings": llmSettingsResponse(settings)})
+	})
 
-		c.Stream(func(w io.Writer) bool {
-			sendUpdate := func(status, message string, progress float64, data any) {
-				update := gin.H{
-					"status":   status,
-					"message":  message,
-					"progress": progress,
-				}
-				if data != nil {
-					update["data"] = data
-				}
-				json.NewEncoder(w).Encode(update)
-				w.(http.Flusher).Flush()
-			}
+	// Get single sign-on settings (Admin only). The client secret is never returned.
+	r.GET("/api/settings/sso", auth.AdminOnly(), func(c *gin.Context) {
+		settings := db.GetSSOSettings()
+		c.JSON(200, gin.H{
+			"settings":         settings,
+			"secretConfigured": settings.ClientSecret != "",
+			"redirectUrl":      ssoRedirectURL(c, settings),
+		})
+	})
 
-			ctx := context.Background()
-			cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-			if err != nil {
-				sendUpdate("error", "Failed to connect to Docker: "+err.Error(), 0, nil)
-				return false
-			}
-			defer cli.Close()
+	// Update single sign-on settings (Admin only). Omitting clientSecret keeps the stored secret.
+	r.PUT("/api/settings/sso", auth.AdminOnly(), func(c *gin.Context) {
+		var req struct {
+			db.SSOSettings
+			ClientSecret *string `json:"clientSecret"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
 
-			imageName := fmt.Sprintf("postgres:%s", req.Version)
-			if req.Version == "" {
-				imageName = "postgres:latest"
-			}
+		settings := req.SSOSettings
+		settings.Issuer = strings.TrimSuffix(strings.TrimSpace(settings.Issuer), "/")
+		settings.ClientID = strings.TrimSpace(settings.ClientID)
+		settings.ClientSecret = db.GetSSOSettings().ClientSecret
+		if req.ClientSecret != nil {
+			settings.ClientSecret = strings.TrimSpace(*req.ClientSecret)
+		}
+		if settings.RoleMappings == nil {
+			settings.RoleMappings = []db.SSORoleMapping{}
+		}
+		if err := auth.ValidateSSOSettings(settings); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 
-			// Pull image with progress tracking
-			sendUpdate("pulling", "Pulling Docker image...", 0, nil)
-			pullResp, err := cli.ImagePull(ctx, imageName, image.PullOptions{})
-			if err != nil {
-				sendUpdate("error", "Failed to pull image: "+err.Error(), 0, nil)
-				return false
-			}
-			defer pullResp.Close()
+		if err := db.UpdateSSOSettings(settings); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to save SSO settings"})
+			return
+		}
+		auth.ResetOIDCCache()
+
+		c.JSON(200, gin.H{
+			"settings":         settings,
+			"secretConfigured": settings.ClientSecret != "",
+			"redirectUrl":      ssoRedirectURL(c, settings),
+		})
+	})
+
+	// Get SMTP settings used for password resets, verification and invitations (Admin only)
+	r.GET("/api/settings/smtp", auth.AdminOnly(), func(c *gin.Context) {
+		settings := db.GetSMTPSettings()
+		c.JSON(200, gin.H{
+			"settings":           settings,
+			"passwordConfigured": settings.Password != "",
+			"appUrl":             mail.AppURL(settings),
+		})
+	})
+
+	// Update SMTP settings (Admin only). Omitting password keeps the stored password.
+	r.PUT("/api/settings/smtp", auth.AdminOnly(), func(c *gin.Context) {
+		var req struct {
+			db.SMTPSettings
+			Password *string `json:"password"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		settings := req.SMTPSettings
+		settings.Password = db.GetSMTPSettings().Password
+		if req.Password != nil {
+			settings.Password = *req.Password
+		}
+		switch settings.Security {
+		case "":
+			settings.Security = db.SMTPSecurityStartTLS
+		case db.SMTPSecurityStartTLS, db.SMTPSecurityTLS, db.SMTPSecurityNone:
+		default:
+			c.JSON(400, gin.H{"error": "security must be one of starttls, tls or none"})
+			return
+		}
+		if settings.Port < 0 || settings.Port > 65535 {
+			c.JSON(400, gin.H{"error": "Invalid port"})
+			return
+		}
+		if settings.From != "" {
+			if _, err := netmail.ParseAddress(settings.From); err != nil {
+				c.JSON(400, gin.H{"error": "Invalid sender address"})
+				return
+			}
+		}
+		if settings.AppURL != "" {
+			if u, err := url.Parse(settings.AppURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				c.JSON(400, gin.H{"error": "appUrl must be an http or https URL"})
+				return
+			}
+		}
+
+		if err := db.UpdateSMTPSettings(settings); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to save SMTP settings"})
+			return
+		}
+		settings = db.GetSMTPSettings()
+		c.JSON(200, gin.H{
+			"settings":           settings,
+			"passwordConfigured": settings.Password != "",
+			"appUrl":             mail.AppURL(settings),
+		})
+	})
+
+	// Send a test email with the saved SMTP settings (Admin only). Defaults to the caller's address.
+	r.POST("/api/settings/smtp/test", auth.AdminOnly(), func(c *gin.Context) {
+		var req struct {
+			To string `json:"to"`
+		}
+		_ = c.ShouldBindJSON(&req)
+		if req.To == "" {
+			req.To = c.GetString("email")
+		}
+		if err := mail.Send(db.GetSMTPSettings(), mail.Test(req.To)); err != nil {
+			if errors.Is(err, mail.ErrNotConfigured) {
+				c.JSON(400, gin.H{"error": "SMTP is not configured"})
+				return
+			}
+			c.JSON(502, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"message": "Test email sent to " + req.To})
+	})
+
+	r.POST("/api/system/update-check", func(c *gin.Context) {
+		system.CheckForUpdates()
+		c.JSON(200, system.GetUpdateStatus())
+	})
+
+	r.POST("/api/system/update", func(c *gin.Context) {
+		if err := system.RunUpdate(); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"message": "Update initiated successfully. The system will restart in a few moments."})
+	})
+
+	// ========== AUDIT LOG API ==========
+
+	// List audit log entries, newest first (Admin only)
+	r.GET("/api/audit", auth.AdminOnly(), func(c *gin.Context) {
+		filter, err := audit.FilterFromQuery(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		entries, total, err := audit.List(filter)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to query audit log"})
+			return
+		}
+		c.JSON(200, gin.H{"entries": entries, "total": total})
+	})
+
+	// Export every matching audit log entry as CSV or JSON (Admin only)
+	r.GET("/api/audit/export", auth.AdminOnly(), func(c *gin.Context) {
+		filter, err := audit.FilterFromQuery(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		format := c.DefaultQuery("format", "csv")
+		if format != "csv" && format != "json" {
+			c.JSON(400, gin.H{"error": "format must be csv or json"})
+			return
+		}
+
+		var entries []audit.Entry
+		filter.Offset = 0
+		filter.Limit = audit.MaxPageSize
+		for {
+			page, _, err := audit.List(filter)
+			if err != nil {
+				c.JSON(500, gin.H{"error": "Failed to query audit log"})
+				return
+			}
+			entries = append(entries, page...)
+			if len(page) < filter.Limit {
+				break
+			}
+			filter.Offset += len(page)
+		}
+
+		filename := fmt.Sprintf("audit-log-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+		if format == "json" {
+			c.JSON(200, entries)
+			return
+		}
+
+		c.Header("Content-Type", "text/csv")
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"id", "created_at", "actor_id", "actor_email", "action", "target_type", "target_id", "ip", "outcome", "status", "details"})
+		for _, e := range entries {
+			details := ""
+			if len(e.Details) > 0 {
+				encoded, _ := json.Marshal(e.Details)
+				details = string(encoded)
+			}
+			w.Write([]string{
+				strconv.FormatInt(e.ID, 10), e.CreatedAt, strconv.Itoa(e.ActorID), e.ActorEmail, e.Action,
+				e.TargetType, e.TargetID, e.IP, e.Outcome, strconv.Itoa(e.Status), details,
+			})
+		}
+		w.Flush()
+	})
+
+	// ========== BACKUPS API ==========
+	r.GET("/api/databases/:id/backups", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
+		}
+		list, err := backups.ListBackups(id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		// If list is nil (empty), return empty array
+		if list == nil {
+			list = []backups.Backup{}
+		}
+		c.JSON(200, list)
+	})
+
+	r.GET("/api/databases/:id/backups/settings", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
+		}
+		settings, err := backups.GetBackupSettings(id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, settings)
+	})
+
+	r.POST("/api/databases/:id/backups/settings", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
+		}
+		var settings backups.BackupSettings
+		if err := c.BindJSON(&settings); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid JSON"})
+			return
+		}
+		settings.DatabaseID = id
+		if err := backups.SaveBackupSettings(&settings); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"message": "Settings saved"})
+	})
+
+	r.POST("/api/databases/:id/backups/manual", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
+		}
+		entry := audit.Annotate(c, "backup.create", "database", idStr, nil)
+		go func() {
+			fmt.Printf("Starting manual backup for DB %d...\n", id)
+			err := backups.PerformBackup(id)
+			if err != nil {
+				fmt.Printf("Backup failed for DB %d: %v\n", id, err)
+			} else {
+				fmt.Printf("Backup completed for DB %d\n", id)
+			}
+			audit.Complete(entry, err)
+		}()
+		c.JSON(200, gin.H{"message": "Backup started"})
+	})
+
+	r.POST("/api/databases/:id/backups/:backupId/restore", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
+		}
+		backupIdStr := c.Param("backupId")
+		backupId, err := strconv.Atoi(backupIdStr)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid backup ID"})
+			return
+		}
+
+		entry := audit.Annotate(c, "backup.restore", "database", idStr, map[string]any{"backupId": backupId})
+		go func() {
+			fmt.Printf("Starting restore for DB %d from backup %d...\n", id, backupId)
+			err := backups.RestoreBackup(id, backupId)
+			if err != nil {
+				fmt.Printf("Restore failed for DB %d: %v\n", id, backupId)
+			} else {
+				fmt.Printf("Restore completed for DB %d\n", id)
+			}
+			audit.Complete(entry, err)
+		}()
+		c.JSON(200, gin.H{"message": "Restore started"})
+	})
+
+	r.POST("/api/databases/:id/backups/:backupId/download-decrypted", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
+		}
+		backupIdStr := c.Param("backupId")
+		backupId, err := strconv.Atoi(backupIdStr)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid backup ID"})
+			return
+		}
+
+		var req struct {
+			PrivateKey string `json:"private_key"`
+			Passphrase string `json:"passphrase"`
+		}
+		_ = c.ShouldBindJSON(&req)
+
+		reader, filename, _, err := backups.GetBackupDownloadReader(id, backupId, req.PrivateKey, req.Passphrase)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		defer reader.Close()
+
+		c.Header("Content-Type", "application/sql")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+		if _, err := io.Copy(c.Writer, reader); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to stream backup"})
+			return
+		}
+	})
+
+	r.POST("/api/databases/:id/backups/:backupId/restore-with-key", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
+		}
+		backupIdStr := c.Param("backupId")
+		backupId, err := strconv.Atoi(backupIdStr)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid backup ID"})
+			return
+		}
+
+		var req struct {
+			PrivateKey string `json:"private_key"`
+			Passphrase string `json:"passphrase"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if strings.TrimSpace(req.PrivateKey) == "" {
+			c.JSON(400, gin.H{"error": "private_key is required"})
+			return
+		}
+
+		// Validate key/decryption upfront so the client gets immediate feedback for wrong keys.
+		reader, _, _, err := backups.GetBackupDownloadReader(id, backupId, req.PrivateKey, req.Passphrase)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		_ = reader.Close()
+
+		entry := audit.Annotate(c, "backup.restore", "database", idStr, map[string]any{"backupId": backupId, "encrypted": true})
+		go func() {
+			fmt.Printf("Starting encrypted restore for DB %d from backup %d...\n", id, backupId)
+			err := backups.RestoreBackupWithPrivateKey(id, backupId, req.PrivateKey, req.Passphrase)
+			if err != nil {
+				fmt.Printf("Encrypted restore failed for DB %d backup %d: %v\n", id, backupId, err)
+			} else {
+				fmt.Printf("Encrypted restore completed for DB %d backup %d\n", id, backupId)
+			}
+			audit.Complete(entry, err)
+		}()
+		c.JSON(200, gin.H{"message": "Restore started"})
+	})
+
+	// Restore from uploaded file
+	r.POST("/api/databases/:id/restore/file", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
+		}
+
+		file, header, err := c.Request.FormFile("file")
+		if err != nil {
+			c.JSON(400, gin.H{"error": "No file uploaded"})
+			return
+		}
+		defer file.Close()
+
+		fmt.Printf("Starting restore for DB %d from uploaded file: %s\n", id, header.Filename)
+
+		entry := audit.Annotate(c, "backup.restore_file", "database", idStr, map[string]any{"filename": header.Filename})
+		go func() {
+			err := backups.RestoreFromFile(id, file)
+			if err != nil {
+				fmt.Printf("Restore from file failed for DB %d: %v\n", id, err)
+			} else {
+				fmt.Printf("Restore from file completed for DB %d\n", id)
+			}
+			audit.Complete(entry, err)
+		}()
+		c.JSON(200, gin.H{"message": "Restore started"})
+	})
+
+	// Restore from external connection
+	r.POST("/api/databases/:id/restore/connection", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
+		}
+
+		var req struct {
+			ConnectionString string `json:"connection_string"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		if req.ConnectionString == "" {
+			c.JSON(400, gin.H{"error": "Connection string is required"})
+			return
+		}
+
+		fmt.Printf("Starting restore for DB %d from external connection\n", id)
+
+		// The connection string is not recorded since it usually carries a password
+		entry := audit.Annotate(c, "backup.restore_connection", "database", idStr, nil)
+		go func() {
+			err := backups.RestoreFromConnection(id, req.ConnectionString)
+			if err != nil {
+				fmt.Printf("Restore from connection failed for DB %d: %v\n", id, err)
+			} else {
+				fmt.Printf("Restore from connection completed for DB %d\n", id)
+			}
+			audit.Complete(entry, err)
+		}()
+		c.JSON(200, gin.H{"message": "Restore started"})
+	})
+
+	// ========== PROJECTS API ==========
+
+	// Get all projects
+	r.GET("/api/projects", func(c *gin.Context) {
+		isAdmin := c.GetBool("is_admin")
+		roles, err := db.GetUserProjectRoles(c.GetInt("user_id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to query project roles: " + err.Error()})
+			return
+		}
+
+		rows, err := db.DB.Query("SELECT id, name, description, created_at FROM projects ORDER BY created_at DESC")
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to query projects: " + err.Error()})
+			return
+		}
+		defer rows.Close()
+
+		projects := []map[string]interface{}{}
+		for rows.Next() {
+			var id int
+			var name, description, createdAt string
+			if err := rows.Scan(&id, &name, &description, &createdAt); err != nil {
+				c.JSON(500, gin.H{"error": "Failed to scan project: " + err.Error()})
+				return
+			}
+
+			role, isMember := roles[id]
+			if !isMember {
+				if !isAdmin {
+					continue
+				}
+				role = db.RoleOwner
+			}
+
+			projects = append(projects, map[string]interface{}{
+				"id":          id,
+				"name":        name,
+				"description": description,
+				"created_at":  createdAt,
+				"role":        role,
+			})
+		}
+
+		c.JSON(http.StatusOK, projects)
+	})
+
+	// Create project
+	r.POST("/api/projects", func(c *gin.Context) {
+		var req struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if req.Name == "" {
+			c.JSON(400, gin.H{"error": "Project name is required"})
+			return
+		}
+		if isServiceAccount, _ := db.IsServiceAccountUser(c.GetInt("user_id")); isServiceAccount {
+			c.JSON(403, gin.H{"error": "Service accounts cannot create projects"})
+			return
+		}
+
+		result, err := db.DB.Exec(
+			"INSERT INTO projects (name, description) VALUES (?, ?)",
+			req.Name, req.Description,
+		)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to create project: " + err.Error()})
+			return
+		}
+
+		id, _ := result.LastInsertId()
+		if err := db.SetProjectMember(int(id), c.GetInt("user_id"), db.RoleOwner); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to add project owner: " + err.Error()})
+			return
+		}
+
+		c.JSON(201, gin.H{
+			"id":          id,
+			"name":        req.Name,
+			"description": req.Description,
+			"role":        db.RoleOwner,
+		})
+	})
+
+	// Get single project by ID
+	r.GET("/api/projects/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		var projectID int
+		var name, description, createdAt string
+
+		err := db.DB.QueryRow(
+			"SELECT id, name, description, created_at FROM projects WHERE id = ?",
+			id,
+		).Scan(&projectID, &name, &description, &createdAt)
+
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Project not found"})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"id":          projectID,
+			"name":        name,
+			"description": description,
+			"created_at":  createdAt,
+		})
+	})
+
+	// Update project
+	r.PUT("/api/projects/:id", func(c *gin.Context) {
+		id := c.Param("id")
+
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if strings.TrimSpace(req.Name) == "" {
+			c.JSON(400, gin.H{"error": "Project name is required"})
+			return
+		}
+
+		result, err := db.DB.Exec(
+			"UPDATE projects SET name = ? WHERE id = ?",
+			strings.TrimSpace(req.Name), id,
+		)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to update project: " + err.Error()})
+			return
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil || rowsAffected == 0 {
+			c.JSON(404, gin.H{"error": "Project not found"})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "Project updated",
+		})
+	})
+
+	// Get a project's LLM provider override
+	r.GET("/api/projects/:id/llm-settings", func(c *gin.Context) {
+		projectID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid project ID"})
+			return
+		}
+
+		settings, err := db.GetProjectLLMSettings(projectID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to load LLM settings"})
+			return
+		}
+
+		var override gin.H
+		if settings != nil {
+			override = llmSettingsResponse(*settings)
+		}
+		c.JSON(200, gin.H{
+			"override": override,
+			"instance": llmSettingsResponse(db.GetInstanceLLMSettings()),
+		})
+	})
+
+	// Set a project's LLM provider override. Omitting apiKey keeps the stored key.
+	r.PUT("/api/projects/:id/llm-settings", func(c *gin.Context) {
+		projectID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid project ID"})
+			return
+		}
+
+		var exists int
+		if err := db.DB.QueryRow("SELECT COUNT(*) FROM projects WHERE id = ?", projectID).Scan(&exists); err != nil || exists == 0 {
+			c.JSON(404, gin.H{"error": "Project not found"})
+			return
+		}
+
+		var req struct {
+			Provider string  `json:"provider"`
+			BaseURL  string  `json:"baseUrl"`
+			Model    string  `json:"model"`
+			APIKey   *string `json:"apiKey"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		settings := db.LLMSettings{
+			Provider: strings.TrimSpace(req.Provider),
+			BaseURL:  strings.TrimSpace(req.BaseURL),
+			Model:    strings.TrimSpace(req.Model),
+		}
+		if req.APIKey != nil {
+			settings.APIKey = strings.TrimSpace(*req.APIKey)
+		} else if existing, err := db.GetProjectLLMSettings(projectID); err == nil && existing != nil {
+			settings.APIKey = existing.APIKey
+		}
+		if err := llm.Validate(settings); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.UpdateProjectLLMSettings(projectID, settings); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to save LLM settings"})
+			return
+		}
+
+		c.JSON(200, gin.H{"override": llmSettingsResponse(settings)})
+	})
+
+	// Remove a project's LLM provider override
+	r.DELETE("/api/projects/:id/llm-settings", func(c *gin.Context) {
+		projectID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid project ID"})
+			return
+		}
+
+		if err := db.DeleteProjectLLMSettings(projectID); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to delete LLM settings"})
+			return
+		}
+
+		c.JSON(200, gin.H{"message": "Project uses the instance LLM settings"})
+	})
+
+	// Get databases for a project
+	r.GET("/api/projects/:id/databases", func(c *gin.Context) {
+		id := c.Param("id")
+		rows, err := db.DB.Query("SELECT id, name, type, host, port, status FROM databases WHERE project_id = ?", id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to query databases: " + err.Error()})
+			return
+		}
+		defer rows.Close()
+
+		databases := []map[string]interface{}{}
+		for rows.Next() {
+			var dbID, port int
+			var name, dbType, host, status string
+			if err := rows.Scan(&dbID, &name, &dbType, &host, &port, &status); err != nil {
+				c.JSON(500, gin.H{"error": "Failed to scan database: " + err.Error()})
+				return
+			}
+			databases = append(databases, map[string]interface{}{
+				"id":     dbID,
+				"name":   name,
+				"type":   dbType,
+				"host":   host,
+				"port":   port,
+				"status": status,
+			})
+		}
+
+		c.JSON(http.StatusOK, databases)
+	})
+
+	// ========== PROJECT MEMBERS API ==========
+
+	// List project members
+	r.GET("/api/projects/:id/members", func(c *gin.Context) {
+		members, err := db.ListProjectMembers(c.GetInt("project_id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to query members: " + err.Error()})
+			return
+		}
+		c.JSON(200, members)
+	})
+
+	// Add an existing user to a project or change their role
+	r.PUT("/api/projects/:id/members/:userId", func(c *gin.Context) {
+		projectID := c.GetInt("project_id")
+		userID, err := strconv.Atoi(c.Param("userId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		var req struct {
+			Role string `json:"role"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || !db.IsValidRole(req.Role) {
+			c.JSON(400, gin.H{"error": "Role must be one of owner, admin, developer or viewer"})
+			return
+		}
+
+		var exists int
+		if err := db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE id = ?", userID).Scan(&exists); err != nil || exists == 0 {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+
+		current, err := db.GetProjectRole(projectID, userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to query member"})
+			return
+		}
+		// Service accounts belong to the one project they were created in and never own it
+		isServiceAccount, err := db.IsServiceAccountUser(userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to query member"})
+			return
+		}
+		if isServiceAccount && (current == "" || req.Role == db.RoleOwner) {
+			c.JSON(400, gin.H{"error": "Service accounts can only be given the admin, developer or viewer role in their own project"})
+			return
+		}
+		// Only owners can hand out or take away ownership
+		if (req.Role == db.RoleOwner || current == db.RoleOwner) && c.GetString("project_role") != db.RoleOwner {
+			c.JSON(403, gin.H{"error": "Only owners can change ownership"})
+			return
+		}
+		if current == db.RoleOwner && req.Role != db.RoleOwner {
+			if owners, err := db.CountProjectOwners(projectID); err != nil || owners <= 1 {
+				c.JSON(400, gin.H{"error": "A project must keep at least one owner"})
+				return
+			}
+		}
+
+		if err := db.SetProjectMember(projectID, userID, req.Role); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to save member: " + err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"message": "Member saved", "userId": userID, "role": req.Role})
+	})
+
+	// Remove a member from a project
+	r.DELETE("/api/projects/:id/members/:userId", func(c *gin.Context) {
+		projectID := c.GetInt("project_id")
+		userID, err := strconv.Atoi(c.Param("userId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		current, err := db.GetProjectRole(projectID, userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to query member"})
+			return
+		}
+		if current == "" {
+			c.JSON(404, gin.H{"error": "Member not found"})
+			return
+		}
+		if current == db.RoleOwner {
+			if c.GetString("project_role") != db.RoleOwner {
+				c.JSON(403, gin.H{"error": "Only owners can remove an owner"})
+				return
+			}
+			if owners, err := db.CountProjectOwners(projectID); err != nil || owners <= 1 {
+				c.JSON(400, gin.H{"error": "A project must keep at least one owner"})
+				return
+			}
+		}
+
+		if err := db.RemoveProjectMember(projectID, userID); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to remove member: " + err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"message": "Member removed"})
+	})
+
+	// Invite someone by email. Existing users are added right away; new emails
+	// are whitelisted and join the project when they register.
+	r.POST("/api/projects/:id/invitations", func(c *gin.Context) {
+		projectID := c.GetInt("project_id")
+
+		var req struct {
+			Email string `json:"email" binding:"required"`
+			Role  string `json:"role"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Email is required"})
+			return
+		}
+		email := strings.TrimSpace(req.Email)
+		role := req.Role
+		if role == "" {
+			role = db.RoleDeveloper
+		}
+		if !db.IsValidRole(role) {
+			c.JSON(400, gin.H{"error": "Role must be one of owner, admin, developer or viewer"})
+			return
+		}
+		if role == db.RoleOwner && c.GetString("project_role") != db.RoleOwner {
+			c.JSON(403, gin.H{"error": "Only owners can invite owners"})
+			return
+		}
+
+		var projectName string
+		if err := db.DB.QueryRow("SELECT name FROM projects WHERE id = ?", projectID).Scan(&projectName); err != nil {
+			c.JSON(404, gin.H{"error": "Project not found"})
+			return
+		}
+
+		user, err := db.GetUserByEmail(email)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to look up user"})
+			return
+		}
+		if user != nil {
+			if current, _ := db.GetProjectRole(projectID, user.ID); current != "" {
+				c.JSON(409, gin.H{"error": "User is already a member of this project"})
+				return
+			}
+			if err := db.SetProjectMember(projectID, user.ID, role); err != nil {
+				c.JSON(500, gin.H{"error": "Failed to add member: " + err.Error()})
+				return
+			}
+			c.JSON(201, gin.H{"message": "User added to project", "userId": user.ID, "role": role})
+			return
+		}
+
+		if err := db.CreateProjectInvitation(projectID, email, role, c.GetInt("user_id")); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to create invitation: " + err.Error()})
+			return
+		}
+		invitationSent := false
+		if db.GetSMTPSettings().Configured() {
+			if err := auth.SendInvitation(email, c.GetString("email"), projectName); err != nil {
+				log.Printf("Failed to send invitation to %s: %v", email, err)
+			} else {
+				invitationSent = true
+			}
+		}
+		c.JSON(201, gin.H{"message": "Invitation created", "email": email, "role": role, "invitationSent": invitationSent})
+	})
+
+	// List pending invitations
+	r.GET("/api/projects/:id/invitations", func(c *gin.Context) {
+		invitations, err := db.ListProjectInvitations(c.GetInt("project_id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to query invitations: " + err.Error()})
+			return
+		}
+		c.JSON(200, invitations)
+	})
+
+	// Cancel a pending invitation
+	r.DELETE("/api/projects/:id/invitations/:invitationId", func(c *gin.Context) {
+		invitationID, err := strconv.Atoi(c.Param("invitationId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid invitation ID"})
+			return
+		}
+
+		deleted, err := db.DeleteProjectInvitation(c.GetInt("project_id"), invitationID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to delete invitation: " + err.Error()})
+			return
+		}
+		if !deleted {
+			c.JSON(404, gin.H{"error": "Invitation not found"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "Invitation cancelled"})
+	})
+
+	// ========== SERVICE ACCOUNTS API ==========
+
+	// List the service accounts of a project with their tokens
+	r.GET("/api/projects/:id/service-accounts", func(c *gin.Context) {
+		accounts, err := db.ListServiceAccounts(c.GetInt("project_id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to load service accounts"})
+			return
+		}
+		c.JSON(200, accounts)
+	})
+
+	// Create a service account that acts as a project member with the given role
+	r.POST("/api/projects/:id/service-accounts", func(c *gin.Context) {
+		projectID := c.GetInt("project_id")
+		var req struct {
+			Name        string `json:"name" binding:"required"`
+			Description string `json:"description"`
+			Role        string `json:"role"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+			c.JSON(400, gin.H{"error": "Name is required"})
+			return
+		}
+		if req.Role == "" {
+			req.Role = db.RoleDeveloper
+		}
+		if !db.IsValidRole(req.Role) || req.Role == db.RoleOwner {
+			c.JSON(400, gin.H{"error": "Role must be one of admin, developer or viewer"})
+			return
+		}
+
+		suffix, err := auth.GenerateTokenID()
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to create service account"})
+			return
+		}
+		// The backing user needs a unique email; .invalid can never receive mail
+		email := fmt.Sprintf("svc-%d-%s@service-accounts.invalid", projectID, suffix[:12])
+		account, err := db.CreateServiceAccount(projectID, strings.TrimSpace(req.Name), req.Description, req.Role, c.GetInt("user_id"), email)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to create service account"})
+			return
+		}
+		audit.Annotate(c, "service_account.create", "service_account", strconv.Itoa(account.ID), map[string]any{
+			"projectId": projectID, "name": account.Name, "role": account.Role,
+		})
+		c.JSON(201, account)
+	})
+
+	// Delete a service account and revoke its tokens
+	r.DELETE("/api/projects/:id/service-accounts/:accountId", func(c *gin.Context) {
+		accountID, err := strconv.Atoi(c.Param("accountId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid service account ID"})
+			return
+		}
+		audit.Annotate(c, "service_account.delete", "service_account", c.Param("accountId"), map[string]any{"projectId": c.GetInt("project_id")})
+		err = db.DeleteServiceAccount(c.GetInt("project_id"), accountID)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(404, gin.H{"error": "Service account not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to delete service account"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "Service account deleted"})
+	})
+
+	// Create a token for a service account. The secret is only returned once.
+	r.POST("/api/projects/:id/service-accounts/:accountId/tokens", func(c *gin.Context) {
+		accountID, err := strconv.Atoi(c.Param("accountId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid service account ID"})
+			return
+		}
+		account, err := db.GetServiceAccount(c.GetInt("project_id"), accountID)
+		if err != nil || account == nil {
+			c.JSON(404, gin.H{"error": "Service account not found"})
+			return
+		}
+		name, scopes, ttl, ok := bindAPITokenRequest(c, false)
+		if !ok {
+			return
+		}
+
+		secret, token, err := auth.CreateAPIToken(account.UserID, account.ID, c.GetInt("user_id"), name, scopes, ttl)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to create token"})
+			return
+		}
+		audit.Annotate(c, "api_token.create", "api_token", strconv.Itoa(token.ID), map[string]any{
+			"serviceAccountId": account.ID, "name": name, "scopes": scopes, "expiresAt": token.ExpiresAt,
+		})
+		c.JSON(201, gin.H{"token": secret, "apiToken": token})
+	})
+
+	// Revoke a service account token
+	r.DELETE("/api/projects/:id/service-accounts/:accountId/tokens/:tokenId", func(c *gin.Context) {
+		accountID, err := strconv.Atoi(c.Param("accountId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid service account ID"})
+			return
+		}
+		tokenID, err := strconv.Atoi(c.Param("tokenId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid token ID"})
+			return
+		}
+		if account, err := db.GetServiceAccount(c.GetInt("project_id"), accountID); err != nil || account == nil {
+			c.JSON(404, gin.H{"error": "Service account not found"})
+			return
+		}
+		audit.Annotate(c, "api_token.revoke", "api_token", c.Param("tokenId"), map[string]any{"serviceAccountId": accountID})
+		err = db.RevokeServiceAccountToken(accountID, tokenID)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(404, gin.H{"error": "Token not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to revoke token"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "Token revoked"})
+	})
+
+	// ========== DATABASES API ==========
+
+	// Get all databases
+	r.GET("/api/databases", func(c *gin.Context) {
+		isAdmin := c.GetBool("is_admin")
+		roles, err := db.GetUserProjectRoles(c.GetInt("user_id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to query project roles: " + err.Error()})
+			return
+		}
+
+		rows, err := db.DB.Query("SELECT id, name, type, host, port, status, COALESCE(project_id, 0) FROM databases")
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to query databases: " + err.Error()})
+			return
+		}
+		defer rows.Close()
+
+		databases := []map[string]interface{}{}
+		for rows.Next() {
+			var id, port, projectID int
+			var name, dbType, host, status string
+			if err := rows.Scan(&id, &name, &dbType, &host, &port, &status, &projectID); err != nil {
+				c.JSON(500, gin.H{"error": "Failed to scan database: " + err.Error()})
+				return
+			}
+			if _, isMember := roles[projectID]; !isMember && !isAdmin {
+				continue
+			}
+
+			databases = append(databases, map[string]interface{}{
+				"id":        id,
+				"name":      name,
+				"type":      dbType,
+				"host":      host,
+				"port":      port,
+				"status":    status,
+				"projectId": projectID,
+			})
+		}
+
+		c.JSON(http.StatusOK, databases)
+	})
+
+	// Image flavors databases can be created from
+	r.GET("/api/databases/flavors", func(c *gin.Context) {
+		c.JSON(200, docker.Flavors)
+	})
+
+	// Create database (Streaming for progress)
+	r.POST("/api/databases", func(c *gin.Context) {
+		var req struct {
+			Name         string  `json:"name"`
+			Type         string  `json:"type"`
+			Version      string  `json:"version"`
+			ProjectID    int     `json:"projectId"`
+			MaxCPU       float64 `json:"maxCpu"`
+			MaxRAMMB     int     `json:"maxRamMb"`
+			MaxStorageMB int     `json:"maxStorageMb"`
+			ServerID     int     `json:"serverId"`
+			// Flavor picks an image with extensions preinstalled, see
+			// GET /api/databases/flavors
+			Flavor string `json:"flavor"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		// Only instance admins choose the Docker host; 0 is the local daemon
+		if req.ServerID != docker.LocalServer {
+			if !c.GetBool("is_admin") {
+				c.JSON(403, gin.H{"error": "Only admins can place databases on a remote server"})
+				return
+			}
+			server, err := db.GetServer(req.ServerID)
+			if err != nil || server == nil {
+				c.JSON(400, gin.H{"error": "Invalid server ID"})
+				return
+			}
+		}
+
+		// Set defaults if not provided
+		if req.MaxCPU == 0 {
+			req.MaxCPU = 1.0
+		}
+		if req.MaxRAMMB == 0 {
+			req.MaxRAMMB = 512
+		}
+		if req.MaxStorageMB == 0 {
+			req.MaxStorageMB = 1024
+		}
+
+		if req.Type != "postgresql" {
+			c.JSON(400, gin.H{"error": "Only postgresql is supported for now"})
+			return
+		}
+		if req.Flavor == "" {
+			req.Flavor = docker.FlavorPostgres
+		}
+		imageName, err := docker.FlavorImage(req.Flavor, req.Version)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Validate project exists
+		if req.ProjectID > 0 {
+			var count int
+			err := db.DB.QueryRow("SELECT COUNT(*) FROM projects WHERE id = ?", req.ProjectID).Scan(&count)
+			if err != nil || count == 0 {
+				c.JSON(400, gin.H{"error": "Invalid project ID"})
+				return
+			}
+		}
+
+		// Creating databases needs the admin role in the target project;
+		// databases outside a project are reserved to instance admins
+		if !c.GetBool("is_admin") {
+			role, err := db.GetProjectRole(req.ProjectID, c.GetInt("user_id"))
+			if err != nil || req.ProjectID == 0 || !db.RoleAtLeast(role, db.RoleAdmin) {
+				c.JSON(403, gin.H{"error": "Creating a database requires the admin role in the project"})
+				return
+			}
+		}
+
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+
+		c.Stream(func(w io.Writer) bool {
+			sendUpdate := func(status, message string, progress float64, data any) {
+				update := gin.H{
+					"status":   status,
+					"message":  message,
+					"progress": progress,
+				}
+				if data != nil {
+					update["data"] = data
+				}
+				json.NewEncoder(w).Encode(update)
+				w.(http.Flusher).Flush()
+			}
+
+			ctx := context.Background()
+			cli, err := docker.NewClient(req.ServerID)
+			if err != nil {
+				sendUpdate("error", "Failed to connect to Docker: "+err.Error(), 0, nil)
+				return false
+			}
+			defer cli.Close()
+			if req.ServerID != docker.LocalServer {
+				if err := docker.EnsureServerNetwork(req.ServerID); err != nil {
+					sendUpdate("error", "Failed to prepare the server network: "+err.Error(), 0, nil)
+					return false
+				}
+			}
+
+			// Pull image with progress tracking
+			sendUpdate("pulling", "Pulling Docker image...", 0, nil)
+			pullResp, err := cli.ImagePull(ctx, imageName, image.PullOptions{})
+			if err != nil {
+				sendUpdate("error", "Failed to pull image: "+err.Error(), 0, nil)
+				return false
+			}
+			defer pullResp.Close()
 
 			// Parse Docker pull progress
 			decoder := json.NewDecoder(pullResp)
@@ -1252,1308 +3687,2539 @@ func main() {
 						Total   int64 `json:"total"`
 					} `json:"progressDetail"`
 				}
-				if err := decoder.Decode(&pullUpdate); err != nil {
-					if err == io.EOF {
-						break
-					}
-					break
+				if err := decoder.Decode(&pullUpdate); err != nil {
+					if err == io.EOF {
+						break
+					}
+					break
+				}
+
+				if pullUpdate.ProgressDetail.Total > 0 {
+					percent := float64(pullUpdate.ProgressDetail.Current) / float64(pullUpdate.ProgressDetail.Total) * 100
+					sendUpdate("pulling", "Pulling "+imageName+"...", percent, nil)
+				}
+			}
+
+			// Get a free port. Ports on remote servers are assigned by their
+			// daemon and read back once the container started.
+			sendUpdate("creating", "Generating configuration...", 100, nil)
+			hostPort := ""
+			freePort := 0
+			if req.ServerID == docker.LocalServer {
+				freePort, err = getFreePort()
+				if err != nil {
+					sendUpdate("error", "Failed to get free port: "+err.Error(), 0, nil)
+					return false
+				}
+				hostPort = strconv.Itoa(freePort)
+			}
+
+			password, err := generatePassword(16)
+			if err != nil {
+				sendUpdate("error", "Failed to generate password", 0, nil)
+				return false
+			}
+
+			randBytes := make([]byte, 8)
+			rand.Read(randBytes)
+			containerName := fmt.Sprintf("baseful-%s-%s", req.Name, hex.EncodeToString(randBytes))
+
+			// Create container
+			sendUpdate("creating", "Creating container...", 100, nil)
+			resp, err := cli.ContainerCreate(ctx, &container.Config{
+				Image:    imageName,
+				Hostname: req.Name,
+				Env: []string{
+					"POSTGRES_PASSWORD=" + password,
+					"POSTGRES_DB=" + req.Name,
+				},
+				ExposedPorts: nat.PortSet{
+					"5432/tcp": struct{}{},
+				},
+				Labels: map[string]string{
+					"managed-by":         "baseful",
+					"baseful.database":   req.Name,
+					"baseful.project_id": fmt.Sprintf("%d", req.ProjectID),
+					"baseful.flavor":     req.Flavor,
+				},
+			}, &container.HostConfig{
+				NetworkMode: docker.NetworkName,
+				PortBindings: nat.PortMap{
+					"5432/tcp": []nat.PortBinding{
+						{HostIP: "0.0.0.0", HostPort: hostPort},
+					},
+				},
+				Resources: container.Resources{
+					Memory:   int64(req.MaxRAMMB) * 1024 * 1024,
+					NanoCPUs: int64(req.MaxCPU * 1000000000),
+				},
+				SecurityOpt: []string{
+					"no-new-privileges:true",
+				},
+				CapDrop: []string{"ALL"},
+				CapAdd:  []string{"CHOWN", "SETGID", "SETUID", "DAC_OVERRIDE"},
+				RestartPolicy: container.RestartPolicy{
+					Name: "unless-stopped",
+				},
+			}, nil, nil, containerName)
+
+			if err != nil {
+				sendUpdate("error", "Failed to create container: "+err.Error(), 0, nil)
+				return false
+			}
+
+			// Start container
+			sendUpdate("starting", "Starting database engine...", 100, nil)
+			if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+				_ = cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
+				sendUpdate("error", "Failed to start container: "+err.Error(), 0, nil)
+				return false
+			}
+			if hostPort == "" {
+				freePort, err = docker.MappedPort(ctx, cli, resp.ID, "5432/tcp")
+				if err != nil {
+					_ = cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
+					sendUpdate("error", err.Error(), 0, nil)
+					return false
+				}
+			}
+
+			// Store in DB
+			sendUpdate("finalizing", "Finalizing database setup...", 100, nil)
+			encryptedPassword, err := secrets.Encrypt(password)
+			if err != nil {
+				_ = cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
+				sendUpdate("error", "Failed to encrypt database password: "+err.Error(), 0, nil)
+				return false
+			}
+			result, err := db.DB.Exec(
+				"INSERT INTO databases (name, type, host, port, mapped_port, container_id, version, password, status, project_id, max_cpu, max_ram_mb, max_storage_mb, server_id, flavor, image) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, 0), ?, ?)",
+				req.Name, req.Type, containerName, 5432, freePort, resp.ID, req.Version, encryptedPassword, "active", req.ProjectID, req.MaxCPU, req.MaxRAMMB, req.MaxStorageMB, req.ServerID, req.Flavor, imageName,
+			)
+
+			if err != nil {
+				sendUpdate("error", "Failed to save to database: "+err.Error(), 0, nil)
+				return false
+			}
+
+			databaseID, _ := result.LastInsertId()
+
+			// Tokens and connection strings
+			tokenID, _ := auth.GenerateTokenID()
+			issuedAt := time.Now().UTC()
+			expiresAt := issuedAt.AddDate(2, 0, 0)
+			jwtToken, _ := auth.GenerateJWTWithTimestamps(int(databaseID), 0, tokenID, issuedAt, expiresAt)
+			tokenHash := db.HashToken(jwtToken)
+			db.CreateToken(int(databaseID), tokenID, tokenHash, issuedAt, expiresAt)
+
+			proxyHost := auth.GetProxyHost()
+			proxyPort := auth.GetProxyPort()
+			portInt, _ := strconv.Atoi(proxyPort)
+			connectionString := auth.GenerateConnectionString(jwtToken, int(databaseID), proxyHost, portInt, "require")
+
+			// Final success message with data
+			sendUpdate("success", "Database created successfully!", 100, gin.H{
+				"id":                databaseID,
+				"container_id":      resp.ID,
+				"connection_string": connectionString,
+				"internal_host":     containerName,
+				"internal_port":     5432,
+			})
+
+			return false
+		})
+	})
+
+	// Get single database by ID
+	r.GET("/api/databases/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		var db_id, port, projectID int
+		var name, dbType, host, status, version, containerID, flavor string
+
+		err := db.DB.QueryRow(
+			"SELECT id, name, type, host, port, status, version, project_id, container_id, COALESCE(flavor, 'postgres') FROM databases WHERE id = ?",
+			id,
+		).Scan(&db_id, &name, &dbType, &host, &port, &status, &version, &projectID, &containerID, &flavor)
+
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Database not found"})
+			return
+		}
+
+		// Get existing active token (don't regenerate on every fetch)
+		tokenRecord, _ := db.GetActiveTokenForDatabase(db_id)
+
+		proxyHost := auth.GetProxyHost()
+		proxyPort := auth.GetProxyPort()
+		portInt, _ := strconv.Atoi(proxyPort)
+
+		// Return censored connection string (actual token not exposed)
+		connectionString := auth.GenerateConnectionString("***CENSORED***", db_id, proxyHost, portInt, "require")
+
+		response := gin.H{
+			"id":                db_id,
+			"name":              name,
+			"type":              dbType,
+			"host":              host,
+			"port":              port,
+			"status":            status,
+			"version":           version,
+			"flavor":            flavor,
+			"connection_string": connectionString,
+			"projectId":         projectID,
+			"container_id":      containerID,
+		}
+
+		if tokenRecord != nil {
+			response["has_token"] = true
+			response["token_id"] = tokenRecord.TokenID
+			response["token_expires_at"] = tokenRecord.ExpiresAt
+			response["token_revoked"] = tokenRecord.Revoked
+		} else {
+			response["has_token"] = false
+		}
+
+		c.JSON(200, response)
+	})
+
+	// Database Control Endpoints
+	r.POST("/api/databases/:id/:action", func(c *gin.Context) {
+		id := c.Param("id")
+		action := c.Param("action")
+		audit.Annotate(c, "database."+action, "database", id, nil)
+
+		var containerID, status string
+		err := db.DB.QueryRow("SELECT container_id, status FROM databases WHERE id = ?", id).Scan(&containerID, &status)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Database not found"})
+			return
+		}
+
+		ctx := context.Background()
+		cli, err := databaseClient(id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to connect to Docker"})
+			return
+		}
+		defer cli.Close()
+
+		switch action {
+		case "start":
+			if status == db.StatusSleeping {
+				dbID, _ := strconv.Atoi(id)
+				wakeCtx, cancel := context.WithTimeout(ctx, suspend.WakeTimeout)
+				defer cancel()
+				if err := suspend.Wake(wakeCtx, dbID); err != nil {
+					c.JSON(500, gin.H{"error": "Failed to wake database: " + err.Error()})
+					return
+				}
+				break
+			}
+			if err := cli.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
+				c.JSON(500, gin.H{"error": "Failed to start container: " + err.Error()})
+				return
+			}
+			db.DB.Exec("UPDATE databases SET status = 'active' WHERE id = ?", id)
+			dbID, _ := strconv.Atoi(id)
+			go failover.Resume(context.Background(), dbID)
+		case "stop":
+			// Pause HA health checks so the stop is not taken for a failure
+			db.DB.Exec("UPDATE databases SET ha_suspended = 1 WHERE id = ?", id)
+			if err := cli.ContainerStop(ctx, containerID, container.StopOptions{}); err != nil {
+				db.DB.Exec("UPDATE databases SET ha_suspended = 0 WHERE id = ?", id)
+				c.JSON(500, gin.H{"error": "Failed to stop container: " + err.Error()})
+				return
+			}
+			db.DB.Exec("UPDATE databases SET status = 'stopped' WHERE id = ?", id)
+		case "restart":
+			db.DB.Exec("UPDATE databases SET ha_suspended = 1 WHERE id = ?", id)
+			// Health checks resume once Postgres accepts connections again
+			dbID, _ := strconv.Atoi(id)
+			err := cli.ContainerRestart(ctx, containerID, container.StopOptions{})
+			go failover.Resume(context.Background(), dbID)
+			if err != nil {
+				c.JSON(500, gin.H{"error": "Failed to restart container: " + err.Error()})
+				return
+			}
+			db.DB.Exec("UPDATE databases SET status = 'active' WHERE id = ?", id)
+		case "suspend":
+			// Put the database to sleep now; it wakes on its next connection
+			dbID, _ := strconv.Atoi(id)
+			if status != "active" {
+				c.JSON(400, gin.H{"error": "Only running databases can be suspended"})
+				return
+			}
+			if ha, err := db.GetHAStatus(dbID); err == nil && ha.Enabled {
+				c.JSON(400, gin.H{"error": "Databases in high availability mode cannot be suspended"})
+				return
+			}
+			if err := suspend.Suspend(ctx, dbID); err != nil {
+				c.JSON(500, gin.H{"error": "Failed to suspend database: " + err.Error()})
+				return
+			}
+			c.JSON(200, gin.H{"message": "Database suspended"})
+			return
+		case "vacuum":
+			var dbName string
+			err := db.DB.QueryRow("SELECT name FROM databases WHERE id = ?", id).Scan(&dbName)
+			if err != nil {
+				c.JSON(404, gin.H{"error": "Database not found"})
+				return
+			}
+
+			// Run VACUUM ANALYZE;
+			command := fmt.Sprintf("psql -U postgres -d %s -c \"VACUUM ANALYZE;\"", dbName)
+			databaseID, _ := strconv.Atoi(id)
+			serverID, _ := db.GetDatabaseServerID(databaseID)
+			res, err := docker.ExecCommand(serverID, containerID, command, "/")
+			if err != nil {
+				c.JSON(500, gin.H{"error": "Failed to vacuum database: " + err.Error()})
+				return
+			}
+
+			if strings.Contains(res.Output, "ERROR") {
+				c.JSON(500, gin.H{"error": "PostgreSQL error: " + res.Output})
+				return
+			}
+
+			c.JSON(200, gin.H{"message": "Database vacuumed successfully", "output": res.Output})
+			return
+		case "delete":
+			// Replicas go first; they depend on the primary. Turning off HA
+			// stops health checks and removes the standby.
+			dbID, _ := strconv.Atoi(id)
+			if err := failover.Disable(ctx, dbID); errors.Is(err, failover.ErrBusy) {
+				c.JSON(409, gin.H{"error": err.Error()})
+				return
+			} else if err != nil {
+				c.JSON(500, gin.H{"error": "Failed to turn off high availability: " + err.Error()})
+				return
+			}
+			if err := replication.RemoveAll(ctx, dbID); err != nil {
+				c.JSON(500, gin.H{"error": "Failed to remove replicas: " + err.Error()})
+				return
+			}
+
+			// Revoke all tokens first
+			db.RevokeAllTokensForDatabase(dbID)
+
+			// Stop and remove main container
+			_ = cli.ContainerStop(ctx, containerID, container.StopOptions{})
+			_ = cli.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true})
+			// Delete tokens from DB
+			db.DB.Exec("DELETE FROM database_tokens WHERE database_id = ?", id)
+			db.DB.Exec("DELETE FROM database_events WHERE database_id = ?", id)
+			db.DB.Exec("DELETE FROM database_config_changes WHERE database_id = ?", id)
+			db.DB.Exec("DELETE FROM database_roles WHERE database_id = ?", id)
+			// Delete from DB
+			_, err = db.DB.Exec("DELETE FROM databases WHERE id = ?", id)
+			if err != nil {
+				c.JSON(500, gin.H{"error": "Failed to delete database"})
+				return
+			}
+			c.JSON(200, gin.H{"message": "Database deleted"})
+			return
+		default:
+			c.JSON(400, gin.H{"error": "Invalid action"})
+			return
+		}
+
+		c.JSON(200, gin.H{"message": fmt.Sprintf("Database %sed", action)})
+	})
+
+	// ========== BRANCHES API ==========
+
+	// Get all branches for a database
+	r.GET("/api/databases/:id/branches", func(c *gin.Context) {
+		id := c.Param("id")
+
+		// Verify database exists
+		var dbName string
+		err := db.DB.QueryRow("SELECT name FROM databases WHERE id = ?", id).Scan(&dbName)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Database not found"})
+			return
+		}
+
+		rows, err := db.DB.Query("SELECT id, database_id, name, container_id, port, status, is_default, created_at FROM branches WHERE database_id = ? ORDER BY is_default DESC, created_at DESC", id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to query branches: " + err.Error()})
+			return
+		}
+		defer rows.Close()
+
+		branches := []map[string]interface{}{}
+		for rows.Next() {
+			var branchID, databaseID, port int
+			var name, containerID, status, createdAt string
+			var isDefault bool
+			if err := rows.Scan(&branchID, &databaseID, &name, &containerID, &port, &status, &isDefault, &createdAt); err != nil {
+				c.JSON(500, gin.H{"error": "Failed to scan branch: " + err.Error()})
+				return
+			}
+
+			branches = append(branches, map[string]interface{}{
+				"id":           branchID,
+				"database_id":  databaseID,
+				"name":         name,
+				"container_id": containerID,
+				"port":         port,
+				"status":       status,
+				"is_default":   isDefault,
+				"created_at":   createdAt,
+			})
+		}
+
+		c.JSON(http.StatusOK, branches)
+	})
+
+	// Create a new branch
+	r.POST("/api/databases/:id/branches", func(c *gin.Context) {
+		id := c.Param("id")
+
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if req.Name == "" {
+			c.JSON(400, gin.H{"error": "Branch name is required"})
+			return
+		}
+
+		// Verify database exists and get details
+		var dbID, dbPort int
+		var dbName, dbType, dbHost, dbPassword, dbVersion, dbContainerID, dbImage string
+		err := db.DB.QueryRow(
+			"SELECT id, name, type, host, port, password, version, container_id, COALESCE(image, '') FROM databases WHERE id = ?",
+			id,
+		).Scan(&dbID, &dbName, &dbType, &dbHost, &dbPort, &dbPassword, &dbVersion, &dbContainerID, &dbImage)
+
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Database not found"})
+			return
+		}
+		if dbPassword, err = secrets.Decrypt(dbPassword); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to decrypt database password"})
+			return
+		}
+
+		// Check if branch name already exists
+		var count int
+		err = db.DB.QueryRow("SELECT COUNT(*) FROM branches WHERE database_id = ? AND name = ?", id, req.Name).Scan(&count)
+		if err != nil || count > 0 {
+			c.JSON(400, gin.H{"error": "Branch with this name already exists"})
+			return
+		}
+
+		ctx := context.Background()
+		cli, err := databaseClient(id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to connect to Docker: " + err.Error()})
+			return
+		}
+		defer cli.Close()
+
+		// Get a free port for the new branch; remote servers assign their own
+		serverID, err := db.GetDatabaseServerID(dbID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to resolve database server"})
+			return
+		}
+		hostPort := ""
+		freePort := 0
+		if serverID == docker.LocalServer {
+			freePort, err = getFreePort()
+			if err != nil {
+				c.JSON(500, gin.H{"error": "Failed to get free port: " + err.Error()})
+				return
+			}
+			hostPort = strconv.Itoa(freePort)
+		}
+
+		// Generate unique container name
+		randBytes := make([]byte, 8)
+		rand.Read(randBytes)
+		containerName := fmt.Sprintf("baseful-%s-%s-%s", dbName, req.Name, hex.EncodeToString(randBytes))
+
+		// Create new container for the branch from the database's image, so
+		// its extensions are available
+		imageName := dbImage
+		if imageName == "" {
+			imageName = fmt.Sprintf("postgres:%s", dbVersion)
+			if dbVersion == "" {
+				imageName = "postgres:latest"
+			}
+		}
+
+		resp, err := cli.ContainerCreate(ctx, &container.Config{
+			Image:    imageName,
+			Hostname: req.Name,
+			Env: []string{
+				"POSTGRES_PASSWORD=" + dbPassword,
+				"POSTGRES_DB=" + dbName,
+			},
+			ExposedPorts: nat.PortSet{
+				"5432/tcp": struct{}{},
+			},
+			Labels: map[string]string{
+				"managed-by":       "baseful",
+				"baseful.branch":   req.Name,
+				"baseful.database": dbName,
+			},
+		}, &container.HostConfig{
+			NetworkMode: docker.NetworkName,
+			PortBindings: nat.PortMap{
+				"5432/tcp": []nat.PortBinding{
+					{HostIP: "0.0.0.0", HostPort: hostPort},
+				},
+			},
+			SecurityOpt: []string{
+				"no-new-privileges:true",
+			},
+			CapDrop: []string{"ALL"},
+			CapAdd:  []string{"CHOWN", "SETGID", "SETUID", "DAC_OVERRIDE"},
+			RestartPolicy: container.RestartPolicy{
+				Name: "unless-stopped",
+			},
+		}, nil, nil, containerName)
+
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to create container: " + err.Error()})
+			return
+		}
+
+		// Start the container
+		if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+			_ = cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
+			c.JSON(500, gin.H{"error": "Failed to start container: " + err.Error()})
+			return
+		}
+		if hostPort == "" {
+			if freePort, err = docker.MappedPort(ctx, cli, resp.ID, "5432/tcp"); err != nil {
+				_ = cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		// Wait for PostgreSQL to be ready
+		time.Sleep(3 * time.Second)
+
+		// Copy data from source database if it's not the first branch
+		var sourceBranchCount int
+		db.DB.QueryRow("SELECT COUNT(*) FROM branches WHERE database_id = ?", id).Scan(&sourceBranchCount)
+
+		if sourceBranchCount > 0 {
+			// Get the default branch container
+			var sourceContainerID string
+			err = db.DB.QueryRow("SELECT container_id FROM branches WHERE database_id = ? AND is_default = 1", id).Scan(&sourceContainerID)
+			if err == nil {
+				// Use pg_dump to copy data
+				dumpCmd := []string{"pg_dump", "-U", "postgres", "-d", dbName, "--no-owner", "--no-acl"}
+				dumpExec, err := cli.ContainerExecCreate(ctx, sourceContainerID, container.ExecOptions{
+					Cmd:          dumpCmd,
+					AttachStdout: true,
+					AttachStderr: true,
+				})
+				if err == nil {
+					dumpAttach, err := cli.ContainerExecAttach(ctx, dumpExec.ID, container.ExecAttachOptions{})
+					if err == nil {
+						defer dumpAttach.Close()
+
+						// Create exec to restore in new container
+						restoreCmd := []string{"psql", "-U", "postgres", "-d", dbName}
+						restoreExec, err := cli.ContainerExecCreate(ctx, resp.ID, container.ExecOptions{
+							Cmd:          restoreCmd,
+							AttachStdin:  true,
+							AttachStdout: true,
+							AttachStderr: true,
+						})
+						if err == nil {
+							restoreAttach, err := cli.ContainerExecAttach(ctx, restoreExec.ID, container.ExecAttachOptions{})
+							if err == nil {
+								defer restoreAttach.Close()
+								io.Copy(restoreAttach.Conn, dumpAttach.Reader)
+							}
+						}
+					}
+				}
+			}
+		}
+
+		// Store branch in database
+		result, err := db.DB.Exec(
+			"INSERT INTO branches (database_id, name, container_id, port, status, is_default) VALUES (?, ?, ?, ?, ?, ?)",
+			id, req.Name, resp.ID, freePort, "running", 0,
+		)
+
+		if err != nil {
+			_ = cli.ContainerStop(ctx, resp.ID, container.StopOptions{})
+			_ = cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
+			c.JSON(500, gin.H{"error": "Failed to save branch: " + err.Error()})
+			return
+		}
+
+		branchID, _ := result.LastInsertId()
+
+		c.JSON(200, gin.H{
+			"message":      "Branch created successfully",
+			"id":           branchID,
+			"name":         req.Name,
+			"container_id": resp.ID,
+			"port":         freePort,
+			"status":       "running",
+		})
+	})
+
+	// Branch control endpoints
+	r.POST("/api/databases/:id/branches/:branchId/:action", func(c *gin.Context) {
+		id := c.Param("id")
+		branchID := c.Param("branchId")
+		action := c.Param("action")
+		audit.Annotate(c, "branch."+action, "branch", branchID, map[string]any{"databaseId": id})
+
+		var containerID, status string
+		err := db.DB.QueryRow("SELECT container_id, status FROM branches WHERE id = ? AND database_id = ?", branchID, id).Scan(&containerID, &status)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Branch not found"})
+			return
+		}
+
+		ctx := context.Background()
+		cli, err := databaseClient(id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to connect to Docker"})
+			return
+		}
+		defer cli.Close()
+
+		switch action {
+		case "start":
+			if err := cli.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
+				c.JSON(500, gin.H{"error": "Failed to start container: " + err.Error()})
+				return
+			}
+			db.DB.Exec("UPDATE branches SET status = 'running' WHERE id = ?", branchID)
+		case "stop":
+			if err := cli.ContainerStop(ctx, containerID, container.StopOptions{}); err != nil {
+				c.JSON(500, gin.H{"error": "Failed to stop container: " + err.Error()})
+				return
+			}
+			db.DB.Exec("UPDATE branches SET status = 'stopped' WHERE id = ?", branchID)
+		case "delete":
+			// Stop and remove container
+			_ = cli.ContainerStop(ctx, containerID, container.StopOptions{})
+			_ = cli.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true})
+			// Delete from DB
+			_, err = db.DB.Exec("DELETE FROM branches WHERE id = ?", branchID)
+			if err != nil {
+				c.JSON(500, gin.H{"error": "Failed to delete branch"})
+				return
+			}
+			c.JSON(200, gin.H{"message": "Branch deleted"})
+			return
+		case "switch":
+			// Set this branch as default, unset others
+			db.DB.Exec("UPDATE branches SET is_default = 0 WHERE database_id = ?", id)
+			db.DB.Exec("UPDATE branches SET is_default = 1 WHERE id = ?", branchID)
+			c.JSON(200, gin.H{"message": "Switched to branch"})
+			return
+		default:
+			c.JSON(400, gin.H{"error": "Invalid action"})
+			return
+		}
+
+		c.JSON(200, gin.H{"message": fmt.Sprintf("Branch %sed", action)})
+	})
+
+	// SQL Assistant Endpoint
+	// Modes: generate (default), explain, optimize, fix and execute. Execute runs
+	// reads directly; writes and DDL need a second call with confirm and the
+	// returned confirmationHash, and destructive statements are preceded by a backup.
+	r.POST("/api/databases/:id/sql-assistant", func(c *gin.Context) {
+		id := c.Param("id")
+		userID := c.MustGet("user_id").(int)
+
+		var dbID, port, projectID int
+		var name, dbType, host, status, version string
+		err := db.DB.QueryRow(
+			"SELECT id, name, type, host, port, status, version, COALESCE(project_id, 0) FROM databases WHERE id = ?",
+			id,
+		).Scan(&dbID, &name, &dbType, &host, &port, &status, &version, &projectID)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Database not found"})
+			return
+		}
+		if status != "active" && status != db.StatusSleeping {
+			c.JSON(400, gin.H{"error": "Database is not running"})
+			return
+		}
+
+		var req struct {
+			Mode             string `json:"mode"`
+			Prompt           string `json:"prompt"`
+			CurrentQuery     string `json:"currentQuery"`
+			Query            string `json:"query"`
+			Error            string `json:"error"`
+			Confirm          bool   `json:"confirm"`
+			ConfirmationHash string `json:"confirmationHash"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+		mode := req.Mode
+		if mode == "" {
+			mode = "generate"
+		}
+		query := strings.TrimSpace(req.Query)
+		if query == "" && mode != "generate" {
+			query = strings.TrimSpace(req.CurrentQuery)
+		}
+
+		switch mode {
+		case "generate":
+			if strings.TrimSpace(req.Prompt) == "" {
+				c.JSON(400, gin.H{"error": "Prompt cannot be empty"})
+				return
+			}
+		case "explain", "optimize", "fix":
+			if query == "" {
+				c.JSON(400, gin.H{"error": "Query cannot be empty"})
+				return
+			}
+		case "execute":
+			if query == "" && strings.TrimSpace(req.Prompt) == "" {
+				c.JSON(400, gin.H{"error": "Prompt or query is required"})
+				return
+			}
+		default:
+			c.JSON(400, gin.H{"error": "Invalid mode"})
+			return
+		}
+
+		ctx := context.Background()
+		schemaSummary := func() string {
+			cli, err := databaseClient(id)
+			if err != nil {
+				return "Schema summary unavailable."
+			}
+			defer cli.Close()
+
+			var containerID string
+			if err := db.DB.QueryRow("SELECT container_id FROM databases WHERE id = ?", id).Scan(&containerID); err != nil {
+				return "Schema summary unavailable."
+			}
+			summary, err := fetchSchemaSummary(ctx, cli, containerID, name)
+			if err != nil {
+				return "Schema summary unavailable."
+			}
+			return summary
+		}
+
+		// complete writes the error response itself and returns false on failure
+		complete := func(systemPrompt, userPrompt string, maxTokens int) (string, bool) {
+			completion, err := completeForProject(c.Request.Context(), projectID, userID, systemPrompt, userPrompt, maxTokens)
+			if errors.Is(err, llm.ErrNotConfigured) {
+				c.JSON(400, gin.H{"error": err.Error() + ". Add an API key in Profile settings or configure a provider for this project."})
+				return "", false
+			}
+			if err != nil {
+				c.JSON(502, gin.H{"error": "Assistant request failed: " + err.Error()})
+				return "", false
+			}
+			return completion, true
+		}
+
+		generateSQL := func(userPrompt string) (string, bool) {
+			completion, ok := complete(sqlGeneratorPrompt, userPrompt, 800)
+			if !ok {
+				return "", false
+			}
+			sqlText := sanitizeSQLResponse(completion)
+			if strings.TrimSpace(sqlText) == "" {
+				c.JSON(502, gin.H{"error": "Failed to generate SQL: model returned empty SQL"})
+				return "", false
+			}
+			return sqlText, true
+		}
+
+		switch mode {
+		case "generate":
+			sqlText, ok := generateSQL(fmt.Sprintf(
+				"Database name: %s\nSchema:\n%s\nCurrent editor SQL:\n%s\nUser request:\n%s\nOutput only SQL.",
+				name, schemaSummary(), req.CurrentQuery, req.Prompt,
+			))
+			if !ok {
+				return
+			}
+			c.JSON(200, gin.H{
+				"sql":        sqlText,
+				"statements": pg.ClassifySQL(sqlText),
+			})
+
+		case "explain":
+			explanation, ok := complete(
+				"You are a PostgreSQL expert. Explain what the given query does in plain language for a developer: what it reads or changes, how tables are joined and filtered, and anything surprising or risky. Be concise and use short paragraphs or bullet points.",
+				fmt.Sprintf("Schema:\n%s\nQuery:\n%s", schemaSummary(), query),
+				1200,
+			)
+			if !ok {
+				return
+			}
+			c.JSON(200, gin.H{"mode": mode, "explanation": strings.TrimSpace(explanation)})
+
+		case "optimize":
+			kind, _ := pg.SummarizeStatements(pg.ClassifySQL(query))
+			if kind == pg.KindDDL {
+				c.JSON(400, gin.H{"error": "Only queries and data changes can be optimized"})
+				return
+			}
+
+			conn, ok := connectActiveDatabase(c)
+			if !ok {
+				return
+			}
+			defer conn.Close(context.Background())
+
+			// Only reads are actually executed; writes get the estimated plan
+			analyzed := kind == pg.KindRead
+			planCtx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+			defer cancel()
+			plan, err := pg.ExplainQuery(planCtx, conn, query, analyzed)
+			if err != nil {
+				c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+
+			suggestions, ok := complete(
+				"You are a PostgreSQL performance expert. Given a query, its EXPLAIN output and the schema, explain the main costs in the plan and suggest improvements. Only suggest indexes that the plan shows would help. End your answer with a line containing only INDEXES: followed by one CREATE INDEX statement per line, or nothing if no index is needed. Do not use markdown fences.",
+				fmt.Sprintf("Schema:\n%s\nQuery:\n%s\nEXPLAIN output:\n%s", schemaSummary(), query, plan),
+				1500,
+			)
+			if !ok {
+				return
+			}
+			advice, indexes := splitIndexSuggestions(suggestions)
+			c.JSON(200, gin.H{
+				"mode":        mode,
+				"plan":        plan,
+				"analyzed":    analyzed,
+				"suggestions": advice,
+				"indexes":     indexes,
+			})
+
+		case "fix":
+			queryError := strings.TrimSpace(req.Error)
+			if queryError == "" {
+				// Planning the query surfaces syntax and reference errors without running it
+				conn, ok := connectActiveDatabase(c)
+				if !ok {
+					return
+				}
+				_, err := pg.ExplainQuery(c.Request.Context(), conn, query, false)
+				conn.Close(context.Background())
+				if err == nil {
+					c.JSON(400, gin.H{"error": "The query plans without errors. Provide the error message it fails with."})
+					return
 				}
+				queryError = err.Error()
+			}
 
-				if pullUpdate.ProgressDetail.Total > 0 {
-					percent := float64(pullUpdate.ProgressDetail.Current) / float64(pullUpdate.ProgressDetail.Total) * 100
-					sendUpdate("pulling", "Pulling "+imageName+"...", percent, nil)
+			sqlText, ok := generateSQL(fmt.Sprintf(
+				"Database name: %s\nSchema:\n%s\nThis query fails:\n%s\nError:\n%s\nReturn the corrected query with the same intent. Output only SQL.",
+				name, schemaSummary(), query, queryError,
+			))
+			if !ok {
+				return
+			}
+			c.JSON(200, gin.H{
+				"mode":       mode,
+				"sql":        sqlText,
+				"error":      queryError,
+				"statements": pg.ClassifySQL(sqlText),
+			})
+
+		case "execute":
+			sqlText := query
+			if req.Confirm && sqlText == "" {
+				c.JSON(400, gin.H{"error": "The confirmed query is required"})
+				return
+			}
+			if sqlText == "" {
+				generated, ok := generateSQL(fmt.Sprintf(
+					"Database name: %s\nSchema:\n%s\nCurrent editor SQL:\n%s\nUser request:\n%s\nOutput only SQL.",
+					name, schemaSummary(), req.CurrentQuery, req.Prompt,
+				))
+				if !ok {
+					return
 				}
+				sqlText = generated
 			}
 
-			// Get a free port
-			sendUpdate("creating", "Generating configuration...", 100, nil)
-			freePort, err := getFreePort()
-			if err != nil {
-				sendUpdate("error", "Failed to get free port: "+err.Error(), 0, nil)
-				return false
+			statements := pg.ClassifySQL(sqlText)
+			if len(statements) == 0 {
+				c.JSON(400, gin.H{"error": "No SQL statements to execute"})
+				return
+			}
+			kind, destructive := pg.SummarizeStatements(statements)
+			audit.Annotate(c, "sql_assistant.execute", "database", id, map[string]any{
+				"query":       audit.Excerpt(sqlText),
+				"kind":        kind,
+				"destructive": destructive,
+				"confirmed":   req.Confirm,
+			})
+			response := gin.H{
+				"mode":        mode,
+				"sql":         sqlText,
+				"kind":        kind,
+				"destructive": destructive,
+				"statements":  statements,
 			}
 
-			password, err := generatePassword(16)
-			if err != nil {
-				sendUpdate("error", "Failed to generate password", 0, nil)
-				return false
+			if kind != pg.KindRead {
+				if !req.Confirm {
+					response["requiresConfirmation"] = true
+					response["confirmationHash"] = sqlConfirmationHash(sqlText)
+					c.JSON(200, response)
+					return
+				}
+				if req.ConfirmationHash != sqlConfirmationHash(sqlText) {
+					c.JSON(409, gin.H{"error": "The query changed since it was confirmed"})
+					return
+				}
 			}
 
-			randBytes := make([]byte, 8)
-			rand.Read(randBytes)
-			containerName := fmt.Sprintf("baseful-%s-%s", req.Name, hex.EncodeToString(randBytes))
+			if destructive {
+				settings, err := backups.GetBackupSettings(dbID)
+				if err != nil {
+					c.JSON(500, gin.H{"error": "Failed to load backup settings"})
+					return
+				}
+				if settings.Endpoint == "" || settings.Bucket == "" {
+					c.JSON(412, gin.H{"error": "Destructive statements require a backup first. Configure backups for this database."})
+					return
+				}
+				backupID, err := backups.BackupNow(dbID)
+				if err != nil {
+					c.JSON(502, gin.H{"error": "Backup before execution failed: " + err.Error()})
+					return
+				}
+				response["backupId"] = backupID
+				audit.AddDetail(c, "backupId", backupID)
+			}
 
-			// Create container
-			sendUpdate("creating", "Creating container...", 100, nil)
-			resp, err := cli.ContainerCreate(ctx, &container.Config{
-				Image:    imageName,
-				Hostname: req.Name,
-				Env: []string{
-					"POSTGRES_PASSWORD=" + password,
-					"POSTGRES_DB=" + req.Name,
-				},
-				ExposedPorts: nat.PortSet{
-					"5432/tcp": struct{}{},
-				},
-				Labels: map[string]string{
-					"managed-by":         "baseful",
-					"baseful.database":   req.Name,
-					"baseful.project_id": fmt.Sprintf("%d", req.ProjectID),
-				},
-			}, &container.HostConfig{
-				NetworkMode: docker.NetworkName,
-				PortBindings: nat.PortMap{
-					"5432/tcp": []nat.PortBinding{
-						{HostIP: "0.0.0.0", HostPort: strconv.Itoa(freePort)},
-					},
-				},
-				Resources: container.Resources{
-					Memory:   int64(req.MaxRAMMB) * 1024 * 1024,
-					NanoCPUs: int64(req.MaxCPU * 1000000000),
-				},
-				SecurityOpt: []string{
-					"no-new-privileges:true",
-				},
-				CapDrop: []string{"ALL"},
-				CapAdd:  []string{"CHOWN", "SETGID", "SETUID", "DAC_OVERRIDE"},
-				RestartPolicy: container.RestartPolicy{
-					Name: "unless-stopped",
-				},
-			}, nil, nil, containerName)
+			conn, ok := connectActiveDatabase(c)
+			if !ok {
+				return
+			}
+			defer conn.Close(context.Background())
 
+			sqlStatements := make([]string, len(statements))
+			for i, stmt := range statements {
+				sqlStatements[i] = stmt.SQL
+			}
+			results, err := pg.ExecuteStatements(c.Request.Context(), conn, sqlStatements, kind == pg.KindRead, 500)
 			if err != nil {
-				sendUpdate("error", "Failed to create container: "+err.Error(), 0, nil)
-				return false
+				response["error"] = err.Error()
+				c.JSON(pg.ErrorStatus(err), response)
+				return
 			}
+			response["results"] = results
+			c.JSON(200, response)
+		}
+	})
 
-			// Start container
-			sendUpdate("starting", "Starting database engine...", 100, nil)
-			if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-				_ = cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
-				sendUpdate("error", "Failed to start container: "+err.Error(), 0, nil)
-				return false
-			}
+	// SQL Query Endpoint
+	r.POST("/api/databases/:id/query", func(c *gin.Context) {
+		id := c.Param("id")
 
-			// Store in DB
-			sendUpdate("finalizing", "Finalizing database setup...", 100, nil)
-			result, err := db.DB.Exec(
-				"INSERT INTO databases (name, type, host, port, mapped_port, container_id, version, password, status, project_id, max_cpu, max_ram_mb, max_storage_mb) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-				req.Name, req.Type, containerName, 5432, freePort, resp.ID, req.Version, password, "active", req.ProjectID, req.MaxCPU, req.MaxRAMMB, req.MaxStorageMB,
-			)
+		var db_id, port int
+		var name, dbType, host, status, version string
+		err := db.DB.QueryRow(
+			"SELECT id, name, type, host, port, status, version FROM databases WHERE id = ?",
+			id,
+		).Scan(&db_id, &name, &dbType, &host, &port, &status, &version)
 
-			if err != nil {
-				sendUpdate("error", "Failed to save to database: "+err.Error(), 0, nil)
-				return false
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Database not found"})
+			return
+		}
+
+		if status != "active" && status != db.StatusSleeping {
+			c.JSON(400, gin.H{"error": "Database is not running"})
+			return
+		}
+
+		var req struct {
+			Query string `json:"query"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if req.Query == "" {
+			c.JSON(400, gin.H{"error": "Query cannot be empty"})
+			return
+		}
+		audit.Annotate(c, "database.query", "database", id, map[string]any{"query": audit.Excerpt(req.Query)})
+
+		ctx := context.Background()
+		cli, err := databaseClient(id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to connect to Docker"})
+			return
+		}
+		defer cli.Close()
+
+		// Get container ID from database record
+		var containerID string
+		err = db.DB.QueryRow("SELECT container_id FROM databases WHERE id = ?", id).Scan(&containerID)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Container not found"})
+			return
+		}
+
+		// Execute query using psql via docker exec
+		cmd := []string{"psql", "-U", "postgres", "-d", name, "-c", req.Query}
+		execResp, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+			Cmd:          cmd,
+			AttachStdout: true,
+			AttachStderr: true,
+		})
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to create exec: " + err.Error()})
+			return
+		}
+
+		// Attach to exec output
+		attachResp, err := cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{})
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to attach to exec: " + err.Error()})
+			return
+		}
+		defer attachResp.Close()
+
+		// Read output using stdcopy to handle Docker multiplexed stream
+		var stdout, stderr bytes.Buffer
+		_, err = stdcopy.StdCopy(&stdout, &stderr, attachResp.Reader)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to read output: " + err.Error()})
+			return
+		}
+
+		outputStr := stdout.String()
+		if stderr.Len() > 0 && outputStr == "" {
+			outputStr = stderr.String()
+		}
+
+		// Check if it's a SELECT query (returns results) or an action query
+		isSelect := len(outputStr) > 0 && (outputStr[0] == '(' || outputStr[0] == '-' || len(outputStr) > 10)
+
+		c.JSON(200, gin.H{
+			"result":    outputStr,
+			"is_select": isSelect,
+		})
+	})
+
+	// List Schemas Endpoint
+	r.GET("/api/databases/:id/schemas", func(c *gin.Context) {
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
+		}
+		defer conn.Close(context.Background())
+
+		schemas, err := pg.ListSchemas(c.Request.Context(), conn, c.Query("includeSystem") == "true")
+		if err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, schemas)
+	})
+
+	// List Tables Endpoint (all relation kinds unless ?kind= is given)
+	r.GET("/api/databases/:id/tables", func(c *gin.Context) {
+		var kinds []string
+		if kind := c.Query("kind"); kind != "" {
+			kinds = strings.Split(kind, ",")
+		}
+
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
+		}
+		defer conn.Close(context.Background())
+
+		relations, err := pg.ListRelations(c.Request.Context(), conn, c.DefaultQuery("schema", "public"), kinds)
+		if err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		// row_count is kept as a string for existing clients; it is now an estimate
+		tables := []gin.H{}
+		for _, rel := range relations {
+			rowCount := ""
+			if rel.RowEstimate != nil {
+				rowCount = strconv.FormatInt(*rel.RowEstimate, 10)
 			}
+			tables = append(tables, gin.H{
+				"name":        rel.Name,
+				"schema":      rel.Schema,
+				"kind":        rel.Kind,
+				"row_count":   rowCount,
+				"rowEstimate": rel.RowEstimate,
+				"sizeBytes":   rel.SizeBytes,
+				"comment":     rel.Comment,
+			})
+		}
 
-			databaseID, _ := result.LastInsertId()
+		c.JSON(200, tables)
+	})
 
-			// Tokens and connection strings
-			tokenID, _ := auth.GenerateTokenID()
-			issuedAt := time.Now().UTC()
-			expiresAt := issuedAt.AddDate(2, 0, 0)
-			jwtToken, _ := auth.GenerateJWTWithTimestamps(int(databaseID), 0, tokenID, issuedAt, expiresAt)
-			tokenHash := db.HashToken(jwtToken)
-			db.CreateToken(int(databaseID), tokenID, tokenHash, issuedAt, expiresAt)
+	// Get View Definition Endpoint
+	r.GET("/api/databases/:id/tables/:tableName/definition", func(c *gin.Context) {
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
+		}
+		defer conn.Close(context.Background())
+
+		schema := c.DefaultQuery("schema", "public")
+		kind, definition, err := pg.GetViewDefinition(c.Request.Context(), conn, schema, c.Param("tableName"))
+		if err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"schema":     schema,
+			"name":       c.Param("tableName"),
+			"kind":       kind,
+			"definition": definition,
+		})
+	})
+
+	// List Functions Endpoint
+	r.GET("/api/databases/:id/functions", func(c *gin.Context) {
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
+		}
+		defer conn.Close(context.Background())
+
+		functions, err := pg.ListFunctions(c.Request.Context(), conn, c.DefaultQuery("schema", "public"))
+		if err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, functions)
+	})
+
+	// Get Function Source Endpoint
+	r.GET("/api/databases/:id/functions/:oid", func(c *gin.Context) {
+		oid, err := strconv.ParseUint(c.Param("oid"), 10, 32)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid function oid"})
+			return
+		}
+
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
+		}
+		defer conn.Close(context.Background())
+
+		fn, definition, err := pg.GetFunctionDefinition(c.Request.Context(), conn, uint32(oid))
+		if err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{"function": fn, "definition": definition})
+	})
+
+	// List Installed Extensions Endpoint
+	r.GET("/api/databases/:id/extensions", func(c *gin.Context) {
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
+		}
+		defer conn.Close(context.Background())
+
+		extensions, err := pg.ListInstalledExtensions(c.Request.Context(), conn)
+		if err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
 
-			proxyHost := auth.GetProxyHost()
-			proxyPort := auth.GetProxyPort()
-			portInt, _ := strconv.Atoi(proxyPort)
-			connectionString := auth.GenerateConnectionString(jwtToken, int(databaseID), proxyHost, portInt, "require")
+		c.JSON(200, extensions)
+	})
 
-			// Final success message with data
-			sendUpdate("success", "Database created successfully!", 100, gin.H{
-				"id":                databaseID,
-				"container_id":      resp.ID,
-				"connection_string": connectionString,
-				"internal_host":     containerName,
-				"internal_port":     5432,
-			})
+	// List the extensions the database's image provides, with their versions
+	// and whether they are enabled
+	r.GET("/api/databases/:id/extensions/available", func(c *gin.Context) {
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
+		}
+		defer conn.Close(context.Background())
 
-			return false
-		})
+		extensions, err := pg.ListAvailableExtensions(c.Request.Context(), conn)
+		if err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, extensions)
 	})
 
-	// Get single database by ID
-	r.GET("/api/databases/:id", func(c *gin.Context) {
-		id := c.Param("id")
-		var db_id, port, projectID int
-		var name, dbType, host, status, version, password, containerID string
+	// Enable an extension, or update it to another version. Extensions that
+	// must be preloaded answer 409 with restartRequired the first time.
+	r.POST("/api/databases/:id/extensions", func(c *gin.Context) {
+		var req struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			Schema  string `json:"schema"`
+			// Cascade also installs extensions this one depends on
+			Cascade bool `json:"cascade"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+			c.JSON(400, gin.H{"error": "name is required"})
+			return
+		}
+		audit.Annotate(c, "database.extension_enable", "database", c.Param("id"), map[string]any{
+			"extension": req.Name,
+			"version":   req.Version,
+		})
 
-		err := db.DB.QueryRow(
-			"SELECT id, name, type, host, port, status, version, password, project_id, container_id FROM databases WHERE id = ?",
-			id,
-		).Scan(&db_id, &name, &dbType, &host, &port, &status, &version, &password, &projectID, &containerID)
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
+		}
+		defer conn.Close(context.Background())
 
+		extension, err := pg.EnableExtension(c.Request.Context(), conn, req.Name, req.Version, req.Schema, req.Cascade)
+		if errors.Is(err, pg.ErrRestartRequired) {
+			c.JSON(409, gin.H{"error": err.Error(), "restartRequired": true})
+			return
+		}
 		if err != nil {
-			c.JSON(404, gin.H{"error": "Database not found"})
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		// Get existing active token (don't regenerate on every fetch)
-		tokenRecord, _ := db.GetActiveTokenForDatabase(db_id)
+		c.JSON(200, extension)
+	})
 
-		proxyHost := auth.GetProxyHost()
-		proxyPort := auth.GetProxyPort()
-		portInt, _ := strconv.Atoi(proxyPort)
+	// Disable an extension; ?cascade=true also drops objects that use it
+	r.DELETE("/api/databases/:id/extensions/:name", func(c *gin.Context) {
+		cascade := c.Query("cascade") == "true"
+		audit.Annotate(c, "database.extension_disable", "database", c.Param("id"), map[string]any{
+			"extension": c.Param("name"),
+			"cascade":   cascade,
+		})
 
-		// Return censored connection string (actual token not exposed)
-		connectionString := auth.GenerateConnectionString("***CENSORED***", db_id, proxyHost, portInt, "require")
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
+		}
+		defer conn.Close(context.Background())
 
-		response := gin.H{
-			"id":                db_id,
-			"name":              name,
-			"type":              dbType,
-			"host":              host,
-			"port":              port,
-			"status":            status,
-			"version":           version,
-			"connection_string": connectionString,
-			"projectId":         projectID,
-			"container_id":      containerID,
+		if err := pg.DisableExtension(c.Request.Context(), conn, c.Param("name"), cascade); err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+			return
 		}
 
-		if tokenRecord != nil {
-			response["has_token"] = true
-			response["token_id"] = tokenRecord.TokenID
-			response["token_expires_at"] = tokenRecord.ExpiresAt
-			response["token_revoked"] = tokenRecord.Revoked
-		} else {
-			response["has_token"] = false
+		c.JSON(200, gin.H{"message": "Extension disabled"})
+	})
+
+	// Get Table Data Endpoint
+	r.GET("/api/databases/:id/tables/:tableName", func(c *gin.Context) {
+		query, err := tableQueryFromParams(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
 		}
+		respondTableData(c, query)
+	})
 
-		c.JSON(200, response)
+	// Query Table Data Endpoint (same as above with the query in the body, for large filters)
+	r.POST("/api/databases/:id/tables/:tableName/query", func(c *gin.Context) {
+		var query pg.TableQuery
+		if err := decodeJSONBody(c, &query); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+		respondTableData(c, query)
 	})
 
-	// Database Control Endpoints
-	r.POST("/api/databases/:id/:action", func(c *gin.Context) {
-		id := c.Param("id")
-		action := c.Param("action")
+	// Insert Table Rows Endpoint
+	r.POST("/api/databases/:id/tables/:tableName/rows", func(c *gin.Context) {
+		tableName := c.Param("tableName")
 
-		var containerID, status string
-		err := db.DB.QueryRow("SELECT container_id, status FROM databases WHERE id = ?", id).Scan(&containerID, &status)
-		if err != nil {
-			c.JSON(404, gin.H{"error": "Database not found"})
+		var req struct {
+			Rows []map[string]any `json:"rows"`
+		}
+		if err := decodeJSONBody(c, &req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
 			return
 		}
 
-		ctx := context.Background()
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
+		}
+		defer conn.Close(context.Background())
+
+		rows, err := pg.InsertRows(c.Request.Context(), conn, c.DefaultQuery("schema", "public"), tableName, req.Rows)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to connect to Docker"})
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
-		defer cli.Close()
 
-		switch action {
-		case "start":
-			if err := cli.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
-				c.JSON(500, gin.H{"error": "Failed to start container: " + err.Error()})
-				return
-			}
-			db.DB.Exec("UPDATE databases SET status = 'active' WHERE id = ?", id)
-		case "stop":
-			if err := cli.ContainerStop(ctx, containerID, container.StopOptions{}); err != nil {
-				c.JSON(500, gin.H{"error": "Failed to stop container: " + err.Error()})
-				return
-			}
-			db.DB.Exec("UPDATE databases SET status = 'stopped' WHERE id = ?", id)
-		case "restart":
-			if err := cli.ContainerRestart(ctx, containerID, container.StopOptions{}); err != nil {
-				c.JSON(500, gin.H{"error": "Failed to restart container: " + err.Error()})
+		c.JSON(201, gin.H{"rows": rows, "count": len(rows)})
+	})
+
+	// Update Table Rows Endpoint
+	r.PUT("/api/databases/:id/tables/:tableName/rows", func(c *gin.Context) {
+		tableName := c.Param("tableName")
+
+		var req struct {
+			Changes []pg.RowChange `json:"changes"`
+			// Legacy format: single-cell updates keyed by one primary key column
+			Updates []struct {
+				RowID      any    `json:"rowId"`
+				ColumnName string `json:"columnName"`
+				Value      any    `json:"value"`
+			} `json:"updates"`
+			PrimaryKey string `json:"primaryKey"`
+		}
+		if err := decodeJSONBody(c, &req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		changes := req.Changes
+		if len(req.Updates) > 0 {
+			if req.PrimaryKey == "" {
+				c.JSON(400, gin.H{"error": "primaryKey is required with updates"})
 				return
 			}
-			db.DB.Exec("UPDATE databases SET status = 'active' WHERE id = ?", id)
-		case "vacuum":
-			var dbName string
-			err := db.DB.QueryRow("SELECT name FROM databases WHERE id = ?", id).Scan(&dbName)
-			if err != nil {
-				c.JSON(404, gin.H{"error": "Database not found"})
-				return
+			byRow := map[string]int{}
+			for _, update := range req.Updates {
+				rowKey := fmt.Sprint(update.RowID)
+				idx, seen := byRow[rowKey]
+				if !seen {
+					idx = len(changes)
+					byRow[rowKey] = idx
+					changes = append(changes, pg.RowChange{
+						Key:    map[string]any{req.PrimaryKey: update.RowID},
+						Values: map[string]any{},
+					})
+				}
+				changes[idx].Values[update.ColumnName] = update.Value
 			}
+		}
 
-			// Run VACUUM ANALYZE;
-			command := fmt.Sprintf("psql -U postgres -d %s -c \"VACUUM ANALYZE;\"", dbName)
-			res, err := docker.ExecCommand(containerID, command, "/")
-			if err != nil {
-				c.JSON(500, gin.H{"error": "Failed to vacuum database: " + err.Error()})
-				return
-			}
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
+		}
+		defer conn.Close(context.Background())
 
-			if strings.Contains(res.Output, "ERROR") {
-				c.JSON(500, gin.H{"error": "PostgreSQL error: " + res.Output})
-				return
-			}
+		rows, err := pg.UpdateRows(c.Request.Context(), conn, c.DefaultQuery("schema", "public"), tableName, changes)
+		if err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
 
-			c.JSON(200, gin.H{"message": "Database vacuumed successfully", "output": res.Output})
+		c.JSON(200, gin.H{"message": "Rows updated successfully", "rows": rows, "count": len(rows)})
+	})
+
+	// Delete Table Rows Endpoint
+	r.DELETE("/api/databases/:id/tables/:tableName/rows", func(c *gin.Context) {
+		tableName := c.Param("tableName")
+
+		var req struct {
+			Rows []pg.RowChange `json:"rows"`
+		}
+		if err := decodeJSONBody(c, &req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
 			return
-		case "delete":
-			// Revoke all tokens first
-			dbID, _ := strconv.Atoi(id)
-			db.RevokeAllTokensForDatabase(dbID)
+		}
 
-			// Stop and remove main container
-			_ = cli.ContainerStop(ctx, containerID, container.StopOptions{})
-			_ = cli.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true})
-			// Delete tokens from DB
-			db.DB.Exec("DELETE FROM database_tokens WHERE database_id = ?", id)
-			// Delete from DB
-			_, err = db.DB.Exec("DELETE FROM databases WHERE id = ?", id)
-			if err != nil {
-				c.JSON(500, gin.H{"error": "Failed to delete database"})
-				return
-			}
-			c.JSON(200, gin.H{"message": "Database deleted"})
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
 			return
-		default:
-			c.JSON(400, gin.H{"error": "Invalid action"})
+		}
+		defer conn.Close(context.Background())
+
+		rows, err := pg.DeleteRows(c.Request.Context(), conn, c.DefaultQuery("schema", "public"), tableName, req.Rows)
+		if err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(200, gin.H{"message": fmt.Sprintf("Database %sed", action)})
+		c.JSON(200, gin.H{"message": "Rows deleted successfully", "rows": rows, "count": len(rows)})
 	})
 
-	// ========== BRANCHES API ==========
+	// ========== SCHEMA EDITOR API ==========
 
-	// Get all branches for a database
-	r.GET("/api/databases/:id/branches", func(c *gin.Context) {
-		id := c.Param("id")
+	// Preview the DDL generated for a list of schema operations without running it
+	r.POST("/api/databases/:id/schema/preview", func(c *gin.Context) {
+		var req struct {
+			Operations []pg.SchemaOperation `json:"operations"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
 
-		// Verify database exists
-		var dbName string
-		err := db.DB.QueryRow("SELECT name FROM databases WHERE id = ?", id).Scan(&dbName)
+		statements, err := pg.BuildDDL(req.Operations)
 		if err != nil {
-			c.JSON(404, gin.H{"error": "Database not found"})
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		rows, err := db.DB.Query("SELECT id, database_id, name, container_id, port, status, is_default, created_at FROM branches WHERE database_id = ? ORDER BY is_default DESC, created_at DESC", id)
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to query branches: " + err.Error()})
+		c.JSON(200, gin.H{"statements": statements})
+	})
+
+	// Apply schema operations in a single transaction
+	r.POST("/api/databases/:id/schema/apply", func(c *gin.Context) {
+		var req struct {
+			Operations []pg.SchemaOperation `json:"operations"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
 			return
 		}
-		defer rows.Close()
 
-		branches := []map[string]interface{}{}
-		for rows.Next() {
-			var branchID, databaseID, port int
-			var name, containerID, status, createdAt string
-			var isDefault bool
-			if err := rows.Scan(&branchID, &databaseID, &name, &containerID, &port, &status, &isDefault, &createdAt); err != nil {
-				c.JSON(500, gin.H{"error": "Failed to scan branch: " + err.Error()})
-				return
-			}
+		// Validate before connecting so malformed requests fail fast
+		if _, err := pg.BuildDDL(req.Operations); err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
 
-			branches = append(branches, map[string]interface{}{
-				"id":           branchID,
-				"database_id":  databaseID,
-				"name":         name,
-				"container_id": containerID,
-				"port":         port,
-				"status":       status,
-				"is_default":   isDefault,
-				"created_at":   createdAt,
-			})
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
 		}
+		defer conn.Close(context.Background())
 
-		c.JSON(http.StatusOK, branches)
+		statements, err := pg.ApplySchemaOperations(c.Request.Context(), conn, req.Operations)
+		if err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{"message": "Schema updated successfully", "statements": statements})
 	})
 
-	// Create a new branch
-	r.POST("/api/databases/:id/branches", func(c *gin.Context) {
-		id := c.Param("id")
+	// List enum types
+	r.GET("/api/databases/:id/schema/enums", func(c *gin.Context) {
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
+		}
+		defer conn.Close(context.Background())
 
-		var req struct {
-			Name string `json:"name"`
+		enums, err := pg.ListEnums(c.Request.Context(), conn, c.DefaultQuery("schema", "public"))
+		if err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+			return
 		}
-		if err := c.BindJSON(&req); err != nil {
-			c.JSON(400, gin.H{"error": "Invalid request"})
+
+		c.JSON(200, enums)
+	})
+
+	// ========== TOKEN MANAGEMENT API ==========
+
+	// Get tokens for a database
+	r.GET("/api/databases/:id/tokens", func(c *gin.Context) {
+		id := c.Param("id")
+		databaseID, err := strconv.Atoi(id)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
 			return
 		}
 
-		if req.Name == "" {
-			c.JSON(400, gin.H{"error": "Branch name is required"})
+		tokens, err := db.GetTokensForDatabase(databaseID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to get tokens"})
 			return
 		}
 
-		// Verify database exists and get details
-		var dbID, dbPort int
-		var dbName, dbType, dbHost, dbPassword, dbVersion, dbContainerID string
-		err := db.DB.QueryRow(
-			"SELECT id, name, type, host, port, password, version, container_id FROM databases WHERE id = ?",
-			id,
-		).Scan(&dbID, &dbName, &dbType, &dbHost, &dbPort, &dbPassword, &dbVersion, &dbContainerID)
+		c.JSON(200, tokens)
+	})
 
+	// Rotate token for a database (revokes old active tokens and issues a new one)
+	r.POST("/api/databases/:id/tokens/rotate", func(c *gin.Context) {
+		id := c.Param("id")
+		databaseID, err := strconv.Atoi(id)
 		if err != nil {
-			c.JSON(404, gin.H{"error": "Database not found"})
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
 			return
 		}
 
-		// Check if branch name already exists
-		var count int
-		err = db.DB.QueryRow("SELECT COUNT(*) FROM branches WHERE database_id = ? AND name = ?", id, req.Name).Scan(&count)
-		if err != nil || count > 0 {
-			c.JSON(400, gin.H{"error": "Branch with this name already exists"})
+		// Generate new token
+		tokenID, err := auth.GenerateTokenID()
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to generate token ID"})
 			return
 		}
+		audit.Annotate(c, "token.rotate", "database", id, map[string]any{"tokenId": tokenID})
 
-		ctx := context.Background()
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		issuedAt := time.Now().UTC()
+		expiresAt := issuedAt.AddDate(2, 0, 0)
+		jwtToken, err := auth.GenerateJWTWithTimestamps(databaseID, 0, tokenID, issuedAt, expiresAt)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to connect to Docker: " + err.Error()})
+			c.JSON(500, gin.H{"error": "Failed to generate JWT token"})
 			return
 		}
-		defer cli.Close()
 
-		// Get a free port for the new branch
-		freePort, err := getFreePort()
+		// Rotate atomically: revoke old token(s), then store new token.
+		tokenHash := db.HashToken(jwtToken)
+		tx, err := db.DB.Begin()
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to get free port: " + err.Error()})
+			c.JSON(500, gin.H{"error": "Failed to start token rotation"})
 			return
 		}
+		defer tx.Rollback()
 
-		// Generate unique container name
-		randBytes := make([]byte, 8)
-		rand.Read(randBytes)
-		containerName := fmt.Sprintf("baseful-%s-%s-%s", dbName, req.Name, hex.EncodeToString(randBytes))
-
-		// Create new container for the branch
-		imageName := fmt.Sprintf("postgres:%s", dbVersion)
-		if dbVersion == "" {
-			imageName = "postgres:latest"
+		if _, err := tx.Exec("UPDATE database_tokens SET revoked = 1 WHERE database_id = ? AND revoked = 0 AND COALESCE(target, '') = '' AND COALESCE(role, '') = ''", databaseID); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to revoke old token"})
+			return
 		}
-
-		resp, err := cli.ContainerCreate(ctx, &container.Config{
-			Image:    imageName,
-			Hostname: req.Name,
-			Env: []string{
-				"POSTGRES_PASSWORD=" + dbPassword,
-				"POSTGRES_DB=" + dbName,
-			},
-			ExposedPorts: nat.PortSet{
-				"5432/tcp": struct{}{},
-			},
-			Labels: map[string]string{
-				"managed-by":       "baseful",
-				"baseful.branch":   req.Name,
-				"baseful.database": dbName,
-			},
-		}, &container.HostConfig{
-			NetworkMode: docker.NetworkName,
-			PortBindings: nat.PortMap{
-				"5432/tcp": []nat.PortBinding{
-					{HostIP: "0.0.0.0", HostPort: strconv.Itoa(freePort)},
-				},
-			},
-			SecurityOpt: []string{
-				"no-new-privileges:true",
-			},
-			CapDrop: []string{"ALL"},
-			CapAdd:  []string{"CHOWN", "SETGID", "SETUID", "DAC_OVERRIDE"},
-			RestartPolicy: container.RestartPolicy{
-				Name: "unless-stopped",
-			},
-		}, nil, nil, containerName)
-
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to create container: " + err.Error()})
+		insertQuery := "INSERT INTO database_tokens (database_id, token_id, token_hash, expires_at) VALUES (?, ?, ?, ?)"
+		insertArgs := []interface{}{databaseID, tokenID, tokenHash, expiresAt}
+		if db.DatabaseTokensHasIssuedAt() {
+			insertQuery = "INSERT INTO database_tokens (database_id, token_id, token_hash, issued_at, expires_at) VALUES (?, ?, ?, ?, ?)"
+			insertArgs = []interface{}{databaseID, tokenID, tokenHash, issuedAt, expiresAt}
+		}
+		if _, err := tx.Exec(insertQuery, insertArgs...); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to store token"})
 			return
 		}
-
-		// Start the container
-		if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-			_ = cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
-			c.JSON(500, gin.H{"error": "Failed to start container: " + err.Error()})
+		if err := tx.Commit(); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to finalize token rotation"})
 			return
 		}
 
-		// Wait for PostgreSQL to be ready
-		time.Sleep(3 * time.Second)
+		// Generate connection string
+		proxyHost := auth.GetProxyHost()
+		proxyPort := auth.GetProxyPort()
+		portInt, _ := strconv.Atoi(proxyPort)
+		connectionString := auth.GenerateConnectionString(jwtToken, databaseID, proxyHost, portInt, "require")
 
-		// Copy data from source database if it's not the first branch
-		var sourceBranchCount int
-		db.DB.QueryRow("SELECT COUNT(*) FROM branches WHERE database_id = ?", id).Scan(&sourceBranchCount)
+		c.JSON(200, gin.H{
+			"message":           "Token rotated successfully",
+			"token_id":          tokenID,
+			"connection_string": connectionString,
+			"expires_at":        expiresAt,
+		})
+	})
 
-		if sourceBranchCount > 0 {
-			// Get the default branch container
-			var sourceContainerID string
-			err = db.DB.QueryRow("SELECT container_id FROM branches WHERE database_id = ? AND is_default = 1", id).Scan(&sourceContainerID)
-			if err == nil {
-				// Use pg_dump to copy data
-				dumpCmd := []string{"pg_dump", "-U", "postgres", "-d", dbName, "--no-owner", "--no-acl"}
-				dumpExec, err := cli.ContainerExecCreate(ctx, sourceContainerID, container.ExecOptions{
-					Cmd:          dumpCmd,
-					AttachStdout: true,
-					AttachStderr: true,
-				})
-				if err == nil {
-					dumpAttach, err := cli.ContainerExecAttach(ctx, dumpExec.ID, container.ExecAttachOptions{})
-					if err == nil {
-						defer dumpAttach.Close()
+	// Revoke a specific token
+	r.DELETE("/api/databases/:id/tokens/:token_id", func(c *gin.Context) {
+		tokenID := c.Param("token_id")
+		audit.Annotate(c, "token.revoke", "database", c.Param("id"), map[string]any{"tokenId": tokenID})
 
-						// Create exec to restore in new container
-						restoreCmd := []string{"psql", "-U", "postgres", "-d", dbName}
-						restoreExec, err := cli.ContainerExecCreate(ctx, resp.ID, container.ExecOptions{
-							Cmd:          restoreCmd,
-							AttachStdin:  true,
-							AttachStdout: true,
-							AttachStderr: true,
-						})
-						if err == nil {
-							restoreAttach, err := cli.ContainerExecAttach(ctx, restoreExec.ID, container.ExecAttachOptions{})
-							if err == nil {
-								defer restoreAttach.Close()
-								io.Copy(restoreAttach.Conn, dumpAttach.Reader)
-							}
-						}
-					}
-				}
-			}
+		if err := db.RevokeToken(tokenID); err != nil {
+			c.JSON(404, gin.H{"error": "Token not found"})
+			return
 		}
 
-		// Store branch in database
-		result, err := db.DB.Exec(
-			"INSERT INTO branches (database_id, name, container_id, port, status, is_default) VALUES (?, ?, ?, ?, ?, ?)",
-			id, req.Name, resp.ID, freePort, "running", 0,
-		)
+		c.JSON(200, gin.H{"message": "Token revoked successfully"})
+	})
 
+	// Bind a token to a Postgres role, or back to postgres with an empty role.
+	// Its next connections log in as that role.
+	r.PUT("/api/databases/:id/tokens/:token_id/role", func(c *gin.Context) {
+		dbID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
-			_ = cli.ContainerStop(ctx, resp.ID, container.StopOptions{})
-			_ = cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
-			c.JSON(500, gin.H{"error": "Failed to save branch: " + err.Error()})
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
+		}
+		var req struct {
+			Role string `json:"role"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
 			return
 		}
+		if req.Role != "" {
+			if _, err := db.GetRolePassword(dbID, req.Role); err != nil {
+				c.JSON(400, gin.H{"error": "Set the role's password through the API before binding tokens to it"})
+				return
+			}
+		}
+		audit.Annotate(c, "token.bind_role", "database", c.Param("id"), map[string]any{
+			"tokenId": c.Param("token_id"),
+			"role":    req.Role,
+		})
 
-		branchID, _ := result.LastInsertId()
+		if err := db.SetTokenRole(dbID, c.Param("token_id"), req.Role); err == sql.ErrNoRows {
+			c.JSON(404, gin.H{"error": "Token not found"})
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to bind token"})
+			return
+		}
 
-		c.JSON(200, gin.H{
-			"message":      "Branch created successfully",
-			"id":           branchID,
-			"name":         req.Name,
-			"container_id": resp.ID,
-			"port":         freePort,
-			"status":       "running",
-		})
+		c.JSON(200, gin.H{"message": "Token bound to role", "role": req.Role})
 	})
 
-	// Branch control endpoints
-	r.POST("/api/databases/:id/branches/:branchId/:action", func(c *gin.Context) {
+	// Get actual connection string (with warning - only shown once)
+	r.GET("/api/databases/:id/connection-string", func(c *gin.Context) {
 		id := c.Param("id")
-		branchID := c.Param("branchId")
-		action := c.Param("action")
-
-		var containerID, status string
-		err := db.DB.QueryRow("SELECT container_id, status FROM branches WHERE id = ? AND database_id = ?", branchID, id).Scan(&containerID, &status)
+		audit.Annotate(c, "token.reveal", "database", id, nil)
+		dbID, err := strconv.Atoi(id)
 		if err != nil {
-			c.JSON(404, gin.H{"error": "Branch not found"})
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
 			return
 		}
 
-		ctx := context.Background()
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to connect to Docker"})
+		// TLS is mandatory for proxy connections.
+		sslMode := "require"
+
+		// Get existing active token
+		tokenRecord, err := db.GetActiveTokenForDatabase(dbID)
+		if err != nil || tokenRecord == nil {
+			// Generate new token if none exists
+			tokenID, _ := auth.GenerateTokenID()
+			issuedAt := time.Now().UTC()
+			expiresAt := issuedAt.AddDate(2, 0, 0)
+			jwtToken, _ := auth.GenerateJWTWithTimestamps(dbID, 0, tokenID, issuedAt, expiresAt)
+			tokenHash := db.HashToken(jwtToken)
+			db.CreateToken(dbID, tokenID, tokenHash, issuedAt, expiresAt)
+
+			proxyHost := auth.GetProxyHost()
+			if proxyHost == "localhost" || proxyHost == "0.0.0.0" {
+				if publicIP, err := system.GetPublicIP(); err == nil {
+					proxyHost = publicIP
+				}
+			}
+			proxyPort := auth.GetProxyPort()
+			portInt, _ := strconv.Atoi(proxyPort)
+			connectionString := auth.GenerateConnectionString(jwtToken, dbID, proxyHost, portInt, sslMode)
+
+			c.JSON(200, gin.H{
+				"message":           "Database started",
+				"connection_string": connectionString,
+				"expires_at":        expiresAt,
+				"warning":           "Copy this connection string now. You will not be able to see it again. Store it securely.",
+				"ssl_enabled":       true,
+			})
 			return
 		}
-		defer cli.Close()
 
-		switch action {
-		case "start":
-			if err := cli.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
-				c.JSON(500, gin.H{"error": "Failed to start container: " + err.Error()})
-				return
-			}
-			db.DB.Exec("UPDATE branches SET status = 'running' WHERE id = ?", branchID)
-		case "stop":
-			if err := cli.ContainerStop(ctx, containerID, container.StopOptions{}); err != nil {
-				c.JSON(500, gin.H{"error": "Failed to stop container: " + err.Error()})
+		// Generate deterministic JWT for existing token record.
+		jwtToken, err := auth.GenerateJWTWithTimestamps(dbID, 0, tokenRecord.TokenID, tokenRecord.IssuedAt, tokenRecord.ExpiresAt)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to generate connection string"})
+			return
+		}
+		if db.HashToken(jwtToken) != tokenRecord.TokenHash {
+			// Legacy token record without reproducible JWT claims; mint a new stable token.
+			tokenID, _ := auth.GenerateTokenID()
+			issuedAt := time.Now().UTC()
+			expiresAt := issuedAt.AddDate(2, 0, 0)
+			jwtToken, _ = auth.GenerateJWTWithTimestamps(dbID, 0, tokenID, issuedAt, expiresAt)
+			tokenHash := db.HashToken(jwtToken)
+			if _, err := db.CreateToken(dbID, tokenID, tokenHash, issuedAt, expiresAt); err != nil {
+				c.JSON(500, gin.H{"error": "Failed to migrate token format"})
 				return
 			}
-			db.DB.Exec("UPDATE branches SET status = 'stopped' WHERE id = ?", branchID)
-		case "delete":
-			// Stop and remove container
-			_ = cli.ContainerStop(ctx, containerID, container.StopOptions{})
-			_ = cli.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true})
-			// Delete from DB
-			_, err = db.DB.Exec("DELETE FROM branches WHERE id = ?", branchID)
-			if err != nil {
-				c.JSON(500, gin.H{"error": "Failed to delete branch"})
-				return
+			tokenRecord.ExpiresAt = expiresAt
+		}
+
+		proxyHost := auth.GetProxyHost()
+		if proxyHost == "localhost" || proxyHost == "0.0.0.0" {
+			if publicIP, err := system.GetPublicIP(); err == nil {
+				proxyHost = publicIP
 			}
-			c.JSON(200, gin.H{"message": "Branch deleted"})
-			return
-		case "switch":
-			// Set this branch as default, unset others
-			db.DB.Exec("UPDATE branches SET is_default = 0 WHERE database_id = ?", id)
-			db.DB.Exec("UPDATE branches SET is_default = 1 WHERE id = ?", branchID)
-			c.JSON(200, gin.H{"message": "Switched to branch"})
-			return
-		default:
-			c.JSON(400, gin.H{"error": "Invalid action"})
-			return
 		}
+		proxyPort := auth.GetProxyPort()
+		portInt, _ := strconv.Atoi(proxyPort)
+		connectionString := auth.GenerateConnectionString(jwtToken, dbID, proxyHost, portInt, sslMode)
 
-		c.JSON(200, gin.H{"message": fmt.Sprintf("Branch %sed", action)})
+		c.JSON(200, gin.H{
+			"connection_string": connectionString,
+			"expires_at":        tokenRecord.ExpiresAt,
+			"warning":           "Copy this connection string now. You will not be able to see it again. Store it securely.",
+		})
 	})
 
-	// SQL Assistant Endpoint
-	r.POST("/api/databases/:id/sql-assistant", func(c *gin.Context) {
-		id := c.Param("id")
-		userID := c.MustGet("user_id").(int)
+	// ========== READ REPLICAS ==========
 
-		apiKey, err := db.GetUserOpenRouterAPIKey(userID)
+	// List the read replicas of a database with their replication lag
+	r.GET("/api/databases/:id/replicas", func(c *gin.Context) {
+		dbID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to read OpenRouter API key"})
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
 			return
 		}
-		if strings.TrimSpace(apiKey) == "" {
-			c.JSON(400, gin.H{"error": "OpenRouter API key not configured. Add it in Profile settings."})
+
+		replicas, err := db.ListReplicas(dbID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to get replicas"})
 			return
 		}
-
-		var dbID, port int
-		var name, dbType, host, status, version, password string
-		err = db.DB.QueryRow(
-			"SELECT id, name, type, host, port, status, version, password FROM databases WHERE id = ?",
-			id,
-		).Scan(&dbID, &name, &dbType, &host, &port, &status, &version, &password)
+		healthy, err := db.HealthyReplicas(dbID)
 		if err != nil {
-			c.JSON(404, gin.H{"error": "Database not found"})
+			c.JSON(500, gin.H{"error": "Failed to get replicas"})
 			return
 		}
-		if status != "active" {
-			c.JSON(400, gin.H{"error": "Database is not running"})
+
+		c.JSON(200, gin.H{
+			"replicas":      replicas,
+			"healthy":       len(healthy),
+			"maxLagBytes":   db.MaxReplicaLagBytes,
+			"routingTarget": "target_session_attrs=read-only",
+		})
+	})
+
+	// Add a read replica streaming from the database
+	r.POST("/api/databases/:id/replicas", func(c *gin.Context) {
+		id := c.Param("id")
+		dbID, err := strconv.Atoi(id)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
 			return
 		}
 
 		var req struct {
-			Prompt       string `json:"prompt"`
-			CurrentQuery string `json:"currentQuery"`
+			Name string `json:"name"`
 		}
 		if err := c.BindJSON(&req); err != nil {
 			c.JSON(400, gin.H{"error": "Invalid request"})
 			return
 		}
-		if strings.TrimSpace(req.Prompt) == "" {
-			c.JSON(400, gin.H{"error": "Prompt cannot be empty"})
+		if !validReplicaName(req.Name) {
+			c.JSON(400, gin.H{"error": "Replica name must be 1-32 lowercase letters, digits or dashes"})
 			return
 		}
+		audit.Annotate(c, "replica.create", "database", id, map[string]any{"name": req.Name})
 
-		ctx := context.Background()
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to connect to Docker"})
+		var count int
+		if err := db.DB.QueryRow("SELECT COUNT(*) FROM replicas WHERE database_id = ? AND name = ?", dbID, req.Name).Scan(&count); err != nil || count > 0 {
+			c.JSON(400, gin.H{"error": "Replica with this name already exists"})
 			return
 		}
-		defer cli.Close()
 
-		var containerID string
-		err = db.DB.QueryRow("SELECT container_id FROM databases WHERE id = ?", id).Scan(&containerID)
-		if err != nil {
-			c.JSON(404, gin.H{"error": "Container not found"})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		replica, err := replication.Create(ctx, dbID, req.Name, db.ReplicaRoleRead)
+		if errors.Is(err, replication.ErrPrimaryNotRunning) {
+			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
-
-		schemaSummary, err := fetchSchemaSummary(ctx, cli, containerID, name)
-		if err != nil {
-			schemaSummary = "Schema summary unavailable."
-		}
-
-		systemPrompt := strings.Join([]string{
-			"You are a PostgreSQL SQL generator.",
-			"Return ONLY executable SQL and nothing else.",
-			"Do not use markdown fences.",
-			"Do not add explanations.",
-			"Use only PostgreSQL syntax.",
-			"If the request is ambiguous, return a safe SELECT query asking for clarification as a string column.",
-		}, " ")
-
-		userPrompt := fmt.Sprintf(
-			"Database name: %s\nSchema:\n%s\nCurrent editor SQL:\n%s\nUser request:\n%s\nOutput only SQL.",
-			name,
-			schemaSummary,
-			req.CurrentQuery,
-			req.Prompt,
-		)
-
-		sqlText, err := requestSQLFromOpenRouter(apiKey, systemPrompt, userPrompt)
 		if err != nil {
-			c.JSON(502, gin.H{"error": "Failed to generate SQL via OpenRouter: " + err.Error()})
+			c.JSON(500, gin.H{"error": "Failed to create replica", "details": err.Error()})
 			return
 		}
+		audit.AddDetail(c, "replicaId", replica.ID)
 
-		c.JSON(200, gin.H{
-			"sql": sqlText,
-		})
+		c.JSON(200, replica)
 	})
 
-	// SQL Query Endpoint
-	r.POST("/api/databases/:id/query", func(c *gin.Context) {
+	// Remove a read replica and its replication slot
+	r.DELETE("/api/databases/:id/replicas/:replicaId", func(c *gin.Context) {
 		id := c.Param("id")
-
-		var db_id, port int
-		var name, dbType, host, status, version, password string
-		err := db.DB.QueryRow(
-			"SELECT id, name, type, host, port, status, version, password FROM databases WHERE id = ?",
-			id,
-		).Scan(&db_id, &name, &dbType, &host, &port, &status, &version, &password)
-
+		dbID, err := strconv.Atoi(id)
 		if err != nil {
-			c.JSON(404, gin.H{"error": "Database not found"})
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
 			return
 		}
-
-		if status != "active" {
-			c.JSON(400, gin.H{"error": "Database is not running"})
+		replicaID, err := strconv.Atoi(c.Param("replicaId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid replica ID"})
 			return
 		}
+		audit.Annotate(c, "replica.delete", "database", id, map[string]any{"replicaId": replicaID})
 
-		var req struct {
-			Query string `json:"query"`
+		replica, err := db.GetReplica(dbID, replicaID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to get replica"})
+			return
 		}
-		if err := c.BindJSON(&req); err != nil {
-			c.JSON(400, gin.H{"error": "Invalid request"})
+		if replica == nil {
+			c.JSON(404, gin.H{"error": "Replica not found"})
 			return
 		}
+		if replica.Role == db.ReplicaRoleStandby {
+			if ha, err := db.GetHAStatus(dbID); err == nil && ha.Enabled {
+				c.JSON(409, gin.H{"error": "Turn off high availability to remove the standby"})
+				return
+			}
+		}
 
-		if req.Query == "" {
-			c.JSON(400, gin.H{"error": "Query cannot be empty"})
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		if err := replication.Remove(ctx, replica); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to remove replica", "details": err.Error()})
 			return
 		}
 
-		ctx := context.Background()
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		c.JSON(200, gin.H{"message": "Replica removed"})
+	})
+
+	// Replication lag history of a replica
+	r.GET("/api/databases/:id/replicas/:replicaId/metrics", func(c *gin.Context) {
+		dbID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to connect to Docker"})
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
 			return
 		}
-		defer cli.Close()
-
-		// Get container ID from database record
-		var containerID string
-		err = db.DB.QueryRow("SELECT container_id FROM databases WHERE id = ?", id).Scan(&containerID)
+		replicaID, err := strconv.Atoi(c.Param("replicaId"))
 		if err != nil {
-			c.JSON(404, gin.H{"error": "Container not found"})
+			c.JSON(400, gin.H{"error": "Invalid replica ID"})
+			return
+		}
+		if replica, err := db.GetReplica(dbID, replicaID); err != nil || replica == nil {
+			c.JSON(404, gin.H{"error": "Replica not found"})
 			return
 		}
 
-		// Execute query using psql via docker exec
-		cmd := []string{"psql", "-U", "postgres", "-d", name, "-c", req.Query}
-		execResp, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
-			Cmd:          cmd,
-			AttachStdout: true,
-			AttachStderr: true,
-		})
+		history, err := metrics.GetReplicaHistory(replicaID)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to create exec: " + err.Error()})
+			c.JSON(500, gin.H{"error": "Failed to get replica metrics"})
 			return
 		}
 
-		// Attach to exec output
-		attachResp, err := cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{})
+		c.JSON(200, history)
+	})
+
+	// Issue a connection string whose sessions are always routed to a healthy
+	// read replica (only shown once)
+	r.POST("/api/databases/:id/replicas/connection-string", func(c *gin.Context) {
+		id := c.Param("id")
+		dbID, err := strconv.Atoi(id)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to attach to exec: " + err.Error()})
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
 			return
 		}
-		defer attachResp.Close()
 
-		// Read output using stdcopy to handle Docker multiplexed stream
-		var stdout, stderr bytes.Buffer
-		_, err = stdcopy.StdCopy(&stdout, &stderr, attachResp.Reader)
+		tokenID, err := auth.GenerateTokenID()
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to read output: " + err.Error()})
+			c.JSON(500, gin.H{"error": "Failed to generate token ID"})
 			return
 		}
+		audit.Annotate(c, "token.create_replica", "database", id, map[string]any{"tokenId": tokenID})
 
-		outputStr := stdout.String()
-		if stderr.Len() > 0 && outputStr == "" {
-			outputStr = stderr.String()
+		issuedAt := time.Now().UTC()
+		expiresAt := issuedAt.AddDate(2, 0, 0)
+		jwtToken, err := auth.GenerateReplicaJWT(dbID, tokenID, issuedAt, expiresAt)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to generate JWT token"})
+			return
+		}
+		if _, err := db.CreateReplicaToken(dbID, tokenID, db.HashToken(jwtToken), issuedAt, expiresAt); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to store token"})
+			return
 		}
 
-		// Check if it's a SELECT query (returns results) or an action query
-		isSelect := len(outputStr) > 0 && (outputStr[0] == '(' || outputStr[0] == '-' || len(outputStr) > 10)
+		proxyHost := auth.GetProxyHost()
+		if proxyHost == "localhost" || proxyHost == "0.0.0.0" {
+			if publicIP, err := system.GetPublicIP(); err == nil {
+				proxyHost = publicIP
+			}
+		}
+		portInt, _ := strconv.Atoi(auth.GetProxyPort())
+		connectionString := auth.GenerateConnectionString(jwtToken, dbID, proxyHost, portInt, "require")
 
 		c.JSON(200, gin.H{
-			"result":    outputStr,
-			"is_select": isSelect,
+			"token_id":          tokenID,
+			"connection_string": connectionString,
+			"expires_at":        expiresAt,
+			"warning":           "Copy this connection string now. You will not be able to see it again. Store it securely.",
 		})
 	})
 
-	// List Tables Endpoint
-	r.GET("/api/databases/:id/tables", func(c *gin.Context) {
-		id := c.Param("id")
-
-		var db_id, port int
-		var name, dbType, host, status, version, password string
-		err := db.DB.QueryRow(
-			"SELECT id, name, type, host, port, status, version, password FROM databases WHERE id = ?",
-			id,
-		).Scan(&db_id, &name, &dbType, &host, &port, &status, &version, &password)
+	// ========== HIGH AVAILABILITY ==========
 
+	// High-availability mode of a database and its standby
+	r.GET("/api/databases/:id/ha", func(c *gin.Context) {
+		dbID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
-			c.JSON(404, gin.H{"error": "Database not found"})
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
 			return
 		}
 
-		if status != "active" {
-			c.JSON(400, gin.H{"error": "Database is not running"})
+		status, err := db.GetHAStatus(dbID)
+		if err == sql.ErrNoRows {
+			c.JSON(404, gin.H{"error": "Database not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to get high availability status"})
 			return
 		}
 
-		ctx := context.Background()
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		c.JSON(200, status)
+	})
+
+	// Turn high-availability mode on or off. Turning it on creates a standby
+	// that is promoted when the primary fails; turning it off removes it.
+	r.PUT("/api/databases/:id/ha", func(c *gin.Context) {
+		id := c.Param("id")
+		dbID, err := strconv.Atoi(id)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to connect to Docker"})
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
 			return
 		}
-		defer cli.Close()
 
-		var containerID string
-		err = db.DB.QueryRow("SELECT container_id FROM databases WHERE id = ?", id).Scan(&containerID)
+		var req struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+		action := "database.ha_disable"
+		if req.Enabled {
+			action = "database.ha_enable"
+		}
+		audit.Annotate(c, action, "database", id, nil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		if req.Enabled {
+			_, err = failover.Enable(ctx, dbID)
+		} else {
+			err = failover.Disable(ctx, dbID)
+		}
+		switch {
+		case errors.Is(err, failover.ErrBusy):
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, replication.ErrPrimaryNotRunning):
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.JSON(500, gin.H{"error": "Failed to change high availability mode", "details": err.Error()})
+			return
+		}
+
+		status, err := db.GetHAStatus(dbID)
 		if err != nil {
-			c.JSON(404, gin.H{"error": "Container not found"})
+			c.JSON(500, gin.H{"error": "Failed to get high availability status"})
 			return
 		}
+		c.JSON(200, status)
+	})
 
-		// Get list of tables
-		cmd := []string{"psql", "-U", "postgres", "-d", name, "-t", "-c",
-			"SELECT table_name FROM information_schema.tables WHERE table_schema = 'public' ORDER BY table_name"}
-		execResp, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
-			Cmd:          cmd,
-			AttachStdout: true,
-			AttachStderr: true,
-		})
+	// Promote the standby right away, e.g. for a planned switchover. The
+	// current primary is stopped.
+	r.POST("/api/databases/:id/ha/failover", func(c *gin.Context) {
+		id := c.Param("id")
+		dbID, err := strconv.Atoi(id)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to create exec"})
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
 			return
 		}
+		audit.Annotate(c, "database.failover_manual", "database", id, nil)
 
-		attachResp, err := cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{})
+		status, err := db.GetHAStatus(dbID)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to attach to exec"})
+			c.JSON(404, gin.H{"error": "Database not found"})
+			return
+		}
+		if !status.Enabled {
+			c.JSON(400, gin.H{"error": "High availability is not enabled for this database"})
 			return
 		}
-		defer attachResp.Close()
 
-		var stdout, stderr bytes.Buffer
-		_, err = stdcopy.StdCopy(&stdout, &stderr, attachResp.Reader)
-		outputStr := stdout.String()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		err = failover.Failover(ctx, dbID, "a manual failover was requested by "+c.GetString("email"))
+		switch {
+		case errors.Is(err, failover.ErrBusy):
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, failover.ErrNoStandby), errors.Is(err, failover.ErrStandbyNotReady):
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.JSON(500, gin.H{"error": "Failover failed", "details": err.Error()})
+			return
+		}
 
-		// Parse table names
-		tables := []map[string]interface{}{}
-		for _, line := range strings.Split(outputStr, "\n") {
-			tableName := strings.TrimSpace(line)
-			if tableName != "" {
-				// Get row count
-				countCmd := []string{"psql", "-U", "postgres", "-d", name, "-t", "-c",
-					fmt.Sprintf("SELECT COUNT(*) FROM \"%s\"", tableName)}
-				countExec, _ := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
-					Cmd:          countCmd,
-					AttachStdout: true,
-					AttachStderr: true,
-				})
-				countAttach, _ := cli.ContainerExecAttach(ctx, countExec.ID, container.ExecAttachOptions{})
+		c.JSON(200, gin.H{"message": "Standby promoted to primary"})
+	})
 
-				var countStdout, countStderr bytes.Buffer
-				_, _ = stdcopy.StdCopy(&countStdout, &countStderr, countAttach.Reader)
-				countAttach.Close()
+	// Recent events of a database, such as failovers, newest first
+	r.GET("/api/databases/:id/events", func(c *gin.Context) {
+		dbID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if err != nil || limit < 1 || limit > 500 {
+			c.JSON(400, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
 
-				countStr := strings.TrimSpace(countStdout.String())
-				tables = append(tables, map[string]interface{}{
-					"name":      tableName,
-					"row_count": countStr,
-				})
-			}
+		events, err := db.ListEvents(dbID, limit)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to get events"})
+			return
 		}
 
-		c.JSON(200, tables)
+		c.JSON(200, events)
 	})
 
-	// Get Table Data Endpoint
-	r.GET("/api/databases/:id/tables/:tableName", func(c *gin.Context) {
-		id := c.Param("id")
-		tableName := c.Param("tableName")
-
-		var db_id, port int
-		var name, dbType, host, status, version, password string
-		err := db.DB.QueryRow(
-			"SELECT id, name, type, host, port, status, version, password FROM databases WHERE id = ?",
-			id,
-		).Scan(&db_id, &name, &dbType, &host, &port, &status, &version, &password)
-
+	// ========== DATABASE LOGS ==========
+
+	// Postgres server logs of a database's container. Filters: since, until,
+	// tail (default 500), level (minimum severity) and follow=true for a
+	// server-sent event stream.
+	r.GET("/api/databases/:id/logs", func(c *gin.Context) {
+		var containerID string
+		var serverID int
+		err := db.DB.QueryRow("SELECT COALESCE(container_id, ''), COALESCE(server_id, 0) FROM databases WHERE id = ?",
+			c.Param("id")).Scan(&containerID, &serverID)
 		if err != nil {
 			c.JSON(404, gin.H{"error": "Database not found"})
 			return
 		}
+		streamContainerLogs(c, serverID, containerID)
+	})
 
-		if status != "active" {
-			c.JSON(400, gin.H{"error": "Database is not running"})
+	// Postgres server logs of a branch's container, with the same filters
+	r.GET("/api/databases/:id/branches/:branchId/logs", func(c *gin.Context) {
+		var containerID string
+		var serverID int
+		err := db.DB.QueryRow(`
+			SELECT COALESCE(b.container_id, ''), COALESCE(d.server_id, 0)
+			FROM branches b JOIN databases d ON d.id = b.database_id
+			WHERE b.id = ? AND b.database_id = ?`,
+			c.Param("branchId"), c.Param("id")).Scan(&containerID, &serverID)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Branch not found"})
 			return
 		}
+		streamContainerLogs(c, serverID, containerID)
+	})
 
-		ctx := context.Background()
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to connect to Docker"})
+	// Get the settings that decide which statements are logged
+	r.GET("/api/databases/:id/logs/settings", func(c *gin.Context) {
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
 			return
 		}
-		defer cli.Close()
+		defer conn.Close(context.Background())
 
-		var containerID string
-		err = db.DB.QueryRow("SELECT container_id FROM databases WHERE id = ?", id).Scan(&containerID)
+		settings, err := pg.GetLogSettings(c.Request.Context(), conn)
 		if err != nil {
-			c.JSON(404, gin.H{"error": "Container not found"})
+			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
+		c.JSON(200, settings)
+	})
 
-		// Get table schema (columns)
-		schemaCmd := []string{"psql", "-U", "postgres", "-d", name, "-t", "-A", "-F", "|", "-c",
-			fmt.Sprintf("SELECT column_name, data_type, is_nullable FROM information_schema.columns WHERE table_name = '%s' ORDER BY ordinal_position", tableName)}
-		schemaExec, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
-			Cmd:          schemaCmd,
-			AttachStdout: true,
-			AttachStderr: true,
-		})
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to create exec"})
+	// Log statements slower than logMinDurationStatement milliseconds
+	// (-1 disables, 0 logs every statement)
+	r.PUT("/api/databases/:id/logs/settings", func(c *gin.Context) {
+		var req struct {
+			LogMinDurationStatement *int `json:"logMinDurationStatement"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.LogMinDurationStatement == nil {
+			c.JSON(400, gin.H{"error": "logMinDurationStatement is required"})
 			return
 		}
-		schemaAttach, err := cli.ContainerExecAttach(ctx, schemaExec.ID, container.ExecAttachOptions{})
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to attach to exec"})
+		if *req.LogMinDurationStatement < -1 {
+			c.JSON(400, gin.H{"error": "logMinDurationStatement must be -1 or more"})
 			return
 		}
-		var schemaStdout, schemaStderr bytes.Buffer
-		_, _ = stdcopy.StdCopy(&schemaStdout, &schemaStderr, schemaAttach.Reader)
-		schemaAttach.Close()
+		audit.Annotate(c, "database.log_settings", "database", c.Param("id"), map[string]any{
+			"logMinDurationStatement": *req.LogMinDurationStatement,
+		})
 
-		// Parse columns
-		columns := []map[string]interface{}{}
-		for _, line := range strings.Split(schemaStdout.String(), "\n") {
-			parts := strings.Split(strings.TrimSpace(line), "|")
-			if len(parts) >= 3 {
-				colName := strings.TrimSpace(parts[0])
-				if colName != "" {
-					columns = append(columns, map[string]interface{}{
-						"name":     colName,
-						"type":     strings.TrimSpace(parts[1]),
-						"nullable": strings.TrimSpace(parts[2]),
-					})
-				}
-			}
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
 		}
+		defer conn.Close(context.Background())
 
-		// Get foreign key relations for this table
-		relationsCmd := []string{"psql", "-U", "postgres", "-d", name, "-t", "-A", "-F", "|", "-c",
-			fmt.Sprintf(`
-				SELECT
-					kcu.column_name,
-					ccu.table_name AS foreign_table_name,
-					ccu.column_name AS foreign_column_name
-				FROM information_schema.table_constraints tc
-				JOIN information_schema.key_column_usage kcu
-					ON tc.constraint_name = kcu.constraint_name
-					AND tc.table_schema = kcu.table_schema
-				JOIN information_schema.constraint_column_usage ccu
-					ON ccu.constraint_name = tc.constraint_name
-					AND ccu.table_schema = tc.table_schema
-				WHERE tc.constraint_type = 'FOREIGN KEY'
-					AND tc.table_schema = 'public'
-					AND tc.table_name = '%s'
-				ORDER BY kcu.ordinal_position
-			`, tableName)}
-		relationsExec, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
-			Cmd:          relationsCmd,
-			AttachStdout: true,
-			AttachStderr: true,
-		})
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to create exec"})
+		if err := pg.SetLogMinDurationStatement(c.Request.Context(), conn, *req.LogMinDurationStatement); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		relationsAttach, err := cli.ContainerExecAttach(ctx, relationsExec.ID, container.ExecAttachOptions{})
+		settings, err := pg.GetLogSettings(c.Request.Context(), conn)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to attach to exec"})
+			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		var relationsStdout, relationsStderr bytes.Buffer
-		_, _ = stdcopy.StdCopy(&relationsStdout, &relationsStderr, relationsAttach.Reader)
-		relationsAttach.Close()
+		c.JSON(200, settings)
+	})
 
-		relations := []map[string]interface{}{}
-		for _, line := range strings.Split(relationsStdout.String(), "\n") {
-			parts := strings.Split(strings.TrimSpace(line), "|")
-			if len(parts) >= 3 {
-				sourceColumn := strings.TrimSpace(parts[0])
-				referencedTable := strings.TrimSpace(parts[1])
-				referencedColumn := strings.TrimSpace(parts[2])
-				if sourceColumn != "" && referencedTable != "" && referencedColumn != "" {
-					relations = append(relations, map[string]interface{}{
-						"sourceColumn":     sourceColumn,
-						"referencedTable":  referencedTable,
-						"referencedColumn": referencedColumn,
-					})
-				}
-			}
-		}
+	// ========== POSTGRES CONFIGURATION ==========
 
-		// Get pagination parameters
-		offset := c.DefaultQuery("offset", "0")
-		limit := c.DefaultQuery("limit", "100")
-		sortBy := c.DefaultQuery("sortBy", "id")
-		sortDir := c.DefaultQuery("sortOrder", "asc")
+	// Postgres settings of a database from pg_settings. Filters: names
+	// (comma-separated), search (in names and descriptions) and changed=true
+	// for settings the server configuration sets.
+	r.GET("/api/databases/:id/config", func(c *gin.Context) {
+		var names []string
+		if c.Query("names") != "" {
+			names = strings.Split(c.Query("names"), ",")
+		}
+		search := strings.ToLower(c.Query("search"))
+		changedOnly := c.Query("changed") == "true"
 
-		// Validate sortDir
-		if sortDir != "asc" && sortDir != "desc" {
-			sortDir = "asc"
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
 		}
+		defer conn.Close(context.Background())
 
-		// Validate sortBy against columns
-		validSortBy := "id"
-		for _, col := range columns {
-			if col["name"] == sortBy {
-				validSortBy = sortBy
-				break
+		all, err := pg.ListSettings(c.Request.Context(), conn, names)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		settings := []pg.Setting{}
+		pendingRestart := []string{}
+		for _, s := range all {
+			if s.PendingRestart {
+				pendingRestart = append(pendingRestart, s.Name)
+			}
+			if search != "" && !strings.Contains(s.Name, search) && !strings.Contains(strings.ToLower(s.Description), search) {
+				continue
+			}
+			// Our own session sets a few settings, like application_name
+			if changedOnly && (s.Source == "default" || s.Source == "override" || s.Source == "client") {
+				continue
 			}
+			settings = append(settings, s)
 		}
 
-		// Get total count
-		filterCol := c.Query("filterCol")
-		filterOp := c.Query("filterOp")
-		filterVal := c.Query("filterVal")
+		c.JSON(200, gin.H{"settings": settings, "pendingRestart": pendingRestart})
+	})
 
-		whereClause := ""
-		if filterCol != "" && filterOp != "" && filterVal != "" {
-			if filterOp == "equals" {
-				whereClause = fmt.Sprintf("WHERE \"%s\"::text = '%s'", filterCol, filterVal)
-			} else if filterOp == "contains" {
-				whereClause = fmt.Sprintf("WHERE \"%s\"::text ILIKE '%%%s%%'", filterCol, filterVal)
+	// Change Postgres settings with ALTER SYSTEM and reload the configuration.
+	// The body maps setting names to values, or to null to reset them to the
+	// default. Settings with restart context take effect after a restart.
+	r.PUT("/api/databases/:id/config", func(c *gin.Context) {
+		dbID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
+		}
+		var req struct {
+			Settings map[string]any `json:"settings"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Settings) == 0 {
+			c.JSON(400, gin.H{"error": "settings is required"})
+			return
+		}
+		values := make(map[string]*string, len(req.Settings))
+		for name, v := range req.Settings {
+			value, err := configValue(v)
+			if err != nil {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid value for %s: %v", name, err)})
+				return
 			}
+			values[strings.ToLower(strings.TrimSpace(name))] = value
 		}
+		audit.Annotate(c, "database.config", "database", c.Param("id"), map[string]any{"settings": req.Settings})
 
-		countCmd := []string{"psql", "-U", "postgres", "-d", name, "-t", "-c",
-			fmt.Sprintf("SELECT COUNT(*) FROM \"%s\" %s", tableName, whereClause)}
-		countExec, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
-			Cmd:          countCmd,
-			AttachStdout: true,
-			AttachStderr: true,
-		})
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to create exec"})
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
 			return
 		}
-		countAttach, err := cli.ContainerExecAttach(ctx, countExec.ID, container.ExecAttachOptions{})
+		defer conn.Close(context.Background())
+
+		changes, err := pg.ApplyConfig(c.Request.Context(), conn, values)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to attach to exec"})
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
-		var countStdout, countStderr bytes.Buffer
-		_, _ = stdcopy.StdCopy(&countStdout, &countStderr, countAttach.Reader)
-		countAttach.Close()
-		totalCount := strings.TrimSpace(countStdout.String())
 
-		// Get table data with pagination and sorting
-		dataCmd := []string{"psql", "-U", "postgres", "-d", name, "-t", "-A", "-F", "|", "-c",
-			fmt.Sprintf("SELECT * FROM \"%s\" %s ORDER BY \"%s\" %s LIMIT %s OFFSET %s", tableName, whereClause, validSortBy, sortDir, limit, offset)}
-		dataExec, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
-			Cmd:          dataCmd,
-			AttachStdout: true,
-			AttachStderr: true,
-		})
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to create exec"})
-			return
+		history := make([]db.ConfigChange, len(changes))
+		restartRequired := false
+		for i, change := range changes {
+			history[i] = db.ConfigChange{Name: change.Name, OldValue: change.OldValue, NewValue: change.NewValue}
+			restartRequired = restartRequired || change.RequiresRestart
+		}
+		if err := db.RecordConfigChanges(dbID, history, c.GetString("email")); err != nil {
+			log.Printf("Failed to record config changes of database %d: %v", dbID, err)
+		}
+
+		response := gin.H{"changes": changes, "restartRequired": restartRequired}
+		if err := replication.ApplyConfig(c.Request.Context(), dbID, changes); err != nil {
+			response["replicaError"] = err.Error()
 		}
-		dataAttach, err := cli.ContainerExecAttach(ctx, dataExec.ID, container.ExecAttachOptions{})
+		c.JSON(200, response)
+	})
+
+	// Suggested settings for the database's CPU and memory limits, with the
+	// values in use when the database is running
+	r.GET("/api/databases/:id/config/recommendations", func(c *gin.Context) {
+		var maxCPU float64
+		var maxRAMMB int
+		var status string
+		err := db.DB.QueryRow("SELECT max_cpu, max_ram_mb, status FROM databases WHERE id = ?",
+			c.Param("id")).Scan(&maxCPU, &maxRAMMB, &status)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to attach to exec"})
+			c.JSON(404, gin.H{"error": "Database not found"})
 			return
 		}
-		var dataStdout, dataStderr bytes.Buffer
-		_, err = stdcopy.StdCopy(&dataStdout, &dataStderr, dataAttach.Reader)
-		dataAttach.Close()
 
-		// Parse data rows
-		rows := []map[string]interface{}{}
-		dataLines := strings.Split(dataStdout.String(), "\n")
-		for _, line := range dataLines {
-			line = strings.TrimSpace(line)
-			if line == "" || strings.HasPrefix(line, "(") || strings.HasPrefix(line, "-") {
-				continue
+		recommendations := pg.RecommendConfig(maxRAMMB, maxCPU)
+		if status == "active" {
+			conn, ok := connectActiveDatabase(c)
+			if !ok {
+				return
 			}
-			// Simple parsing - split by pipe
-			values := strings.Split(line, "|")
-			if len(values) == len(columns) {
-				row := make(map[string]interface{})
-				for i, col := range columns {
-					val := strings.TrimSpace(values[i])
-					if val == "" || val == "NULL" {
-						row[col["name"].(string)] = nil
-					} else {
-						row[col["name"].(string)] = val
-					}
-				}
-				rows = append(rows, row)
+			defer conn.Close(context.Background())
+
+			names := make([]string, len(recommendations))
+			for i, rec := range recommendations {
+				names[i] = rec.Name
+			}
+			settings, err := pg.ListSettings(c.Request.Context(), conn, names)
+			if err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			current := make(map[string]string, len(settings))
+			for _, s := range settings {
+				current[s.Name] = s.Display
+			}
+			for i := range recommendations {
+				recommendations[i].Current = current[recommendations[i].Name]
 			}
 		}
 
-		c.JSON(200, gin.H{
-			"name":       tableName,
-			"columns":    columns,
-			"relations":  relations,
-			"rows":       rows,
-			"count":      len(rows),
-			"totalCount": totalCount,
-		})
+		c.JSON(200, gin.H{"maxCpu": maxCPU, "maxRamMb": maxRAMMB, "recommendations": recommendations})
 	})
 
-	// Update Table Row Endpoint
-	r.PUT("/api/databases/:id/tables/:tableName/rows", func(c *gin.Context) {
-		id := c.Param("id")
-		tableName := c.Param("tableName")
-
-		var db_id, port int
-		var name, dbType, host, status, version, password string
-		err := db.DB.QueryRow(
-			"SELECT id, name, type, host, port, status, version, password FROM databases WHERE id = ?",
-			id,
-		).Scan(&db_id, &name, &dbType, &host, &port, &status, &version, &password)
-
+	// History of settings changed through the config API, newest first,
+	// optionally of one setting (?name)
+	r.GET("/api/databases/:id/config/history", func(c *gin.Context) {
+		dbID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
-			c.JSON(404, gin.H{"error": "Database not found"})
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if err != nil || limit < 1 || limit > 500 {
+			c.JSON(400, gin.H{"error": "limit must be between 1 and 500"})
 			return
 		}
 
-		if status != "active" {
-			c.JSON(400, gin.H{"error": "Database is not running"})
+		changes, err := db.ListConfigChanges(dbID, c.Query("name"), limit)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to get config history"})
 			return
 		}
+		c.JSON(200, changes)
+	})
 
-		ctx := context.Background()
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	// ========== DATABASE ROLES ==========
+
+	// List Postgres roles. passwordStored marks roles proxy tokens can be
+	// bound to.
+	r.GET("/api/databases/:id/roles", func(c *gin.Context) {
+		dbID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to connect to Docker"})
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
+		}
+		stored, err := db.StoredRoles(dbID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to get stored roles"})
 			return
 		}
-		defer cli.Close()
 
-		var containerID string
-		err = db.DB.QueryRow("SELECT container_id FROM databases WHERE id = ?", id).Scan(&containerID)
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
+		}
+		defer conn.Close(context.Background())
+
+		roles, err := pg.ListRoles(c.Request.Context(), conn)
 		if err != nil {
-			c.JSON(404, gin.H{"error": "Container not found"})
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
+		type roleResponse struct {
+			pg.Role
+			Reserved       bool `json:"reserved"`
+			PasswordStored bool `json:"passwordStored"`
+		}
+		response := make([]roleResponse, len(roles))
+		for i, role := range roles {
+			response[i] = roleResponse{Role: role, Reserved: reservedRole(role.Name), PasswordStored: stored[role.Name]}
+		}
+		c.JSON(200, response)
+	})
 
-		var req struct {
-			Updates []struct {
-				RowID      interface{} `json:"rowId"`
-				ColumnName string      `json:"columnName"`
-				Value      interface{} `json:"value"`
-			} `json:"updates"`
-			PrimaryKey string `json:"primaryKey"`
+	// Create a role. Roles that can log in get a generated password unless
+	// one is given; it is returned once and kept for proxy tokens.
+	r.POST("/api/databases/:id/roles", func(c *gin.Context) {
+		dbID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
 		}
-		if err := c.BindJSON(&req); err != nil {
+		opts := pg.RoleOptions{ConnectionLimit: -1}
+		if err := c.ShouldBindJSON(&opts); err != nil {
 			c.JSON(400, gin.H{"error": "Invalid request"})
 			return
 		}
-
-		fmt.Printf("Received request: primaryKey=%s, updates=%+v\n", req.PrimaryKey, req.Updates)
-
-		// Execute each update
-		for _, update := range req.Updates {
-			var query string
-			if update.Value == nil {
-				query = fmt.Sprintf("UPDATE \"%s\" SET \"%s\" = NULL WHERE \"%s\" = '%v'", tableName, update.ColumnName, req.PrimaryKey, update.RowID)
-			} else {
-				query = fmt.Sprintf("UPDATE \"%s\" SET \"%s\" = '%v' WHERE \"%s\" = '%v'", tableName, update.ColumnName, update.Value, req.PrimaryKey, update.RowID)
-			}
-
-			fmt.Printf("Executing query: %s\n", query)
-
-			cmd := []string{"psql", "-U", "postgres", "-d", name, "-c", query}
-			execResp, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
-				Cmd:          cmd,
-				AttachStdout: true,
-				AttachStderr: true,
-			})
-			if err != nil {
-				c.JSON(500, gin.H{"error": "Failed to create exec: " + err.Error()})
+		if reservedRole(opts.Name) {
+			c.JSON(400, gin.H{"error": "This role name is reserved"})
+			return
+		}
+		for _, name := range opts.InRoles {
+			if reservedRole(name) {
+				c.JSON(400, gin.H{"error": "Roles cannot be members of " + name})
 				return
 			}
-
-			attachResp, err := cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{})
-			if err != nil {
-				c.JSON(500, gin.H{"error": "Failed to attach to exec"})
+		}
+		generated := opts.Login && opts.Password == ""
+		if generated {
+			if opts.Password, err = generatePassword(24); err != nil {
+				c.JSON(500, gin.H{"error": "Failed to generate password"})
 				return
 			}
-			var stdout, stderr bytes.Buffer
-			_, err = stdcopy.StdCopy(&stdout, &stderr, attachResp.Reader)
-			attachResp.Close()
-			fmt.Printf("Query output: %s, stderr: %s\n", stdout.String(), stderr.String())
 		}
+		audit.Annotate(c, "database.role_create", "database", c.Param("id"), map[string]any{
+			"role":  opts.Name,
+			"login": opts.Login,
+		})
 
-		c.JSON(200, gin.H{"message": "Rows updated successfully"})
-	})
-
-	// ========== TOKEN MANAGEMENT API ==========
-
-	// Get tokens for a database
-	r.GET("/api/databases/:id/tokens", func(c *gin.Context) {
-		id := c.Param("id")
-		databaseID, err := strconv.Atoi(id)
-		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid database ID"})
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
 			return
 		}
+		defer conn.Close(context.Background())
 
-		tokens, err := db.GetTokensForDatabase(databaseID)
+		role, err := pg.CreateRole(c.Request.Context(), conn, opts)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to get tokens"})
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
+		if opts.Password != "" {
+			if err := db.SaveRolePassword(dbID, opts.Name, opts.Password); err != nil {
+				c.JSON(500, gin.H{"error": "Role created, but its password could not be stored"})
+				return
+			}
+		}
 
-		c.JSON(200, tokens)
+		response := gin.H{"role": role}
+		if generated {
+			response["password"] = opts.Password
+			response["warning"] = "Copy this password now. You will not be able to see it again."
+		}
+		c.JSON(201, response)
 	})
 
-	// Rotate token for a database (revokes old active tokens and issues a new one)
-	r.POST("/api/databases/:id/tokens/rotate", func(c *gin.Context) {
-		id := c.Param("id")
-		databaseID, err := strconv.Atoi(id)
+	// Drop a role. Objects it owns go to postgres and tokens bound to it are
+	// revoked.
+	r.DELETE("/api/databases/:id/roles/:role", func(c *gin.Context) {
+		dbID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
 			c.JSON(400, gin.H{"error": "Invalid database ID"})
 			return
 		}
+		name := c.Param("role")
+		if reservedRole(name) {
+			c.JSON(400, gin.H{"error": "This role is used by Baseful and cannot be dropped"})
+			return
+		}
+		audit.Annotate(c, "database.role_drop", "database", c.Param("id"), map[string]any{"role": name})
 
-		// Generate new token
-		tokenID, err := auth.GenerateTokenID()
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to generate token ID"})
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
 			return
 		}
+		defer conn.Close(context.Background())
 
-		issuedAt := time.Now().UTC()
-		expiresAt := issuedAt.AddDate(2, 0, 0)
-		jwtToken, err := auth.GenerateJWTWithTimestamps(databaseID, 0, tokenID, issuedAt, expiresAt)
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to generate JWT token"})
+		if err := pg.DropRole(c.Request.Context(), conn, name); err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if err := db.DeleteRole(dbID, name); err != nil {
+			c.JSON(500, gin.H{"error": "Role dropped, but its tokens could not be revoked"})
 			return
 		}
 
-		// Rotate atomically: revoke old token(s), then store new token.
-		tokenHash := db.HashToken(jwtToken)
-		tx, err := db.DB.Begin()
+		c.JSON(200, gin.H{"message": "Role dropped"})
+	})
+
+	// Set the password of a role, or generate one with {"generate": true},
+	// which is returned once. Tokens bound to the role use the new password.
+	r.PUT("/api/databases/:id/roles/:role/password", func(c *gin.Context) {
+		dbID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to start token rotation"})
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
 			return
 		}
-		defer tx.Rollback()
-
-		if _, err := tx.Exec("UPDATE database_tokens SET revoked = 1 WHERE database_id = ? AND revoked = 0", databaseID); err != nil {
-			c.JSON(500, gin.H{"error": "Failed to revoke old token"})
+		name := c.Param("role")
+		if reservedRole(name) {
+			c.JSON(400, gin.H{"error": "This role is used by Baseful and cannot be changed"})
 			return
 		}
-		insertQuery := "INSERT INTO database_tokens (database_id, token_id, token_hash, expires_at) VALUES (?, ?, ?, ?)"
-		insertArgs := []interface{}{databaseID, tokenID, tokenHash, expiresAt}
-		if db.DatabaseTokensHasIssuedAt() {
-			insertQuery = "INSERT INTO database_tokens (database_id, token_id, token_hash, issued_at, expires_at) VALUES (?, ?, ?, ?, ?)"
-			insertArgs = []interface{}{databaseID, tokenID, tokenHash, issuedAt, expiresAt}
+		var req struct {
+			Password string `json:"password"`
+			Generate bool   `json:"generate"`
 		}
-		if _, err := tx.Exec(insertQuery, insertArgs...); err != nil {
-			c.JSON(500, gin.H{"error": "Failed to store token"})
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
 			return
 		}
-		if err := tx.Commit(); err != nil {
-			c.JSON(500, gin.H{"error": "Failed to finalize token rotation"})
+		if (req.Password == "") == !req.Generate {
+			c.JSON(400, gin.H{"error": "Set either password or generate"})
 			return
 		}
+		generated := req.Generate
+		if generated {
+			if req.Password, err = generatePassword(24); err != nil {
+				c.JSON(500, gin.H{"error": "Failed to generate password"})
+				return
+			}
+		}
+		audit.Annotate(c, "database.role_password", "database", c.Param("id"), map[string]any{"role": name})
 
-		// Generate connection string
-		proxyHost := auth.GetProxyHost()
-		proxyPort := auth.GetProxyPort()
-		portInt, _ := strconv.Atoi(proxyPort)
-		connectionString := auth.GenerateConnectionString(jwtToken, databaseID, proxyHost, portInt, "require")
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
+		}
+		defer conn.Close(context.Background())
 
-		c.JSON(200, gin.H{
-			"message":           "Token rotated successfully",
-			"token_id":          tokenID,
-			"connection_string": connectionString,
-			"expires_at":        expiresAt,
-		})
+		if err := pg.SetRolePassword(c.Request.Context(), conn, name, req.Password); err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if err := db.SaveRolePassword(dbID, name, req.Password); err != nil {
+			c.JSON(500, gin.H{"error": "Password changed, but it could not be stored"})
+			return
+		}
+
+		response := gin.H{"message": "Password updated"}
+		if generated {
+			response["password"] = req.Password
+			response["warning"] = "Copy this password now. You will not be able to see it again."
+		}
+		c.JSON(200, response)
 	})
 
-	// Revoke a specific token
-	r.DELETE("/api/databases/:id/tokens/:token_id", func(c *gin.Context) {
-		tokenID := c.Param("token_id")
+	// Privileges granted directly to a role on schemas, tables and sequences
+	r.GET("/api/databases/:id/roles/:role/privileges", func(c *gin.Context) {
+		conn, ok := connectActiveDatabase(c)
+		if !ok {
+			return
+		}
+		defer conn.Close(context.Background())
 
-		if err := db.RevokeToken(tokenID); err != nil {
-			c.JSON(404, gin.H{"error": "Token not found"})
+		if _, err := pg.GetRole(c.Request.Context(), conn, c.Param("role")); err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
+		privileges, err := pg.ListRolePrivileges(c.Request.Context(), conn, c.Param("role"))
+		if err != nil {
+			c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, privileges)
+	})
+
+	// Grant or revoke a privilege preset (read-only, read-write or owner) on
+	// a schema, or on some of its tables
+	for _, action := range []string{"grant", "revoke"} {
+		grant := action == "grant"
+		r.POST("/api/databases/:id/roles/:role/"+action, func(c *gin.Context) {
+			name := c.Param("role")
+			if reservedRole(name) {
+				c.JSON(400, gin.H{"error": "This role is used by Baseful and cannot be changed"})
+				return
+			}
+			var req struct {
+				Schema string   `json:"schema"`
+				Tables []string `json:"tables"`
+				Preset string   `json:"preset"`
+			}
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(400, gin.H{"error": "Invalid request"})
+				return
+			}
+			if req.Schema == "" {
+				req.Schema = "public"
+			}
+			audit.Annotate(c, "database.role_"+action, "database", c.Param("id"), map[string]any{
+				"role":   name,
+				"schema": req.Schema,
+				"tables": req.Tables,
+				"preset": req.Preset,
+			})
 
-		c.JSON(200, gin.H{"message": "Token revoked successfully"})
-	})
+			conn, ok := connectActiveDatabase(c)
+			if !ok {
+				return
+			}
+			defer conn.Close(context.Background())
 
-	// Get actual connection string (with warning - only shown once)
-	r.GET("/api/databases/:id/connection-string", func(c *gin.Context) {
+			change := pg.RevokePreset
+			if grant {
+				change = pg.GrantPreset
+			}
+			if err := change(c.Request.Context(), conn, name, req.Schema, req.Tables, req.Preset); err != nil {
+				c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			privileges, err := pg.ListRolePrivileges(c.Request.Context(), conn, name)
+			if err != nil {
+				c.JSON(pg.ErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(200, privileges)
+		})
+	}
+
+	// Create a proxy connection string whose sessions log in as a role
+	r.POST("/api/databases/:id/roles/:role/connection-string", func(c *gin.Context) {
 		id := c.Param("id")
 		dbID, err := strconv.Atoi(id)
 		if err != nil {
 			c.JSON(400, gin.H{"error": "Invalid database ID"})
 			return
 		}
+		name := c.Param("role")
+		if _, err := db.GetRolePassword(dbID, name); err != nil {
+			c.JSON(400, gin.H{"error": "Set the role's password through the API before creating a connection string"})
+			return
+		}
 
-		// TLS is mandatory for proxy connections.
-		sslMode := "require"
-
-		// Get existing active token
-		tokenRecord, err := db.GetActiveTokenForDatabase(dbID)
-		if err != nil || tokenRecord == nil {
-			// Generate new token if none exists
-			tokenID, _ := auth.GenerateTokenID()
-			issuedAt := time.Now().UTC()
-			expiresAt := issuedAt.AddDate(2, 0, 0)
-			jwtToken, _ := auth.GenerateJWTWithTimestamps(dbID, 0, tokenID, issuedAt, expiresAt)
-			tokenHash := db.HashToken(jwtToken)
-			db.CreateToken(dbID, tokenID, tokenHash, issuedAt, expiresAt)
-
-			proxyHost := auth.GetProxyHost()
-			if proxyHost == "localhost" || proxyHost == "0.0.0.0" {
-				if publicIP, err := system.GetPublicIP(); err == nil {
-					proxyHost = publicIP
-				}
-			}
-			proxyPort := auth.GetProxyPort()
-			portInt, _ := strconv.Atoi(proxyPort)
-			connectionString := auth.GenerateConnectionString(jwtToken, dbID, proxyHost, portInt, sslMode)
-
-			c.JSON(200, gin.H{
-				"message":           "Database started",
-				"connection_string": connectionString,
-				"expires_at":        expiresAt,
-				"warning":           "Copy this connection string now. You will not be able to see it again. Store it securely.",
-				"ssl_enabled":       true,
-			})
+		tokenID, err := auth.GenerateTokenID()
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to generate token ID"})
 			return
 		}
+		audit.Annotate(c, "token.create_role", "database", id, map[string]any{"tokenId": tokenID, "role": name})
 
-		// Generate deterministic JWT for existing token record.
-		jwtToken, err := auth.GenerateJWTWithTimestamps(dbID, 0, tokenRecord.TokenID, tokenRecord.IssuedAt, tokenRecord.ExpiresAt)
+		issuedAt := time.Now().UTC()
+		expiresAt := issuedAt.AddDate(2, 0, 0)
+		jwtToken, err := auth.GenerateJWTWithTimestamps(dbID, 0, tokenID, issuedAt, expiresAt)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to generate connection string"})
+			c.JSON(500, gin.H{"error": "Failed to generate JWT token"})
 			return
 		}
-		if db.HashToken(jwtToken) != tokenRecord.TokenHash {
-			// Legacy token record without reproducible JWT claims; mint a new stable token.
-			tokenID, _ := auth.GenerateTokenID()
-			issuedAt := time.Now().UTC()
-			expiresAt := issuedAt.AddDate(2, 0, 0)
-			jwtToken, _ = auth.GenerateJWTWithTimestamps(dbID, 0, tokenID, issuedAt, expiresAt)
-			tokenHash := db.HashToken(jwtToken)
-			if _, err := db.CreateToken(dbID, tokenID, tokenHash, issuedAt, expiresAt); err != nil {
-				c.JSON(500, gin.H{"error": "Failed to migrate token format"})
-				return
-			}
-			tokenRecord.ExpiresAt = expiresAt
+		if _, err := db.CreateRoleToken(dbID, tokenID, name, db.HashToken(jwtToken), issuedAt, expiresAt); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to store token"})
+			return
 		}
 
 		proxyHost := auth.GetProxyHost()
@@ -2562,13 +6228,14 @@ func main() {
 				proxyHost = publicIP
 			}
 		}
-		proxyPort := auth.GetProxyPort()
-		portInt, _ := strconv.Atoi(proxyPort)
-		connectionString := auth.GenerateConnectionString(jwtToken, dbID, proxyHost, portInt, sslMode)
+		portInt, _ := strconv.Atoi(auth.GetProxyPort())
+		connectionString := auth.GenerateConnectionString(jwtToken, dbID, proxyHost, portInt, "require")
 
 		c.JSON(200, gin.H{
+			"token_id":          tokenID,
+			"role":              name,
 			"connection_string": connectionString,
-			"expires_at":        tokenRecord.ExpiresAt,
+			"expires_at":        expiresAt,
 			"warning":           "Copy this connection string now. You will not be able to see it again. Store it securely.",
 		})
 	})
@@ -2585,11 +6252,11 @@ func main() {
 		}
 
 		var port int
-		var name, dbType, host, status, version, password, containerID string
+		var name, dbType, host, status, version, containerID string
 		err = db.DB.QueryRow(
-			"SELECT id, name, type, host, port, status, version, password, container_id FROM databases WHERE id = ?",
+			"SELECT id, name, type, host, port, status, version, container_id FROM databases WHERE id = ?",
 			id,
-		).Scan(&dbID, &name, &dbType, &host, &port, &status, &version, &password, &containerID)
+		).Scan(&dbID, &name, &dbType, &host, &port, &status, &version, &containerID)
 
 		if err != nil {
 			c.JSON(404, gin.H{"error": "Database not found"})
@@ -2597,7 +6264,7 @@ func main() {
 		}
 
 		ctx := context.Background()
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		cli, err := databaseClient(id)
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Failed to connect to Docker"})
 			return
@@ -2792,7 +6459,7 @@ func main() {
 		}
 
 		ctx := context.Background()
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		cli, err := databaseClient(id)
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Failed to connect to Docker"})
 			return
@@ -2893,7 +6560,7 @@ func main() {
 		}
 
 		ctx := context.Background()
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		cli, err := databaseClient(id)
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Failed to connect to Docker"})
 			return
@@ -3009,7 +6676,7 @@ func main() {
 		// If database is active, update container resources immediately
 		if status == "active" && containerID != "" {
 			ctx := context.Background()
-			cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+			cli, err := databaseClient(id)
 			if err == nil {
 				defer cli.Close()
 
@@ -3038,10 +6705,83 @@ func main() {
 		})
 	})
 
+	// ========== AUTO-SUSPEND ==========
+
+	// Get the auto-suspend policy of a database
+	r.GET("/api/databases/:id/auto-suspend", func(c *gin.Context) {
+		dbID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
+		}
+
+		policy, err := db.GetAutoSuspendPolicy(dbID)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Database not found"})
+			return
+		}
+
+		c.JSON(200, policy)
+	})
+
+	// Set how many minutes a database may go without proxy activity before it
+	// is suspended. 0 keeps it running. Suspended databases wake up on their
+	// next connection.
+	r.PUT("/api/databases/:id/auto-suspend", func(c *gin.Context) {
+		id := c.Param("id")
+		dbID, err := strconv.Atoi(id)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid database ID"})
+			return
+		}
+
+		var req struct {
+			Minutes int `json:"minutes"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+		if req.Minutes != 0 && (req.Minutes < db.MinAutoSuspendMinutes || req.Minutes > db.MaxAutoSuspendMinutes) {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Auto-suspend must be 0 (off) or between %d minutes and 7 days", db.MinAutoSuspendMinutes)})
+			return
+		}
+		audit.Annotate(c, "database.auto_suspend", "database", id, map[string]any{"minutes": req.Minutes})
+
+		if req.Minutes > 0 {
+			ha, err := db.GetHAStatus(dbID)
+			if err != nil {
+				c.JSON(404, gin.H{"error": "Database not found"})
+				return
+			}
+			replicas, err := db.ListReplicas(dbID)
+			if err != nil {
+				c.JSON(500, gin.H{"error": "Failed to get replicas"})
+				return
+			}
+			if ha.Enabled || len(replicas) > 0 {
+				c.JSON(400, gin.H{"error": "Databases with read replicas or high availability cannot be suspended"})
+				return
+			}
+		}
+
+		if err := db.SetAutoSuspendMinutes(dbID, req.Minutes); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to update auto-suspend policy"})
+			return
+		}
+		policy, err := db.GetAutoSuspendPolicy(dbID)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Database not found"})
+			return
+		}
+
+		c.JSON(200, policy)
+	})
+
 	// ========== DOCKER CONTAINERS ==========
 
 	// List all containers
-	r.GET("/api/docker/containers", func(c *gin.Context) {
+	r.GET("/api/docker/containers", auth.AdminOnly(), func(c *gin.Context) {
 		containers, err := docker.ListContainers()
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Failed to list containers: " + err.Error()})
@@ -3050,9 +6790,10 @@ func main() {
 		c.JSON(200, containers)
 	})
 
-	// Execute command in container
-	r.POST("/api/docker/containers/:id/exec", func(c *gin.Context) {
+	// Execute command in container; ?server= selects a remote Docker host
+	r.POST("/api/docker/containers/:id/exec", auth.AdminOnly(), func(c *gin.Context) {
 		id := c.Param("id")
+		serverID, _ := strconv.Atoi(c.Query("server"))
 		var req struct {
 			Command string `json:"command"`
 			Cwd     string `json:"cwd"`
@@ -3066,8 +6807,12 @@ func main() {
 			c.JSON(400, gin.H{"error": "Command is required"})
 			return
 		}
+		audit.Annotate(c, "container.exec", "container", id, map[string]any{
+			"command": audit.Excerpt(req.Command),
+			"cwd":     req.Cwd,
+		})
 
-		result, err := docker.ExecCommand(id, req.Command, req.Cwd)
+		result, err := docker.ExecCommand(serverID, id, req.Command, req.Cwd)
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Failed to execute command: " + err.Error()})
 			return
@@ -3076,10 +6821,324 @@ func main() {
 		c.JSON(200, result)
 	})
 
+	// Interactive terminal in a Baseful container over a WebSocket. Opens a
+	// shell, or ?command= through sh, with a TTY of ?rows= by ?cols=, on the
+	// Docker host selected by ?server=.
+	r.GET("/api/docker/containers/:id/terminal", auth.AdminOnly(), func(c *gin.Context) {
+		id := c.Param("id")
+		serverID, _ := strconv.Atoi(c.Query("server"))
+		command := c.Query("command")
+		entry := audit.Annotate(c, "container.terminal", "container", id, map[string]any{
+			"command": audit.Excerpt(command),
+		})
+		if !websocket.IsWebSocketUpgrade(c.Request) {
+			c.JSON(400, gin.H{"error": "WebSocket upgrade required"})
+			return
+		}
+
+		var cmd []string
+		if command != "" {
+			cmd = []string{"/bin/sh", "-c", command}
+		}
+		rows, _ := strconv.ParseUint(c.Query("rows"), 10, 16)
+		cols, _ := strconv.ParseUint(c.Query("cols"), 10, 16)
+
+		term, err := docker.OpenTerminal(c.Request.Context(), serverID, id, cmd, uint(rows), uint(cols))
+		if errors.Is(err, docker.ErrNotManaged) {
+			c.JSON(403, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to open terminal: " + err.Error()})
+			return
+		}
+		defer term.Close()
+
+		ws, err := terminalUpgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// The upgrader already replied with an HTTP error
+			return
+		}
+		defer ws.Close()
+		// Record the session when it opens; the end is recorded separately
+		// so a session that never closes is still in the audit log
+		audit.Flush(c, http.StatusSwitchingProtocols)
+
+		started := time.Now()
+		code := pumpTerminal(ws, term)
+		entry.Details["exitCode"] = code
+		entry.Details["durationSeconds"] = int(time.Since(started).Seconds())
+		audit.Complete(entry, nil)
+	})
+
+	// ========== CONTAINER DRIFT ==========
+
+	// Compare databases and branches with their containers: rows whose status
+	// is out of date, rows whose container is gone and unreferenced containers
+	r.GET("/api/docker/drift", auth.AdminOnly(), func(c *gin.Context) {
+		report, err := reconcile.Check(c.Request.Context())
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to check containers: " + err.Error()})
+			return
+		}
+		c.JSON(200, report)
+	})
+
+	// Record the actual status of every drifted row now instead of waiting for
+	// the next reconciliation
+	r.POST("/api/docker/drift/sync", auth.AdminOnly(), func(c *gin.Context) {
+		audit.Annotate(c, "container.drift_sync", "instance", "", nil)
+		updated, err := reconcile.Sync(c.Request.Context())
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to sync statuses: " + err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"updated": updated})
+	})
+
+	// Repair one drift: sync or start a row, delete a row whose container is
+	// gone, or remove an orphaned container. serverId selects a remote host.
+	r.POST("/api/docker/drift/repair", auth.AdminOnly(), func(c *gin.Context) {
+		var req struct {
+			ServerID    int    `json:"serverId"`
+			Action      string `json:"action"`
+			Kind        string `json:"kind"`
+			ID          int    `json:"id"`
+			ContainerID string `json:"containerId"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+		switch req.Action {
+		case reconcile.RepairRemoveContainer:
+			if req.ContainerID == "" {
+				c.JSON(400, gin.H{"error": "containerId is required"})
+				return
+			}
+			audit.Annotate(c, "container.drift_repair", "container", req.ContainerID, map[string]any{"action": req.Action, "serverId": req.ServerID})
+		case reconcile.RepairSync, reconcile.RepairStart, reconcile.RepairDeleteRow:
+			if req.Kind != reconcile.KindDatabase && req.Kind != reconcile.KindBranch && req.Kind != reconcile.KindReplica {
+				c.JSON(400, gin.H{"error": "kind must be database, branch or replica"})
+				return
+			}
+			audit.Annotate(c, "container.drift_repair", req.Kind, strconv.Itoa(req.ID), map[string]any{"action": req.Action})
+		default:
+			c.JSON(400, gin.H{"error": "Invalid repair action"})
+			return
+		}
+
+		err := reconcile.Repair(c.Request.Context(), req.ServerID, req.Action, req.Kind, req.ID, req.ContainerID)
+		if errors.Is(err, reconcile.ErrNoDrift) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"message": "Drift repaired"})
+	})
+
+	// ========== SERVERS ==========
+
+	// List the remote Docker hosts with their pinned host key fingerprint and
+	// the number of databases they run
+	r.GET("/api/servers", auth.AdminOnly(), func(c *gin.Context) {
+		servers, err := db.ListServers()
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to list servers"})
+			return
+		}
+		result := []gin.H{}
+		for _, s := range servers {
+			count, _ := db.CountServerDatabases(s.ID)
+			result = append(result, gin.H{
+				"server":             s,
+				"sshHostFingerprint": docker.SSHFingerprint(s.SSHHostKey),
+				"databases":          count,
+			})
+		}
+		c.JSON(200, result)
+	})
+
+	// Register a Docker host. SSH hosts are logged into with a private key and
+	// their host key is pinned on first contact unless one is given; TLS hosts
+	// need a CA and a client certificate. The connection is tested first.
+	r.POST("/api/servers", auth.AdminOnly(), func(c *gin.Context) {
+		var req struct {
+			Name          string `json:"name"`
+			Host          string `json:"host"`
+			Port          int    `json:"port"`
+			Username      string `json:"username"`
+			Transport     string `json:"transport"`
+			DockerSocket  string `json:"dockerSocket"`
+			SSHPrivateKey string `json:"sshPrivateKey"`
+			SSHHostKey    string `json:"sshHostKey"`
+			TLSCACert     string `json:"tlsCaCert"`
+			TLSCert       string `json:"tlsCert"`
+			TLSKey        string `json:"tlsKey"`
+			PublicHost    string `json:"publicHost"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		req.Host = strings.TrimSpace(req.Host)
+		if req.Name == "" || req.Host == "" {
+			c.JSON(400, gin.H{"error": "Name and host are required"})
+			return
+		}
+		if req.Port < 0 || req.Port > 65535 {
+			c.JSON(400, gin.H{"error": "Invalid port"})
+			return
+		}
+
+		server := &db.Server{
+			Name: req.Name, Host: req.Host, Port: req.Port, Transport: req.Transport,
+			PublicHost: strings.TrimSpace(req.PublicHost),
+		}
+		switch req.Transport {
+		case db.ServerTransportSSH:
+			if req.Username == "" || req.SSHPrivateKey == "" {
+				c.JSON(400, gin.H{"error": "Username and SSH private key are required"})
+				return
+			}
+			if server.Port == 0 {
+				server.Port = 22
+			}
+			server.Username = req.Username
+			server.SSHPrivateKey = req.SSHPrivateKey
+			server.DockerSocket = req.DockerSocket
+			if server.DockerSocket == "" {
+				server.DockerSocket = db.DefaultDockerSocket
+			}
+			server.SSHHostKey = strings.TrimSpace(req.SSHHostKey)
+			if server.SSHHostKey == "" {
+				hostKey, err := docker.FetchSSHHostKey(server.Host, server.Port)
+				if err != nil {
+					c.JSON(400, gin.H{"error": err.Error()})
+					return
+				}
+				server.SSHHostKey = hostKey
+			}
+		case db.ServerTransportTLS:
+			if req.TLSCACert == "" || req.TLSCert == "" || req.TLSKey == "" {
+				c.JSON(400, gin.H{"error": "CA certificate, client certificate and client key are required"})
+				return
+			}
+			if server.Port == 0 {
+				server.Port = 2376
+			}
+			server.TLSCACert = req.TLSCACert
+			server.TLSCert = req.TLSCert
+			server.TLSKey = req.TLSKey
+		default:
+			c.JSON(400, gin.H{"error": "transport must be ssh or tls"})
+			return
+		}
+		audit.Annotate(c, "server.create", "server", "", map[string]any{
+			"name": server.Name, "host": server.Host, "transport": server.Transport,
+		})
+
+		version, err := docker.TestServer(c.Request.Context(), server)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := db.CreateServer(server); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to save server"})
+			return
+		}
+		audit.AddDetail(c, "serverId", server.ID)
+		if err := docker.EnsureServerNetwork(server.ID); err != nil {
+			log.Printf("Failed to create network on server %s: %v", server.Name, err)
+		}
+		reconcile.Watch(context.Background(), server.ID)
+
+		c.JSON(201, gin.H{
+			"server":             server,
+			"sshHostFingerprint": docker.SSHFingerprint(server.SSHHostKey),
+			"dockerVersion":      version,
+		})
+	})
+
+	// Check that a server is reachable and return its Docker version
+	r.POST("/api/servers/:id/test", auth.AdminOnly(), func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+		server, err := db.GetServer(id)
+		if err != nil || server == nil {
+			c.JSON(404, gin.H{"error": "Server not found"})
+			return
+		}
+		version, err := docker.TestServer(c.Request.Context(), server)
+		if err != nil {
+			c.JSON(502, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"dockerVersion": version})
+	})
+
+	// Pin the SSH host key a server presents now, e.g. after it was
+	// reinstalled. The new fingerprint should be checked against the host.
+	r.POST("/api/servers/:id/host-key", auth.AdminOnly(), func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+		audit.Annotate(c, "server.host_key", "server", c.Param("id"), nil)
+		server, err := db.GetServer(id)
+		if err != nil || server == nil {
+			c.JSON(404, gin.H{"error": "Server not found"})
+			return
+		}
+		if server.Transport != db.ServerTransportSSH {
+			c.JSON(400, gin.H{"error": "Only SSH servers have a host key"})
+			return
+		}
+		hostKey, err := docker.FetchSSHHostKey(server.Host, server.Port)
+		if err != nil {
+			c.JSON(502, gin.H{"error": err.Error()})
+			return
+		}
+		fingerprint := docker.SSHFingerprint(hostKey)
+		audit.AddDetail(c, "fingerprint", fingerprint)
+		if err := db.UpdateServerHostKey(id, hostKey); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to update host key"})
+			return
+		}
+		docker.CloseServer(id)
+		c.JSON(200, gin.H{"sshHostFingerprint": fingerprint})
+	})
+
+	// Remove a server. Servers still running databases cannot be removed.
+	r.DELETE("/api/servers/:id", auth.AdminOnly(), func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+		audit.Annotate(c, "server.delete", "server", c.Param("id"), nil)
+		server, err := db.GetServer(id)
+		if err != nil || server == nil {
+			c.JSON(404, gin.H{"error": "Server not found"})
+			return
+		}
+		count, err := db.CountServerDatabases(id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to check server databases"})
+			return
+		}
+		if count > 0 {
+			c.JSON(409, gin.H{"error": fmt.Sprintf("Server still runs %d database(s)", count)})
+			return
+		}
+		if err := db.DeleteServer(id); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to delete server"})
+			return
+		}
+		docker.CloseServer(id)
+		c.JSON(200, gin.H{"message": "Server removed"})
+	})
+
 	// ========== DOCKER NETWORK STATUS ==========
 
 	// Get Docker network status
-	r.GET("/api/docker/network", func(c *gin.Context) {
+	r.GET("/api/docker/network", auth.AdminOnly(), func(c *gin.Context) {
 		exists, err := docker.NetworkExists()
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Failed to check network"})
@@ -3110,7 +7169,7 @@ func main() {
 	})
 
 	// Get Proxy status
-	r.GET("/api/docker/proxy", func(c *gin.Context) {
+	r.GET("/api/docker/proxy", auth.AdminOnly(), func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"running": true,
 			"port":    auth.GetProxyPort(),
@@ -3119,7 +7178,7 @@ func main() {
 	})
 
 	// Restart Proxy (not applicable for in-memory proxy)
-	r.POST("/api/docker/proxy/restart", func(c *gin.Context) {
+	r.POST("/api/docker/proxy/restart", auth.AdminOnly(), func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"message": "Proxy is running in-memory and cannot be restarted. Restart the main application instead.",
 		})
@@ -1,7 +1,6 @@
 package metrics
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
@@ -13,10 +12,10 @@ import (
 	"time"
 
 	"baseful/db"
+	"baseful/docker"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/stdcopy"
 	_ "modernc.org/sqlite"
 )
 
@@ -63,6 +62,13 @@ func InitMetricsDB() error {
 		io_write_bps REAL
 	);
 	CREATE INDEX IF NOT EXISTS idx_samples_db_time ON samples(database_id, timestamp);
+	CREATE TABLE IF NOT EXISTS replica_samples (
+		replica_id INTEGER,
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+		lag_bytes INTEGER,
+		lag_seconds REAL
+	);
+	CREATE INDEX IF NOT EXISTS idx_replica_samples_time ON replica_samples(replica_id, timestamp);
 	`
 
 	_, err = MetricsDB.Exec(schema)
@@ -103,6 +109,9 @@ func StartCollector() {
 				log.Printf("Collecting metrics (rate: %ds)...", rate)
 				collectAllMetrics()
 			}
+			// The proxy routes reads by replica health, so replication is
+			// sampled even with metrics disabled
+			collectReplication(enabled)
 
 			// Wait for the next sample or cleanup
 			select {
@@ -116,27 +125,47 @@ func StartCollector() {
 }
 
 func collectAllMetrics() {
-	rows, err := db.DB.Query("SELECT id, container_id, status FROM databases WHERE status = 'active'")
+	rows, err := db.DB.Query("SELECT id, container_id, status, COALESCE(server_id, 0) FROM databases WHERE status = 'active'")
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 
+	// One client per server; they are closed once every sample is taken
 	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		return
-	}
-	defer cli.Close()
+	clients := map[int]*client.Client{}
+	var wg sync.WaitGroup
+	defer func() {
+		go func() {
+			wg.Wait()
+			for _, cli := range clients {
+				cli.Close()
+			}
+		}()
+	}()
 
 	for rows.Next() {
-		var id int
+		var id, serverID int
 		var containerID, status string
-		if err := rows.Scan(&id, &containerID, &status); err != nil {
+		if err := rows.Scan(&id, &containerID, &status, &serverID); err != nil {
 			continue
 		}
 
-		go collectSingleMetric(ctx, cli, id, containerID)
+		cli, ok := clients[serverID]
+		if !ok {
+			cli, err = docker.NewClient(serverID)
+			if err != nil {
+				log.Printf("Failed to connect to Docker on server %d: %v", serverID, err)
+				continue
+			}
+			clients[serverID] = cli
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collectSingleMetric(ctx, cli, id, containerID)
+		}()
 	}
 }
 
@@ -231,21 +260,10 @@ func collectSingleMetric(ctx context.Context, cli *client.Client, dbID int, cont
 
 	// Get active connections
 	var activeConnections int
-	cmd := []string{"psql", "-U", "postgres", "-t", "-A", "-c",
-		"SELECT count(*) FROM pg_stat_activity WHERE application_name IS NULL OR application_name != 'baseful-metrics'"}
-
-	execResp, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
-		Cmd:          cmd,
-		AttachStdout: true,
-	})
+	output, err := psql(ctx, cli, containerID,
+		"SELECT count(*) FROM pg_stat_activity WHERE application_name IS NULL OR application_name != 'baseful-metrics'")
 	if err == nil {
-		attachResp, err := cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{})
-		if err == nil {
-			var stdout bytes.Buffer
-			_, _ = stdcopy.StdCopy(&stdout, &bytes.Buffer{}, attachResp.Reader)
-			attachResp.Close()
-			activeConnections, _ = strconv.Atoi(strings.TrimSpace(stdout.String()))
-		}
+		activeConnections, _ = strconv.Atoi(strings.TrimSpace(output))
 	}
 
 	_, _ = MetricsDB.Exec(
@@ -259,11 +277,178 @@ var (
 	cumulativeLock   sync.Mutex
 )
 
+// psql runs a query in a container as the postgres user and returns the
+// unaligned, tuples-only output with | between columns
+func psql(ctx context.Context, cli *client.Client, containerID, query string) (string, error) {
+	return docker.ExecOutput(ctx, cli, containerID, container.ExecOptions{
+		Cmd: []string{"psql", "-U", "postgres", "-t", "-A", "-F", "|", "-c", query},
+	})
+}
+
+// replicationQuery lists the replica slots of a primary with the state and
+// lag of the replica streaming from each. Disconnected replicas are behind
+// by what the primary retains for their slot.
+const replicationQuery = `SELECT s.slot_name, s.active, COALESCE(r.state, ''),
+	COALESCE(pg_wal_lsn_diff(pg_current_wal_lsn(), COALESCE(r.replay_lsn, s.restart_lsn)), 0)::bigint,
+	COALESCE(EXTRACT(EPOCH FROM r.replay_lag), 0)
+	FROM pg_replication_slots s LEFT JOIN pg_stat_replication r ON r.pid = s.active_pid
+	WHERE s.slot_name LIKE 'baseful\_replica\_%'`
+
+// slotState is one row of replicationQuery
+type slotState struct {
+	active     bool
+	state      string
+	lagBytes   int64
+	lagSeconds float64
+}
+
+// collectReplication samples the replication state and lag of every replica
+// on its primary, and drops slots whose replica was removed
+func collectReplication(storeHistory bool) {
+	rows, err := db.DB.Query(`
+		SELECT DISTINCT d.id, COALESCE(d.container_id, ''), COALESCE(d.status, ''), COALESCE(d.server_id, 0)
+		FROM databases d JOIN replicas r ON r.database_id = d.id
+	`)
+	if err != nil {
+		return
+	}
+	type primary struct {
+		id, serverID        int
+		containerID, status string
+	}
+	var primaries []primary
+	for rows.Next() {
+		var p primary
+		if err := rows.Scan(&p.id, &p.containerID, &p.status, &p.serverID); err == nil {
+			primaries = append(primaries, p)
+		}
+	}
+	rows.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, p := range primaries {
+		replicas, err := db.ListReplicas(p.id)
+		if err != nil {
+			continue
+		}
+		// Replicas of a primary that cannot be sampled keep their last sample,
+		// which failover relies on; lag_checked_at tells how old it is
+		if p.status != "active" || p.containerID == "" {
+			continue
+		}
+		slots, err := sampleSlots(ctx, p.serverID, p.containerID)
+		if err != nil {
+			log.Printf("Failed to sample replication of database %d: %v", p.id, err)
+			continue
+		}
+
+		known := map[string]bool{}
+		for _, r := range replicas {
+			known[r.SlotName()] = true
+			if r.Status == "creating" {
+				continue
+			}
+			slot, ok := slots[r.SlotName()]
+			state := slot.state
+			if !ok || state == "" {
+				state = db.ReplicationDisconnected
+			}
+			if err := db.UpdateReplicaLag(r.ID, state, slot.lagBytes, slot.lagSeconds); err != nil {
+				log.Printf("Failed to record lag of replica %d: %v", r.ID, err)
+			}
+			if storeHistory && ok {
+				_, _ = MetricsDB.Exec("INSERT INTO replica_samples (replica_id, lag_bytes, lag_seconds) VALUES (?, ?, ?)",
+					r.ID, slot.lagBytes, slot.lagSeconds)
+			}
+		}
+
+		// A slot without a replica retains WAL forever; drop it
+		for name, slot := range slots {
+			if known[name] || slot.active {
+				continue
+			}
+			cli, err := docker.NewClient(p.serverID)
+			if err != nil {
+				continue
+			}
+			if _, err := psql(ctx, cli, p.containerID, "SELECT pg_drop_replication_slot('"+name+"')"); err != nil {
+				log.Printf("Failed to drop replication slot %s: %v", name, err)
+			} else {
+				log.Printf("Dropped replication slot %s of a removed replica", name)
+			}
+			cli.Close()
+		}
+	}
+}
+
+func sampleSlots(ctx context.Context, serverID int, containerID string) (map[string]slotState, error) {
+	cli, err := docker.NewClient(serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+	output, err := psql(ctx, cli, containerID, replicationQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := map[string]slotState{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) != 5 {
+			continue
+		}
+		lagBytes, _ := strconv.ParseInt(fields[3], 10, 64)
+		lagSeconds, _ := strconv.ParseFloat(fields[4], 64)
+		slots[fields[0]] = slotState{
+			active:     fields[1] == "t",
+			state:      fields[2],
+			lagBytes:   lagBytes,
+			lagSeconds: lagSeconds,
+		}
+	}
+	return slots, nil
+}
+
 func CleanupOldMetrics() {
 	_, err := MetricsDB.Exec("DELETE FROM samples WHERE timestamp < datetime('now', '-1 hour')")
 	if err != nil {
 		log.Printf("Failed to cleanup old metrics: %v", err)
 	}
+	_, err = MetricsDB.Exec("DELETE FROM replica_samples WHERE timestamp < datetime('now', '-1 hour')")
+	if err != nil {
+		log.Printf("Failed to cleanup old replica metrics: %v", err)
+	}
+}
+
+// ReplicaLagSample is a replication lag measurement of a replica
+type ReplicaLagSample struct {
+	Timestamp  time.Time `json:"timestamp"`
+	LagBytes   int64     `json:"lag_bytes"`
+	LagSeconds float64   `json:"lag_seconds"`
+}
+
+// GetReplicaHistory returns the recent lag samples of a replica
+func GetReplicaHistory(replicaID int) ([]ReplicaLagSample, error) {
+	rows, err := MetricsDB.Query(
+		"SELECT timestamp, lag_bytes, lag_seconds FROM replica_samples WHERE replica_id = ? ORDER BY timestamp ASC",
+		replicaID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []ReplicaLagSample{}
+	for rows.Next() {
+		var s ReplicaLagSample
+		if err := rows.Scan(&s.Timestamp, &s.LagBytes, &s.LagSeconds); err != nil {
+			continue
+		}
+		history = append(history, s)
+	}
+	return history, nil
 }
 
 func GetHistory(dbID int) ([]MetricSample, error) {
@@ -0,0 +1,249 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrObjectNotFound is returned when a catalog object lookup matches nothing
+var ErrObjectNotFound = errors.New("object not found")
+
+// Schema describes a namespace in the database
+type Schema struct {
+	Name     string `json:"name"`
+	Owner    string `json:"owner"`
+	IsSystem bool   `json:"isSystem"`
+}
+
+// Relation describes any pg_class entry the browser can show
+type Relation struct {
+	Schema      string  `json:"schema"`
+	Name        string  `json:"name"`
+	Kind        string  `json:"kind"`
+	RowEstimate *int64  `json:"rowEstimate"` // nil when the relation has never been analyzed
+	SizeBytes   int64   `json:"sizeBytes"`
+	Comment     *string `json:"comment,omitempty"`
+}
+
+// Function describes a function, procedure or aggregate
+type Function struct {
+	OID        uint32 `json:"oid"`
+	Schema     string `json:"schema"`
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	Arguments  string `json:"arguments"`
+	ResultType string `json:"resultType"`
+	Language   string `json:"language"`
+}
+
+// Extension describes an installed extension
+type Extension struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Schema  string `json:"schema"`
+}
+
+// RelationKinds maps pg_class.relkind to the names used by the API
+var RelationKinds = map[string]string{
+	"r": "table",
+	"p": "partitioned_table",
+	"v": "view",
+	"m": "materialized_view",
+	"f": "foreign_table",
+	"S": "sequence",
+}
+
+var functionKinds = map[string]string{
+	"f": "function",
+	"p": "procedure",
+	"a": "aggregate",
+	"w": "window",
+}
+
+// ListSchemas returns the schemas in the database. Internal schemas such as
+// pg_toast are never listed; pg_catalog and information_schema only on request.
+func ListSchemas(ctx context.Context, q Querier, includeSystem bool) ([]Schema, error) {
+	rows, err := q.Query(ctx, `
+		SELECT n.nspname::text, pg_get_userbyid(n.nspowner)::text,
+			n.nspname IN ('pg_catalog', 'information_schema')
+		FROM pg_namespace n
+		WHERE n.nspname NOT LIKE 'pg\_toast%' AND n.nspname NOT LIKE 'pg\_temp\_%'
+			AND ($1 OR n.nspname NOT IN ('pg_catalog', 'information_schema'))
+		ORDER BY n.nspname = 'public' DESC, n.nspname
+	`, includeSystem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schemas: %w", err)
+	}
+	defer rows.Close()
+
+	schemas := []Schema{}
+	for rows.Next() {
+		var s Schema
+		if err := rows.Scan(&s.Name, &s.Owner, &s.IsSystem); err != nil {
+			return nil, fmt.Errorf("failed to scan schema: %w", err)
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas, rows.Err()
+}
+
+// ListRelations returns the relations in a schema, optionally restricted to the
+// given kinds. Row counts are planner estimates from pg_class.reltuples.
+func ListRelations(ctx context.Context, q Querier, schema string, kinds []string) ([]Relation, error) {
+	var relkinds []string
+	for _, kind := range kinds {
+		found := false
+		for code, name := range RelationKinds {
+			if name == kind {
+				relkinds = append(relkinds, code)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown relation kind %q: %w", kind, ErrInvalidRequest)
+		}
+	}
+	if len(relkinds) == 0 {
+		for code := range RelationKinds {
+			relkinds = append(relkinds, code)
+		}
+	}
+
+	rows, err := q.Query(ctx, `
+		SELECT n.nspname::text, c.relname::text, c.relkind::text,
+			CASE WHEN c.reltuples < 0 OR c.relkind IN ('v', 'S', 'f') THEN NULL ELSE c.reltuples::bigint END,
+			pg_total_relation_size(c.oid),
+			obj_description(c.oid, 'pg_class')
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relkind::text = ANY($2) AND NOT c.relispartition
+		ORDER BY c.relname
+	`, schema, relkinds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query relations: %w", err)
+	}
+	defer rows.Close()
+
+	relations := []Relation{}
+	for rows.Next() {
+		var rel Relation
+		var relkind string
+		if err := rows.Scan(&rel.Schema, &rel.Name, &relkind, &rel.RowEstimate, &rel.SizeBytes, &rel.Comment); err != nil {
+			return nil, fmt.Errorf("failed to scan relation: %w", err)
+		}
+		rel.Kind = RelationKinds[relkind]
+		relations = append(relations, rel)
+	}
+	return relations, rows.Err()
+}
+
+// GetViewDefinition returns the SQL of a view or materialized view
+func GetViewDefinition(ctx context.Context, q Querier, schema, name string) (string, string, error) {
+	var relkind string
+	var definition *string
+	err := q.QueryRow(ctx, `
+		SELECT c.relkind::text,
+			CASE WHEN c.relkind IN ('v', 'm') THEN pg_get_viewdef(c.oid, true) END
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relname = $2
+	`, schema, name).Scan(&relkind, &definition)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", fmt.Errorf("%s.%s: %w", schema, name, ErrObjectNotFound)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to query view definition: %w", err)
+	}
+	if definition == nil {
+		return "", "", fmt.Errorf("%s.%s is a %s, definitions are only available for views: %w", schema, name, RelationKinds[relkind], ErrInvalidRequest)
+	}
+	return RelationKinds[relkind], *definition, nil
+}
+
+// ListFunctions returns the functions and procedures defined in a schema
+func ListFunctions(ctx context.Context, q Querier, schema string) ([]Function, error) {
+	rows, err := q.Query(ctx, `
+		SELECT p.oid, n.nspname::text, p.proname::text, p.prokind::text,
+			pg_get_function_identity_arguments(p.oid),
+			COALESCE(pg_get_function_result(p.oid), ''),
+			l.lanname::text
+		FROM pg_proc p
+		JOIN pg_namespace n ON n.oid = p.pronamespace
+		JOIN pg_language l ON l.oid = p.prolang
+		WHERE n.nspname = $1
+			AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = p.oid AND d.deptype = 'e')
+		ORDER BY p.proname, p.oid
+	`, schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query functions: %w", err)
+	}
+	defer rows.Close()
+
+	functions := []Function{}
+	for rows.Next() {
+		var fn Function
+		var kind string
+		if err := rows.Scan(&fn.OID, &fn.Schema, &fn.Name, &kind, &fn.Arguments, &fn.ResultType, &fn.Language); err != nil {
+			return nil, fmt.Errorf("failed to scan function: %w", err)
+		}
+		fn.Kind = functionKinds[kind]
+		functions = append(functions, fn)
+	}
+	return functions, rows.Err()
+}
+
+// GetFunctionDefinition returns the CREATE statement of a function or procedure
+func GetFunctionDefinition(ctx context.Context, q Querier, oid uint32) (*Function, string, error) {
+	var fn Function
+	var kind string
+	var definition *string
+	err := q.QueryRow(ctx, `
+		SELECT p.oid, n.nspname::text, p.proname::text, p.prokind::text,
+			pg_get_function_identity_arguments(p.oid),
+			COALESCE(pg_get_function_result(p.oid), ''),
+			l.lanname::text,
+			CASE WHEN p.prokind <> 'a' THEN pg_get_functiondef(p.oid) END
+		FROM pg_proc p
+		JOIN pg_namespace n ON n.oid = p.pronamespace
+		JOIN pg_language l ON l.oid = p.prolang
+		WHERE p.oid = $1
+	`, oid).Scan(&fn.OID, &fn.Schema, &fn.Name, &kind, &fn.Arguments, &fn.ResultType, &fn.Language, &definition)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, "", fmt.Errorf("function %d: %w", oid, ErrObjectNotFound)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query function definition: %w", err)
+	}
+	fn.Kind = functionKinds[kind]
+	if definition == nil {
+		return nil, "", fmt.Errorf("aggregates have no function definition: %w", ErrInvalidRequest)
+	}
+	return &fn, *definition, nil
+}
+
+// ListInstalledExtensions returns the extensions installed in the database
+func ListInstalledExtensions(ctx context.Context, q Querier) ([]Extension, error) {
+	rows, err := q.Query(ctx, `
+		SELECT e.extname::text, e.extversion, n.nspname::text
+		FROM pg_extension e
+		JOIN pg_namespace n ON n.oid = e.extnamespace
+		ORDER BY e.extname
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query extensions: %w", err)
+	}
+	defer rows.Close()
+
+	extensions := []Extension{}
+	for rows.Next() {
+		var ext Extension
+		if err := rows.Scan(&ext.Name, &ext.Version, &ext.Schema); err != nil {
+			return nil, fmt.Errorf("failed to scan extension: %w", err)
+		}
+		extensions = append(extensions, ext)
+	}
+	return extensions, rows.Err()
+}
@@ -0,0 +1,230 @@
+package pg
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Statement kinds, from least to most invasive
+const (
+	KindRead  = "read"
+	KindWrite = "write"
+	KindDDL   = "ddl"
+)
+
+// ClassifiedStatement is a single SQL statement with what it is expected to do.
+// Destructive statements can modify or remove existing data or objects.
+type ClassifiedStatement struct {
+	SQL         string `json:"sql"`
+	Command     string `json:"command"`
+	Kind        string `json:"kind"`
+	Destructive bool   `json:"destructive"`
+}
+
+var kindRank = map[string]int{KindRead: 0, KindWrite: 1, KindDDL: 2}
+
+// ClassifySQL splits a script into statements and classifies each one. The
+// classification is lexical; callers should still run reads in a read-only
+// transaction rather than rely on it alone.
+func ClassifySQL(script string) []ClassifiedStatement {
+	var result []ClassifiedStatement
+	for _, stmt := range SplitStatements(script) {
+		result = append(result, classifyStatement(stmt))
+	}
+	return result
+}
+
+// SummarizeStatements returns the most invasive kind among the statements and
+// whether any of them is destructive
+func SummarizeStatements(stmts []ClassifiedStatement) (string, bool) {
+	kind := KindRead
+	destructive := false
+	for _, stmt := range stmts {
+		if kindRank[stmt.Kind] > kindRank[kind] {
+			kind = stmt.Kind
+		}
+		destructive = destructive || stmt.Destructive
+	}
+	return kind, destructive
+}
+
+// SplitStatements splits a script on semicolons outside of strings, quoted
+// identifiers, dollar-quoted bodies and comments. Statements without any
+// keyword, such as a trailing comment, are dropped.
+func SplitStatements(script string) []string {
+	var statements []string
+	start := 0
+	lex(script, func(pos int, token string) {
+		if token == ";" {
+			if stmt := strings.TrimSpace(script[start:pos]); len(keywords(stmt)) > 0 {
+				statements = append(statements, stmt)
+			}
+			start = pos + 1
+		}
+	})
+	if stmt := strings.TrimSpace(script[start:]); len(keywords(stmt)) > 0 {
+		statements = append(statements, stmt)
+	}
+	return statements
+}
+
+func classifyStatement(stmt string) ClassifiedStatement {
+	words := keywords(stmt)
+	result := ClassifiedStatement{SQL: stmt, Kind: KindDDL, Destructive: true}
+	if len(words) == 0 {
+		return result
+	}
+	result.Command = words[0]
+
+	has := func(names ...string) bool {
+		for _, w := range words[1:] {
+			for _, name := range names {
+				if w == name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	switch words[0] {
+	case "SELECT":
+		if has("INTO") {
+			result.Command = "SELECT INTO"
+			result.Kind, result.Destructive = KindDDL, false
+		} else {
+			result.Kind, result.Destructive = KindRead, false
+		}
+	case "SHOW", "VALUES", "TABLE":
+		result.Kind, result.Destructive = KindRead, false
+	case "EXPLAIN":
+		// EXPLAIN ANALYZE runs the statement, so it is as invasive as what it explains
+		if !has("ANALYZE", "ANALYSE") {
+			result.Kind, result.Destructive = KindRead, false
+			break
+		}
+		fallthrough
+	case "WITH":
+		switch {
+		case has("UPDATE", "DELETE", "MERGE", "TRUNCATE", "DROP", "ALTER"):
+			result.Kind, result.Destructive = KindWrite, true
+		case has("INSERT"):
+			result.Kind, result.Destructive = KindWrite, false
+		default:
+			result.Kind, result.Destructive = KindRead, false
+		}
+	case "INSERT":
+		// ON CONFLICT ... DO UPDATE overwrites existing rows
+		result.Kind, result.Destructive = KindWrite, has("UPDATE")
+	case "COPY":
+		result.Kind, result.Destructive = KindWrite, false
+	case "UPDATE", "DELETE", "MERGE", "DO", "CALL":
+		result.Kind, result.Destructive = KindWrite, true
+	case "CREATE":
+		result.Kind, result.Destructive = KindDDL, len(words) > 2 && words[1] == "OR" && words[2] == "REPLACE"
+	case "COMMENT", "REFRESH", "ANALYZE", "ANALYSE", "VACUUM", "REINDEX", "CLUSTER", "GRANT":
+		result.Kind, result.Destructive = KindDDL, false
+	}
+	return result
+}
+
+// keywords returns the upper-cased bare words of a statement, skipping strings,
+// quoted identifiers and comments
+func keywords(stmt string) []string {
+	var words []string
+	lex(stmt, func(_ int, token string) {
+		if token != ";" && token != "" {
+			words = append(words, strings.ToUpper(token))
+		}
+	})
+	return words
+}
+
+// lex walks a SQL text and reports bare words and top-level semicolons
+func lex(s string, emit func(pos int, token string)) {
+	for i := 0; i < len(s); {
+		ch := s[i]
+		switch {
+		case ch == '-' && i+1 < len(s) && s[i+1] == '-':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case ch == '/' && i+1 < len(s) && s[i+1] == '*':
+			// Block comments nest in Postgres
+			depth := 0
+			for i < len(s) {
+				if strings.HasPrefix(s[i:], "/*") {
+					depth++
+					i += 2
+				} else if strings.HasPrefix(s[i:], "*/") {
+					depth--
+					i += 2
+					if depth == 0 {
+						break
+					}
+				} else {
+					i++
+				}
+			}
+		case ch == '\'' || ch == '"':
+			// Only E'' strings treat backslash as an escape character
+			escapes := ch == '\'' && i > 0 && (s[i-1] == 'E' || s[i-1] == 'e') &&
+				(i == 1 || !isWordChar(rune(s[i-2])))
+			i++
+			for i < len(s) {
+				if s[i] == ch {
+					if i+1 < len(s) && s[i+1] == ch {
+						i += 2
+						continue
+					}
+					break
+				}
+				if escapes && s[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+		case ch == '$':
+			end := strings.IndexByte(s[i+1:], '$')
+			tag := ""
+			if end >= 0 {
+				tag = s[i : i+end+2]
+			}
+			if end >= 0 && isDollarTag(tag) {
+				closing := strings.Index(s[i+len(tag):], tag)
+				if closing < 0 {
+					return
+				}
+				i += len(tag) + closing + len(tag)
+			} else {
+				i++
+			}
+		case ch == ';':
+			emit(i, ";")
+			i++
+		case isWordChar(rune(ch)):
+			start := i
+			for i < len(s) && (isWordChar(rune(s[i])) || s[i] >= '0' && s[i] <= '9' || s[i] == '$') {
+				i++
+			}
+			emit(start, s[start:i])
+		default:
+			i++
+		}
+	}
+}
+
+func isDollarTag(tag string) bool {
+	inner := tag[1 : len(tag)-1]
+	for i, r := range inner {
+		if !isWordChar(r) && !(i > 0 && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordChar(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || r >= 0x80
+}
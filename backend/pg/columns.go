@@ -0,0 +1,90 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrTableNotFound  = errors.New("table not found")
+	ErrUnknownColumn  = errors.New("unknown column")
+	ErrInvalidRequest = errors.New("invalid request")
+)
+
+// Column describes a table column as reported by pg_catalog
+type Column struct {
+	Name         string  `json:"name"`
+	Type         string  `json:"type"`
+	Nullable     bool    `json:"nullable"`
+	Default      *string `json:"default,omitempty"`
+	IsPrimaryKey bool    `json:"isPrimaryKey"`
+	IsGenerated  bool    `json:"isGenerated"`
+}
+
+// Querier is satisfied by both *pgx.Conn and pgx.Tx
+type Querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// GetColumns returns the columns of a table in ordinal order
+func GetColumns(ctx context.Context, q Querier, schema, table string) ([]Column, error) {
+	rows, err := q.Query(ctx, `
+		SELECT a.attname,
+			format_type(a.atttypid, a.atttypmod),
+			NOT a.attnotnull,
+			pg_get_expr(d.adbin, d.adrelid),
+			COALESCE(a.attnum = ANY(i.indkey), false),
+			a.attidentity = 'a' OR a.attgenerated <> ''
+		FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		LEFT JOIN pg_index i ON i.indrelid = c.oid AND i.indisprimary
+		WHERE n.nspname = $1 AND c.relname = $2 AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attnum
+	`, schema, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query columns: %w", err)
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var col Column
+		if err := rows.Scan(&col.Name, &col.Type, &col.Nullable, &col.Default, &col.IsPrimaryKey, &col.IsGenerated); err != nil {
+			return nil, fmt.Errorf("failed to scan column: %w", err)
+		}
+		columns = append(columns, col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query columns: %w", err)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("%s.%s: %w", schema, table, ErrTableNotFound)
+	}
+	return columns, nil
+}
+
+// PrimaryKey returns the primary key columns, empty if the table has none
+func PrimaryKey(columns []Column) []Column {
+	var pk []Column
+	for _, col := range columns {
+		if col.IsPrimaryKey {
+			pk = append(pk, col)
+		}
+	}
+	return pk
+}
+
+func findColumn(columns []Column, name string) (Column, error) {
+	for _, col := range columns {
+		if col.Name == name {
+			return col, nil
+		}
+	}
+	return Column{}, fmt.Errorf("%q: %w", name, ErrUnknownColumn)
+}
@@ -0,0 +1,383 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Setting is a server configuration parameter as reported by pg_settings
+type Setting struct {
+	Name string `json:"name"`
+	// Value is the current value in Unit; Display is how SHOW prints it
+	Value       string   `json:"value"`
+	Display     string   `json:"display"`
+	Unit        string   `json:"unit"`
+	Type        string   `json:"type"`
+	Category    string   `json:"category"`
+	Description string   `json:"description"`
+	Context     string   `json:"context"`
+	Min         string   `json:"min"`
+	Max         string   `json:"max"`
+	EnumValues  []string `json:"enumValues"`
+	Default     string   `json:"default"`
+	Source      string   `json:"source"`
+	// PendingRestart is set when a changed value only takes effect after a
+	// restart
+	PendingRestart bool `json:"pendingRestart"`
+	// Managed settings are set by Baseful and cannot be changed
+	Managed bool `json:"managed"`
+}
+
+// RequiresRestart reports whether changes to the setting need a server restart
+func (s Setting) RequiresRestart() bool {
+	return s.Context == "postmaster"
+}
+
+// managedSettings keep containers reachable and replication working, or can
+// keep the server from starting
+var managedSettings = map[string]bool{
+	"listen_addresses":         true,
+	"port":                     true,
+	"data_directory":           true,
+	"config_file":              true,
+	"hba_file":                 true,
+	"ident_file":               true,
+	"unix_socket_directories":  true,
+	"shared_preload_libraries": true,
+	"wal_level":                true,
+	"max_wal_senders":          true,
+	"max_replication_slots":    true,
+	"hot_standby":              true,
+	"primary_conninfo":         true,
+	"primary_slot_name":        true,
+	"restore_command":          true,
+	"archive_mode":             true,
+	"archive_command":          true,
+}
+
+// ListSettings returns the server's settings ordered by name, or only the
+// named ones when names is not empty
+func ListSettings(ctx context.Context, q Querier, names []string) ([]Setting, error) {
+	var filter []string
+	if len(names) > 0 {
+		filter = names
+	}
+	rows, err := q.Query(ctx, `
+		SELECT name, COALESCE(setting, ''), current_setting(name), COALESCE(unit, ''), vartype, category,
+			COALESCE(short_desc, ''), context, COALESCE(min_val, ''), COALESCE(max_val, ''),
+			COALESCE(enumvals, '{}'), COALESCE(boot_val, ''), source, pending_restart
+		FROM pg_settings
+		WHERE $1::text[] IS NULL OR name = ANY($1)
+		ORDER BY name`, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settings: %w", err)
+	}
+	defer rows.Close()
+
+	settings := []Setting{}
+	for rows.Next() {
+		var s Setting
+		err := rows.Scan(&s.Name, &s.Value, &s.Display, &s.Unit, &s.Type, &s.Category, &s.Description, &s.Context,
+			&s.Min, &s.Max, &s.EnumValues, &s.Default, &s.Source, &s.PendingRestart)
+		if err != nil {
+			return nil, err
+		}
+		s.Managed = managedSettings[s.Name]
+		settings = append(settings, s)
+	}
+	return settings, rows.Err()
+}
+
+// ConfigChange is an applied setting change
+type ConfigChange struct {
+	Name     string `json:"name"`
+	OldValue string `json:"oldValue"`
+	// NewValue is nil when the setting was reset to its default
+	NewValue        *string `json:"newValue"`
+	RequiresRestart bool    `json:"requiresRestart"`
+}
+
+// ApplyConfig validates settings against pg_settings, persists them with
+// ALTER SYSTEM and reloads the configuration. A nil value resets a setting
+// to its default. Either all settings are applied or none.
+func ApplyConfig(ctx context.Context, conn *pgx.Conn, values map[string]*string) ([]ConfigChange, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no settings given: %w", ErrInvalidRequest)
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	current, err := ListSettings(ctx, conn, names)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]Setting, len(current))
+	for _, s := range current {
+		byName[s.Name] = s
+	}
+
+	changes := make([]ConfigChange, 0, len(names))
+	for _, name := range names {
+		s, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown setting %q: %w", name, ErrInvalidRequest)
+		}
+		if s.Managed {
+			return nil, fmt.Errorf("%s is managed by Baseful: %w", name, ErrInvalidRequest)
+		}
+		if s.Context == "internal" {
+			return nil, fmt.Errorf("%s is read-only: %w", name, ErrInvalidRequest)
+		}
+		change := ConfigChange{Name: name, OldValue: s.Display, RequiresRestart: s.RequiresRestart()}
+		if value := values[name]; value != nil {
+			normalized, err := validateSetting(s, *value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %v: %w", name, err, ErrInvalidRequest)
+			}
+			change.NewValue = &normalized
+		}
+		changes = append(changes, change)
+	}
+
+	// ALTER SYSTEM cannot run in a transaction; remember what
+	// postgresql.auto.conf held so a failed change can be rolled back
+	previous, err := autoConfValues(ctx, conn, names)
+	if err != nil {
+		return nil, err
+	}
+	for i, change := range changes {
+		if err := alterSystem(ctx, conn, change.Name, change.NewValue); err != nil {
+			for _, applied := range changes[:i] {
+				prev, ok := previous[applied.Name]
+				if !ok {
+					alterSystem(ctx, conn, applied.Name, nil)
+					continue
+				}
+				alterSystem(ctx, conn, applied.Name, &prev)
+			}
+			return nil, fmt.Errorf("failed to set %s: %w", change.Name, err)
+		}
+	}
+
+	if _, err := conn.Exec(ctx, "SELECT pg_reload_conf()"); err != nil {
+		return nil, fmt.Errorf("failed to reload configuration: %w", err)
+	}
+	return changes, nil
+}
+
+func alterSystem(ctx context.Context, conn *pgx.Conn, name string, value *string) error {
+	_, err := conn.Exec(ctx, AlterSystemStatement(name, value))
+	return err
+}
+
+// AlterSystemStatement returns the statement that sets a setting in
+// postgresql.auto.conf, or removes it when value is nil. The name must have
+// been looked up in pg_settings.
+func AlterSystemStatement(name string, value *string) string {
+	// Custom settings contain a dot
+	ident := pgx.Identifier(strings.Split(name, ".")).Sanitize()
+	if value == nil {
+		return "ALTER SYSTEM RESET " + ident
+	}
+	return "ALTER SYSTEM SET " + ident + " = '" + strings.ReplaceAll(*value, "'", "''") + "'"
+}
+
+// autoConfValues returns the values postgresql.auto.conf holds for the named
+// settings
+func autoConfValues(ctx context.Context, conn *pgx.Conn, names []string) (map[string]string, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT name, setting FROM pg_file_settings
+		WHERE sourcefile LIKE '%postgresql.auto.conf' AND name = ANY($1) AND setting IS NOT NULL
+		ORDER BY seqno`, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read postgresql.auto.conf: %w", err)
+	}
+	defer rows.Close()
+
+	values := map[string]string{}
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		values[name] = value
+	}
+	return values, rows.Err()
+}
+
+// validateSetting checks a value against the type, range and allowed values
+// of a setting. Booleans and enums are returned in their canonical spelling.
+func validateSetting(s Setting, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if strings.ContainsAny(value, "\n\r\x00") {
+		return "", fmt.Errorf("values cannot contain line breaks")
+	}
+
+	switch s.Type {
+	case "bool":
+		switch strings.ToLower(value) {
+		case "on", "true", "yes", "1":
+			return "on", nil
+		case "off", "false", "no", "0":
+			return "off", nil
+		}
+		return "", fmt.Errorf("expected on or off")
+
+	case "enum":
+		for _, allowed := range s.EnumValues {
+			if strings.EqualFold(value, allowed) {
+				return allowed, nil
+			}
+		}
+		return "", fmt.Errorf("expected one of %s", strings.Join(s.EnumValues, ", "))
+
+	case "integer", "real":
+		n, err := parseQuantity(value, s.Unit)
+		if err != nil {
+			return "", err
+		}
+		if s.Type == "integer" {
+			n = math.Round(n)
+		}
+		min, errMin := strconv.ParseFloat(s.Min, 64)
+		max, errMax := strconv.ParseFloat(s.Max, 64)
+		if errMin == nil && errMax == nil && (n < min || n > max) {
+			return "", fmt.Errorf("must be between %s and %s%s", s.Min, s.Max, unitSuffix(s.Unit))
+		}
+		return value, nil
+	}
+	return value, nil
+}
+
+// Multipliers of the units Postgres accepts, in bytes and microseconds
+var (
+	memoryUnits = map[string]float64{"B": 1, "kB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30, "TB": 1 << 40}
+	timeUnits   = map[string]float64{"us": 1, "ms": 1e3, "s": 1e6, "min": 60e6, "h": 3600e6, "d": 86400e6}
+)
+
+// parseQuantity parses a number with an optional unit, like "256MB" or
+// "30s", into a multiple of a setting's unit, like "8kB" or "ms"
+func parseQuantity(value, settingUnit string) (float64, error) {
+	i := strings.IndexFunc(value, func(r rune) bool {
+		return !(r >= '0' && r <= '9') && r != '.' && r != '-' && r != '+' && r != 'e' && r != 'E'
+	})
+	number, unit := value, ""
+	if i >= 0 {
+		number, unit = value[:i], strings.TrimSpace(value[i:])
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("expected a number")
+	}
+	if unit == "" {
+		return n, nil
+	}
+
+	base, baseScale := splitUnit(settingUnit)
+	units := memoryUnits
+	if _, ok := timeUnits[base]; ok {
+		units = timeUnits
+	} else if _, ok := memoryUnits[base]; !ok {
+		return 0, fmt.Errorf("takes no unit")
+	}
+	scale, ok := units[unit]
+	if !ok {
+		valid := make([]string, 0, len(units))
+		for u := range units {
+			valid = append(valid, u)
+		}
+		sort.Slice(valid, func(a, b int) bool { return units[valid[a]] < units[valid[b]] })
+		return 0, fmt.Errorf("unknown unit %q, expected one of %s", unit, strings.Join(valid, ", "))
+	}
+	return n * scale / (units[base] * baseScale), nil
+}
+
+// splitUnit splits a pg_settings unit like "8kB" into its unit and factor
+func splitUnit(unit string) (string, float64) {
+	i := strings.IndexFunc(unit, func(r rune) bool { return r < '0' || r > '9' })
+	if i <= 0 {
+		return unit, 1
+	}
+	factor, _ := strconv.ParseFloat(unit[:i], 64)
+	return unit[i:], factor
+}
+
+func unitSuffix(unit string) string {
+	if unit == "" {
+		return ""
+	}
+	return " (in " + unit + ")"
+}
+
+// Recommendation is a suggested setting value derived from resource limits
+type Recommendation struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+	// Current is the value the server uses now, when it is running
+	Current string `json:"current,omitempty"`
+}
+
+// RecommendConfig suggests memory, connection and parallelism settings for a
+// server limited to maxRAMMB of memory and maxCPU cores
+func RecommendConfig(maxRAMMB int, maxCPU float64) []Recommendation {
+	ramKB := maxRAMMB * 1024
+	cpus := int(math.Ceil(maxCPU))
+	if cpus < 1 {
+		cpus = 1
+	}
+
+	// Each connection needs a few MB of its own besides work_mem
+	maxConnections := clamp(maxRAMMB/5, 20, 500)
+	sharedBuffersKB := ramKB / 4
+	workMemKB := clamp((ramKB-sharedBuffersKB)/(maxConnections*3), 64, 256*1024)
+	maintenanceWorkMemKB := clamp(ramKB/16, 1024, 2*1024*1024)
+	walBuffersKB := clamp(sharedBuffersKB*3/100, 64, 16*1024)
+
+	return []Recommendation{
+		{Name: "max_connections", Value: strconv.Itoa(maxConnections),
+			Reason: "One connection per 5 MB of memory, between 20 and 500; use the proxy's pooling for more clients"},
+		{Name: "shared_buffers", Value: formatKB(sharedBuffersKB),
+			Reason: "25% of the memory limit"},
+		{Name: "effective_cache_size", Value: formatKB(ramKB * 3 / 4),
+			Reason: "75% of the memory limit, shared buffers plus the OS page cache"},
+		{Name: "work_mem", Value: formatKB(workMemKB),
+			Reason: "Memory outside shared buffers split over max_connections, three sorts or hashes each"},
+		{Name: "maintenance_work_mem", Value: formatKB(maintenanceWorkMemKB),
+			Reason: "1/16 of the memory limit, at most 2 GB"},
+		{Name: "wal_buffers", Value: formatKB(walBuffersKB),
+			Reason: "3% of shared buffers, at most 16 MB"},
+		{Name: "max_worker_processes", Value: strconv.Itoa(max(8, cpus)),
+			Reason: "At least 8, one per core on larger limits"},
+		{Name: "max_parallel_workers", Value: strconv.Itoa(cpus),
+			Reason: "One per core of the CPU limit"},
+		{Name: "max_parallel_workers_per_gather", Value: strconv.Itoa(cpus / 2),
+			Reason: "Half the cores, so parallel queries leave room for others"},
+		{Name: "max_parallel_maintenance_workers", Value: strconv.Itoa(cpus / 2),
+			Reason: "Half the cores, for index builds and vacuums"},
+	}
+}
+
+func clamp(n, lo, hi int) int {
+	return min(max(n, lo), hi)
+}
+
+// formatKB prints kilobytes in the largest unit that divides them
+func formatKB(kb int) string {
+	switch {
+	case kb%(1024*1024) == 0:
+		return fmt.Sprintf("%dGB", kb/(1024*1024))
+	case kb%1024 == 0:
+		return fmt.Sprintf("%dMB", kb/1024)
+	}
+	return fmt.Sprintf("%dkB", kb)
+}
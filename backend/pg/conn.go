@@ -0,0 +1,75 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"baseful/db"
+	"baseful/suspend"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ApplicationName identifies Baseful's own sessions in pg_stat_activity
+const ApplicationName = "baseful-browser"
+
+// Connect opens a direct connection to a managed database as the postgres superuser.
+// Like the proxy, it tries the container's internal host first and falls back to
+// the mapped port on localhost when the backend runs outside the Docker network.
+// Sleeping databases are woken up first.
+func Connect(ctx context.Context, databaseID int) (*pgx.Conn, error) {
+	dbInfo, err := db.GetDatabaseByID(databaseID)
+	if err != nil {
+		return nil, err
+	}
+	if dbInfo.Status == db.StatusSleeping {
+		if err := suspend.Wake(ctx, databaseID); err != nil {
+			return nil, fmt.Errorf("failed to wake database: %w", err)
+		}
+		if dbInfo, err = db.GetDatabaseByID(databaseID); err != nil {
+			return nil, err
+		}
+	}
+	suspend.Touch(databaseID)
+
+	// Databases on remote servers are only reachable on their published port
+	if dbInfo.ServerHost != "" {
+		conn, err := connectTo(ctx, dbInfo, dbInfo.ServerHost, dbInfo.MappedPort, 5*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to database: %w", err)
+		}
+		return conn, nil
+	}
+
+	conn, err := connectTo(ctx, dbInfo, dbInfo.Host, dbInfo.Port, 2*time.Second)
+	if err != nil && dbInfo.MappedPort > 0 {
+		conn, err = connectTo(ctx, dbInfo, "127.0.0.1", dbInfo.MappedPort, 5*time.Second)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return conn, nil
+}
+
+func connectTo(ctx context.Context, dbInfo *db.DatabaseInfo, host string, port int, timeout time.Duration) (*pgx.Conn, error) {
+	connURL := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword("postgres", dbInfo.Password),
+		Host:   fmt.Sprintf("%s:%d", host, port),
+		Path:   "/" + dbInfo.Name,
+	}
+	query := connURL.Query()
+	query.Set("sslmode", "disable")
+	query.Set("application_name", ApplicationName)
+	connURL.RawQuery = query.Encode()
+
+	cfg, err := pgx.ParseConfig(connURL.String())
+	if err != nil {
+		return nil, err
+	}
+	cfg.ConnectTimeout = timeout
+
+	return pgx.ConnectConfig(ctx, cfg)
+}
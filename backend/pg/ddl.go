@@ -0,0 +1,468 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ColumnDef describes a column for create_table and add_column
+type ColumnDef struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	NotNull    bool   `json:"notNull,omitempty"`
+	Default    string `json:"default,omitempty"` // SQL expression
+	PrimaryKey bool   `json:"primaryKey,omitempty"`
+	Unique     bool   `json:"unique,omitempty"`
+	Identity   bool   `json:"identity,omitempty"`
+}
+
+// ColumnAlter describes changes to an existing column. Nil fields are left untouched;
+// an empty Default drops the column default.
+type ColumnAlter struct {
+	Type    string  `json:"type,omitempty"`
+	Using   string  `json:"using,omitempty"` // SQL expression converting existing values
+	NotNull *bool   `json:"notNull,omitempty"`
+	Default *string `json:"default,omitempty"`
+}
+
+// IndexDef describes an index for create_index
+type IndexDef struct {
+	Name    string   `json:"name,omitempty"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique,omitempty"`
+	Method  string   `json:"method,omitempty"`
+	Where   string   `json:"where,omitempty"` // SQL predicate for partial indexes
+}
+
+// ConstraintDef describes a table constraint for add_constraint and create_table
+type ConstraintDef struct {
+	Name              string   `json:"name,omitempty"`
+	Type              string   `json:"type"` // primary_key, unique, check, foreign_key
+	Columns           []string `json:"columns,omitempty"`
+	Expression        string   `json:"expression,omitempty"` // check constraints
+	ReferencedSchema  string   `json:"referencedSchema,omitempty"`
+	ReferencedTable   string   `json:"referencedTable,omitempty"`
+	ReferencedColumns []string `json:"referencedColumns,omitempty"`
+	OnDelete          string   `json:"onDelete,omitempty"`
+	OnUpdate          string   `json:"onUpdate,omitempty"`
+}
+
+// SchemaOperation is a single structured schema change
+type SchemaOperation struct {
+	Op          string          `json:"op"`
+	Schema      string          `json:"schema,omitempty"`
+	Table       string          `json:"table,omitempty"`
+	Name        string          `json:"name,omitempty"`
+	NewName     string          `json:"newName,omitempty"`
+	Columns     []ColumnDef     `json:"columns,omitempty"`
+	Column      *ColumnDef      `json:"column,omitempty"`
+	Alter       *ColumnAlter    `json:"alter,omitempty"`
+	Index       *IndexDef       `json:"index,omitempty"`
+	Constraint  *ConstraintDef  `json:"constraint,omitempty"`
+	Constraints []ConstraintDef `json:"constraints,omitempty"`
+	Values      []string        `json:"values,omitempty"`
+	IfExists    bool            `json:"ifExists,omitempty"`
+	IfNotExists bool            `json:"ifNotExists,omitempty"`
+	Cascade     bool            `json:"cascade,omitempty"`
+}
+
+// Type names may contain modifiers and array bounds but nothing that could end the statement
+var typeNamePattern = regexp.MustCompile(`^[A-Za-z_"][A-Za-z0-9_ ."(),\[\]]*$`)
+
+var indexMethods = map[string]bool{"btree": true, "hash": true, "gist": true, "gin": true, "spgist": true, "brin": true}
+
+var referentialActions = map[string]string{
+	"no_action":   "NO ACTION",
+	"restrict":    "RESTRICT",
+	"cascade":     "CASCADE",
+	"set_null":    "SET NULL",
+	"set_default": "SET DEFAULT",
+}
+
+// BuildDDL validates the operations and renders them as SQL statements
+func BuildDDL(ops []SchemaOperation) ([]string, error) {
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("no operations given: %w", ErrInvalidRequest)
+	}
+
+	var statements []string
+	for i, op := range ops {
+		stmts, err := op.statements()
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+		}
+		statements = append(statements, stmts...)
+	}
+	return statements, nil
+}
+
+// ApplySchemaOperations runs the generated DDL in a single transaction and returns
+// the executed statements
+func ApplySchemaOperations(ctx context.Context, conn *pgx.Conn, ops []SchemaOperation) ([]string, error) {
+	statements, err := BuildDDL(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	for i, stmt := range statements {
+		// The extended protocol refuses multiple commands, so user supplied
+		// expressions cannot smuggle additional statements
+		if _, err := tx.Exec(ctx, stmt, pgx.QueryExecModeExec); err != nil {
+			return nil, fmt.Errorf("statement %d failed: %w", i+1, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return statements, nil
+}
+
+func (op SchemaOperation) statements() ([]string, error) {
+	schema := op.Schema
+	if schema == "" {
+		schema = "public"
+	}
+
+	switch op.Op {
+	case "create_table", "drop_table", "rename_table", "add_column", "drop_column",
+		"rename_column", "alter_column", "create_index", "add_constraint", "drop_constraint":
+		if op.Table == "" {
+			return nil, fmt.Errorf("table is required: %w", ErrInvalidRequest)
+		}
+	}
+	table := pgx.Identifier{schema, op.Table}.Sanitize()
+
+	switch op.Op {
+	case "create_table":
+		return op.createTable(table)
+
+	case "drop_table":
+		return []string{fmt.Sprintf("DROP TABLE %s%s%s", ifExists(op.IfExists), table, cascade(op.Cascade))}, nil
+
+	case "rename_table":
+		if op.NewName == "" {
+			return nil, fmt.Errorf("newName is required: %w", ErrInvalidRequest)
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s RENAME TO %s", table, quoteIdent(op.NewName))}, nil
+
+	case "add_column":
+		if op.Column == nil {
+			return nil, fmt.Errorf("column is required: %w", ErrInvalidRequest)
+		}
+		def, err := columnDefinition(*op.Column, true)
+		if err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s%s", table, ifNotExists(op.IfNotExists), def)}, nil
+
+	case "drop_column":
+		if op.Name == "" {
+			return nil, fmt.Errorf("name is required: %w", ErrInvalidRequest)
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s%s%s", table, ifExists(op.IfExists), quoteIdent(op.Name), cascade(op.Cascade))}, nil
+
+	case "rename_column":
+		if op.Name == "" || op.NewName == "" {
+			return nil, fmt.Errorf("name and newName are required: %w", ErrInvalidRequest)
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table, quoteIdent(op.Name), quoteIdent(op.NewName))}, nil
+
+	case "alter_column":
+		return op.alterColumn(table)
+
+	case "create_index":
+		return op.createIndex(schema, table)
+
+	case "drop_index":
+		if op.Name == "" {
+			return nil, fmt.Errorf("name is required: %w", ErrInvalidRequest)
+		}
+		return []string{fmt.Sprintf("DROP INDEX %s%s%s", ifExists(op.IfExists), pgx.Identifier{schema, op.Name}.Sanitize(), cascade(op.Cascade))}, nil
+
+	case "add_constraint":
+		if op.Constraint == nil {
+			return nil, fmt.Errorf("constraint is required: %w", ErrInvalidRequest)
+		}
+		def, err := constraintDefinition(*op.Constraint, schema)
+		if err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s ADD %s", table, def)}, nil
+
+	case "drop_constraint":
+		if op.Name == "" {
+			return nil, fmt.Errorf("name is required: %w", ErrInvalidRequest)
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s%s%s", table, ifExists(op.IfExists), quoteIdent(op.Name), cascade(op.Cascade))}, nil
+
+	case "create_enum":
+		if op.Name == "" || len(op.Values) == 0 {
+			return nil, fmt.Errorf("name and values are required: %w", ErrInvalidRequest)
+		}
+		labels := make([]string, len(op.Values))
+		for i, v := range op.Values {
+			labels[i] = quoteLiteral(v)
+		}
+		return []string{fmt.Sprintf("CREATE TYPE %s AS ENUM (%s)", pgx.Identifier{schema, op.Name}.Sanitize(), strings.Join(labels, ", "))}, nil
+
+	case "add_enum_values":
+		if op.Name == "" || len(op.Values) == 0 {
+			return nil, fmt.Errorf("name and values are required: %w", ErrInvalidRequest)
+		}
+		var stmts []string
+		for _, v := range op.Values {
+			stmts = append(stmts, fmt.Sprintf("ALTER TYPE %s ADD VALUE %s%s", pgx.Identifier{schema, op.Name}.Sanitize(), ifNotExists(op.IfNotExists), quoteLiteral(v)))
+		}
+		return stmts, nil
+
+	case "drop_enum":
+		if op.Name == "" {
+			return nil, fmt.Errorf("name is required: %w", ErrInvalidRequest)
+		}
+		return []string{fmt.Sprintf("DROP TYPE %s%s%s", ifExists(op.IfExists), pgx.Identifier{schema, op.Name}.Sanitize(), cascade(op.Cascade))}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported operation %q: %w", op.Op, ErrInvalidRequest)
+}
+
+func (op SchemaOperation) createTable(table string) ([]string, error) {
+	if len(op.Columns) == 0 {
+		return nil, fmt.Errorf("at least one column is required: %w", ErrInvalidRequest)
+	}
+
+	// Composite primary keys become a table constraint
+	var pkColumns []string
+	for _, col := range op.Columns {
+		if col.PrimaryKey {
+			pkColumns = append(pkColumns, col.Name)
+		}
+	}
+	inlinePK := len(pkColumns) == 1
+
+	var parts []string
+	for _, col := range op.Columns {
+		def, err := columnDefinition(col, inlinePK)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, def)
+	}
+	if len(pkColumns) > 1 {
+		parts = append(parts, fmt.Sprintf("PRIMARY KEY (%s)", quoteIdents(pkColumns)))
+	}
+	for _, con := range op.Constraints {
+		def, err := constraintDefinition(con, op.Schema)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, def)
+	}
+
+	return []string{fmt.Sprintf("CREATE TABLE %s%s (\n    %s\n)", ifNotExists(op.IfNotExists), table, strings.Join(parts, ",\n    "))}, nil
+}
+
+func (op SchemaOperation) alterColumn(table string) ([]string, error) {
+	if op.Name == "" || op.Alter == nil {
+		return nil, fmt.Errorf("name and alter are required: %w", ErrInvalidRequest)
+	}
+
+	column := quoteIdent(op.Name)
+	var actions []string
+	if op.Alter.Type != "" {
+		if err := validateType(op.Alter.Type); err != nil {
+			return nil, err
+		}
+		action := fmt.Sprintf("ALTER COLUMN %s TYPE %s", column, op.Alter.Type)
+		if op.Alter.Using != "" {
+			action += " USING " + op.Alter.Using
+		}
+		actions = append(actions, action)
+	}
+	if op.Alter.NotNull != nil {
+		if *op.Alter.NotNull {
+			actions = append(actions, fmt.Sprintf("ALTER COLUMN %s SET NOT NULL", column))
+		} else {
+			actions = append(actions, fmt.Sprintf("ALTER COLUMN %s DROP NOT NULL", column))
+		}
+	}
+	if op.Alter.Default != nil {
+		if *op.Alter.Default == "" {
+			actions = append(actions, fmt.Sprintf("ALTER COLUMN %s DROP DEFAULT", column))
+		} else {
+			actions = append(actions, fmt.Sprintf("ALTER COLUMN %s SET DEFAULT %s", column, *op.Alter.Default))
+		}
+	}
+	if len(actions) == 0 {
+		return nil, fmt.Errorf("nothing to alter: %w", ErrInvalidRequest)
+	}
+
+	return []string{fmt.Sprintf("ALTER TABLE %s %s", table, strings.Join(actions, ", "))}, nil
+}
+
+func (op SchemaOperation) createIndex(schema, table string) ([]string, error) {
+	if op.Index == nil || len(op.Index.Columns) == 0 {
+		return nil, fmt.Errorf("index with at least one column is required: %w", ErrInvalidRequest)
+	}
+
+	method := strings.ToLower(op.Index.Method)
+	if method == "" {
+		method = "btree"
+	}
+	if !indexMethods[method] {
+		return nil, fmt.Errorf("unsupported index method %q: %w", op.Index.Method, ErrInvalidRequest)
+	}
+
+	var b strings.Builder
+	b.WriteString("CREATE ")
+	if op.Index.Unique {
+		b.WriteString("UNIQUE ")
+	}
+	b.WriteString("INDEX ")
+	if op.Index.Name != "" {
+		b.WriteString(ifNotExists(op.IfNotExists))
+		b.WriteString(quoteIdent(op.Index.Name))
+		b.WriteString(" ")
+	}
+	fmt.Fprintf(&b, "ON %s USING %s (%s)", table, method, quoteIdents(op.Index.Columns))
+	if op.Index.Where != "" {
+		b.WriteString(" WHERE ")
+		b.WriteString(op.Index.Where)
+	}
+	return []string{b.String()}, nil
+}
+
+func columnDefinition(col ColumnDef, allowPrimaryKey bool) (string, error) {
+	if col.Name == "" {
+		return "", fmt.Errorf("column name is required: %w", ErrInvalidRequest)
+	}
+	if err := validateType(col.Type); err != nil {
+		return "", err
+	}
+
+	def := quoteIdent(col.Name) + " " + col.Type
+	if col.Identity {
+		def += " GENERATED BY DEFAULT AS IDENTITY"
+	}
+	if col.NotNull {
+		def += " NOT NULL"
+	}
+	if col.Default != "" {
+		def += " DEFAULT " + col.Default
+	}
+	if col.PrimaryKey && allowPrimaryKey {
+		def += " PRIMARY KEY"
+	}
+	if col.Unique {
+		def += " UNIQUE"
+	}
+	return def, nil
+}
+
+func constraintDefinition(con ConstraintDef, schema string) (string, error) {
+	var def string
+	switch con.Type {
+	case "primary_key", "unique":
+		if len(con.Columns) == 0 {
+			return "", fmt.Errorf("%s constraint needs columns: %w", con.Type, ErrInvalidRequest)
+		}
+		keyword := "UNIQUE"
+		if con.Type == "primary_key" {
+			keyword = "PRIMARY KEY"
+		}
+		def = fmt.Sprintf("%s (%s)", keyword, quoteIdents(con.Columns))
+
+	case "check":
+		if con.Expression == "" {
+			return "", fmt.Errorf("check constraint needs an expression: %w", ErrInvalidRequest)
+		}
+		def = fmt.Sprintf("CHECK (%s)", con.Expression)
+
+	case "foreign_key":
+		if len(con.Columns) == 0 || con.ReferencedTable == "" {
+			return "", fmt.Errorf("foreign key needs columns and referencedTable: %w", ErrInvalidRequest)
+		}
+		refSchema := con.ReferencedSchema
+		if refSchema == "" {
+			refSchema = schema
+		}
+		if refSchema == "" {
+			refSchema = "public"
+		}
+		def = fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s", quoteIdents(con.Columns), pgx.Identifier{refSchema, con.ReferencedTable}.Sanitize())
+		if len(con.ReferencedColumns) > 0 {
+			def += fmt.Sprintf(" (%s)", quoteIdents(con.ReferencedColumns))
+		}
+		for _, action := range []struct{ clause, value string }{{"ON DELETE", con.OnDelete}, {"ON UPDATE", con.OnUpdate}} {
+			if action.value == "" {
+				continue
+			}
+			sqlAction, ok := referentialActions[action.value]
+			if !ok {
+				return "", fmt.Errorf("unsupported referential action %q: %w", action.value, ErrInvalidRequest)
+			}
+			def += " " + action.clause + " " + sqlAction
+		}
+
+	default:
+		return "", fmt.Errorf("unsupported constraint type %q: %w", con.Type, ErrInvalidRequest)
+	}
+
+	if con.Name != "" {
+		def = "CONSTRAINT " + quoteIdent(con.Name) + " " + def
+	}
+	return def, nil
+}
+
+func validateType(typeName string) error {
+	if typeName == "" {
+		return fmt.Errorf("column type is required: %w", ErrInvalidRequest)
+	}
+	if !typeNamePattern.MatchString(typeName) {
+		return fmt.Errorf("invalid column type %q: %w", typeName, ErrInvalidRequest)
+	}
+	return nil
+}
+
+func quoteIdents(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteIdent(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+func ifExists(enabled bool) string {
+	if enabled {
+		return "IF EXISTS "
+	}
+	return ""
+}
+
+func ifNotExists(enabled bool) string {
+	if enabled {
+		return "IF NOT EXISTS "
+	}
+	return ""
+}
+
+func cascade(enabled bool) string {
+	if enabled {
+		return " CASCADE"
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// StatementResult is the outcome of one executed statement. Values are returned
+// in Postgres' text format, as psql would print them; NULL is nil.
+type StatementResult struct {
+	Statement    string      `json:"statement"`
+	CommandTag   string      `json:"commandTag"`
+	RowsAffected int64       `json:"rowsAffected"`
+	Columns      []string    `json:"columns"`
+	Rows         [][]*string `json:"rows"`
+	Truncated    bool        `json:"truncated"`
+}
+
+// ExecuteStatements runs statements in order. Read-only execution happens in a
+// READ ONLY transaction that is rolled back; otherwise multiple statements run
+// in one transaction so a failure leaves nothing half applied.
+func ExecuteStatements(ctx context.Context, conn *pgx.Conn, statements []string, readOnly bool, maxRows int) ([]StatementResult, error) {
+	if len(statements) == 0 {
+		return nil, fmt.Errorf("no statements given: %w", ErrInvalidRequest)
+	}
+
+	// A single write runs on its own so statements that refuse to run inside a
+	// transaction block (CREATE INDEX CONCURRENTLY, VACUUM) still work
+	if !readOnly && len(statements) == 1 {
+		result, err := executeStatement(ctx, conn, statements[0], maxRows)
+		if err != nil {
+			return nil, err
+		}
+		return []StatementResult{*result}, nil
+	}
+
+	txOptions := pgx.TxOptions{}
+	if readOnly {
+		txOptions.AccessMode = pgx.ReadOnly
+	}
+	tx, err := conn.BeginTx(ctx, txOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var results []StatementResult
+	for i, stmt := range statements {
+		result, err := executeStatement(ctx, tx.Conn(), stmt, maxRows)
+		if err != nil {
+			return nil, fmt.Errorf("statement %d: %w", i+1, err)
+		}
+		results = append(results, *result)
+	}
+
+	if readOnly {
+		return results, nil
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func executeStatement(ctx context.Context, conn *pgx.Conn, stmt string, maxRows int) (*StatementResult, error) {
+	// The simple protocol returns every value as text, whatever its type
+	rows, err := conn.Query(ctx, stmt, pgx.QueryExecModeSimpleProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &StatementResult{Statement: stmt, Columns: []string{}, Rows: [][]*string{}}
+	for _, field := range rows.FieldDescriptions() {
+		result.Columns = append(result.Columns, field.Name)
+	}
+	for rows.Next() {
+		if len(result.Rows) >= maxRows {
+			result.Truncated = true
+			continue
+		}
+		raw := rows.RawValues()
+		row := make([]*string, len(raw))
+		for i, value := range raw {
+			if value != nil {
+				text := string(value)
+				row[i] = &text
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tag := rows.CommandTag()
+	result.CommandTag = tag.String()
+	result.RowsAffected = tag.RowsAffected()
+	return result, nil
+}
+
+// ExplainQuery returns the text plan of a query. With analyze set, the query is
+// executed inside a read-only transaction that is always rolled back, and
+// buffer usage is included. Statements that write, or call functions with
+// side effects such as nextval, fail instead of being analyzed.
+func ExplainQuery(ctx context.Context, conn *pgx.Conn, query string, analyze bool) (string, error) {
+	statements := SplitStatements(query)
+	if len(statements) != 1 {
+		return "", fmt.Errorf("exactly one statement can be explained: %w", ErrInvalidRequest)
+	}
+
+	options := "FORMAT TEXT"
+	var txOptions pgx.TxOptions
+	if analyze {
+		options = "ANALYZE, BUFFERS, FORMAT TEXT"
+		// A rollback does not undo everything, e.g. sequence increments
+		txOptions.AccessMode = pgx.ReadOnly
+	}
+
+	tx, err := conn.BeginTx(ctx, txOptions)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, fmt.Sprintf("EXPLAIN (%s) %s", options, statements[0]), pgx.QueryExecModeSimpleProtocol)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		lines = append(lines, string(rows.RawValues()[0]))
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
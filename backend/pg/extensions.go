@@ -0,0 +1,194 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrRestartRequired is returned when an extension's library was added to
+// shared_preload_libraries and the server must restart before it can be
+// enabled
+var ErrRestartRequired = errors.New("restart required")
+
+// preloadLibraries maps extensions that only work when their library is
+// loaded at server start to that library
+var preloadLibraries = map[string]string{
+	"pg_stat_statements": "pg_stat_statements",
+	"timescaledb":        "timescaledb",
+	"pg_cron":            "pg_cron",
+	"pgaudit":            "pgaudit",
+	"pg_squeeze":         "pg_squeeze",
+	"pg_partman":         "pg_partman_bgw",
+}
+
+// AvailableExtension is an extension the server can install, from
+// pg_available_extensions
+type AvailableExtension struct {
+	Name           string   `json:"name"`
+	Comment        string   `json:"comment"`
+	DefaultVersion string   `json:"defaultVersion"`
+	Versions       []string `json:"versions"`
+	// InstalledVersion and Schema are set when the extension is enabled
+	InstalledVersion *string `json:"installedVersion"`
+	Schema           *string `json:"schema"`
+	// RequiresPreload is set for extensions whose library must be in
+	// shared_preload_libraries
+	RequiresPreload bool `json:"requiresPreload"`
+	Preloaded       bool `json:"preloaded"`
+}
+
+// ListAvailableExtensions returns the extensions available on the server
+// ordered by name, with the installed version in the connected database
+func ListAvailableExtensions(ctx context.Context, q Querier) ([]AvailableExtension, error) {
+	var preload string
+	if err := q.QueryRow(ctx, "SELECT current_setting('shared_preload_libraries')").Scan(&preload); err != nil {
+		return nil, fmt.Errorf("failed to read shared_preload_libraries: %w", err)
+	}
+	loaded := splitLibraries(preload)
+
+	rows, err := q.Query(ctx, `
+		SELECT a.name::text, COALESCE(a.comment, ''), COALESCE(a.default_version, ''),
+			COALESCE((SELECT array_agg(v.version ORDER BY v.version) FROM pg_available_extension_versions v WHERE v.name = a.name), '{}'),
+			e.extversion, n.nspname::text
+		FROM pg_available_extensions a
+		LEFT JOIN pg_extension e ON e.extname = a.name
+		LEFT JOIN pg_namespace n ON n.oid = e.extnamespace
+		ORDER BY a.name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extensions: %w", err)
+	}
+	defer rows.Close()
+
+	extensions := []AvailableExtension{}
+	for rows.Next() {
+		var e AvailableExtension
+		if err := rows.Scan(&e.Name, &e.Comment, &e.DefaultVersion, &e.Versions, &e.InstalledVersion, &e.Schema); err != nil {
+			return nil, err
+		}
+		if library, ok := preloadLibraries[e.Name]; ok {
+			e.RequiresPreload = true
+			e.Preloaded = slices.Contains(loaded, library)
+		}
+		extensions = append(extensions, e)
+	}
+	return extensions, rows.Err()
+}
+
+// GetAvailableExtension returns an available extension by name
+func GetAvailableExtension(ctx context.Context, q Querier, name string) (*AvailableExtension, error) {
+	extensions, err := ListAvailableExtensions(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	for i := range extensions {
+		if extensions[i].Name == name {
+			return &extensions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("extension %s is not available in this image: %w", name, ErrObjectNotFound)
+}
+
+// EnableExtension installs an extension, or updates it when it is installed
+// in another version. An empty version installs the default one. Extensions
+// that must be preloaded are added to shared_preload_libraries first, and
+// ErrRestartRequired asks to enable them again after a restart.
+func EnableExtension(ctx context.Context, conn *pgx.Conn, name, version, schema string, cascade bool) (*AvailableExtension, error) {
+	ext, err := GetAvailableExtension(ctx, conn, name)
+	if err != nil {
+		return nil, err
+	}
+	if version != "" && !slices.Contains(ext.Versions, version) {
+		return nil, fmt.Errorf("%s has no version %s, available: %s: %w", name, version, strings.Join(ext.Versions, ", "), ErrInvalidRequest)
+	}
+
+	if ext.RequiresPreload && !ext.Preloaded {
+		if err := addPreloadLibrary(ctx, conn, preloadLibraries[name]); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s was added to shared_preload_libraries; restart the database and enable it again: %w",
+			preloadLibraries[name], ErrRestartRequired)
+	}
+
+	ident := pgx.Identifier{name}.Sanitize()
+	var statement string
+	switch {
+	case ext.InstalledVersion == nil:
+		statement = "CREATE EXTENSION " + ident
+		if schema != "" {
+			statement += " SCHEMA " + pgx.Identifier{schema}.Sanitize()
+		}
+		if version != "" {
+			statement += " VERSION " + quoteLiteral(version)
+		}
+		if cascade {
+			statement += " CASCADE"
+		}
+	case version != "" && version != *ext.InstalledVersion:
+		statement = "ALTER EXTENSION " + ident + " UPDATE TO " + quoteLiteral(version)
+	case schema != "" && schema != *ext.Schema:
+		statement = "ALTER EXTENSION " + ident + " SET SCHEMA " + pgx.Identifier{schema}.Sanitize()
+	default:
+		return ext, nil
+	}
+	if _, err := conn.Exec(ctx, statement); err != nil {
+		return nil, err
+	}
+	return GetAvailableExtension(ctx, conn, name)
+}
+
+// DisableExtension drops an installed extension. Objects depending on it,
+// like columns of its types, are only dropped with cascade.
+func DisableExtension(ctx context.Context, conn *pgx.Conn, name string, cascade bool) error {
+	ext, err := GetAvailableExtension(ctx, conn, name)
+	if err != nil {
+		return err
+	}
+	if ext.InstalledVersion == nil {
+		return fmt.Errorf("extension %s is not enabled: %w", name, ErrObjectNotFound)
+	}
+	statement := "DROP EXTENSION " + pgx.Identifier{name}.Sanitize()
+	if cascade {
+		statement += " CASCADE"
+	}
+	_, err = conn.Exec(ctx, statement)
+	return err
+}
+
+// addPreloadLibrary appends a library to shared_preload_libraries. The
+// setting only takes effect on the next start.
+func addPreloadLibrary(ctx context.Context, conn *pgx.Conn, library string) error {
+	// Read the value of the configuration files, which may already hold
+	// libraries added since the server started
+	var preload string
+	err := conn.QueryRow(ctx, `
+		SELECT COALESCE((SELECT setting FROM pg_file_settings
+			WHERE name = 'shared_preload_libraries' AND error IS NULL ORDER BY seqno DESC LIMIT 1),
+			current_setting('shared_preload_libraries'))`).Scan(&preload)
+	if err != nil {
+		return fmt.Errorf("failed to read shared_preload_libraries: %w", err)
+	}
+	libraries := splitLibraries(preload)
+	if slices.Contains(libraries, library) {
+		return nil
+	}
+	value := strings.Join(append(libraries, library), ",")
+	if _, err := conn.Exec(ctx, AlterSystemStatement("shared_preload_libraries", &value)); err != nil {
+		return fmt.Errorf("failed to set shared_preload_libraries: %w", err)
+	}
+	return nil
+}
+
+func splitLibraries(setting string) []string {
+	libraries := []string{}
+	for _, library := range strings.Split(setting, ",") {
+		if library = strings.Trim(strings.TrimSpace(library), `"`); library != "" {
+			libraries = append(libraries, library)
+		}
+	}
+	return libraries
+}
@@ -0,0 +1,45 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// LogSettings are the server settings that control which statements reach
+// the Postgres log
+type LogSettings struct {
+	// LogMinDurationStatement logs statements running at least this many
+	// milliseconds; -1 disables it and 0 logs every statement
+	LogMinDurationStatement int `json:"logMinDurationStatement"`
+}
+
+// GetLogSettings reads the current log settings
+func GetLogSettings(ctx context.Context, q Querier) (*LogSettings, error) {
+	var settings LogSettings
+	err := q.QueryRow(ctx,
+		"SELECT setting::int FROM pg_settings WHERE name = 'log_min_duration_statement'",
+	).Scan(&settings.LogMinDurationStatement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log settings: %w", err)
+	}
+	return &settings, nil
+}
+
+// SetLogMinDurationStatement persists log_min_duration_statement with ALTER
+// SYSTEM, so it survives restarts and is copied into new branches, and
+// reloads the configuration
+func SetLogMinDurationStatement(ctx context.Context, conn *pgx.Conn, ms int) error {
+	if ms < -1 {
+		return fmt.Errorf("log_min_duration_statement must be -1 or more")
+	}
+	// ALTER SYSTEM takes no parameters; ms is an integer
+	if _, err := conn.Exec(ctx, fmt.Sprintf("ALTER SYSTEM SET log_min_duration_statement = %d", ms)); err != nil {
+		return fmt.Errorf("failed to set log_min_duration_statement: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "SELECT pg_reload_conf()"); err != nil {
+		return fmt.Errorf("failed to reload configuration: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+)
+
+// Index describes an index on a table
+type Index struct {
+	Name       string   `json:"name"`
+	Method     string   `json:"method"`
+	Columns    []string `json:"columns"`
+	IsUnique   bool     `json:"isUnique"`
+	IsPrimary  bool     `json:"isPrimary"`
+	Definition string   `json:"definition"`
+}
+
+// Constraint describes a table constraint
+type Constraint struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Columns    []string `json:"columns"`
+	Definition string   `json:"definition"`
+}
+
+// ForeignKey describes a foreign key constraint and the table it references
+type ForeignKey struct {
+	Name              string   `json:"name"`
+	Columns           []string `json:"columns"`
+	ReferencedSchema  string   `json:"referencedSchema"`
+	ReferencedTable   string   `json:"referencedTable"`
+	ReferencedColumns []string `json:"referencedColumns"`
+	OnUpdate          string   `json:"onUpdate"`
+	OnDelete          string   `json:"onDelete"`
+}
+
+// TableMetadata holds the structural metadata of a table beyond its columns
+type TableMetadata struct {
+	Indexes     []Index      `json:"indexes"`
+	Constraints []Constraint `json:"constraints"`
+	ForeignKeys []ForeignKey `json:"foreignKeys"`
+}
+
+// EnumType describes a user defined enum
+type EnumType struct {
+	Schema string   `json:"schema"`
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+var constraintTypes = map[string]string{
+	"p": "primary_key",
+	"u": "unique",
+	"f": "foreign_key",
+	"c": "check",
+	"x": "exclusion",
+	"n": "not_null",
+	"t": "trigger",
+}
+
+var foreignKeyActions = map[string]string{
+	"a": "no_action",
+	"r": "restrict",
+	"c": "cascade",
+	"n": "set_null",
+	"d": "set_default",
+}
+
+// GetTableMetadata returns the indexes, constraints and foreign keys of a table
+func GetTableMetadata(ctx context.Context, q Querier, schema, table string) (*TableMetadata, error) {
+	meta := &TableMetadata{
+		Indexes:     []Index{},
+		Constraints: []Constraint{},
+		ForeignKeys: []ForeignKey{},
+	}
+
+	indexRows, err := q.Query(ctx, `
+		SELECT i.relname::text, am.amname::text, ix.indisunique, ix.indisprimary,
+			pg_get_indexdef(ix.indexrelid),
+			ARRAY(
+				SELECT pg_get_indexdef(ix.indexrelid, k + 1, true)
+				FROM generate_subscripts(ix.indkey, 1) AS k
+				ORDER BY k
+			)
+		FROM pg_index ix
+		JOIN pg_class i ON i.oid = ix.indexrelid
+		JOIN pg_class t ON t.oid = ix.indrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		JOIN pg_am am ON am.oid = i.relam
+		WHERE n.nspname = $1 AND t.relname = $2
+		ORDER BY i.relname
+	`, schema, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query indexes: %w", err)
+	}
+	for indexRows.Next() {
+		var idx Index
+		if err := indexRows.Scan(&idx.Name, &idx.Method, &idx.IsUnique, &idx.IsPrimary, &idx.Definition, &idx.Columns); err != nil {
+			indexRows.Close()
+			return nil, fmt.Errorf("failed to scan index: %w", err)
+		}
+		meta.Indexes = append(meta.Indexes, idx)
+	}
+	indexRows.Close()
+	if err := indexRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query indexes: %w", err)
+	}
+
+	conRows, err := q.Query(ctx, `
+		SELECT con.conname::text, con.contype::text, pg_get_constraintdef(con.oid, true),
+			ARRAY(
+				SELECT a.attname::text
+				FROM unnest(con.conkey) WITH ORDINALITY AS k(attnum, ord)
+				JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum
+				ORDER BY k.ord
+			),
+			COALESCE(fn.nspname::text, ''), COALESCE(ft.relname::text, ''),
+			ARRAY(
+				SELECT a.attname::text
+				FROM unnest(con.confkey) WITH ORDINALITY AS k(attnum, ord)
+				JOIN pg_attribute a ON a.attrelid = con.confrelid AND a.attnum = k.attnum
+				ORDER BY k.ord
+			),
+			con.confupdtype::text, con.confdeltype::text
+		FROM pg_constraint con
+		JOIN pg_class t ON t.oid = con.conrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		LEFT JOIN pg_class ft ON ft.oid = con.confrelid
+		LEFT JOIN pg_namespace fn ON fn.oid = ft.relnamespace
+		WHERE n.nspname = $1 AND t.relname = $2
+		ORDER BY con.conname
+	`, schema, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query constraints: %w", err)
+	}
+	defer conRows.Close()
+
+	for conRows.Next() {
+		var con Constraint
+		var conType, updType, delType string
+		var fk ForeignKey
+		if err := conRows.Scan(&con.Name, &conType, &con.Definition, &con.Columns,
+			&fk.ReferencedSchema, &fk.ReferencedTable, &fk.ReferencedColumns, &updType, &delType); err != nil {
+			return nil, fmt.Errorf("failed to scan constraint: %w", err)
+		}
+		con.Type = constraintTypes[conType]
+		if con.Type == "" {
+			con.Type = conType
+		}
+		meta.Constraints = append(meta.Constraints, con)
+
+		if con.Type == "foreign_key" {
+			fk.Name = con.Name
+			fk.Columns = con.Columns
+			fk.OnUpdate = foreignKeyActions[updType]
+			fk.OnDelete = foreignKeyActions[delType]
+			meta.ForeignKeys = append(meta.ForeignKeys, fk)
+		}
+	}
+	if err := conRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query constraints: %w", err)
+	}
+
+	return meta, nil
+}
+
+// ListEnums returns the enum types defined in a schema with their labels in sort order
+func ListEnums(ctx context.Context, q Querier, schema string) ([]EnumType, error) {
+	rows, err := q.Query(ctx, `
+		SELECT n.nspname::text, t.typname::text,
+			ARRAY(SELECT e.enumlabel::text FROM pg_enum e WHERE e.enumtypid = t.oid ORDER BY e.enumsortorder)
+		FROM pg_type t
+		JOIN pg_namespace n ON n.oid = t.typnamespace
+		WHERE t.typtype = 'e' AND n.nspname = $1
+		ORDER BY t.typname
+	`, schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query enums: %w", err)
+	}
+	defer rows.Close()
+
+	enums := []EnumType{}
+	for rows.Next() {
+		var e EnumType
+		if err := rows.Scan(&e.Schema, &e.Name, &e.Values); err != nil {
+			return nil, fmt.Errorf("failed to scan enum: %w", err)
+		}
+		enums = append(enums, e)
+	}
+	return enums, rows.Err()
+}
@@ -0,0 +1,463 @@
+package pg
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// MaxPageSize caps the number of rows returned by a single table query
+const MaxPageSize = 1000
+
+// Filter is a node in a filter expression. A node either combines child
+// filters with And/Or, or compares a single column against a value.
+type Filter struct {
+	And []Filter `json:"and,omitempty"`
+	Or  []Filter `json:"or,omitempty"`
+
+	Column string   `json:"column,omitempty"`
+	Op     string   `json:"op,omitempty"`
+	Value  any      `json:"value,omitempty"`
+	Values []any    `json:"values,omitempty"` // in, not_in and between
+	Path   []string `json:"path,omitempty"`   // JSON path into a json or jsonb column
+	AsText bool     `json:"asText,omitempty"` // compare the column's text representation
+}
+
+// SortKey orders results by one column
+type SortKey struct {
+	Column    string `json:"column"`
+	Direction string `json:"direction,omitempty"` // asc (default) or desc
+}
+
+// TableQuery describes a page of rows to read from a table.
+// Setting Keyset (or passing a Cursor) switches from offset to keyset pagination.
+type TableQuery struct {
+	Filter *Filter   `json:"filter,omitempty"`
+	Sort   []SortKey `json:"sort,omitempty"`
+	Limit  int       `json:"limit,omitempty"`
+	Offset int       `json:"offset,omitempty"`
+	Keyset bool      `json:"keyset,omitempty"`
+	Cursor string    `json:"cursor,omitempty"`
+}
+
+// TableResult is a page of rows. TotalCount is only computed for offset pagination;
+// NextCursor is only set for keyset pagination when more rows may follow.
+type TableResult struct {
+	Columns    []Column         `json:"columns"`
+	Rows       []map[string]any `json:"rows"`
+	TotalCount *int64           `json:"totalCount"`
+	NextCursor string           `json:"nextCursor,omitempty"`
+}
+
+var comparisonOps = map[string]string{
+	"eq":  "=",
+	"neq": "<>",
+	"lt":  "<",
+	"lte": "<=",
+	"gt":  ">",
+	"gte": ">=",
+}
+
+// QueryTable reads a filtered, sorted page of rows. Every value is bound as a
+// parameter; identifiers are checked against the table's columns.
+func QueryTable(ctx context.Context, q Querier, schema, table string, query TableQuery) (*TableResult, error) {
+	columns, err := GetColumns(ctx, q, schema, table)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Limit <= 0 {
+		query.Limit = 100
+	}
+	if query.Limit > MaxPageSize {
+		query.Limit = MaxPageSize
+	}
+	if query.Offset < 0 {
+		return nil, fmt.Errorf("offset must not be negative: %w", ErrInvalidRequest)
+	}
+	keyset := query.Keyset || query.Cursor != ""
+	if keyset && query.Offset > 0 {
+		return nil, fmt.Errorf("offset cannot be combined with keyset pagination: %w", ErrInvalidRequest)
+	}
+
+	sortKeys, err := resolveSort(columns, query.Sort, keyset)
+	if err != nil {
+		return nil, err
+	}
+
+	var args []any
+	var conds []string
+	if query.Filter != nil {
+		cond, err := compileFilter(columns, *query.Filter, &args)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+
+	target := pgx.Identifier{schema, table}.Sanitize()
+	result := &TableResult{Columns: columns}
+
+	if !keyset {
+		var total int64
+		countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s AS t%s", target, whereClause(conds))
+		if err := q.QueryRow(ctx, countQuery, args...).Scan(&total); err != nil {
+			return nil, err
+		}
+		result.TotalCount = &total
+	}
+
+	if query.Cursor != "" {
+		cond, err := keysetCondition(columns, sortKeys, query.Cursor, &args)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+
+	var order []string
+	for _, key := range sortKeys {
+		order = append(order, fmt.Sprintf("t.%s %s", quoteIdent(key.Column), strings.ToUpper(key.Direction)))
+	}
+	orderClause := ""
+	if len(order) > 0 {
+		orderClause = " ORDER BY " + strings.Join(order, ", ")
+	}
+
+	dataQuery := fmt.Sprintf("SELECT row_to_json(t) FROM %s AS t%s%s LIMIT %d", target, whereClause(conds), orderClause, query.Limit)
+	if !keyset {
+		dataQuery += fmt.Sprintf(" OFFSET %d", query.Offset)
+	}
+
+	rows, err := queryJSONRows(ctx, q, dataQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = []map[string]any{}
+	}
+	result.Rows = rows
+
+	if keyset && len(rows) == query.Limit {
+		last := rows[len(rows)-1]
+		values := make([]any, len(sortKeys))
+		for i, key := range sortKeys {
+			values[i] = last[key.Column]
+		}
+		cursor, err := json.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		result.NextCursor = base64.RawURLEncoding.EncodeToString(cursor)
+	}
+
+	return result, nil
+}
+
+// ParseSort parses "col1:asc,col2:desc" into sort keys
+func ParseSort(spec string) []SortKey {
+	var keys []SortKey
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		column, direction, _ := strings.Cut(part, ":")
+		keys = append(keys, SortKey{Column: column, Direction: direction})
+	}
+	return keys
+}
+
+// resolveSort validates the requested sort keys. Keyset pagination needs a total
+// order, so the primary key is appended as a tiebreaker when it is not already sorted on.
+func resolveSort(columns []Column, requested []SortKey, keyset bool) ([]SortKey, error) {
+	var keys []SortKey
+	seen := map[string]bool{}
+	for _, key := range requested {
+		col, err := findColumn(columns, key.Column)
+		if err != nil {
+			return nil, err
+		}
+		direction := strings.ToLower(key.Direction)
+		if direction == "" {
+			direction = "asc"
+		}
+		if direction != "asc" && direction != "desc" {
+			return nil, fmt.Errorf("invalid sort direction %q: %w", key.Direction, ErrInvalidRequest)
+		}
+		if seen[col.Name] {
+			continue
+		}
+		seen[col.Name] = true
+		keys = append(keys, SortKey{Column: col.Name, Direction: direction})
+	}
+
+	pk := PrimaryKey(columns)
+	if len(pk) == 0 {
+		if keyset {
+			return nil, fmt.Errorf("keyset pagination requires a primary key: %w", ErrInvalidRequest)
+		}
+		return keys, nil
+	}
+	// Offset pagination also gets a stable order, otherwise pages can overlap
+	for _, col := range pk {
+		if !seen[col.Name] {
+			keys = append(keys, SortKey{Column: col.Name, Direction: "asc"})
+		}
+	}
+	return keys, nil
+}
+
+// keysetCondition selects the rows that sort after the cursor position, following
+// Postgres' default NULL placement (last for ascending, first for descending)
+func keysetCondition(columns []Column, keys []SortKey, cursor string, args *[]any) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("invalid cursor: %w", ErrInvalidRequest)
+	}
+	values, err := decodeJSONArray(raw)
+	if err != nil || len(values) != len(keys) {
+		return "", fmt.Errorf("invalid cursor: %w", ErrInvalidRequest)
+	}
+
+	var branches []string
+	var equal []string
+	for i, key := range keys {
+		col, err := findColumn(columns, key.Column)
+		if err != nil {
+			return "", err
+		}
+		ident := "t." + quoteIdent(col.Name)
+
+		var after, same string
+		if values[i] == nil {
+			same = ident + " IS NULL"
+			if key.Direction == "asc" {
+				after = "FALSE"
+			} else {
+				after = ident + " IS NOT NULL"
+			}
+		} else {
+			param, err := bindValue(col, values[i], args)
+			if err != nil {
+				return "", err
+			}
+			same = fmt.Sprintf("%s = %s", ident, param)
+			if key.Direction == "asc" {
+				after = fmt.Sprintf("(%s > %s OR %s IS NULL)", ident, param, ident)
+			} else {
+				after = fmt.Sprintf("%s < %s", ident, param)
+			}
+		}
+
+		branches = append(branches, "("+strings.Join(append(append([]string{}, equal...), after), " AND ")+")")
+		equal = append(equal, same)
+	}
+	return "(" + strings.Join(branches, " OR ") + ")", nil
+}
+
+// compileFilter turns a filter tree into a SQL condition, appending its parameters to args
+func compileFilter(columns []Column, f Filter, args *[]any) (string, error) {
+	if len(f.And) > 0 || len(f.Or) > 0 {
+		if f.Column != "" || f.Op != "" {
+			return "", fmt.Errorf("a filter cannot combine a condition with and/or: %w", ErrInvalidRequest)
+		}
+		if len(f.And) > 0 && len(f.Or) > 0 {
+			return "", fmt.Errorf("a filter cannot have both and and or: %w", ErrInvalidRequest)
+		}
+		children, joiner := f.And, " AND "
+		if len(f.Or) > 0 {
+			children, joiner = f.Or, " OR "
+		}
+		var parts []string
+		for _, child := range children {
+			part, err := compileFilter(columns, child, args)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, part)
+		}
+		return "(" + strings.Join(parts, joiner) + ")", nil
+	}
+
+	col, err := findColumn(columns, f.Column)
+	if err != nil {
+		return "", err
+	}
+
+	ident := "t." + quoteIdent(col.Name)
+	bind := func(value any) (string, error) { return bindValue(col, value, args) }
+
+	if len(f.Path) > 0 {
+		if col.Type != "json" && col.Type != "jsonb" {
+			return "", fmt.Errorf("column %q is not json: %w", col.Name, ErrInvalidRequest)
+		}
+		*args = append(*args, f.Path)
+		document := ident + "::jsonb"
+		path := fmt.Sprintf("$%d::text[]", len(*args))
+		ident = fmt.Sprintf("(%s #>> %s)", document, path)
+		col = Column{Name: col.Name, Type: "text"}
+		// Numbers and booleans inside documents compare by value, not as
+		// text. Rows holding another JSON type at the path compare as NULL
+		// instead of failing the cast.
+		typed := func(jsonType, sqlType string) {
+			ident = fmt.Sprintf("(CASE WHEN jsonb_typeof(%s #> %s) = '%s' THEN %s::%s END)",
+				document, path, jsonType, ident, sqlType)
+			col.Type = sqlType
+		}
+		switch pathValueType(f) {
+		case "numeric":
+			typed("number", "numeric")
+		case "boolean":
+			typed("boolean", "boolean")
+		}
+		bind = func(value any) (string, error) { return bindValue(col, value, args) }
+	} else if f.AsText {
+		ident += "::text"
+		bind = func(value any) (string, error) {
+			text, err := encodeValue(Column{Name: col.Name, Type: "text"}, value)
+			if err != nil {
+				return "", err
+			}
+			*args = append(*args, text)
+			return fmt.Sprintf("$%d::text", len(*args)), nil
+		}
+	}
+
+	switch f.Op {
+	case "eq", "neq", "lt", "lte", "gt", "gte":
+		if f.Value == nil {
+			return "", fmt.Errorf("%s on %q needs a value, use is_null for NULL: %w", f.Op, col.Name, ErrInvalidRequest)
+		}
+		param, err := bind(f.Value)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %s %s", ident, comparisonOps[f.Op], param), nil
+
+	case "is_null":
+		return ident + " IS NULL", nil
+
+	case "not_null":
+		return ident + " IS NOT NULL", nil
+
+	case "in", "not_in":
+		if len(f.Values) == 0 {
+			return "", fmt.Errorf("%s on %q needs values: %w", f.Op, col.Name, ErrInvalidRequest)
+		}
+		var params []string
+		for _, value := range f.Values {
+			param, err := bind(value)
+			if err != nil {
+				return "", err
+			}
+			params = append(params, param)
+		}
+		keyword := "IN"
+		if f.Op == "not_in" {
+			keyword = "NOT IN"
+		}
+		return fmt.Sprintf("%s %s (%s)", ident, keyword, strings.Join(params, ", ")), nil
+
+	case "between":
+		if len(f.Values) != 2 || f.Values[0] == nil || f.Values[1] == nil {
+			return "", fmt.Errorf("between on %q needs two values: %w", col.Name, ErrInvalidRequest)
+		}
+		low, err := bind(f.Values[0])
+		if err != nil {
+			return "", err
+		}
+		high, err := bind(f.Values[1])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s BETWEEN %s AND %s", ident, low, high), nil
+
+	case "contains", "starts_with", "ends_with", "like", "ilike":
+		text, ok := f.Value.(string)
+		if !ok {
+			return "", fmt.Errorf("%s on %q needs a string value: %w", f.Op, col.Name, ErrInvalidRequest)
+		}
+		operator := "ILIKE"
+		switch f.Op {
+		case "contains":
+			text = "%" + escapeLike(text) + "%"
+		case "starts_with":
+			text = escapeLike(text) + "%"
+		case "ends_with":
+			text = "%" + escapeLike(text)
+		case "like":
+			operator = "LIKE"
+		}
+		*args = append(*args, text)
+		if !strings.HasSuffix(ident, "::text") {
+			ident += "::text"
+		}
+		return fmt.Sprintf("%s %s $%d", ident, operator, len(*args)), nil
+	}
+
+	return "", fmt.Errorf("unknown filter operator %q: %w", f.Op, ErrInvalidRequest)
+}
+
+// bindValue appends a value as a parameter converted to the column's type
+func bindValue(col Column, value any, args *[]any) (string, error) {
+	encoded, err := encodeValue(col, value)
+	if err != nil {
+		return "", err
+	}
+	*args = append(*args, encoded)
+	return castParam(col, len(*args)), nil
+}
+
+// pathValueType infers how to compare a value extracted from a JSON document
+func pathValueType(f Filter) string {
+	values := f.Values
+	if f.Value != nil {
+		values = append([]any{f.Value}, values...)
+	}
+	if len(values) == 0 {
+		return "text"
+	}
+	kind := ""
+	for _, value := range values {
+		var k string
+		switch value.(type) {
+		case json.Number, float64:
+			k = "numeric"
+		case bool:
+			k = "boolean"
+		default:
+			return "text"
+		}
+		if kind != "" && kind != k {
+			return "text"
+		}
+		kind = k
+	}
+	return kind
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+func decodeJSONArray(raw []byte) ([]any, error) {
+	var values []any
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
@@ -0,0 +1,320 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Role is a Postgres role as reported by pg_roles
+type Role struct {
+	Name            string     `json:"name"`
+	Login           bool       `json:"login"`
+	Superuser       bool       `json:"superuser"`
+	CreateDB        bool       `json:"createDb"`
+	CreateRole      bool       `json:"createRole"`
+	Replication     bool       `json:"replication"`
+	BypassRLS       bool       `json:"bypassRls"`
+	ConnectionLimit int        `json:"connectionLimit"`
+	ValidUntil      *time.Time `json:"validUntil"`
+	MemberOf        []string   `json:"memberOf"`
+}
+
+// RoleOptions are the attributes of a role created through the API.
+// Superuser, replication and role creation rights are left to postgres.
+type RoleOptions struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Login    bool   `json:"login"`
+	CreateDB bool   `json:"createDb"`
+	// ConnectionLimit caps concurrent sessions; -1 is unlimited
+	ConnectionLimit int        `json:"connectionLimit"`
+	ValidUntil      *time.Time `json:"validUntil"`
+	// InRoles are roles the new role becomes a member of
+	InRoles []string `json:"inRoles"`
+}
+
+// Privilege presets for GrantPreset and RevokePreset
+const (
+	PresetReadOnly  = "read-only"
+	PresetReadWrite = "read-write"
+	PresetOwner     = "owner"
+)
+
+// presetPrivileges lists what each preset grants on schemas, tables and
+// sequences
+var presetPrivileges = map[string]struct{ schema, tables, sequences string }{
+	PresetReadOnly:  {"USAGE", "SELECT", "SELECT"},
+	PresetReadWrite: {"USAGE", "SELECT, INSERT, UPDATE, DELETE", "USAGE, SELECT, UPDATE"},
+	PresetOwner:     {"ALL PRIVILEGES", "ALL PRIVILEGES", "ALL PRIVILEGES"},
+}
+
+// ValidRoleName reports whether a name can be used for a new role: lowercase
+// letters, digits and underscores, starting with a letter or underscore, and
+// not reserved by Postgres
+func ValidRoleName(name string) bool {
+	if name == "" || len(name) > 63 || strings.HasPrefix(name, "pg_") || name == "public" {
+		return false
+	}
+	for i, r := range name {
+		if (r < 'a' || r > 'z') && r != '_' && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// ListRoles returns the roles of the server ordered by name, without the
+// predefined pg_ roles
+func ListRoles(ctx context.Context, q Querier) ([]Role, error) {
+	rows, err := q.Query(ctx, `
+		SELECT r.rolname::text, r.rolcanlogin, r.rolsuper, r.rolcreatedb, r.rolcreaterole, r.rolreplication,
+			r.rolbypassrls, r.rolconnlimit, CASE WHEN isfinite(r.rolvaliduntil) THEN r.rolvaliduntil END,
+			COALESCE((SELECT array_agg(g.rolname::text ORDER BY g.rolname) FROM pg_auth_members m
+				JOIN pg_roles g ON g.oid = m.roleid WHERE m.member = r.oid), '{}')
+		FROM pg_roles r
+		WHERE r.rolname NOT LIKE 'pg\_%'
+		ORDER BY r.rolname`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var r Role
+		if err := rows.Scan(&r.Name, &r.Login, &r.Superuser, &r.CreateDB, &r.CreateRole, &r.Replication,
+			&r.BypassRLS, &r.ConnectionLimit, &r.ValidUntil, &r.MemberOf); err != nil {
+			return nil, err
+		}
+		roles = append(roles, r)
+	}
+	return roles, rows.Err()
+}
+
+// GetRole returns a role by name
+func GetRole(ctx context.Context, q Querier, name string) (*Role, error) {
+	roles, err := ListRoles(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		if roles[i].Name == name {
+			return &roles[i], nil
+		}
+	}
+	return nil, fmt.Errorf("role %s not found: %w", name, ErrObjectNotFound)
+}
+
+// CreateRole creates a role with the given options
+func CreateRole(ctx context.Context, conn *pgx.Conn, opts RoleOptions) (*Role, error) {
+	if !ValidRoleName(opts.Name) {
+		return nil, fmt.Errorf("role names use lowercase letters, digits and underscores and cannot start with pg_: %w", ErrInvalidRequest)
+	}
+	if opts.Login && opts.Password == "" {
+		return nil, fmt.Errorf("roles that can log in need a password: %w", ErrInvalidRequest)
+	}
+	if opts.ConnectionLimit < -1 {
+		return nil, fmt.Errorf("connectionLimit must be -1 or more: %w", ErrInvalidRequest)
+	}
+	if len(opts.InRoles) > 0 {
+		privileged, err := privilegedRole(ctx, conn, opts.InRoles)
+		if err != nil {
+			return nil, err
+		}
+		if privileged != "" {
+			return nil, fmt.Errorf("%s has superuser, replication or predefined role privileges and cannot be granted: %w", privileged, ErrInvalidRequest)
+		}
+	}
+
+	statement := "CREATE ROLE " + pgx.Identifier{opts.Name}.Sanitize() + " WITH NOSUPERUSER NOCREATEROLE NOREPLICATION NOBYPASSRLS"
+	if opts.Login {
+		statement += " LOGIN"
+	} else {
+		statement += " NOLOGIN"
+	}
+	if opts.CreateDB {
+		statement += " CREATEDB"
+	}
+	statement += fmt.Sprintf(" CONNECTION LIMIT %d", opts.ConnectionLimit)
+	if opts.Password != "" {
+		statement += " PASSWORD " + quoteLiteral(opts.Password)
+	}
+	if opts.ValidUntil != nil {
+		statement += " VALID UNTIL " + quoteLiteral(opts.ValidUntil.UTC().Format(time.RFC3339))
+	}
+	if len(opts.InRoles) > 0 {
+		statement += " IN ROLE " + quoteIdents(opts.InRoles)
+	}
+	if _, err := conn.Exec(ctx, statement); err != nil {
+		return nil, err
+	}
+	return GetRole(ctx, conn, opts.Name)
+}
+
+// privilegedRole returns the first of names that is a superuser, can bypass
+// row security or replicate, or is a member of such a role or a predefined
+// pg_ role. Members of the new role could SET ROLE to it.
+func privilegedRole(ctx context.Context, q Querier, names []string) (string, error) {
+	var name string
+	err := q.QueryRow(ctx, `
+		SELECT r.rolname::text FROM pg_roles r
+		WHERE r.rolname = ANY($1) AND EXISTS (
+			SELECT 1 FROM pg_roles p
+			WHERE (p.rolsuper OR p.rolreplication OR p.rolbypassrls OR p.rolname LIKE 'pg\_%')
+				AND pg_has_role(r.oid, p.oid, 'MEMBER'))
+		ORDER BY r.rolname
+		LIMIT 1`, names).Scan(&name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to check roles: %w", err)
+	}
+	return name, nil
+}
+
+// SetRolePassword changes the password of a role
+func SetRolePassword(ctx context.Context, conn *pgx.Conn, name, password string) error {
+	if password == "" {
+		return fmt.Errorf("password is required: %w", ErrInvalidRequest)
+	}
+	if _, err := GetRole(ctx, conn, name); err != nil {
+		return err
+	}
+	_, err := conn.Exec(ctx, "ALTER ROLE "+pgx.Identifier{name}.Sanitize()+" WITH PASSWORD "+quoteLiteral(password))
+	return err
+}
+
+// DropRole drops a role. Objects it owns in the connected database are
+// handed to the connected user first, and its privileges are revoked.
+func DropRole(ctx context.Context, conn *pgx.Conn, name string) error {
+	if _, err := GetRole(ctx, conn, name); err != nil {
+		return err
+	}
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	ident := pgx.Identifier{name}.Sanitize()
+	for _, statement := range []string{
+		"REASSIGN OWNED BY " + ident + " TO CURRENT_USER",
+		"DROP OWNED BY " + ident,
+		"DROP ROLE " + ident,
+	} {
+		if _, err := tx.Exec(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// GrantPreset grants a role the privileges of a preset on a schema: on the
+// given tables, or on all tables and sequences of the schema, including
+// those created later by the connected user
+func GrantPreset(ctx context.Context, conn *pgx.Conn, role, schema string, tables []string, preset string) error {
+	return changePrivileges(ctx, conn, true, role, schema, tables, preset)
+}
+
+// RevokePreset revokes the privileges of a preset from a role, mirroring
+// GrantPreset
+func RevokePreset(ctx context.Context, conn *pgx.Conn, role, schema string, tables []string, preset string) error {
+	return changePrivileges(ctx, conn, false, role, schema, tables, preset)
+}
+
+func changePrivileges(ctx context.Context, conn *pgx.Conn, grant bool, role, schema string, tables []string, preset string) error {
+	privileges, ok := presetPrivileges[preset]
+	if !ok {
+		return fmt.Errorf("unknown preset %q, expected %s, %s or %s: %w", preset, PresetReadOnly, PresetReadWrite, PresetOwner, ErrInvalidRequest)
+	}
+	if schema == "" {
+		return fmt.Errorf("schema is required: %w", ErrInvalidRequest)
+	}
+	if _, err := GetRole(ctx, conn, role); err != nil {
+		return err
+	}
+
+	verb, preposition := "GRANT", " TO "
+	if !grant {
+		verb, preposition = "REVOKE", " FROM "
+	}
+	grantee := preposition + pgx.Identifier{role}.Sanitize()
+	schemaIdent := pgx.Identifier{schema}.Sanitize()
+
+	var statements []string
+	// Revoking a table preset keeps access to the schema, which other
+	// tables may still need
+	if grant || len(tables) == 0 {
+		statements = append(statements, verb+" "+privileges.schema+" ON SCHEMA "+schemaIdent+grantee)
+	}
+	if len(tables) > 0 {
+		qualified := make([]string, len(tables))
+		for i, table := range tables {
+			qualified[i] = pgx.Identifier{schema, table}.Sanitize()
+		}
+		statements = append(statements, verb+" "+privileges.tables+" ON TABLE "+strings.Join(qualified, ", ")+grantee)
+	} else {
+		statements = append(statements,
+			verb+" "+privileges.tables+" ON ALL TABLES IN SCHEMA "+schemaIdent+grantee,
+			verb+" "+privileges.sequences+" ON ALL SEQUENCES IN SCHEMA "+schemaIdent+grantee,
+			"ALTER DEFAULT PRIVILEGES IN SCHEMA "+schemaIdent+" "+verb+" "+privileges.tables+" ON TABLES"+grantee,
+			"ALTER DEFAULT PRIVILEGES IN SCHEMA "+schemaIdent+" "+verb+" "+privileges.sequences+" ON SEQUENCES"+grantee,
+		)
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// RolePrivilege lists the privileges a role holds on a schema, or on a
+// table or sequence when Table is set
+type RolePrivilege struct {
+	Schema     string   `json:"schema"`
+	Table      string   `json:"table,omitempty"`
+	Privileges []string `json:"privileges"`
+}
+
+// ListRolePrivileges returns the privileges granted directly to a role in the
+// connected database
+func ListRolePrivileges(ctx context.Context, q Querier, role string) ([]RolePrivilege, error) {
+	rows, err := q.Query(ctx, `
+		SELECT n.nspname::text, '', array_agg(a.privilege_type ORDER BY a.privilege_type)
+		FROM pg_namespace n, aclexplode(n.nspacl) a
+		WHERE a.grantee = (SELECT oid FROM pg_roles WHERE rolname = $1)
+		GROUP BY n.nspname
+		UNION ALL
+		SELECT n.nspname::text, c.relname::text, array_agg(a.privilege_type ORDER BY a.privilege_type)
+		FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace, aclexplode(c.relacl) a
+		WHERE a.grantee = (SELECT oid FROM pg_roles WHERE rolname = $1) AND c.relkind IN ('r', 'p', 'v', 'm', 'f', 'S')
+		GROUP BY n.nspname, c.relname
+		ORDER BY 1, 2`, role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list privileges: %w", err)
+	}
+	defer rows.Close()
+
+	privileges := []RolePrivilege{}
+	for rows.Next() {
+		var p RolePrivilege
+		if err := rows.Scan(&p.Schema, &p.Table, &p.Privileges); err != nil {
+			return nil, err
+		}
+		privileges = append(privileges, p)
+	}
+	return privileges, rows.Err()
+}
@@ -0,0 +1,430 @@
+package pg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrRowNotFound = errors.New("row not found")
+	ErrRowConflict = errors.New("row was modified by someone else")
+)
+
+// RowChange identifies a single row by key and describes what to write to it.
+// Original holds the values the client last saw; when present, the write only
+// succeeds if the row still matches them (optimistic concurrency).
+type RowChange struct {
+	Key      map[string]any `json:"key"`
+	Values   map[string]any `json:"values,omitempty"`
+	Original map[string]any `json:"original,omitempty"`
+}
+
+// ErrorStatus maps errors returned by this package to an HTTP status code
+func ErrorStatus(err error) int {
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, ErrTableNotFound), errors.Is(err, ErrRowNotFound), errors.Is(err, ErrObjectNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrRowConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrUnknownColumn), errors.Is(err, ErrInvalidRequest):
+		return http.StatusBadRequest
+	case errors.As(err, &pgErr):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// InsertRows inserts all rows in a single transaction and returns them as stored
+func InsertRows(ctx context.Context, conn *pgx.Conn, schema, table string, rows []map[string]any) ([]map[string]any, error) {
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no rows given: %w", ErrInvalidRequest)
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	columns, err := GetColumns(ctx, tx, schema, table)
+	if err != nil {
+		return nil, err
+	}
+
+	target := pgx.Identifier{schema, table}.Sanitize()
+	var result []map[string]any
+	for i, row := range rows {
+		var names, placeholders []string
+		var args []any
+		for _, name := range sortedKeys(row) {
+			col, err := findColumn(columns, name)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", i, err)
+			}
+			arg, err := encodeValue(col, row[name])
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", i, err)
+			}
+			args = append(args, arg)
+			names = append(names, quoteIdent(col.Name))
+			placeholders = append(placeholders, castParam(col, len(args)))
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s AS t DEFAULT VALUES RETURNING row_to_json(t)", target)
+		if len(names) > 0 {
+			query = fmt.Sprintf("INSERT INTO %s AS t (%s) VALUES (%s) RETURNING row_to_json(t)",
+				target, strings.Join(names, ", "), strings.Join(placeholders, ", "))
+		}
+
+		inserted, err := queryJSONRows(ctx, tx, query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+		result = append(result, inserted...)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// UpdateRows applies every change in a single transaction and returns the updated rows.
+// If any row is missing or no longer matches its Original values, nothing is written.
+func UpdateRows(ctx context.Context, conn *pgx.Conn, schema, table string, changes []RowChange) ([]map[string]any, error) {
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("no changes given: %w", ErrInvalidRequest)
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	columns, err := GetColumns(ctx, tx, schema, table)
+	if err != nil {
+		return nil, err
+	}
+
+	target := pgx.Identifier{schema, table}.Sanitize()
+	var result []map[string]any
+	for i, change := range changes {
+		if len(change.Values) == 0 {
+			return nil, fmt.Errorf("row %d: no values to update: %w", i, ErrInvalidRequest)
+		}
+
+		var sets []string
+		var args []any
+		for _, name := range sortedKeys(change.Values) {
+			col, err := findColumn(columns, name)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", i, err)
+			}
+			arg, err := encodeValue(col, change.Values[name])
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", i, err)
+			}
+			args = append(args, arg)
+			sets = append(sets, fmt.Sprintf("%s = %s", quoteIdent(col.Name), castParam(col, len(args))))
+		}
+
+		where, args, err := rowPredicate(columns, change, args)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+
+		query := fmt.Sprintf("UPDATE %s AS t SET %s WHERE %s RETURNING row_to_json(t)",
+			target, strings.Join(sets, ", "), where)
+		updated, err := queryJSONRows(ctx, tx, query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+		if err := checkSingleRow(ctx, tx, target, columns, change, len(updated)); err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+		result = append(result, updated...)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteRows deletes every identified row in a single transaction and returns the deleted rows
+func DeleteRows(ctx context.Context, conn *pgx.Conn, schema, table string, changes []RowChange) ([]map[string]any, error) {
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("no rows given: %w", ErrInvalidRequest)
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	columns, err := GetColumns(ctx, tx, schema, table)
+	if err != nil {
+		return nil, err
+	}
+
+	target := pgx.Identifier{schema, table}.Sanitize()
+	var result []map[string]any
+	for i, change := range changes {
+		where, args, err := rowPredicate(columns, change, nil)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+
+		query := fmt.Sprintf("DELETE FROM %s AS t WHERE %s RETURNING row_to_json(t)", target, where)
+		deleted, err := queryJSONRows(ctx, tx, query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+		if err := checkSingleRow(ctx, tx, target, columns, change, len(deleted)); err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+		result = append(result, deleted...)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// rowPredicate builds the WHERE clause identifying a row by its key, plus the
+// optimistic concurrency checks against the client's original values
+func rowPredicate(columns []Column, change RowChange, args []any) (string, []any, error) {
+	if len(change.Key) == 0 {
+		return "", nil, fmt.Errorf("row key is required: %w", ErrInvalidRequest)
+	}
+
+	pk := PrimaryKey(columns)
+	if len(pk) > 0 {
+		for _, col := range pk {
+			if _, ok := change.Key[col.Name]; !ok {
+				return "", nil, fmt.Errorf("key is missing primary key column %q: %w", col.Name, ErrInvalidRequest)
+			}
+		}
+		if len(change.Key) != len(pk) {
+			return "", nil, fmt.Errorf("key must contain exactly the primary key columns: %w", ErrInvalidRequest)
+		}
+	}
+
+	var conds []string
+	for _, name := range sortedKeys(change.Key) {
+		col, err := findColumn(columns, name)
+		if err != nil {
+			return "", nil, err
+		}
+		arg, err := encodeValue(col, change.Key[name])
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, arg)
+		if col.IsPrimaryKey {
+			conds = append(conds, fmt.Sprintf("t.%s = %s", quoteIdent(col.Name), castParam(col, len(args))))
+		} else {
+			conds = append(conds, compareExpr(col, len(args)))
+		}
+	}
+
+	for _, name := range sortedKeys(change.Original) {
+		if _, isKey := change.Key[name]; isKey {
+			continue
+		}
+		col, err := findColumn(columns, name)
+		if err != nil {
+			return "", nil, err
+		}
+		arg, err := encodeValue(col, change.Original[name])
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, arg)
+		conds = append(conds, compareExpr(col, len(args)))
+	}
+
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// checkSingleRow verifies a keyed write touched exactly one row. When it touched
+// none, it tells apart a missing row from one that changed since it was read.
+func checkSingleRow(ctx context.Context, tx pgx.Tx, target string, columns []Column, change RowChange, affected int) error {
+	if affected == 1 {
+		return nil
+	}
+	if affected > 1 {
+		return fmt.Errorf("key matches %d rows: %w", affected, ErrInvalidRequest)
+	}
+	if len(change.Original) == 0 {
+		return ErrRowNotFound
+	}
+
+	where, args, err := rowPredicate(columns, RowChange{Key: change.Key}, nil)
+	if err != nil {
+		return err
+	}
+	var exists bool
+	query := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s AS t WHERE %s)", target, where)
+	if err := tx.QueryRow(ctx, query, args...).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrRowConflict
+	}
+	return ErrRowNotFound
+}
+
+func queryJSONRows(ctx context.Context, q Querier, query string, args ...any) ([]map[string]any, error) {
+	rows, err := q.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []map[string]any
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		row, err := decodeJSONRow(raw)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+func decodeJSONRow(raw []byte) (map[string]any, error) {
+	// UseNumber keeps bigint and numeric values exact on the way back out
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var row map[string]any
+	if err := decoder.Decode(&row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// castParam returns a placeholder that is bound as text and converted with the
+// column type's input function, so every type accepts its literal representation
+func castParam(col Column, n int) string {
+	return fmt.Sprintf("$%d::text::%s", n, col.Type)
+}
+
+// compareExpr returns a NULL-safe equality check between a column and a parameter.
+// Types without an equality operator are compared through a normalized form.
+func compareExpr(col Column, n int) string {
+	ident := "t." + quoteIdent(col.Name)
+	switch col.Type {
+	case "json":
+		return fmt.Sprintf("%s::jsonb IS NOT DISTINCT FROM $%d::text::jsonb", ident, n)
+	case "xml", "point", "line", "lseg", "box", "path", "polygon", "circle":
+		return fmt.Sprintf("%s::text IS NOT DISTINCT FROM $%d::text::%s::text", ident, n, col.Type)
+	}
+	return fmt.Sprintf("%s IS NOT DISTINCT FROM %s", ident, castParam(col, n))
+}
+
+// encodeValue converts a JSON-decoded value into the text form bound for a column
+func encodeValue(col Column, value any) (any, error) {
+	// JSON documents are bound in their JSON encoding, so a string value
+	// becomes a JSON string rather than being parsed as document text
+	if value != nil && (col.Type == "json" || col.Type == "jsonb") {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", col.Name, ErrInvalidRequest)
+		}
+		return string(encoded), nil
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	case []any:
+		if strings.HasSuffix(col.Type, "[]") {
+			return arrayLiteral(v)
+		}
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("column %q: %w", col.Name, ErrInvalidRequest)
+	}
+	return string(encoded), nil
+}
+
+// arrayLiteral renders a JSON array as a Postgres array literal
+func arrayLiteral(values []any) (string, error) {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		switch v := value.(type) {
+		case nil:
+			parts = append(parts, "NULL")
+		case []any:
+			nested, err := arrayLiteral(v)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, nested)
+		default:
+			var text string
+			switch item := v.(type) {
+			case string:
+				text = item
+			case bool:
+				text = strconv.FormatBool(item)
+			case float64:
+				text = strconv.FormatFloat(item, 'f', -1, 64)
+			case json.Number:
+				text = item.String()
+			default:
+				encoded, err := json.Marshal(item)
+				if err != nil {
+					return "", fmt.Errorf("invalid array element: %w", ErrInvalidRequest)
+				}
+				text = string(encoded)
+			}
+			text = strings.ReplaceAll(text, `\`, `\\`)
+			text = strings.ReplaceAll(text, `"`, `\"`)
+			parts = append(parts, `"`+text+`"`)
+		}
+	}
+	return "{" + strings.Join(parts, ",") + "}", nil
+}
+
+func quoteIdent(name string) string {
+	return pgx.Identifier{name}.Sanitize()
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -85,6 +85,7 @@ func (h *APIHandler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/proxy/revocations", h.GetRevocations)
 	mux.HandleFunc("/api/proxy/revoke", h.RevokeToken)
 	mux.HandleFunc("/api/proxy/unrevoke", h.UnrevokeToken)
+	mux.HandleFunc("/api/proxy/lockouts", h.Lockouts)
 }
 
 // GetStatus returns the current proxy status
@@ -348,6 +349,36 @@ func (h *APIHandler) UnrevokeToken(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Lockouts lists clients throttled after failed authentication (GET) or
+// unlocks one with ?ip= (DELETE)
+func (h *APIHandler) Lockouts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.respondJSON(w, APIResponse{
+			Success:   true,
+			Data:      AuthLockouts(),
+			Timestamp: time.Now(),
+		})
+	case http.MethodDelete:
+		ip := r.URL.Query().Get("ip")
+		if ip == "" {
+			h.respondError(w, "ip is required", http.StatusBadRequest)
+			return
+		}
+		if !UnlockClient(ip) {
+			h.respondError(w, "Client is not locked out", http.StatusNotFound)
+			return
+		}
+		h.respondJSON(w, APIResponse{
+			Success:   true,
+			Message:   "Client unlocked",
+			Timestamp: time.Now(),
+		})
+	default:
+		h.respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 // respondJSON writes a JSON response
 func (h *APIHandler) respondJSON(w http.ResponseWriter, response APIResponse) {
 	w.Header().Set("Content-Type", "application/json")
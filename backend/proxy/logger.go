@@ -207,6 +207,31 @@ func (l *Logger) TokenExpired(tokenID, remoteIP string) {
 	l.log(LogLevelWarning, "Token expired - connection rejected", conn, nil, nil)
 }
 
+// AuthThrottled logs a connection rejected because its client failed to
+// authenticate too often
+func (l *Logger) AuthThrottled(remoteIP string, retryAfter time.Duration, locked bool) {
+	conn := &ConnectionInfo{
+		RemoteIP: remoteIP,
+	}
+	metadata := map[string]string{
+		"retry_after": retryAfter.Round(time.Second).String(),
+		"locked":      fmt.Sprintf("%t", locked),
+	}
+	l.log(LogLevelWarning, "Too many failed authentication attempts - connection rejected", conn, metadata, nil)
+}
+
+// ClientLockedOut logs when a client is locked out after repeated authentication failures
+func (l *Logger) ClientLockedOut(remoteIP string, failures int, duration time.Duration) {
+	conn := &ConnectionInfo{
+		RemoteIP: remoteIP,
+	}
+	metadata := map[string]string{
+		"failures": fmt.Sprintf("%d", failures),
+		"duration": duration.String(),
+	}
+	l.log(LogLevelError, "Client locked out after repeated authentication failures", conn, metadata, nil)
+}
+
 // SSLTerminated logs SSL/TLS termination events
 func (l *Logger) SSLTerminated(remoteIP string, success bool, err error) {
 	conn := &ConnectionInfo{
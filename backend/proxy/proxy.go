@@ -3,6 +3,7 @@ package proxy
 import (
 	"baseful/auth"
 	"baseful/db"
+	"baseful/suspend"
 	"bytes"
 	"context"
 	"crypto/rand"
@@ -95,6 +96,7 @@ type ConnectionMetadata struct {
 	ID          string
 	ClientIP    string
 	DatabaseID  int
+	ReplicaID   int
 	TokenID     string
 	ConnectedAt time.Time
 	LastActive  time.Time
@@ -126,6 +128,10 @@ func (p *ProxyServer) Start() error {
 	p.wg.Add(1)
 	go p.idleConnectionChecker()
 
+	// Start the scale-to-zero checker
+	p.wg.Add(1)
+	go p.autoSuspendChecker()
+
 	p.wg.Add(1)
 	go func() {
 		defer p.wg.Done()
@@ -296,14 +302,23 @@ func (p *ProxyServer) handleConnection(frontend net.Conn) {
 	// Update frontend connection if it was upgraded to TLS
 	frontend = newFrontend
 
+	// Clients that failed too often are turned away before their token is checked
+	if wait, locked := authThrottle.Wait(clientHost(clientIP)); wait > 0 {
+		p.logger.AuthThrottled(clientHost(clientIP), wait, locked)
+		p.sendError(frontend, "28000", "Too many failed authentication attempts, try again later")
+		return
+	}
+
 	// 2. Validate JWT and check token revocation
 	claims, err := auth.ValidateJWT(jwtToken)
 	if err != nil {
 		p.logger.TokenExpired("", clientIP)
+		p.authFailed(clientIP, "invalid_token", nil)
 		p.sendError(frontend, "28000", "Invalid or expired JWT token")
 		return
 	}
 	if claims.Purpose != "db_proxy" {
+		p.authFailed(clientIP, "invalid_purpose", claims)
 		p.sendError(frontend, "28000", "Invalid token purpose")
 		return
 	}
@@ -311,10 +326,13 @@ func (p *ProxyServer) handleConnection(frontend net.Conn) {
 	// Check if token has been revoked
 	if err := p.checkTokenRevocation(claims.TokenID); err != nil {
 		p.logger.TokenRevoked(claims.TokenID, clientIP)
+		p.authFailed(clientIP, "revoked_token", claims)
 		p.sendError(frontend, "28000", "Token has been revoked")
 		return
 	}
-	if err := p.checkTokenActive(claims, jwtToken); err != nil {
+	tokenRecord, err := p.checkTokenActive(claims, jwtToken)
+	if err != nil {
+		p.authFailed(clientIP, "inactive_token", claims)
 		p.sendError(frontend, "28000", "Invalid or revoked JWT token")
 		return
 	}
@@ -331,41 +349,70 @@ func (p *ProxyServer) handleConnection(frontend net.Conn) {
 
 	connMeta.DatabaseID = claims.DatabaseID
 
-	// 4. Connect and Handshake with Backend
-	// Try connecting to the internal host first (for Docker-to-Docker)
-	backendHost := dbInfo.Host
-	backendPort := dbInfo.Port
+	// A sleeping database is started first; the client waits until it is ready
+	if dbInfo.Status == db.StatusSleeping {
+		if dbInfo, err = p.wakeDatabase(frontend, dbInfo); err != nil {
+			p.logger.Warning("Failed to wake database", nil, map[string]string{"database_id": fmt.Sprintf("%d", claims.DatabaseID)}, err)
+			p.sendError(frontend, "57P03", "The database is waking up and not ready yet, try again shortly")
+			return
+		}
+	}
+	suspend.Touch(claims.DatabaseID)
 
-	backend, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", backendHost, backendPort), 200*time.Millisecond)
+	// Tokens bound to a role log in as it instead of postgres
+	backendUser, backendPassword := "postgres", dbInfo.Password
+	if tokenRecord.Role != "" {
+		password, err := db.GetRolePassword(claims.DatabaseID, tokenRecord.Role)
+		if err != nil {
+			p.logger.Warning("Role of token unavailable", nil, map[string]string{
+				"database_id": fmt.Sprintf("%d", claims.DatabaseID),
+				"role":        tokenRecord.Role,
+			}, err)
+			p.sendError(frontend, "28000", "The role of this token is not available")
+			return
+		}
+		backendUser, backendPassword = tokenRecord.Role, password
+	}
 
-	// If internal connection fails and we have a mapped port, try connecting via localhost (for Host-to-Docker)
-	if err != nil && dbInfo.MappedPort > 0 {
-		p.logger.Warning("Internal connection failed, trying localhost", nil, map[string]string{
-			"error":       err.Error(),
-			"mapped_port": fmt.Sprintf("%d", dbInfo.MappedPort),
-		}, nil)
-		backendHost = "127.0.0.1"
-		backendPort = dbInfo.MappedPort
-		backend, err = net.DialTimeout("tcp", fmt.Sprintf("%s:%d", backendHost, backendPort), 5*time.Second)
+	// 4. Pick the backend: read-only sessions go to a healthy replica
+	wantReplica, requireReplica := readTarget(claims, startupParams)
+	backendInfo := dbInfo
+	if wantReplica {
+		replica, err := p.pickReplica(claims.DatabaseID)
+		if err != nil {
+			p.logger.Warning("Replica lookup failed", nil, map[string]string{"database_id": fmt.Sprintf("%d", claims.DatabaseID)}, err)
+		}
+		if replica != nil {
+			backendInfo = replicaInfo(dbInfo, replica)
+			connMeta.ReplicaID = replica.ID
+			defer p.releaseReplica(replica.ID)
+		} else if requireReplica {
+			p.sendError(frontend, "08006", "No healthy read replica is available")
+			return
+		}
 	}
 
+	// 5. Connect and Handshake with Backend
+	backend, err := p.dialBackend(backendInfo)
+	if err != nil && connMeta.ReplicaID != 0 && !requireReplica {
+		// Reads that may use the primary fall back to it
+		backendInfo = dbInfo
+		connMeta.ReplicaID = 0
+		backend, err = p.dialBackend(backendInfo)
+	}
 	if err != nil {
-		p.logger.Error("Backend connection failed", nil, map[string]string{
-			"host": backendHost,
-			"port": fmt.Sprintf("%d", backendPort),
-		}, err)
-		p.sendError(frontend, "08006", fmt.Sprintf("Failed to connect to backend database at %s:%d", dbInfo.Host, dbInfo.Port))
+		p.sendError(frontend, "08006", fmt.Sprintf("Failed to connect to backend database at %s:%d", backendInfo.Host, backendInfo.Port))
 		return
 	}
 	defer backend.Close()
 
-	err = p.handleBackendHandshake(backend, dbInfo, startupParams, frontend)
+	err = p.handleBackendHandshake(backend, backendInfo, backendUser, backendPassword, startupParams, frontend)
 	if err != nil {
 		p.logger.Warning("Backend handshake failed", nil, map[string]string{"error": err.Error()}, nil)
 		return
 	}
 
-	// 5. Synchronized! Both are now at ReadyForQuery.
+	// 6. Synchronized! Both are now at ReadyForQuery.
 	frontend.SetDeadline(time.Time{})
 
 	// Store connection metadata
@@ -376,7 +423,7 @@ func (p *ProxyServer) handleConnection(frontend net.Conn) {
 		LocalPort:  p.port,
 	}, claims.DatabaseID, claims.TokenID)
 
-	// 6. Pipe data with idle timeout tracking
+	// 7. Pipe data with idle timeout tracking
 	errChan := make(chan error, 2)
 	go func() {
 		errChan <- p.pipeWithIdleTracking(frontend, backend, connMeta, false)
@@ -399,8 +446,48 @@ func (p *ProxyServer) handleConnection(frontend net.Conn) {
 		BytesRecv:  connMeta.BytesRecv,
 	}, duration, connMeta.BytesSent, connMeta.BytesRecv)
 
-	// Remove from active connections
+	// Remove from active connections; the database stays awake for a full
+	// auto-suspend period after its last session ends
 	p.activeConns.Delete(connID)
+	suspend.Touch(claims.DatabaseID)
+}
+
+// dialBackend connects to a database container. Local containers are tried
+// on the Docker network first, then on their published port on localhost;
+// containers on remote servers are reached on their published port.
+func (p *ProxyServer) dialBackend(info *db.DatabaseInfo) (net.Conn, error) {
+	backendHost := info.Host
+	backendPort := info.Port
+
+	var backend net.Conn
+	var err error
+	if info.ServerHost != "" {
+		backendHost = info.ServerHost
+		backendPort = info.MappedPort
+		backend, err = net.DialTimeout("tcp", fmt.Sprintf("%s:%d", backendHost, backendPort), 5*time.Second)
+	} else {
+		backend, err = net.DialTimeout("tcp", fmt.Sprintf("%s:%d", backendHost, backendPort), 200*time.Millisecond)
+	}
+
+	// If internal connection fails and we have a mapped port, try connecting via localhost (for Host-to-Docker)
+	if err != nil && info.MappedPort > 0 && info.ServerHost == "" {
+		p.logger.Warning("Internal connection failed, trying localhost", nil, map[string]string{
+			"error":       err.Error(),
+			"mapped_port": fmt.Sprintf("%d", info.MappedPort),
+		}, nil)
+		backendHost = "127.0.0.1"
+		backendPort = info.MappedPort
+		backend, err = net.DialTimeout("tcp", fmt.Sprintf("%s:%d", backendHost, backendPort), 5*time.Second)
+	}
+
+	if err != nil {
+		p.logger.Error("Backend connection failed", nil, map[string]string{
+			"host": backendHost,
+			"port": fmt.Sprintf("%d", backendPort),
+		}, err)
+		return nil, err
+	}
+	return backend, nil
 }
 
 func (p *ProxyServer) handleFrontendHandshake(conn net.Conn) (net.Conn, map[string]string, string, error) {
@@ -495,13 +582,15 @@ func (p *ProxyServer) handleFrontendHandshake(conn net.Conn) (net.Conn, map[stri
 	return conn, params, jwtToken, nil
 }
 
-func (p *ProxyServer) handleBackendHandshake(backend net.Conn, dbInfo *db.DatabaseInfo, params map[string]string, frontend net.Conn) error {
+// handleBackendHandshake starts a session on the backend as user, whatever
+// user the client asked for
+func (p *ProxyServer) handleBackendHandshake(backend net.Conn, dbInfo *db.DatabaseInfo, user, password string, params map[string]string, frontend net.Conn) error {
 	// 1. Send Startup to Backend
 	var buf bytes.Buffer
 	binary.Write(&buf, binary.BigEndian, uint32(196608)) // Protocol 3.0
 	for k, v := range params {
 		if k == "user" {
-			v = "postgres"
+			v = user
 		} // Force backend user
 		if k == "database" {
 			v = dbInfo.Name // Use the actual database name stored in DB
@@ -534,19 +623,19 @@ func (p *ProxyServer) handleBackendHandshake(backend net.Conn, dbInfo *db.Databa
 				continue
 			}
 			if authType == 3 { // Cleartext requested
-				resp := append([]byte{'p'}, uint32ToBytes(uint32(len(dbInfo.Password)+5))...)
-				resp = append(resp, []byte(dbInfo.Password)...)
+				resp := append([]byte{'p'}, uint32ToBytes(uint32(len(password)+5))...)
+				resp = append(resp, []byte(password)...)
 				resp = append(resp, 0)
 				backend.Write(resp)
 			} else if authType == 5 { // MD5 requested
 				salt := payload[4:8]
-				digest := md5Hash(dbInfo.Password, "postgres", salt)
+				digest := md5Hash(password, user, salt)
 				resp := append([]byte{'p'}, uint32ToBytes(uint32(len(digest)+5))...)
 				resp = append(resp, []byte(digest)...)
 				resp = append(resp, 0)
 				backend.Write(resp)
 			} else if authType == 10 { // SASL (SCRAM-SHA-256) requested
-				if err := p.handleSCRAMAuth(backend, payload[4:], dbInfo.Password); err != nil {
+				if err := p.handleSCRAMAuth(backend, payload[4:], user, password); err != nil {
 					return err
 				}
 			} else {
@@ -623,25 +712,26 @@ func (p *ProxyServer) checkTokenRevocation(tokenID string) error {
 	return nil
 }
 
-// checkTokenActive verifies token state against persistent storage and exact token hash.
-func (p *ProxyServer) checkTokenActive(claims *auth.JWTClaims, rawToken string) error {
+// checkTokenActive verifies token state against persistent storage and exact
+// token hash, and returns the stored token
+func (p *ProxyServer) checkTokenActive(claims *auth.JWTClaims, rawToken string) (*db.TokenRecord, error) {
 	tokenRecord, err := db.GetTokenByID(claims.TokenID)
 	if err != nil {
-		return fmt.Errorf("token lookup failed: %w", err)
+		return nil, fmt.Errorf("token lookup failed: %w", err)
 	}
 	if tokenRecord.DatabaseID != claims.DatabaseID {
-		return fmt.Errorf("token/database mismatch")
+		return nil, fmt.Errorf("token/database mismatch")
 	}
 	if tokenRecord.Revoked {
-		return fmt.Errorf("token revoked")
+		return nil, fmt.Errorf("token revoked")
 	}
 	if tokenRecord.ExpiresAt.Before(time.Now()) {
-		return fmt.Errorf("token expired")
+		return nil, fmt.Errorf("token expired")
 	}
 	if tokenRecord.TokenHash != db.HashToken(rawToken) {
-		return fmt.Errorf("token hash mismatch")
+		return nil, fmt.Errorf("token hash mismatch")
 	}
-	return nil
+	return tokenRecord, nil
 }
 
 func (p *ProxyServer) sendError(conn net.Conn, code, message string) {
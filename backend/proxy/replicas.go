@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"strings"
+	"sync"
+
+	"baseful/auth"
+	"baseful/db"
+)
+
+// Connections per replica, to send new sessions to the least busy one
+var (
+	replicaConnsMu sync.Mutex
+	replicaConns   = map[int]int{}
+	replicaTurn    int
+)
+
+// readTarget reports whether a session should go to a read replica, and
+// whether it must. Replica tokens always use replicas; other clients can ask
+// with target_session_attrs.
+func readTarget(claims *auth.JWTClaims, params map[string]string) (want, require bool) {
+	attrs, ok := takeTargetSessionAttrs(params)
+	if claims.Target == db.TokenTargetReplica {
+		return true, true
+	}
+	if !ok {
+		return false, false
+	}
+	switch attrs {
+	case "read-only", "standby":
+		return true, true
+	case "prefer-standby":
+		return true, false
+	}
+	return false, false
+}
+
+// takeTargetSessionAttrs removes target_session_attrs from the startup
+// parameters, given directly or in options as "-c target_session_attrs=...".
+// Postgres does not know the setting and would reject the connection.
+func takeTargetSessionAttrs(params map[string]string) (string, bool) {
+	attrs, ok := params["target_session_attrs"]
+	delete(params, "target_session_attrs")
+
+	options, found := params["options"]
+	if !found {
+		return attrs, ok
+	}
+	var kept []string
+	fields := strings.Fields(options)
+	for i := 0; i < len(fields); i++ {
+		arg, setting := fields[i:i+1], ""
+		switch {
+		case fields[i] == "-c" && i+1 < len(fields):
+			arg, setting = fields[i:i+2], fields[i+1]
+			i++
+		case strings.HasPrefix(fields[i], "-c"), strings.HasPrefix(fields[i], "--"):
+			setting = fields[i][2:]
+		}
+		name, value, _ := strings.Cut(setting, "=")
+		if strings.ReplaceAll(name, "-", "_") == "target_session_attrs" {
+			attrs, ok = value, true
+			continue
+		}
+		kept = append(kept, arg...)
+	}
+	if len(kept) == 0 {
+		delete(params, "options")
+	} else {
+		params["options"] = strings.Join(kept, " ")
+	}
+	return attrs, ok
+}
+
+// pickReplica returns the healthy replica of a database with the fewest
+// sessions, rotating between equally busy ones, or nil if none is healthy.
+// The caller releases it with releaseReplica.
+func (p *ProxyServer) pickReplica(databaseID int) (*db.Replica, error) {
+	replicas, err := db.HealthyReplicas(databaseID)
+	if err != nil || len(replicas) == 0 {
+		return nil, err
+	}
+
+	replicaConnsMu.Lock()
+	defer replicaConnsMu.Unlock()
+	replicaTurn++
+	best := -1
+	for i := range replicas {
+		candidate := (replicaTurn + i) % len(replicas)
+		if best < 0 || replicaConns[replicas[candidate].ID] < replicaConns[replicas[best].ID] {
+			best = candidate
+		}
+	}
+	replicaConns[replicas[best].ID]++
+	return &replicas[best], nil
+}
+
+func (p *ProxyServer) releaseReplica(replicaID int) {
+	replicaConnsMu.Lock()
+	defer replicaConnsMu.Unlock()
+	if replicaConns[replicaID]--; replicaConns[replicaID] <= 0 {
+		delete(replicaConns, replicaID)
+	}
+}
+
+// replicaInfo returns the connection information of a replica. Replicas are
+// physical copies, so the database name and password are the primary's.
+func replicaInfo(primary *db.DatabaseInfo, replica *db.Replica) *db.DatabaseInfo {
+	info := *primary
+	info.Host = replica.Host
+	info.Port = replica.Port
+	info.MappedPort = replica.MappedPort
+	return &info
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"baseful/db"
+	"baseful/suspend"
+)
+
+// autoSuspendChecker periodically suspends databases that had no proxy
+// activity for as long as their auto-suspend policy allows. Sessions that
+// sent nothing in that time do not keep a database awake; they end when its
+// container stops.
+func (p *ProxyServer) autoSuspendChecker() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			p.suspendIdleDatabases()
+		}
+	}
+}
+
+func (p *ProxyServer) suspendIdleDatabases() {
+	policies, err := db.AutoSuspendCandidates()
+	if err != nil {
+		p.logger.Warning("Failed to load auto-suspend policies", nil, nil, err)
+		return
+	}
+	if len(policies) == 0 {
+		return
+	}
+
+	lastActive := map[int]time.Time{}
+	p.activeConns.Range(func(key, value interface{}) bool {
+		meta := value.(*ConnectionMetadata)
+		if meta.LastActive.After(lastActive[meta.DatabaseID]) {
+			lastActive[meta.DatabaseID] = meta.LastActive
+		}
+		return true
+	})
+
+	for _, policy := range policies {
+		idleSince := suspend.LastActivity(policy.DatabaseID)
+		if t := lastActive[policy.DatabaseID]; t.After(idleSince) {
+			idleSince = t
+		}
+		if time.Since(idleSince) < time.Duration(policy.Minutes)*time.Minute {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(p.ctx, time.Minute)
+		err := suspend.Suspend(ctx, policy.DatabaseID)
+		cancel()
+		metadata := map[string]string{
+			"database_id": fmt.Sprintf("%d", policy.DatabaseID),
+			"idle_for":    time.Since(idleSince).Round(time.Second).String(),
+		}
+		if err != nil {
+			p.logger.Warning("Failed to suspend idle database", nil, metadata, err)
+			continue
+		}
+		p.logger.Info("Suspended idle database", nil, metadata)
+	}
+}
+
+// wakeDatabase starts a sleeping database for a connecting client and
+// returns its refreshed connection details. The client's handshake deadline
+// is extended while it waits.
+func (p *ProxyServer) wakeDatabase(frontend net.Conn, dbInfo *db.DatabaseInfo) (*db.DatabaseInfo, error) {
+	frontend.SetDeadline(time.Now().Add(suspend.WakeTimeout + AuthTimeout))
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(p.ctx, suspend.WakeTimeout)
+	defer cancel()
+	if err := suspend.Wake(ctx, dbInfo.ID); err != nil {
+		return nil, err
+	}
+	p.logger.Info("Woke sleeping database", nil, map[string]string{
+		"database_id": fmt.Sprintf("%d", dbInfo.ID),
+		"wake_time":   time.Since(start).Round(time.Millisecond).String(),
+	})
+	return db.GetDatabaseByID(dbInfo.ID)
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"baseful/audit"
+	"baseful/auth"
+)
+
+// authPolicy slows down clients that keep presenting invalid, expired or
+// revoked tokens, and locks them out when they continue
+var authPolicy = auth.ThrottlePolicy{
+	FreeAttempts: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Minute,
+	LockoutAfter: 30, LockoutFor: time.Hour, Window: time.Hour,
+}
+
+var authThrottle = auth.NewThrottle(authPolicy)
+
+// clientHost strips the port from a remote address so all connections of a
+// client share one counter
+func clientHost(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// authFailed counts a rejected token against the client and records the
+// attempt in the audit log. claims is nil when the token could not be parsed.
+func (p *ProxyServer) authFailed(remoteAddr, reason string, claims *auth.JWTClaims) {
+	host := clientHost(remoteAddr)
+	_, locked := authThrottle.Fail(host)
+	if locked {
+		p.logger.ClientLockedOut(host, authPolicy.LockoutAfter, authPolicy.LockoutFor)
+	}
+
+	// A standalone proxy opens the database read-only and cannot write the audit log
+	if os.Getenv("DB_READ_ONLY") == "true" {
+		return
+	}
+	entry := audit.Entry{
+		Action: "proxy.auth", TargetType: "ip", TargetID: host, IP: host,
+		Outcome: audit.OutcomeDenied, Details: map[string]any{"reason": reason},
+	}
+	if claims != nil && claims.TokenID != "" {
+		entry.TargetType, entry.TargetID = "token", claims.TokenID
+		entry.Details["databaseId"] = claims.DatabaseID
+	}
+	if err := audit.Write(entry); err != nil {
+		log.Printf("Failed to write audit log entry: %v", err)
+	}
+	if !locked {
+		return
+	}
+	if err := audit.Write(audit.Entry{
+		Action: "proxy.lockout", TargetType: "ip", TargetID: host, IP: host,
+		Outcome: audit.OutcomeDenied, Details: map[string]any{"reason": reason},
+	}); err != nil {
+		log.Printf("Failed to write audit log entry: %v", err)
+	}
+}
+
+// AuthLockouts lists the clients that are throttled or locked out after
+// failed token authentication
+func AuthLockouts() []auth.ThrottleState {
+	return authThrottle.Blocked()
+}
+
+// UnlockClient clears the failed authentication attempts of a client IP
+func UnlockClient(ip string) bool {
+	return authThrottle.Reset(ip)
+}
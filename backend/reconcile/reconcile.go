@@ -0,0 +1,530 @@
+// Package reconcile keeps the database and branch statuses stored in SQLite in
+// line with the Docker containers behind them. It follows Docker events for
+// Baseful containers and periodically compares the full state, so crashes,
+// manual removals and Docker restarts show up in the dashboard.
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"baseful/db"
+	"baseful/docker"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// Row kinds
+const (
+	KindDatabase = "database"
+	KindBranch   = "branch"
+	KindReplica  = "replica"
+)
+
+// StatusMissing marks a row whose container no longer exists
+const StatusMissing = "missing"
+
+// fullSyncInterval is how often the whole state is compared, to catch events
+// missed while the backend or the event stream was down
+const fullSyncInterval = 5 * time.Minute
+
+// managedFilter selects the containers Baseful created
+var managedFilter = filters.Arg("label", "managed-by=baseful")
+
+// Drift is a database or branch whose recorded status does not match its
+// container
+type Drift struct {
+	ServerID       int    `json:"serverId"`
+	Kind           string `json:"kind"`
+	ID             int    `json:"id"`
+	DatabaseID     int    `json:"databaseId"`
+	Name           string `json:"name"`
+	ContainerID    string `json:"containerId"`
+	RecordedStatus string `json:"recordedStatus"`
+	ActualStatus   string `json:"actualStatus"`
+	ContainerState string `json:"containerState,omitempty"`
+}
+
+// Orphan is a Baseful container that no database or branch refers to
+type Orphan struct {
+	ServerID    int               `json:"serverId"`
+	ContainerID string            `json:"containerId"`
+	Name        string            `json:"name"`
+	Image       string            `json:"image"`
+	State       string            `json:"state"`
+	Labels      map[string]string `json:"labels"`
+	Created     time.Time         `json:"created"`
+}
+
+// Unreachable is a remote Docker host that could not be checked. Its rows
+// are left as they are rather than reported as dangling.
+type Unreachable struct {
+	ServerID int    `json:"serverId"`
+	Name     string `json:"name"`
+	Error    string `json:"error"`
+}
+
+// Report lists the differences between SQLite and Docker. Mismatched rows
+// have a container in another state, dangling rows have none.
+type Report struct {
+	CheckedAt   time.Time     `json:"checkedAt"`
+	Mismatched  []Drift       `json:"mismatched"`
+	Dangling    []Drift       `json:"dangling"`
+	Orphans     []Orphan      `json:"orphans"`
+	Unreachable []Unreachable `json:"unreachable"`
+}
+
+func newReport() *Report {
+	return &Report{
+		CheckedAt: time.Now().UTC(), Mismatched: []Drift{}, Dangling: []Drift{},
+		Orphans: []Orphan{}, Unreachable: []Unreachable{},
+	}
+}
+
+// row is a database or branch with the container it runs in
+type row struct {
+	kind        string
+	id          int
+	databaseID  int
+	name        string
+	containerID string
+	status      string
+}
+
+// statusFor returns the status a row should have for a container state.
+// Databases call a running container "active", branches and replicas "running".
+// Sleeping databases stay sleeping while their container is stopped.
+func statusFor(kind, recorded, state string) string {
+	switch {
+	case state == "":
+		return StatusMissing
+	case state == container.StateRunning || state == container.StateRestarting:
+		if kind == KindDatabase {
+			return "active"
+		}
+		return "running"
+	case kind == KindDatabase && recorded == db.StatusSleeping:
+		return db.StatusSleeping
+	default:
+		return "stopped"
+	}
+}
+
+// serverIDs returns the local daemon followed by every remote server
+func serverIDs() ([]int, map[int]string, error) {
+	servers, err := db.ListServers()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list servers: %w", err)
+	}
+	ids := []int{docker.LocalServer}
+	names := map[int]string{docker.LocalServer: "local"}
+	for _, s := range servers {
+		ids = append(ids, s.ID)
+		names[s.ID] = s.Name
+	}
+	return ids, names, nil
+}
+
+// loadRows returns the databases and branches running on a server
+func loadRows(serverID int) ([]row, error) {
+	rows, err := db.DB.Query(`
+		SELECT 'database', id, id, name, COALESCE(container_id, ''), COALESCE(status, '')
+		FROM databases WHERE COALESCE(server_id, 0) = ?
+		UNION ALL
+		SELECT 'branch', b.id, b.database_id, b.name, COALESCE(b.container_id, ''), COALESCE(b.status, '')
+		FROM branches b JOIN databases d ON d.id = b.database_id WHERE COALESCE(d.server_id, 0) = ?
+		UNION ALL
+		SELECT 'replica', r.id, r.database_id, r.name, COALESCE(r.container_id, ''), COALESCE(r.status, '')
+		FROM replicas r JOIN databases d ON d.id = r.database_id WHERE COALESCE(d.server_id, 0) = ?
+	`, serverID, serverID, serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.kind, &r.id, &r.databaseID, &r.name, &r.containerID, &r.status); err != nil {
+			return nil, err
+		}
+		if r.containerID != "" {
+			result = append(result, r)
+		}
+	}
+	return result, rows.Err()
+}
+
+// Check compares every database and branch with the Baseful containers on
+// the local daemon and every remote server
+func Check(ctx context.Context) (*Report, error) {
+	ids, names, err := serverIDs()
+	if err != nil {
+		return nil, err
+	}
+	report := newReport()
+	for _, serverID := range ids {
+		err := func() error {
+			cli, err := docker.NewClient(serverID)
+			if err != nil {
+				return err
+			}
+			defer cli.Close()
+			return check(ctx, cli, serverID, report)
+		}()
+		if err == nil {
+			continue
+		}
+		if serverID == docker.LocalServer {
+			return nil, err
+		}
+		report.Unreachable = append(report.Unreachable, Unreachable{ServerID: serverID, Name: names[serverID], Error: err.Error()})
+	}
+	return report, nil
+}
+
+// check adds the drift of one server to a report
+func check(ctx context.Context, cli *client.Client, serverID int, report *Report) error {
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true, Filters: filters.NewArgs(managedFilter)})
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %w", err)
+	}
+	rows, err := loadRows(serverID)
+	if err != nil {
+		return fmt.Errorf("failed to load databases: %w", err)
+	}
+
+	var mismatched, dangling []Drift
+	var orphans []Orphan
+	byID := map[string]container.Summary{}
+	for _, c := range containers {
+		byID[c.ID] = c
+	}
+
+	referenced := map[string]bool{}
+	for _, r := range rows {
+		referenced[r.containerID] = true
+		c, ok := byID[r.containerID]
+		if !ok {
+			// Containers can exist without the label when created by hand
+			inspect, err := cli.ContainerInspect(ctx, r.containerID)
+			if err == nil {
+				c = container.Summary{ID: inspect.ID, State: inspect.State.Status}
+				ok = true
+			} else if !cerrdefs.IsNotFound(err) {
+				return fmt.Errorf("failed to inspect container %s: %w", r.containerID, err)
+			}
+		}
+
+		drift := Drift{
+			ServerID: serverID, Kind: r.kind, ID: r.id, DatabaseID: r.databaseID, Name: r.name,
+			ContainerID: r.containerID, RecordedStatus: r.status,
+		}
+		if !ok {
+			drift.ActualStatus = StatusMissing
+			if r.status != StatusMissing {
+				dangling = append(dangling, drift)
+			}
+			continue
+		}
+		drift.ContainerState = c.State
+		drift.ActualStatus = statusFor(r.kind, r.status, c.State)
+		if drift.ActualStatus != r.status {
+			mismatched = append(mismatched, drift)
+		}
+	}
+
+	for _, c := range containers {
+		if referenced[c.ID] {
+			continue
+		}
+		name := ""
+		if len(c.Names) > 0 {
+			name = c.Names[0]
+		}
+		orphans = append(orphans, Orphan{
+			ServerID: serverID, ContainerID: c.ID, Name: name, Image: c.Image, State: c.State,
+			Labels: c.Labels, Created: time.Unix(c.Created, 0).UTC(),
+		})
+	}
+
+	// Only add to the report once the whole server was checked
+	report.Mismatched = append(report.Mismatched, mismatched...)
+	report.Dangling = append(report.Dangling, dangling...)
+	report.Orphans = append(report.Orphans, orphans...)
+	return nil
+}
+
+// Sync checks the state and records the actual status of every drifted row.
+// Dangling rows are marked missing; rows on unreachable servers are left
+// alone. It returns the number of rows updated.
+func Sync(ctx context.Context) (int, error) {
+	report, err := Check(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return apply(report)
+}
+
+// syncServer records the actual status of every drifted row on one server
+func syncServer(ctx context.Context, cli *client.Client, serverID int) (int, error) {
+	report := newReport()
+	if err := check(ctx, cli, serverID, report); err != nil {
+		return 0, err
+	}
+	return apply(report)
+}
+
+func apply(report *Report) (int, error) {
+	updated := 0
+	for _, d := range append(report.Mismatched, report.Dangling...) {
+		if err := setStatus(d.Kind, d.ID, d.RecordedStatus, d.ActualStatus); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// setStatus records a status change. The old status guards against
+// overwriting a change an API action made in the meantime.
+func setStatus(kind string, id int, from, to string) error {
+	table := "databases"
+	switch kind {
+	case KindBranch:
+		table = "branches"
+	case KindReplica:
+		table = "replicas"
+	}
+	_, err := db.DB.Exec("UPDATE "+table+" SET status = ? WHERE id = ? AND COALESCE(status, '') = ?", to, id, from)
+	if err != nil {
+		return fmt.Errorf("failed to update %s %d: %w", kind, id, err)
+	}
+	log.Printf("Reconciler: %s %d is %s (was %s)", kind, id, to, from)
+	return nil
+}
+
+// syncContainer updates the rows running in one container after an event
+func syncContainer(ctx context.Context, cli *client.Client, serverID int, containerID string) error {
+	state := ""
+	inspect, err := cli.ContainerInspect(ctx, containerID)
+	if err == nil {
+		containerID = inspect.ID
+		state = inspect.State.Status
+	} else if !cerrdefs.IsNotFound(err) {
+		return err
+	}
+
+	rows, err := loadRows(serverID)
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if r.containerID != containerID {
+			continue
+		}
+		if status := statusFor(r.kind, r.status, state); status != r.status {
+			if err := setStatus(r.kind, r.id, r.status, status); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// relevantActions are the container events that can change a status
+var relevantActions = map[events.Action]bool{
+	events.ActionStart: true, events.ActionRestart: true, events.ActionStop: true,
+	events.ActionDie: true, events.ActionKill: true, events.ActionOOM: true,
+	events.ActionDestroy: true, events.ActionPause: true, events.ActionUnPause: true,
+}
+
+// Start runs the reconciler in the background until ctx is cancelled, with
+// one watcher for the local daemon and one for every remote server
+func Start(ctx context.Context) {
+	ids, _, err := serverIDs()
+	if err != nil {
+		log.Printf("Reconciler: %v; watching the local daemon only", err)
+		ids = []int{docker.LocalServer}
+	}
+	for _, serverID := range ids {
+		Watch(ctx, serverID)
+	}
+}
+
+// Watch reconciles one server in the background until ctx is cancelled or
+// the server is removed. Call it for servers registered after Start.
+func Watch(ctx context.Context, serverID int) {
+	go func() {
+		backoff := time.Second
+		for {
+			err := run(ctx, serverID)
+			if ctx.Err() != nil || errors.Is(err, docker.ErrServerNotFound) {
+				return
+			}
+			log.Printf("Reconciler: server %d: %v, retrying in %s", serverID, err, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff = min(backoff*2, time.Minute)
+		}
+	}()
+}
+
+// run does a full sync of a server, then follows its events until the
+// stream fails
+func run(ctx context.Context, serverID int) error {
+	cli, err := docker.NewClient(serverID)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	// Subscribe before the full sync so no event in between is lost
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	messages, errs := cli.Events(streamCtx, events.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("type", string(events.ContainerEventType)), managedFilter),
+	})
+
+	if _, err := syncServer(ctx, cli, serverID); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(fullSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-messages:
+			if !relevantActions[msg.Action] {
+				continue
+			}
+			if err := syncContainer(ctx, cli, serverID, msg.Actor.ID); err != nil {
+				log.Printf("Reconciler: failed to sync container %s: %v", msg.Actor.ID, err)
+			}
+		case <-ticker.C:
+			if _, err := syncServer(ctx, cli, serverID); err != nil {
+				log.Printf("Reconciler: full sync failed: %v", err)
+			}
+		case err := <-errs:
+			if err == nil {
+				err = errors.New("event stream closed")
+			}
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Repair actions
+const (
+	// RepairSync records the actual status of a drifted row
+	RepairSync = "sync"
+	// RepairStart starts the stopped container of a row
+	RepairStart = "start"
+	// RepairDeleteRow deletes a branch or database whose container is gone
+	RepairDeleteRow = "delete_row"
+	// RepairRemoveContainer removes an orphaned container
+	RepairRemoveContainer = "remove_container"
+)
+
+// ErrNoDrift is returned when a repair targets something that is in sync
+var ErrNoDrift = errors.New("no drift found for this target")
+
+// Repair fixes one drifted row or orphaned container on a server. Rows are
+// addressed by kind and id, orphans by container ID.
+func Repair(ctx context.Context, serverID int, action, kind string, id int, containerID string) error {
+	cli, err := docker.NewClient(serverID)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	report := newReport()
+	if err := check(ctx, cli, serverID, report); err != nil {
+		return err
+	}
+
+	if action == RepairRemoveContainer {
+		for _, o := range report.Orphans {
+			if o.ContainerID == containerID {
+				if err := cli.ContainerRemove(ctx, o.ContainerID, container.RemoveOptions{Force: true, RemoveVolumes: true}); err != nil {
+					return fmt.Errorf("failed to remove container: %w", err)
+				}
+				return nil
+			}
+		}
+		return ErrNoDrift
+	}
+
+	var drift *Drift
+	for _, d := range append(report.Mismatched, report.Dangling...) {
+		if d.Kind == kind && d.ID == id {
+			drift = &d
+			break
+		}
+	}
+	if drift == nil {
+		return ErrNoDrift
+	}
+
+	switch action {
+	case RepairSync:
+		return setStatus(drift.Kind, drift.ID, drift.RecordedStatus, drift.ActualStatus)
+	case RepairStart:
+		if drift.ActualStatus == StatusMissing {
+			return errors.New("the container no longer exists")
+		}
+		if err := cli.ContainerStart(ctx, drift.ContainerID, container.StartOptions{}); err != nil {
+			return fmt.Errorf("failed to start container: %w", err)
+		}
+		return syncContainer(ctx, cli, serverID, drift.ContainerID)
+	case RepairDeleteRow:
+		if drift.ActualStatus != StatusMissing {
+			return errors.New("only rows without a container can be deleted")
+		}
+		return deleteRow(drift)
+	}
+	return fmt.Errorf("unknown repair action %q", action)
+}
+
+// deleteRow removes a dangling branch or replica, or a dangling database
+// together with its tokens, branches and replicas
+func deleteRow(d *Drift) error {
+	switch d.Kind {
+	case KindBranch:
+		_, err := db.DB.Exec("DELETE FROM branches WHERE id = ?", d.ID)
+		return err
+	case KindReplica:
+		// The metrics collector drops the replica's slot on the primary
+		return db.DeleteReplica(d.ID)
+	}
+
+	if err := db.RevokeAllTokensForDatabase(d.ID); err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"DELETE FROM database_tokens WHERE database_id = ?",
+		"DELETE FROM branches WHERE database_id = ?",
+		"DELETE FROM replicas WHERE database_id = ?",
+		"DELETE FROM database_events WHERE database_id = ?",
+		"DELETE FROM database_config_changes WHERE database_id = ?",
+		"DELETE FROM database_roles WHERE database_id = ?",
+		"DELETE FROM databases WHERE id = ?",
+	} {
+		if _, err := db.DB.Exec(query, d.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,459 @@
+// Package replication adds streaming replication read replicas to databases.
+// A replica is a container bootstrapped with pg_basebackup from its primary,
+// which then runs as a hot standby following the primary over a replication
+// slot. Replication state and lag are sampled by the metrics collector.
+package replication
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"baseful/db"
+	"baseful/docker"
+	"baseful/pg"
+	"baseful/secrets"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+)
+
+// ReplicatorRole is the role replicas log into their primary with
+const ReplicatorRole = "baseful_replicator"
+
+// ErrPrimaryNotRunning is returned when replicas are added to a stopped database
+var ErrPrimaryNotRunning = errors.New("the database must be running to add a replica")
+
+// bootstrapScript clones the primary into an empty data directory, writing
+// standby.signal and primary_conninfo, then starts Postgres as usual. A
+// failed clone is wiped so the next start retries it.
+const bootstrapScript = `set -e
+if [ ! -s "$PGDATA/PG_VERSION" ]; then
+	mkdir -p "$PGDATA"
+	chmod 700 "$PGDATA"
+	chown postgres:postgres "$PGDATA"
+	gosu postgres pg_basebackup -D "$PGDATA" -R -X stream -S "$REPLICATION_SLOT" \
+		-d "host=$PRIMARY_HOST port=5432 user=` + ReplicatorRole + ` application_name=$REPLICATION_SLOT" \
+		|| { rm -rf "$PGDATA"/*; exit 1; }
+fi
+exec docker-entrypoint.sh postgres
+`
+
+// primary is the database a replica is created from
+type primary struct {
+	id          int
+	name        string
+	host        string
+	containerID string
+	status      string
+	password    string
+	projectID   int
+	serverID    int
+	maxCPU      float64
+	maxRAMMB    int
+}
+
+func loadPrimary(databaseID int) (*primary, error) {
+	var p primary
+	err := db.DB.QueryRow(`
+		SELECT id, name, host, COALESCE(container_id, ''), COALESCE(status, ''), password,
+			COALESCE(project_id, 0), COALESCE(server_id, 0), COALESCE(max_cpu, 1), COALESCE(max_ram_mb, 512)
+		FROM databases WHERE id = ?
+	`, databaseID).Scan(&p.id, &p.name, &p.host, &p.containerID, &p.status, &p.password,
+		&p.projectID, &p.serverID, &p.maxCPU, &p.maxRAMMB)
+	if err != nil {
+		return nil, err
+	}
+	if p.password, err = secrets.Decrypt(p.password); err != nil {
+		return nil, fmt.Errorf("failed to decrypt database password: %w", err)
+	}
+	return &p, nil
+}
+
+// Create adds a replica with a role to a database and starts it. The replica
+// streams from a slot of its own; it shows up as streaming once the metrics
+// collector sees it connected.
+func Create(ctx context.Context, databaseID int, name, role string) (*db.Replica, error) {
+	p, err := loadPrimary(databaseID)
+	if err != nil {
+		return nil, err
+	}
+	if p.status != "active" || p.containerID == "" {
+		return nil, ErrPrimaryNotRunning
+	}
+
+	cli, err := docker.NewClient(p.serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	// Replicas run the exact image of their primary
+	inspect, err := cli.ContainerInspect(ctx, p.containerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect the primary: %w", err)
+	}
+	replicationPassword, err := preparePrimary(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	replica := &db.Replica{DatabaseID: databaseID, Name: name, Role: role, Port: 5432, Status: "creating"}
+	if err := db.CreateReplica(replica); err != nil {
+		return nil, fmt.Errorf("failed to save replica: %w", err)
+	}
+	fail := func(err error) (*db.Replica, error) {
+		if replica.ContainerID != "" {
+			_ = cli.ContainerRemove(ctx, replica.ContainerID, container.RemoveOptions{Force: true, RemoveVolumes: true})
+		}
+		if dropErr := dropSlot(ctx, databaseID, replica.SlotName()); dropErr != nil {
+			log.Printf("Failed to drop replication slot %s: %v", replica.SlotName(), dropErr)
+		}
+		_ = db.DeleteReplica(replica.ID)
+		return nil, err
+	}
+
+	if err := createSlot(ctx, databaseID, replica.SlotName()); err != nil {
+		return fail(err)
+	}
+
+	random := make([]byte, 4)
+	rand.Read(random)
+	kind := "replica"
+	if role == db.ReplicaRoleStandby {
+		kind = "standby"
+	}
+	containerName := fmt.Sprintf("baseful-%s-%s-%s-%s", p.name, kind, name, hex.EncodeToString(random))
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image:      inspect.Config.Image,
+		Hostname:   name,
+		Entrypoint: []string{"bash", "-c", bootstrapScript},
+		Env: []string{
+			"POSTGRES_PASSWORD=" + p.password,
+			"POSTGRES_DB=" + p.name,
+			"PGPASSWORD=" + replicationPassword,
+			"PRIMARY_HOST=" + p.host,
+			"REPLICATION_SLOT=" + replica.SlotName(),
+		},
+		ExposedPorts: nat.PortSet{
+			"5432/tcp": struct{}{},
+		},
+		Labels: map[string]string{
+			"managed-by":         "baseful",
+			"baseful.database":   p.name,
+			"baseful.replica":    name,
+			"baseful.project_id": fmt.Sprintf("%d", p.projectID),
+		},
+	}, &container.HostConfig{
+		NetworkMode: docker.NetworkName,
+		PortBindings: nat.PortMap{
+			"5432/tcp": []nat.PortBinding{{HostIP: "0.0.0.0"}},
+		},
+		Resources: container.Resources{
+			Memory:   int64(p.maxRAMMB) * 1024 * 1024,
+			NanoCPUs: int64(p.maxCPU * 1000000000),
+		},
+		SecurityOpt: []string{
+			"no-new-privileges:true",
+		},
+		CapDrop: []string{"ALL"},
+		CapAdd:  []string{"CHOWN", "SETGID", "SETUID", "DAC_OVERRIDE"},
+		RestartPolicy: container.RestartPolicy{
+			Name: "unless-stopped",
+		},
+	}, nil, nil, containerName)
+	if err != nil {
+		return fail(fmt.Errorf("failed to create container: %w", err))
+	}
+	replica.ContainerID = resp.ID
+	replica.Host = containerName
+
+	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return fail(fmt.Errorf("failed to start container: %w", err))
+	}
+	if replica.MappedPort, err = docker.MappedPort(ctx, cli, resp.ID, "5432/tcp"); err != nil {
+		return fail(err)
+	}
+	replica.Status = "running"
+	if err := db.UpdateReplicaContainer(replica); err != nil {
+		return fail(fmt.Errorf("failed to save replica: %w", err))
+	}
+	return replica, nil
+}
+
+// preparePrimary makes sure the replication role exists and may connect,
+// and returns its password. The password is kept so replicas created
+// earlier keep working.
+func preparePrimary(ctx context.Context, p *primary) (string, error) {
+	password, err := db.GetReplicationPassword(p.id)
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		random := make([]byte, 24)
+		if _, err := rand.Read(random); err != nil {
+			return "", err
+		}
+		password = hex.EncodeToString(random)
+		if err := db.SetReplicationPassword(p.id, password); err != nil {
+			return "", fmt.Errorf("failed to save replication password: %w", err)
+		}
+	}
+
+	conn, err := pg.Connect(ctx, p.id)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close(ctx)
+
+	var exists bool
+	if err := conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = $1)", ReplicatorRole).Scan(&exists); err != nil {
+		return "", fmt.Errorf("failed to look up the replication role: %w", err)
+	}
+	statement := "CREATE ROLE " + ReplicatorRole + " WITH REPLICATION LOGIN PASSWORD "
+	if exists {
+		statement = "ALTER ROLE " + ReplicatorRole + " WITH REPLICATION LOGIN PASSWORD "
+	}
+	if _, err := conn.Exec(ctx, statement+"'"+strings.ReplaceAll(password, "'", "''")+"'"); err != nil {
+		return "", fmt.Errorf("failed to set up the replication role: %w", err)
+	}
+
+	// The image only allows regular connections from the network; replication
+	// connections need a pg_hba.conf entry of their own
+	var hbaFile string
+	if err := conn.QueryRow(ctx, "SHOW hba_file").Scan(&hbaFile); err != nil {
+		return "", fmt.Errorf("failed to locate pg_hba.conf: %w", err)
+	}
+	cli, err := docker.NewClient(p.serverID)
+	if err != nil {
+		return "", err
+	}
+	defer cli.Close()
+	_, err = docker.ExecOutput(ctx, cli, p.containerID, container.ExecOptions{
+		User: "postgres",
+		Env: []string{
+			"HBA_FILE=" + hbaFile,
+			"HBA_LINE=host replication " + ReplicatorRole + " all scram-sha-256",
+		},
+		Cmd: []string{"sh", "-c", `grep -qxF "$HBA_LINE" "$HBA_FILE" || echo "$HBA_LINE" >> "$HBA_FILE"`},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to allow replication connections: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "SELECT pg_reload_conf()"); err != nil {
+		return "", fmt.Errorf("failed to reload the configuration: %w", err)
+	}
+	return password, nil
+}
+
+func createSlot(ctx context.Context, databaseID int, slot string) error {
+	conn, err := pg.Connect(ctx, databaseID)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+	_, err = conn.Exec(ctx, `SELECT pg_create_physical_replication_slot($1)
+		WHERE NOT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1)`, slot)
+	if err != nil {
+		return fmt.Errorf("failed to create replication slot: %w", err)
+	}
+	return nil
+}
+
+// dropSlot removes a replication slot so the primary stops retaining WAL for it
+func dropSlot(ctx context.Context, databaseID int, slot string) error {
+	conn, err := pg.Connect(ctx, databaseID)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+	_, err = conn.Exec(ctx, `SELECT pg_drop_replication_slot(slot_name) FROM pg_replication_slots
+		WHERE slot_name = $1 AND NOT active`, slot)
+	return err
+}
+
+// Promote turns a replica into a primary, waiting up to a minute for it to
+// leave recovery. The caller records the new primary.
+func Promote(ctx context.Context, replica *db.Replica) error {
+	serverID, err := db.GetDatabaseServerID(replica.DatabaseID)
+	if err != nil {
+		return err
+	}
+	cli, err := docker.NewClient(serverID)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	out, err := docker.ExecOutput(ctx, cli, replica.ContainerID, container.ExecOptions{
+		User: "postgres",
+		Cmd:  []string{"psql", "-t", "-A", "-c", "SELECT pg_promote(true, 60)"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to promote replica: %w", err)
+	}
+	if strings.TrimSpace(out) != "t" {
+		return fmt.Errorf("replica %s did not finish promotion within a minute", replica.Name)
+	}
+	return nil
+}
+
+// Follow points the replicas of a database at its current primary, e.g. after
+// a failover. Each replica gets its slot on the new primary and restarts with
+// a new primary_conninfo, then follows the promoted timeline. Replicas that
+// replayed past the point of promotion cannot follow and stay disconnected.
+func Follow(ctx context.Context, databaseID int) error {
+	p, err := loadPrimary(databaseID)
+	if err != nil {
+		return err
+	}
+	password, err := db.GetReplicationPassword(databaseID)
+	if err != nil {
+		return err
+	}
+	replicas, err := db.ListReplicas(databaseID)
+	if err != nil {
+		return err
+	}
+	cli, err := docker.NewClient(p.serverID)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	var failed []string
+	for i := range replicas {
+		if err := follow(ctx, cli, p, password, &replicas[i]); err != nil {
+			log.Printf("Replica %s could not follow the new primary of %s: %v", replicas[i].Name, p.name, err)
+			failed = append(failed, replicas[i].Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("replicas %s could not follow the new primary", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// followScript replaces primary_conninfo in postgresql.auto.conf, where
+// pg_basebackup -R wrote it
+const followScript = `conf="$PGDATA/postgresql.auto.conf"
+grep -v '^primary_conninfo' "$conf" > "$conf.tmp"
+echo "$PRIMARY_CONNINFO" >> "$conf.tmp" && mv "$conf.tmp" "$conf"`
+
+func follow(ctx context.Context, cli *client.Client, p *primary, password string, replica *db.Replica) error {
+	if replica.ContainerID == "" {
+		return nil
+	}
+	if err := createSlot(ctx, p.id, replica.SlotName()); err != nil {
+		return err
+	}
+	conninfo := fmt.Sprintf("host=%s port=5432 user=%s password=%s application_name=%s",
+		p.host, ReplicatorRole, password, replica.SlotName())
+	_, err := docker.ExecOutput(ctx, cli, replica.ContainerID, container.ExecOptions{
+		User: "postgres",
+		Env:  []string{"PRIMARY_CONNINFO=primary_conninfo = '" + conninfo + "'"},
+		Cmd:  []string{"bash", "-c", followScript},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update primary_conninfo: %w", err)
+	}
+	if err := cli.ContainerRestart(ctx, replica.ContainerID, container.StopOptions{}); err != nil {
+		return fmt.Errorf("failed to restart replica: %w", err)
+	}
+	return nil
+}
+
+// ApplyConfig copies settings changed on the primary to its replicas, which
+// keep their own postgresql.auto.conf. Standbys refuse to run with lower
+// limits, such as max_connections, than their primary.
+func ApplyConfig(ctx context.Context, databaseID int, changes []pg.ConfigChange) error {
+	replicas, err := db.ListReplicas(databaseID)
+	if err != nil {
+		return err
+	}
+	if len(replicas) == 0 || len(changes) == 0 {
+		return nil
+	}
+	serverID, err := db.GetDatabaseServerID(databaseID)
+	if err != nil {
+		return err
+	}
+	cli, err := docker.NewClient(serverID)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	// ALTER SYSTEM cannot run in a transaction, so every statement gets a -c
+	// of its own
+	cmd := []string{"psql", "-v", "ON_ERROR_STOP=1"}
+	for _, change := range changes {
+		cmd = append(cmd, "-c", pg.AlterSystemStatement(change.Name, change.NewValue))
+	}
+	cmd = append(cmd, "-c", "SELECT pg_reload_conf()")
+
+	var failed []string
+	for _, replica := range replicas {
+		if replica.ContainerID == "" || replica.Status != "running" {
+			failed = append(failed, replica.Name)
+			continue
+		}
+		_, err := docker.ExecOutput(ctx, cli, replica.ContainerID, container.ExecOptions{User: "postgres", Cmd: cmd})
+		if err != nil {
+			log.Printf("Failed to apply settings to replica %s: %v", replica.Name, err)
+			failed = append(failed, replica.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("settings were not applied to replicas %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Remove deletes a replica's container and row. Its slot is dropped when
+// the primary is reachable; otherwise the metrics collector drops it later.
+func Remove(ctx context.Context, replica *db.Replica) error {
+	serverID, err := db.GetDatabaseServerID(replica.DatabaseID)
+	if err != nil {
+		return err
+	}
+	if replica.ContainerID != "" {
+		cli, err := docker.NewClient(serverID)
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+		err = cli.ContainerRemove(ctx, replica.ContainerID, container.RemoveOptions{Force: true, RemoveVolumes: true})
+		if err != nil && !cerrdefs.IsNotFound(err) {
+			return fmt.Errorf("failed to remove container: %w", err)
+		}
+	}
+	if err := db.DeleteReplica(replica.ID); err != nil {
+		return err
+	}
+	if err := dropSlot(ctx, replica.DatabaseID, replica.SlotName()); err != nil {
+		log.Printf("Failed to drop replication slot %s, it is dropped on the next sample: %v", replica.SlotName(), err)
+	}
+	return nil
+}
+
+// RemoveAll deletes every replica of a database, e.g. before the database
+// itself is deleted
+func RemoveAll(ctx context.Context, databaseID int) error {
+	replicas, err := db.ListReplicas(databaseID)
+	if err != nil {
+		return err
+	}
+	for i := range replicas {
+		if err := Remove(ctx, &replicas[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// prefix marks encrypted values: enc:v1:<key id>:<wrapped data key>:<ciphertext>
+const prefix = "enc:v1:"
+
+// ErrUnknownKey is returned for values encrypted with a master key that is not configured
+var ErrUnknownKey = errors.New("value was encrypted with an unknown master key")
+
+type masterKey struct {
+	id  string
+	key []byte
+}
+
+var (
+	keysMu sync.Mutex
+	keys   []masterKey // keys[0] encrypts, all of them decrypt
+)
+
+// Init loads the master keys. It is called lazily by Encrypt and Decrypt, but
+// calling it at startup surfaces configuration errors early.
+//
+// Values are encrypted with their own random data key, which is wrapped with
+// the master key (envelope encryption). The master key comes from MASTER_KEY
+// or a key file (MASTER_KEY_FILE, by default master.key next to the database).
+// Retired keys in MASTER_KEY_PREVIOUS or on later lines of the key file keep
+// old values readable until rotate-master-key re-encrypts them.
+func Init() error {
+	_, err := currentKeys()
+	return err
+}
+
+func currentKeys() ([]masterKey, error) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+	if keys == nil {
+		loaded, err := loadKeys()
+		if err != nil {
+			return nil, err
+		}
+		keys = loaded
+	}
+	return keys, nil
+}
+
+// KeyID returns the ID of the current master key
+func KeyID() (string, error) {
+	k, err := currentKeys()
+	if err != nil {
+		return "", err
+	}
+	return k[0].id, nil
+}
+
+// IsEncrypted reports whether a stored value is encrypted
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// IsCurrent reports whether a stored value is encrypted with the current master key
+func IsCurrent(value string) bool {
+	id, err := KeyID()
+	if err != nil || !IsEncrypted(value) {
+		return false
+	}
+	return strings.HasPrefix(value, prefix+id+":")
+}
+
+// Encrypt encrypts a value for storage. Empty values stay empty.
+func Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	k, err := currentKeys()
+	if err != nil {
+		return "", err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrapped, err := seal(k[0].key, dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return prefix + k[0].id + ":" + encode(wrapped) + ":" + encode(ciphertext), nil
+}
+
+// Decrypt returns the plaintext of a stored value. Values that are not
+// encrypted, e.g. rows written before encryption was introduced, are
+// returned unchanged.
+func Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	all, err := currentKeys()
+	if err != nil {
+		return "", err
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted value")
+	}
+	var master []byte
+	for _, k := range all {
+		if k.id == parts[0] {
+			master = k.key
+			break
+		}
+	}
+	if master == nil {
+		return "", fmt.Errorf("%w (key id %s)", ErrUnknownKey, parts[0])
+	}
+
+	wrapped, err := decode(parts[1])
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := decode(parts[2])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := open(master, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	plaintext, err := open(dataKey, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// GenerateKey returns a new random master key in the format MASTER_KEY expects
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// UsesKeyFile reports whether the master key is read from a key file rather
+// than the MASTER_KEY environment variable
+func UsesKeyFile() bool {
+	return strings.TrimSpace(os.Getenv("MASTER_KEY")) == ""
+}
+
+// AddKeyToFile generates a new master key and makes it the current key of the
+// key file. The previous keys stay in the file so existing values remain
+// readable. It returns the ID of the new key.
+func AddKeyToFile() (string, error) {
+	if !UsesKeyFile() {
+		return "", errors.New("the master key is set through MASTER_KEY; set the new key there and the old one in MASTER_KEY_PREVIOUS")
+	}
+	path := keyFilePath()
+	existing, err := readKeyFile(path)
+	if err != nil {
+		return "", err
+	}
+	key, err := GenerateKey()
+	if err != nil {
+		return "", err
+	}
+	if err := writeKeyFile(path, append([]string{key}, existing...)); err != nil {
+		return "", err
+	}
+	return reload()
+}
+
+// RetireOldKeys removes every key but the current one from the key file. Only
+// call it once all values are encrypted with the current key.
+func RetireOldKeys() error {
+	if !UsesKeyFile() {
+		return nil
+	}
+	path := keyFilePath()
+	existing, err := readKeyFile(path)
+	if err != nil {
+		return err
+	}
+	if err := writeKeyFile(path, existing[:1]); err != nil {
+		return err
+	}
+	_, err = reload()
+	return err
+}
+
+func reload() (string, error) {
+	keysMu.Lock()
+	keys = nil
+	keysMu.Unlock()
+	return KeyID()
+}
+
+func keyFilePath() string {
+	if path := os.Getenv("MASTER_KEY_FILE"); path != "" {
+		return path
+	}
+	return DefaultKeyFile()
+}
+
+// writeKeyFile replaces the key file through a temporary file so a crash
+// never leaves it half written
+func writeKeyFile(path string, lines []string) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write master key file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write master key file: %w", err)
+	}
+	return nil
+}
+
+// DefaultKeyFile returns the key file used when MASTER_KEY_FILE is not set
+func DefaultKeyFile() string {
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "./data.db"
+	}
+	return filepath.Join(filepath.Dir(dbPath), "master.key")
+}
+
+func loadKeys() ([]masterKey, error) {
+	var encoded []string
+	if env := strings.TrimSpace(os.Getenv("MASTER_KEY")); env != "" {
+		encoded = append(encoded, env)
+	} else {
+		lines, err := readKeyFile(keyFilePath())
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, lines...)
+	}
+	for _, previous := range strings.Split(os.Getenv("MASTER_KEY_PREVIOUS"), ",") {
+		if previous = strings.TrimSpace(previous); previous != "" {
+			encoded = append(encoded, previous)
+		}
+	}
+
+	result := make([]masterKey, 0, len(encoded))
+	for i, e := range encoded {
+		key, err := base64.StdEncoding.DecodeString(e)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("master key %d must be 32 bytes encoded as base64", i+1)
+		}
+		sum := sha256.Sum256(key)
+		result = append(result, masterKey{id: hex.EncodeToString(sum[:4]), key: key})
+	}
+	return result, nil
+}
+
+// readKeyFile returns the keys in a key file, current key first. A missing
+// file is created with a new key unless the database is opened read-only.
+func readKeyFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && os.Getenv("DB_READ_ONLY") != "true" {
+		key, err := GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("failed to create master key file: %w", err)
+		}
+		fmt.Printf("Warning: MASTER_KEY not set, generated master key file %s. Back it up: secrets in the database cannot be decrypted without it.\n", path)
+		return []string{key}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key file: %w", err)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("master key file %s is empty", path)
+	}
+	return lines, nil
+}
+
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
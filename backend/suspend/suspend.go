@@ -0,0 +1,160 @@
+// Package suspend puts idle databases to sleep and wakes them up again. A
+// sleeping database's container is stopped; the proxy and the backend start
+// it again on the next connection and wait until Postgres accepts
+// connections. The proxy decides when a database is idle.
+package suspend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"baseful/db"
+	"baseful/docker"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// WakeTimeout bounds how long a connection waits for a sleeping database
+const WakeTimeout = 60 * time.Second
+
+// ErrNotReady is returned when a woken database does not accept connections in time
+var ErrNotReady = errors.New("the database did not become ready in time")
+
+var (
+	locksMu sync.Mutex
+	locks   = map[int]*sync.Mutex{}
+
+	// lastActivity holds when each database was last used through this
+	// process. Databases not used since it started count from its start.
+	lastActivity sync.Map
+	started      = time.Now()
+)
+
+// lock serializes suspending and waking a database
+func lock(databaseID int) func() {
+	locksMu.Lock()
+	l, ok := locks[databaseID]
+	if !ok {
+		l = &sync.Mutex{}
+		locks[databaseID] = l
+	}
+	locksMu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// Touch records that a database is in use
+func Touch(databaseID int) {
+	lastActivity.Store(databaseID, time.Now())
+}
+
+// LastActivity returns when a database was last used through this process
+func LastActivity(databaseID int) time.Time {
+	if t, ok := lastActivity.Load(databaseID); ok {
+		return t.(time.Time)
+	}
+	return started
+}
+
+// Suspend stops the container of an active database and marks it sleeping.
+// The status changes first so the reconciler does not take the stop for a
+// crash.
+func Suspend(ctx context.Context, databaseID int) error {
+	unlock := lock(databaseID)
+	defer unlock()
+
+	var containerID string
+	var serverID int
+	err := db.DB.QueryRow("SELECT COALESCE(container_id, ''), COALESCE(server_id, 0) FROM databases WHERE id = ?",
+		databaseID).Scan(&containerID, &serverID)
+	if err != nil {
+		return err
+	}
+	if containerID == "" {
+		return nil
+	}
+	if marked, err := db.MarkSleeping(databaseID); err != nil || !marked {
+		return err
+	}
+
+	cli, err := docker.NewClient(serverID)
+	if err == nil {
+		defer cli.Close()
+		err = cli.ContainerStop(ctx, containerID, container.StopOptions{})
+	}
+	if err != nil {
+		db.DB.Exec("UPDATE databases SET status = 'active', suspended_at = NULL WHERE id = ? AND status = ?", databaseID, db.StatusSleeping)
+		return fmt.Errorf("failed to stop container: %w", err)
+	}
+	log.Printf("Suspended idle database %d", databaseID)
+	return nil
+}
+
+// Wake starts a sleeping database and waits until it accepts connections.
+// It returns right away for databases that are not sleeping; concurrent
+// callers wait for the same wake-up.
+func Wake(ctx context.Context, databaseID int) error {
+	unlock := lock(databaseID)
+	defer unlock()
+
+	var containerID, status string
+	var serverID int
+	err := db.DB.QueryRow("SELECT COALESCE(container_id, ''), COALESCE(status, ''), COALESCE(server_id, 0) FROM databases WHERE id = ?",
+		databaseID).Scan(&containerID, &status, &serverID)
+	if err != nil {
+		return err
+	}
+	if status != db.StatusSleeping {
+		return nil
+	}
+
+	cli, err := docker.NewClient(serverID)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	begin := time.Now()
+	if err := cli.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start container: %w", err)
+	}
+	if err := waitReady(ctx, cli, containerID); err != nil {
+		return err
+	}
+	// Published ports are assigned again on every start unless fixed
+	mappedPort, err := docker.MappedPort(ctx, cli, containerID, "5432/tcp")
+	if err != nil {
+		return err
+	}
+	if err := db.MarkAwake(databaseID, mappedPort); err != nil {
+		return err
+	}
+	Touch(databaseID)
+	log.Printf("Woke database %d in %s", databaseID, time.Since(begin).Round(time.Millisecond))
+	return nil
+}
+
+// waitReady polls pg_isready in the container until Postgres accepts TCP
+// connections
+func waitReady(ctx context.Context, cli *client.Client, containerID string) error {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		_, err := docker.ExecOutput(ctx, cli, containerID, container.ExecOptions{
+			Cmd: []string{"pg_isready", "-q", "-h", "127.0.0.1", "-p", "5432", "-U", "postgres"},
+		})
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ErrNotReady
+		case <-ticker.C:
+		}
+	}
+}
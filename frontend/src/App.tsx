@@ -15,6 +15,9 @@ import Security from "./pages/Security";
 import Users from "./pages/Users";
 import Login from "./pages/Login";
 import Register from "./pages/Register";
+import ForgotPassword from "./pages/ForgotPassword";
+import ResetPassword from "./pages/ResetPassword";
+import VerifyEmail from "./pages/VerifyEmail";
 import Profile from "./pages/Profile";
 import AuthLayout from "./components/auth/AuthLayout";
 import Sidebar from "./components/dashboard/sidebar";
@@ -38,6 +41,9 @@ function App() {
                 <Route element={<AuthLayout />}>
                   <Route path="/login" element={<Login />} />
                   <Route path="/register" element={<Register />} />
+                  <Route path="/forgot-password" element={<ForgotPassword />} />
+                  <Route path="/reset-password" element={<ResetPassword />} />
+                  <Route path="/verify-email" element={<VerifyEmail />} />
                 </Route>
 
                 {/* Protected Routes */}
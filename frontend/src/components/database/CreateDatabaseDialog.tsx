@@ -32,6 +32,22 @@ interface Project {
   description: string;
 }
 
+interface Server {
+  id: number;
+  name: string;
+  host: string;
+}
+
+interface Flavor {
+  name: string;
+  label: string;
+  description: string;
+  extensions: string[];
+  versions: string[];
+}
+
+const POSTGRES_VERSIONS = ["15", "16", "17", "18"];
+
 interface CreateDatabaseDialogProps {
   onDatabaseCreated: () => void;
   children?: React.ReactNode;
@@ -47,8 +63,12 @@ export default function CreateDatabaseDialog({
   const [name, setName] = useState("");
   const [type, setType] = useState("postgresql");
   const [version, setVersion] = useState("17");
+  const [flavor, setFlavor] = useState("postgres");
+  const [flavors, setFlavors] = useState<Flavor[]>([]);
   const [projectId, setProjectId] = useState<string>("");
   const [projects, setProjects] = useState<Project[]>([]);
+  const [serverId, setServerId] = useState<string>("0");
+  const [servers, setServers] = useState<Server[]>([]);
   const [maxCpu, setMaxCpu] = useState<number>(1);
   const [maxRamMb, setMaxRamMb] = useState<number>(512);
   const [maxStorageMb, setMaxStorageMb] = useState<number>(1024);
@@ -62,9 +82,54 @@ export default function CreateDatabaseDialog({
   useEffect(() => {
     if (open && token) {
       fetchProjects();
+      fetchServers();
+      fetchFlavors();
     }
   }, [open, token]);
 
+  const fetchFlavors = async () => {
+    if (!token) return;
+    try {
+      const response = await authFetch("/api/databases/flavors", token, {}, logout);
+      if (!response.ok) return;
+      const data = await response.json();
+      setFlavors(Array.isArray(data) ? data : []);
+    } catch (err) {
+      console.error("Failed to fetch image flavors:", err);
+    }
+  };
+
+  // Flavored images are only published for some major versions
+  const selectedFlavor = flavors.find((f) => f.name === flavor);
+  const availableVersions = POSTGRES_VERSIONS.filter(
+    (v) => !selectedFlavor || selectedFlavor.versions.length === 0 || selectedFlavor.versions.includes(v),
+  );
+
+  const handleFlavorChange = (value: string) => {
+    setFlavor(value);
+    const next = flavors.find((f) => f.name === value);
+    if (next && next.versions.length > 0 && !next.versions.includes(version)) {
+      setVersion(next.versions[next.versions.length - 1]);
+    }
+  };
+
+  // Remote servers are only listed for admins; everyone else uses the local host
+  const fetchServers = async () => {
+    if (!token) return;
+    try {
+      const response = await authFetch("/api/servers", token, {}, logout);
+      if (!response.ok) {
+        setServers([]);
+        return;
+      }
+      const data = await response.json();
+      setServers(Array.isArray(data) ? data.map((entry: { server: Server }) => entry.server) : []);
+    } catch (err) {
+      console.error("Failed to fetch servers:", err);
+      setServers([]);
+    }
+  };
+
   const fetchProjects = async () => {
     if (!token) return;
     try {
@@ -114,10 +179,12 @@ export default function CreateDatabaseDialog({
           name,
           type,
           version,
+          flavor,
           projectId: parseInt(projectId),
           maxCpu,
           maxRamMb,
           maxStorageMb,
+          serverId: parseInt(serverId),
         }),
       });
 
@@ -179,6 +246,7 @@ export default function CreateDatabaseDialog({
               setName("");
               setType("postgresql");
               setVersion("17");
+              setFlavor("postgres");
               setProjectId("");
               setMaxCpu(1);
               setMaxRamMb(512);
@@ -256,6 +324,46 @@ export default function CreateDatabaseDialog({
               </Select>
             </div>
 
+            {servers.length > 0 && (
+              <div className="grid gap-2">
+                <Label htmlFor="server" className="text-neutral-400 uppercase tracking-wider text-xs font-medium">Server</Label>
+                <Select value={serverId} onValueChange={setServerId}>
+                  <SelectTrigger className="w-full">
+                    <SelectValue placeholder="Select a server" />
+                  </SelectTrigger>
+                  <SelectContent>
+                    <SelectItem value="0">Local</SelectItem>
+                    {servers.map((server) => (
+                      <SelectItem key={server.id} value={String(server.id)}>
+                        {server.name} ({server.host})
+                      </SelectItem>
+                    ))}
+                  </SelectContent>
+                </Select>
+              </div>
+            )}
+
+            {type === "postgresql" && flavors.length > 0 && (
+              <div className="grid gap-2">
+                <Label htmlFor="flavor" className="text-neutral-400 uppercase tracking-wider text-xs font-medium">Image</Label>
+                <Select value={flavor} onValueChange={handleFlavorChange}>
+                  <SelectTrigger className="w-full">
+                    <SelectValue placeholder="Select image" />
+                  </SelectTrigger>
+                  <SelectContent>
+                    {flavors.map((f) => (
+                      <SelectItem key={f.name} value={f.name}>
+                        {f.label}
+                      </SelectItem>
+                    ))}
+                  </SelectContent>
+                </Select>
+                {selectedFlavor && (
+                  <p className="text-xs text-neutral-500">{selectedFlavor.description}</p>
+                )}
+              </div>
+            )}
+
             {type === "postgresql" && (
               <div className="grid gap-2">
                 <Label htmlFor="version" className="text-neutral-400 uppercase tracking-wider text-xs font-medium">PostgreSQL Version</Label>
@@ -264,10 +372,11 @@ export default function CreateDatabaseDialog({
                     <SelectValue placeholder="Select version" />
                   </SelectTrigger>
                   <SelectContent>
-                    <SelectItem value="15">15</SelectItem>
-                    <SelectItem value="16">16</SelectItem>
-                    <SelectItem value="17">17</SelectItem>
-                    <SelectItem value="18">18 (latest)</SelectItem>
+                    {availableVersions.map((v) => (
+                      <SelectItem key={v} value={v}>
+                        {v === POSTGRES_VERSIONS[POSTGRES_VERSIONS.length - 1] ? `${v} (latest)` : v}
+                      </SelectItem>
+                    ))}
                   </SelectContent>
                 </Select>
               </div>
@@ -15,16 +15,22 @@ interface User {
     avatarUrl?: string;
 }
 
+interface SSOStatus {
+    enabled: boolean;
+    label: string;
+}
+
 interface AuthContextType {
     user: User | null;
     token: string | null;
     isLoading: boolean;
     isInitialized: boolean;
-    login: (token: string, user: User) => void;
+    sso: SSOStatus;
+    passwordReset: boolean;
+    login: (token: string, user: User, refreshToken?: string) => void;
     logout: () => void;
     updateUser: (user: User) => void;
     refreshStatus: () => Promise<void>;
-    resetAdmin: () => Promise<void>;
 }
 
 const AuthContext = createContext<AuthContextType | null>(null);
@@ -42,6 +48,8 @@ export function AuthProvider({ children }: { children: ReactNode }) {
     const [token, setToken] = useState<string | null>(null);
     const [isLoading, setIsLoading] = useState(true);
     const [isInitialized, setIsInitialized] = useState(false);
+    const [sso, setSSO] = useState<SSOStatus>({ enabled: false, label: "" });
+    const [passwordReset, setPasswordReset] = useState(false);
 
     useEffect(() => {
         const storedToken = localStorage.getItem("baseful_token");
@@ -53,6 +61,11 @@ export function AuthProvider({ children }: { children: ReactNode }) {
         }
 
         refreshStatus().finally(() => setIsLoading(false));
+
+        // authFetch rotates the tokens when the access token expires
+        const onToken = (e: Event) => setToken((e as CustomEvent<string>).detail);
+        window.addEventListener("baseful:token", onToken);
+        return () => window.removeEventListener("baseful:token", onToken);
     }, []);
 
     const refreshStatus = async () => {
@@ -60,23 +73,39 @@ export function AuthProvider({ children }: { children: ReactNode }) {
             const response = await fetch("/api/auth/status");
             const data = await response.json();
             setIsInitialized(data.initialized);
+            if (data.sso) setSSO(data.sso);
+            setPasswordReset(!!data.passwordReset);
         } catch (error) {
             console.error("Failed to fetch auth status:", error);
         }
     };
 
-    const login = (newToken: string, newUser: User) => {
+    const login = (newToken: string, newUser: User, refreshToken?: string) => {
         setToken(newToken);
         setUser(newUser);
         localStorage.setItem("baseful_token", newToken);
         localStorage.setItem("baseful_user", JSON.stringify(newUser));
+        if (refreshToken) {
+            localStorage.setItem("baseful_refresh_token", refreshToken);
+        } else {
+            localStorage.removeItem("baseful_refresh_token");
+        }
     };
 
     const logout = () => {
+        // Revoke the session on the server; the local state is cleared either way
+        const currentToken = localStorage.getItem("baseful_token");
+        if (currentToken) {
+            fetch("/api/auth/logout", {
+                method: "POST",
+                headers: { Authorization: `Bearer ${currentToken}` },
+            }).catch(() => {});
+        }
         setToken(null);
         setUser(null);
         localStorage.removeItem("baseful_token");
         localStorage.removeItem("baseful_user");
+        localStorage.removeItem("baseful_refresh_token");
     };
 
     const updateUser = (newUser: User) => {
@@ -84,16 +113,6 @@ export function AuthProvider({ children }: { children: ReactNode }) {
         localStorage.setItem("baseful_user", JSON.stringify(newUser));
     };
 
-    const resetAdmin = async () => {
-        try {
-            await fetch("/api/debug/reset-admin", { method: "POST" });
-            logout();
-            await refreshStatus();
-        } catch (error) {
-            console.error("Failed to reset admin:", error);
-        }
-    };
-
     return (
         <AuthContext.Provider
             value={{
@@ -101,11 +120,12 @@ export function AuthProvider({ children }: { children: ReactNode }) {
                 token,
                 isLoading,
                 isInitialized,
+                sso,
+                passwordReset,
                 login,
                 logout,
                 updateUser,
                 refreshStatus,
-                resetAdmin,
             }}
         >
             {children}
@@ -1,5 +1,46 @@
+let refreshing: Promise<string | null> | null = null;
+
 /**
- * Simple authenticated fetch wrapper
+ * Exchange the stored refresh token for a new access token. Concurrent
+ * callers share one request because every refresh token works only once.
+ */
+export function refreshSession(): Promise<string | null> {
+    if (!refreshing) {
+        refreshing = doRefresh().finally(() => {
+            refreshing = null;
+        });
+    }
+    return refreshing;
+}
+
+async function doRefresh(): Promise<string | null> {
+    const refreshToken = localStorage.getItem("baseful_refresh_token");
+    if (!refreshToken) return null;
+
+    const response = await fetch("/api/auth/refresh", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ refreshToken }),
+    });
+    if (!response.ok) {
+        // Another tab may have rotated the token in the meantime
+        const current = localStorage.getItem("baseful_refresh_token");
+        if (current && current !== refreshToken) {
+            return localStorage.getItem("baseful_token");
+        }
+        return null;
+    }
+
+    const data = await response.json();
+    localStorage.setItem("baseful_token", data.token);
+    localStorage.setItem("baseful_refresh_token", data.refreshToken);
+    window.dispatchEvent(new CustomEvent("baseful:token", { detail: data.token }));
+    return data.token;
+}
+
+/**
+ * Simple authenticated fetch wrapper. An expired access token is refreshed
+ * once before the request counts as unauthorized.
  */
 export async function authFetch(
     url: string,
@@ -7,18 +48,29 @@ export async function authFetch(
     options: RequestInit = {},
     onUnauthorized?: () => void
 ) {
-    const headers = {
-        ...options.headers,
-    } as Record<string, string>;
+    const send = (accessToken: string | null) => {
+        const headers = {
+            ...options.headers,
+        } as Record<string, string>;
 
-    if (token) {
-        headers["Authorization"] = `Bearer ${token}`;
-    }
+        if (accessToken) {
+            headers["Authorization"] = `Bearer ${accessToken}`;
+        }
 
-    const response = await fetch(url, {
-        ...options,
-        headers,
-    });
+        return fetch(url, {
+            ...options,
+            headers,
+        });
+    };
+
+    let response = await send(token);
+
+    if (response.status === 401 && token) {
+        const refreshed = await refreshSession().catch(() => null);
+        if (refreshed) {
+            response = await send(refreshed);
+        }
+    }
 
     if (response.status === 401 && onUnauthorized) {
         onUnauthorized();
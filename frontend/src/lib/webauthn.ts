@@ -0,0 +1,83 @@
+/**
+ * Helpers to pass WebAuthn options and responses between the browser API,
+ * which uses ArrayBuffers, and the server, which uses base64url strings.
+ */
+
+function fromBase64URL(value: string): ArrayBuffer {
+    const base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+    const padded = base64 + "=".repeat((4 - (base64.length % 4)) % 4);
+    const binary = atob(padded);
+    const bytes = new Uint8Array(binary.length);
+    for (let i = 0; i < binary.length; i++) bytes[i] = binary.charCodeAt(i);
+    return bytes.buffer;
+}
+
+function toBase64URL(buffer: ArrayBuffer | null): string | undefined {
+    if (!buffer) return undefined;
+    const bytes = new Uint8Array(buffer);
+    let binary = "";
+    for (let i = 0; i < bytes.length; i++) binary += String.fromCharCode(bytes[i]);
+    return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+/**
+ * Sign a login challenge returned by the server with a security key or passkey
+ */
+export async function getAssertion(options: any) {
+    const publicKey = options.publicKey;
+    const credential = (await navigator.credentials.get({
+        publicKey: {
+            ...publicKey,
+            challenge: fromBase64URL(publicKey.challenge),
+            allowCredentials: (publicKey.allowCredentials || []).map((c: any) => ({
+                ...c,
+                id: fromBase64URL(c.id),
+            })),
+        },
+    })) as PublicKeyCredential | null;
+    if (!credential) throw new Error("No security key response");
+
+    const response = credential.response as AuthenticatorAssertionResponse;
+    return {
+        id: credential.id,
+        rawId: toBase64URL(credential.rawId),
+        type: credential.type,
+        response: {
+            authenticatorData: toBase64URL(response.authenticatorData),
+            clientDataJSON: toBase64URL(response.clientDataJSON),
+            signature: toBase64URL(response.signature),
+            userHandle: toBase64URL(response.userHandle),
+        },
+    };
+}
+
+/**
+ * Create a new credential for the registration options returned by the server
+ */
+export async function createCredential(options: any) {
+    const publicKey = options.publicKey;
+    const credential = (await navigator.credentials.create({
+        publicKey: {
+            ...publicKey,
+            challenge: fromBase64URL(publicKey.challenge),
+            user: { ...publicKey.user, id: fromBase64URL(publicKey.user.id) },
+            excludeCredentials: (publicKey.excludeCredentials || []).map((c: any) => ({
+                ...c,
+                id: fromBase64URL(c.id),
+            })),
+        },
+    })) as PublicKeyCredential | null;
+    if (!credential) throw new Error("No security key response");
+
+    const response = credential.response as AuthenticatorAttestationResponse;
+    return {
+        id: credential.id,
+        rawId: toBase64URL(credential.rawId),
+        type: credential.type,
+        response: {
+            attestationObject: toBase64URL(response.attestationObject),
+            clientDataJSON: toBase64URL(response.clientDataJSON),
+            transports: response.getTransports ? response.getTransports() : [],
+        },
+    };
+}
@@ -21,6 +21,8 @@ interface ContainerInfo {
     ip: string;
     labels: Record<string, string>;
     created: number;
+    serverId: number;
+    serverName: string;
 }
 
 export default function Containers() {
@@ -75,6 +77,7 @@ export default function Containers() {
 
         const cmd = command.trim();
         const containerId = selectedContainer.id;
+        const serverId = selectedContainer.serverId;
         const cwd = containerCwd[containerId] || "/";
 
         setExecuting(true);
@@ -91,7 +94,7 @@ export default function Containers() {
         setCommand("");
 
         try {
-            const response = await authFetch(`/api/docker/containers/${containerId}/exec`, token, {
+            const response = await authFetch(`/api/docker/containers/${containerId}/exec?server=${serverId}`, token, {
                 method: "POST",
                 headers: { "Content-Type": "application/json" },
                 body: JSON.stringify({ command: cmd, cwd: cwd }),
@@ -330,6 +333,7 @@ export default function Containers() {
                                             }`}>
                                             {container.state}
                                         </Badge>
+                                        <span className="text-[10px] text-muted-foreground font-mono">{container.serverName}</span>
                                     </div>
                                     <CardTitle className="text-base font-semibold tracking-tight text-foreground">
                                         {container.names[0].replace("/", "")}
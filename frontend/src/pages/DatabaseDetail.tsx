@@ -362,6 +362,8 @@ export default function DatabaseDetail() {
         return "bg-green-600/10 text-green-300";
       case "starting":
         return "bg-yellow-600/10 text-yellow-300";
+      case "sleeping":
+        return "bg-blue-600/10 text-blue-300";
       case "stopped":
         return "bg-red-600/10 text-red-300";
       default:
@@ -472,7 +474,8 @@ export default function DatabaseDetail() {
                   </Button>
                 </PopoverTrigger>
                 <PopoverContent className="w-fit p-2 flex flex-col gap-2">
-                  {database?.status === "stopped" ? (
+                  {database?.status === "stopped" ||
+                  database?.status === "sleeping" ? (
                     <Button
                       onClick={() => handleAction("start")}
                       variant={"secondary"}
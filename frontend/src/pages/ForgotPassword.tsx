@@ -0,0 +1,97 @@
+import { useState } from "react";
+import { Link } from "react-router-dom";
+
+export default function ForgotPassword() {
+  const [email, setEmail] = useState("");
+  const [error, setError] = useState("");
+  const [message, setMessage] = useState("");
+  const [loading, setLoading] = useState(false);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError("");
+    setLoading(true);
+
+    try {
+      const response = await fetch("/api/auth/password/forgot", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ email }),
+      });
+
+      const data = await response.json();
+
+      if (!response.ok) {
+        throw new Error(data.error || "Failed to request a reset link");
+      }
+
+      setMessage(data.message);
+    } catch (err: any) {
+      setError(err.message);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <div className="space-y-8">
+      <div className="text-center">
+        <h1 className="text-3xl font-medium tracking-tight text-foreground">
+          Forgot Password
+        </h1>
+        <p className="mt-2 text-muted-foreground text-sm">
+          We'll email you a link to choose a new password
+        </p>
+      </div>
+
+      {message ? (
+        <div className="rounded-md border border-border bg-card p-3 text-sm text-foreground">
+          {message}
+        </div>
+      ) : (
+        <form className="mt-8 space-y-6" onSubmit={handleSubmit}>
+          {error && (
+            <div className="rounded-md bg-destructive/15 p-3 text-sm text-destructive">
+              {error}
+            </div>
+          )}
+
+          <div>
+            <label
+              htmlFor="email"
+              className="block text-sm font-medium text-muted-foreground"
+            >
+              Email address
+            </label>
+            <input
+              id="email"
+              type="email"
+              required
+              className="mt-1 block w-full rounded-md border border-border bg-card md:bg-background px-3 py-2 text-foreground shadow-sm focus:ring-0 focus:outline-none sm:text-sm"
+              value={email}
+              onChange={(e) => setEmail(e.target.value)}
+            />
+          </div>
+
+          <button
+            type="submit"
+            disabled={loading}
+            className="flex w-full justify-center rounded-md border border-transparent bg-primary px-4 py-2 text-sm font-semibold text-primary-foreground shadow-sm hover:bg-primary/90 focus:outline-none focus:ring-2 focus:ring-primary focus:ring-offset-2 disabled:opacity-50"
+          >
+            {loading ? "Sending..." : "Send reset link"}
+          </button>
+        </form>
+      )}
+
+      <p className="mt-4 text-center text-sm text-muted-foreground">
+        Remembered it?{" "}
+        <Link
+          to="/login"
+          className="font-semibold text-primary hover:text-primary/80"
+        >
+          Log in
+        </Link>
+      </p>
+    </div>
+  );
+}
@@ -1,24 +1,79 @@
 import { useState, useEffect } from "react";
 import { Link, useNavigate } from "react-router-dom";
 import { useAuth } from "../context/AuthContext";
+import { getAssertion } from "../lib/webauthn";
+
+const inputClass =
+  "mt-1 block w-full rounded-md border border-border bg-card md:bg-background px-3 py-2 text-foreground shadow-sm focus:ring-0 focus:outline-none sm:text-sm";
+const buttonClass =
+  "flex w-full justify-center rounded-md border border-transparent bg-primary px-4 py-2 text-sm font-semibold text-primary-foreground shadow-sm hover:bg-primary/90 focus:outline-none focus:ring-2 focus:ring-primary focus:ring-offset-2 disabled:opacity-50";
+const secondaryButtonClass =
+  "flex w-full justify-center rounded-md border border-border bg-card px-4 py-2 text-sm font-semibold text-foreground shadow-sm hover:bg-muted disabled:opacity-50";
+
+// Second login step after the password was accepted
+interface MFAChallenge {
+  mfaToken: string;
+  methods: string[];
+}
+
+// Session limited to enrolling a second factor
+interface MFASetup {
+  token: string;
+  user: any;
+  secret?: string;
+  otpauthUri?: string;
+  recoveryCodes?: string[];
+  session?: { token: string; user: any; refreshToken?: string };
+}
 
 export default function Login() {
   const [email, setEmail] = useState("");
   const [password, setPassword] = useState("");
   const [error, setError] = useState("");
   const [loading, setLoading] = useState(false);
+  const [code, setCode] = useState("");
+  const [challenge, setChallenge] = useState<MFAChallenge | null>(null);
+  const [setup, setSetup] = useState<MFASetup | null>(null);
+  const [unverified, setUnverified] = useState(false);
+  const [notice, setNotice] = useState("");
 
-  const { login, user, isInitialized } = useAuth();
+  const { login, user, isInitialized, sso, passwordReset } = useAuth();
   const navigate = useNavigate();
 
+  // Single sign-on returns here with the session token or an error in the URL fragment
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    const ssoToken = params.get("sso_token");
+    const ssoRefreshToken = params.get("sso_refresh_token") || undefined;
+    const ssoError = params.get("sso_error");
+    if (!ssoToken && !ssoError) return;
+    window.history.replaceState(null, "", window.location.pathname);
+
+    if (ssoError) {
+      setError(ssoError);
+      return;
+    }
+    const returnTo = params.get("return_to") || "/";
+    fetch("/api/auth/me", { headers: { Authorization: `Bearer ${ssoToken}` } })
+      .then(async (response) => {
+        const data = await response.json();
+        if (!response.ok) throw new Error(data.error || "Login failed");
+        login(ssoToken!, data, ssoRefreshToken);
+        navigate(returnTo);
+      })
+      .catch((err) => setError(err.message));
+  }, [login, navigate]);
+
   useEffect(() => {
     if (user) navigate("/");
-    if (!isInitialized) navigate("/register");
-  }, [user, isInitialized, navigate]);
+    if (!isInitialized && !sso.enabled) navigate("/register");
+  }, [user, isInitialized, sso.enabled, navigate]);
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
     setError("");
+    setNotice("");
+    setUnverified(false);
     setLoading(true);
 
     try {
@@ -31,10 +86,52 @@ export default function Login() {
       const data = await response.json();
 
       if (!response.ok) {
+        setUnverified(!!data.emailVerificationRequired);
         throw new Error(data.error || "Login failed");
       }
 
-      login(data.token, data.user);
+      if (data.mfaRequired) {
+        setChallenge({ mfaToken: data.mfaToken, methods: data.methods || [] });
+        return;
+      }
+      if (data.mfaSetupRequired) {
+        await startSetup(data.token, data.user);
+        return;
+      }
+
+      login(data.token, data.user, data.refreshToken);
+      navigate("/");
+    } catch (err: any) {
+      setError(err.message);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const postJSON = async (url: string, body: unknown, token?: string) => {
+    const headers: Record<string, string> = { "Content-Type": "application/json" };
+    if (token) headers["Authorization"] = `Bearer ${token}`;
+    const response = await fetch(url, {
+      method: "POST",
+      headers,
+      body: JSON.stringify(body),
+    });
+    const data = await response.json();
+    if (!response.ok) throw new Error(data.error || "Request failed");
+    return data;
+  };
+
+  const handleCode = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (!challenge) return;
+    setError("");
+    setLoading(true);
+    try {
+      const data = await postJSON("/api/auth/login/mfa", {
+        mfaToken: challenge.mfaToken,
+        code,
+      });
+      login(data.token, data.user, data.refreshToken);
       navigate("/");
     } catch (err: any) {
       setError(err.message);
@@ -43,6 +140,232 @@ export default function Login() {
     }
   };
 
+  const handleSecurityKey = async () => {
+    if (!challenge) return;
+    setError("");
+    setLoading(true);
+    try {
+      const begin = await postJSON("/api/auth/login/webauthn/begin", {
+        mfaToken: challenge.mfaToken,
+      });
+      const credential = await getAssertion(begin.options);
+      const data = await postJSON("/api/auth/login/webauthn/finish", {
+        mfaToken: challenge.mfaToken,
+        ceremonyId: begin.ceremonyId,
+        credential,
+      });
+      login(data.token, data.user, data.refreshToken);
+      navigate("/");
+    } catch (err: any) {
+      setError(err.message);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const handlePasskey = async () => {
+    setError("");
+    setLoading(true);
+    try {
+      const begin = await postJSON("/api/auth/passkey/begin", {});
+      const credential = await getAssertion(begin.options);
+      const data = await postJSON("/api/auth/passkey/finish", {
+        ceremonyId: begin.ceremonyId,
+        credential,
+      });
+      login(data.token, data.user, data.refreshToken);
+      navigate("/");
+    } catch (err: any) {
+      setError(err.message);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  // Two-factor authentication is enforced but not set up yet: enroll an
+  // authenticator app before the full session is issued
+  const startSetup = async (token: string, setupUser: any) => {
+    const data = await postJSON("/api/auth/mfa/totp/setup", {}, token);
+    setSetup({ token, user: setupUser, secret: data.secret, otpauthUri: data.otpauthUri });
+  };
+
+  const handleEnable = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (!setup) return;
+    setError("");
+    setLoading(true);
+    try {
+      const data = await postJSON("/api/auth/mfa/totp/enable", { code }, setup.token);
+      setSetup({
+        ...setup,
+        recoveryCodes: data.recoveryCodes,
+        session: { token: data.token, user: data.user, refreshToken: data.refreshToken },
+      });
+    } catch (err: any) {
+      setError(err.message);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const finishSetup = () => {
+    if (!setup?.session) return;
+    login(setup.session.token, setup.session.user, setup.session.refreshToken);
+    navigate("/");
+  };
+
+  const resendVerification = async () => {
+    setError("");
+    try {
+      const data = await postJSON("/api/auth/email/resend", { email });
+      setUnverified(false);
+      setNotice(data.message);
+    } catch (err: any) {
+      setError(err.message);
+    }
+  };
+
+  const errorBox = error && (
+    <div className="rounded-md bg-destructive/15 p-3 text-sm text-destructive">
+      {error}
+      {unverified && (
+        <button
+          type="button"
+          className="mt-2 block font-semibold underline"
+          onClick={resendVerification}
+        >
+          Resend confirmation email
+        </button>
+      )}
+    </div>
+  );
+
+  if (setup) {
+    return (
+      <div className="space-y-8">
+        <div className="text-center">
+          <h1 className="text-3xl font-medium tracking-tight text-foreground">
+            Set Up Two-Factor Authentication
+          </h1>
+          <p className="mt-2 text-muted-foreground text-sm">
+            Your administrator requires a second factor for this account
+          </p>
+        </div>
+
+        {setup.recoveryCodes ? (
+          <div className="space-y-6">
+            <p className="text-sm text-muted-foreground">
+              Save these recovery codes somewhere safe. Each code can be used
+              once if you lose access to your authenticator app.
+            </p>
+            <pre className="rounded-md border border-border bg-card p-3 text-sm text-foreground">
+              {setup.recoveryCodes.join("\n")}
+            </pre>
+            <button type="button" className={buttonClass} onClick={finishSetup}>
+              Continue
+            </button>
+          </div>
+        ) : (
+          <form className="space-y-6" onSubmit={handleEnable}>
+            {errorBox}
+            <p className="text-sm text-muted-foreground">
+              Add this key to your authenticator app, then enter the 6-digit
+              code it shows.
+            </p>
+            <code className="block break-all rounded-md border border-border bg-card p-3 text-sm text-foreground">
+              {setup.secret}
+            </code>
+            <a
+              href={setup.otpauthUri}
+              className="block text-center text-sm font-semibold text-primary hover:text-primary/80"
+            >
+              Open in authenticator app
+            </a>
+            <input
+              inputMode="numeric"
+              autoComplete="one-time-code"
+              required
+              placeholder="123456"
+              className={inputClass}
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+            />
+            <button type="submit" disabled={loading} className={buttonClass}>
+              {loading ? "Verifying..." : "Enable"}
+            </button>
+          </form>
+        )}
+      </div>
+    );
+  }
+
+  if (challenge) {
+    const hasCode =
+      challenge.methods.includes("totp") ||
+      challenge.methods.includes("recovery_code");
+    return (
+      <div className="space-y-8">
+        <div className="text-center">
+          <h1 className="text-3xl font-medium tracking-tight text-foreground">
+            Two-Factor Authentication
+          </h1>
+          <p className="mt-2 text-muted-foreground text-sm">
+            Confirm it's you to finish logging in
+          </p>
+        </div>
+
+        {hasCode && (
+          <form className="space-y-6" onSubmit={handleCode}>
+            {errorBox}
+            <div>
+              <label
+                htmlFor="code"
+                className="block text-sm font-medium text-muted-foreground"
+              >
+                Authentication or recovery code
+              </label>
+              <input
+                id="code"
+                autoComplete="one-time-code"
+                required
+                className={inputClass}
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+              />
+            </div>
+            <button type="submit" disabled={loading} className={buttonClass}>
+              {loading ? "Verifying..." : "Verify"}
+            </button>
+          </form>
+        )}
+        {!hasCode && errorBox}
+
+        {challenge.methods.includes("webauthn") && (
+          <button
+            type="button"
+            disabled={loading}
+            className={secondaryButtonClass}
+            onClick={handleSecurityKey}
+          >
+            Use a security key
+          </button>
+        )}
+
+        <button
+          type="button"
+          className="w-full text-center text-sm text-muted-foreground hover:text-foreground"
+          onClick={() => {
+            setChallenge(null);
+            setCode("");
+            setError("");
+          }}
+        >
+          Back to login
+        </button>
+      </div>
+    );
+  }
+
   return (
     <div className="space-y-8">
       <div className="text-center">
@@ -55,9 +378,10 @@ export default function Login() {
       </div>
 
       <form className="mt-8 space-y-6" onSubmit={handleSubmit}>
-        {error && (
-          <div className="rounded-md bg-destructive/15 p-3 text-sm text-destructive">
-            {error}
+        {errorBox}
+        {notice && (
+          <div className="rounded-md border border-border bg-card p-3 text-sm text-foreground">
+            {notice}
           </div>
         )}
 
@@ -93,6 +417,14 @@ export default function Login() {
               value={password}
               onChange={(e) => setPassword(e.target.value)}
             />
+            {passwordReset && (
+              <Link
+                to="/forgot-password"
+                className="mt-2 inline-block text-xs font-semibold text-primary hover:text-primary/80"
+              >
+                Forgot password?
+              </Link>
+            )}
           </div>
         </div>
 
@@ -107,6 +439,26 @@ export default function Login() {
         </div>
       </form>
 
+      {sso.enabled && (
+        <a
+          href="/api/auth/oidc/login"
+          className="flex w-full justify-center rounded-md border border-border bg-card px-4 py-2 text-sm font-semibold text-foreground shadow-sm hover:bg-muted"
+        >
+          {`Continue with ${sso.label}`}
+        </a>
+      )}
+
+      {window.PublicKeyCredential && (
+        <button
+          type="button"
+          disabled={loading}
+          className={secondaryButtonClass}
+          onClick={handlePasskey}
+        >
+          Log in with a passkey
+        </button>
+      )}
+
       <p className="mt-4 text-center text-sm text-muted-foreground">
         Don't have an account?{" "}
         <Link
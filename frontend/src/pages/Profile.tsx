@@ -2,7 +2,7 @@ import { useState, useRef, useEffect } from "react";
 import { useAuth } from "../context/AuthContext";
 import { authFetch } from "../lib/api";
 import { Facehash } from "facehash";
-import { Camera, Lock, User, At, ArrowLeft, SignOut } from "@phosphor-icons/react";
+import { Camera, Lock, User, At, ArrowLeft, SignOut, Devices, Key } from "@phosphor-icons/react";
 import { Link } from "react-router-dom";
 
 export default function Profile() {
@@ -23,6 +23,13 @@ export default function Profile() {
     const [openRouterLoading, setOpenRouterLoading] = useState(false);
     const [openRouterSaving, setOpenRouterSaving] = useState(false);
     const [message, setMessage] = useState<{ type: "success" | "error"; text: string } | null>(null);
+    const [sessions, setSessions] = useState<{
+        id: string;
+        device: string;
+        ip: string;
+        lastSeenAt: string;
+        current: boolean;
+    }[]>([]);
 
     const fileInputRef = useRef<HTMLInputElement>(null);
 
@@ -47,6 +54,111 @@ export default function Profile() {
         loadOpenRouterStatus();
     }, [token, logout]);
 
+    const loadSessions = async () => {
+        if (!token) return;
+        try {
+            const res = await authFetch("/api/auth/sessions", token, {}, logout);
+            if (res.ok) setSessions(await res.json());
+        } catch {
+            // Keep the profile usable even if this fails.
+        }
+    };
+
+    useEffect(() => {
+        loadSessions();
+    }, [token]);
+
+    const [apiTokens, setApiTokens] = useState<{
+        id: number;
+        name: string;
+        prefix: string;
+        scopes: string[];
+        expiresAt: string;
+        lastUsedAt: string;
+        expired: boolean;
+    }[]>([]);
+    const [tokenScopes, setTokenScopes] = useState<{ name: string; description: string }[]>([]);
+    const [tokenName, setTokenName] = useState("");
+    const [tokenExpiry, setTokenExpiry] = useState("90");
+    const [selectedScopes, setSelectedScopes] = useState<string[]>([]);
+    const [newToken, setNewToken] = useState("");
+
+    const loadApiTokens = async () => {
+        if (!token) return;
+        try {
+            const [tokensRes, scopesRes] = await Promise.all([
+                authFetch("/api/auth/tokens", token, {}, logout),
+                authFetch("/api/auth/tokens/scopes", token, {}, logout),
+            ]);
+            if (tokensRes.ok) setApiTokens(await tokensRes.json());
+            if (scopesRes.ok) setTokenScopes(await scopesRes.json());
+        } catch {
+            // Keep the profile usable even if this fails.
+        }
+    };
+
+    useEffect(() => {
+        loadApiTokens();
+    }, [token]);
+
+    const handleCreateToken = async (e: React.FormEvent) => {
+        e.preventDefault();
+        if (!token) return;
+        setMessage(null);
+        try {
+            const res = await authFetch("/api/auth/tokens", token, {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({
+                    name: tokenName,
+                    scopes: selectedScopes,
+                    expiresInDays: Number(tokenExpiry),
+                }),
+            }, logout);
+            const data = await res.json();
+            if (!res.ok) throw new Error(data.error || "Failed to create token");
+            setNewToken(data.token);
+            setTokenName("");
+            setSelectedScopes([]);
+            await loadApiTokens();
+        } catch (err: any) {
+            setMessage({ type: "error", text: err.message });
+        }
+    };
+
+    const revokeApiToken = async (id: number) => {
+        if (!token) return;
+        setMessage(null);
+        try {
+            const res = await authFetch(`/api/auth/tokens/${id}`, token, { method: "DELETE" }, logout);
+            if (!res.ok) {
+                const data = await res.json();
+                throw new Error(data.error || "Failed to revoke token");
+            }
+            await loadApiTokens();
+        } catch (err: any) {
+            setMessage({ type: "error", text: err.message });
+        }
+    };
+
+    const revokeSession = async (id?: string) => {
+        if (!token) return;
+        setMessage(null);
+        try {
+            const url = id
+                ? `/api/auth/sessions/${encodeURIComponent(id)}`
+                : "/api/auth/sessions?keepCurrent=true";
+            const res = await authFetch(url, token, { method: "DELETE" }, logout);
+            if (!res.ok) {
+                const data = await res.json();
+                throw new Error(data.error || "Failed to sign out session");
+            }
+            await loadSessions();
+        } catch (err: any) {
+            setMessage({ type: "error", text: err.message });
+        }
+    };
+
     const handleUpdateProfile = async (e: React.FormEvent) => {
         e.preventDefault();
         if (!token) return;
@@ -102,7 +214,8 @@ export default function Profile() {
             setCurrentPassword("");
             setNewPassword("");
             setConfirmPassword("");
-            setMessage({ type: "success", text: "Password updated successfully" });
+            setMessage({ type: "success", text: "Password updated successfully. Other devices were signed out." });
+            loadSessions();
         } catch (err: any) {
             setMessage({ type: "error", text: err.message });
         } finally {
@@ -352,6 +465,153 @@ export default function Profile() {
                         </form>
                     </section>
 
+                    {/* Sessions */}
+                    <section className="bg-card border border-border rounded-xl p-6 shadow-sm">
+                        <div className="flex items-center justify-between mb-6">
+                            <div className="flex items-center gap-2 text-foreground font-semibold">
+                                <Devices size={18} weight="bold" className="text-primary" />
+                                <h2>Sessions</h2>
+                            </div>
+                            {sessions.length > 1 && (
+                                <button
+                                    type="button"
+                                    onClick={() => revokeSession()}
+                                    className="px-3 h-8 bg-secondary text-secondary-foreground rounded-lg text-xs font-medium hover:bg-secondary/80 transition-colors"
+                                >
+                                    Sign out other sessions
+                                </button>
+                            )}
+                        </div>
+                        <div className="divide-y divide-border">
+                            {sessions.map((session) => (
+                                <div key={session.id} className="flex items-center justify-between py-3">
+                                    <div>
+                                        <p className="text-sm font-medium">
+                                            {session.device}
+                                            {session.current && (
+                                                <span className="ml-2 text-xs text-primary">This device</span>
+                                            )}
+                                        </p>
+                                        <p className="text-xs text-muted-foreground">
+                                            {session.ip} · Last active {new Date(session.lastSeenAt).toLocaleString()}
+                                        </p>
+                                    </div>
+                                    {!session.current && (
+                                        <button
+                                            type="button"
+                                            onClick={() => revokeSession(session.id)}
+                                            className="px-3 h-8 text-xs font-medium text-destructive hover:bg-destructive/10 rounded-lg transition-colors"
+                                        >
+                                            Sign out
+                                        </button>
+                                    )}
+                                </div>
+                            ))}
+                        </div>
+                    </section>
+
+                    {/* Personal Access Tokens */}
+                    <section className="bg-card border border-border rounded-xl p-6 shadow-sm">
+                        <div className="flex items-center gap-2 mb-6 text-foreground font-semibold">
+                            <Key size={18} weight="bold" className="text-primary" />
+                            <h2>Personal Access Tokens</h2>
+                        </div>
+                        {newToken && (
+                            <div className="mb-4 space-y-1 rounded-lg border border-primary/30 bg-primary/5 p-3">
+                                <p className="text-xs text-muted-foreground">
+                                    Copy this token now. It will not be shown again.
+                                </p>
+                                <code className="block break-all text-sm">{newToken}</code>
+                            </div>
+                        )}
+                        <div className="divide-y divide-border mb-4">
+                            {apiTokens.map((apiToken) => (
+                                <div key={apiToken.id} className="flex items-center justify-between py-3">
+                                    <div>
+                                        <p className="text-sm font-medium">
+                                            {apiToken.name}
+                                            <span className="ml-2 font-mono text-xs text-muted-foreground">{apiToken.prefix}…</span>
+                                        </p>
+                                        <p className="text-xs text-muted-foreground">
+                                            {apiToken.scopes.join(", ")} ·{" "}
+                                            {apiToken.expired
+                                                ? "Expired"
+                                                : apiToken.expiresAt
+                                                    ? `Expires ${new Date(apiToken.expiresAt).toLocaleDateString()}`
+                                                    : "No expiry"}{" "}
+                                            ·{" "}
+                                            {apiToken.lastUsedAt
+                                                ? `Last used ${new Date(apiToken.lastUsedAt).toLocaleString()}`
+                                                : "Never used"}
+                                        </p>
+                                    </div>
+                                    <button
+                                        type="button"
+                                        onClick={() => revokeApiToken(apiToken.id)}
+                                        className="px-3 h-8 text-xs font-medium text-destructive hover:bg-destructive/10 rounded-lg transition-colors"
+                                    >
+                                        Revoke
+                                    </button>
+                                </div>
+                            ))}
+                        </div>
+                        <form onSubmit={handleCreateToken} className="space-y-4">
+                            <div className="grid grid-cols-2 gap-4">
+                                <div className="space-y-1">
+                                    <label className="text-xs font-medium text-muted-foreground">Name</label>
+                                    <input
+                                        value={tokenName}
+                                        onChange={(e) => setTokenName(e.target.value)}
+                                        className="w-full h-10 px-3 bg-muted/30 border border-border rounded-lg text-sm focus:outline-none focus:ring-1 focus:ring-primary/50 transition-shadow"
+                                        placeholder="CI pipeline"
+                                        required
+                                    />
+                                </div>
+                                <div className="space-y-1">
+                                    <label className="text-xs font-medium text-muted-foreground">Expiration</label>
+                                    <select
+                                        value={tokenExpiry}
+                                        onChange={(e) => setTokenExpiry(e.target.value)}
+                                        className="w-full h-10 px-3 bg-muted/30 border border-border rounded-lg text-sm focus:outline-none focus:ring-1 focus:ring-primary/50 transition-shadow"
+                                    >
+                                        <option value="7">7 days</option>
+                                        <option value="30">30 days</option>
+                                        <option value="90">90 days</option>
+                                        <option value="365">1 year</option>
+                                        <option value="0">No expiry</option>
+                                    </select>
+                                </div>
+                            </div>
+                            <div className="grid grid-cols-2 gap-2">
+                                {tokenScopes
+                                    .filter((scope) => scope.name !== "admin" || user?.isAdmin)
+                                    .map((scope) => (
+                                        <label key={scope.name} className="flex items-start gap-2 text-xs" title={scope.description}>
+                                            <input
+                                                type="checkbox"
+                                                checked={selectedScopes.includes(scope.name)}
+                                                onChange={(e) =>
+                                                    setSelectedScopes(
+                                                        e.target.checked
+                                                            ? [...selectedScopes, scope.name]
+                                                            : selectedScopes.filter((s) => s !== scope.name)
+                                                    )
+                                                }
+                                            />
+                                            <span className="font-mono">{scope.name}</span>
+                                        </label>
+                                    ))}
+                            </div>
+                            <button
+                                type="submit"
+                                disabled={!tokenName || selectedScopes.length === 0}
+                                className="mt-2 px-4 h-10 bg-primary text-primary-foreground rounded-lg text-sm font-medium hover:bg-primary/90 transition-colors shadow-sm disabled:opacity-50"
+                            >
+                                Generate Token
+                            </button>
+                        </form>
+                    </section>
+
                     <section className="bg-card border border-border rounded-xl p-6 shadow-sm">
                         <div className="flex items-center gap-2 mb-6 text-foreground font-semibold">
                             <Lock size={18} weight="bold" className="text-primary" />
@@ -1,14 +1,18 @@
 import { useState, useEffect } from "react";
-import { Link, useNavigate } from "react-router-dom";
+import { Link, useNavigate, useSearchParams } from "react-router-dom";
 import { useAuth } from "../context/AuthContext";
 
 export default function Register() {
-  const [email, setEmail] = useState("");
+  // Invitation emails link here with the address and a token that confirms it
+  const [searchParams] = useSearchParams();
+  const inviteToken = searchParams.get("invite") || "";
+  const [email, setEmail] = useState(searchParams.get("email") || "");
   const [password, setPassword] = useState("");
   const [firstName, setFirstName] = useState("");
   const [lastName, setLastName] = useState("");
   const [error, setError] = useState("");
   const [loading, setLoading] = useState(false);
+  const [verificationSent, setVerificationSent] = useState("");
 
   const { login, user, isInitialized } = useAuth();
   const navigate = useNavigate();
@@ -26,7 +30,13 @@ export default function Register() {
       const response = await fetch("/api/auth/register", {
         method: "POST",
         headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ email, password, firstName, lastName }),
+        body: JSON.stringify({
+          email,
+          password,
+          firstName,
+          lastName,
+          inviteToken: inviteToken || undefined,
+        }),
       });
 
       const data = await response.json();
@@ -35,7 +45,12 @@ export default function Register() {
         throw new Error(data.error || "Registration failed");
       }
 
-      login(data.token, data.user);
+      if (data.verificationRequired) {
+        setVerificationSent(data.message);
+        return;
+      }
+
+      login(data.token, data.user, data.refreshToken);
       navigate("/");
     } catch (err: any) {
       setError(err.message);
@@ -44,6 +59,30 @@ export default function Register() {
     }
   };
 
+  if (verificationSent) {
+    return (
+      <div className="space-y-8">
+        <div className="text-center">
+          <h1 className="text-3xl font-medium tracking-tight text-foreground">
+            Confirm Your Email
+          </h1>
+          <p className="mt-2 text-muted-foreground text-sm">
+            {verificationSent}
+          </p>
+        </div>
+        <p className="mt-4 text-center text-sm text-muted-foreground">
+          Confirmed already?{" "}
+          <Link
+            to="/login"
+            className="font-semibold text-primary hover:text-primary/80"
+          >
+            Log in
+          </Link>
+        </p>
+      </div>
+    );
+  }
+
   return (
     <div className="space-y-8">
       <div className="text-center">
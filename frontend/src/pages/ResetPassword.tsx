@@ -0,0 +1,130 @@
+import { useState } from "react";
+import { Link, useSearchParams } from "react-router-dom";
+
+export default function ResetPassword() {
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get("token") || "";
+  const [password, setPassword] = useState("");
+  const [confirm, setConfirm] = useState("");
+  const [error, setError] = useState("");
+  const [done, setDone] = useState(false);
+  const [loading, setLoading] = useState(false);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError("");
+    if (password !== confirm) {
+      setError("Passwords do not match");
+      return;
+    }
+    setLoading(true);
+
+    try {
+      const response = await fetch("/api/auth/password/reset", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ token, password }),
+      });
+
+      const data = await response.json();
+
+      if (!response.ok) {
+        throw new Error(data.error || "Failed to reset password");
+      }
+
+      setDone(true);
+    } catch (err: any) {
+      setError(err.message);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const inputClass =
+    "mt-1 block w-full rounded-md border border-border bg-card md:bg-background px-3 py-2 text-foreground shadow-sm focus:ring-0 focus:outline-none sm:text-sm";
+
+  return (
+    <div className="space-y-8">
+      <div className="text-center">
+        <h1 className="text-3xl font-medium tracking-tight text-foreground">
+          Choose a New Password
+        </h1>
+        <p className="mt-2 text-muted-foreground text-sm">
+          All your other sessions will be signed out
+        </p>
+      </div>
+
+      {done ? (
+        <div className="rounded-md border border-border bg-card p-3 text-sm text-foreground">
+          Your password was updated. You can now log in with it.
+        </div>
+      ) : !token ? (
+        <div className="rounded-md bg-destructive/15 p-3 text-sm text-destructive">
+          This link is incomplete. Open the link from the email again.
+        </div>
+      ) : (
+        <form className="mt-8 space-y-6" onSubmit={handleSubmit}>
+          {error && (
+            <div className="rounded-md bg-destructive/15 p-3 text-sm text-destructive">
+              {error}
+            </div>
+          )}
+
+          <div className="space-y-4">
+            <div>
+              <label
+                htmlFor="password"
+                className="block text-sm font-medium text-muted-foreground"
+              >
+                New password
+              </label>
+              <input
+                id="password"
+                type="password"
+                autoComplete="new-password"
+                required
+                className={inputClass}
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+              />
+            </div>
+            <div>
+              <label
+                htmlFor="confirm"
+                className="block text-sm font-medium text-muted-foreground"
+              >
+                Confirm new password
+              </label>
+              <input
+                id="confirm"
+                type="password"
+                autoComplete="new-password"
+                required
+                className={inputClass}
+                value={confirm}
+                onChange={(e) => setConfirm(e.target.value)}
+              />
+            </div>
+          </div>
+
+          <button
+            type="submit"
+            disabled={loading}
+            className="flex w-full justify-center rounded-md border border-transparent bg-primary px-4 py-2 text-sm font-semibold text-primary-foreground shadow-sm hover:bg-primary/90 focus:outline-none focus:ring-2 focus:ring-primary focus:ring-offset-2 disabled:opacity-50"
+          >
+            {loading ? "Saving..." : "Set password"}
+          </button>
+        </form>
+      )}
+
+      <p className="mt-4 text-center text-sm text-muted-foreground">
+        <Link
+          to="/login"
+          className="font-semibold text-primary hover:text-primary/80"
+        >
+          Back to login
+        </Link>
+      </p>
+    </div>
+  );
+}
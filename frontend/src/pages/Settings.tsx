@@ -8,6 +8,7 @@ import {
   Warning,
   Check,
   CircleNotch,
+  Moon,
 } from "@phosphor-icons/react";
 import { Button } from "@/components/ui/button";
 import { Input } from "@/components/ui/input";
@@ -47,6 +48,8 @@ export default function Settings() {
     max_ram_mb: 512,
     max_storage_mb: 1024,
   });
+  const [autoSuspend, setAutoSuspend] = useState(0);
+  const [originalAutoSuspend, setOriginalAutoSuspend] = useState(0);
   const [loading, setLoading] = useState(true);
   const [saving, setSaving] = useState(false);
   const [error, setError] = useState<string | null>(null);
@@ -57,6 +60,7 @@ export default function Settings() {
     if (id) {
       fetchDatabase();
       fetchResourceLimits();
+      fetchAutoSuspend();
     }
   }, [id]);
 
@@ -91,27 +95,57 @@ export default function Settings() {
     }
   };
 
+  const fetchAutoSuspend = async () => {
+    try {
+      const res = await authFetch(`/api/databases/${id}/auto-suspend`, token, {}, logout);
+      if (res.ok) {
+        const data = await res.json();
+        setAutoSuspend(data.minutes || 0);
+        setOriginalAutoSuspend(data.minutes || 0);
+      }
+    } catch (err) {
+      console.error("Failed to fetch auto-suspend policy:", err);
+    }
+  };
+
   const handleSave = async () => {
     setSaving(true);
     setError(null);
     setSuccess(null);
 
     try {
-      const res = await authFetch(`/api/databases/${id}/limits`, token, {
-        method: "PUT",
-        headers: { "Content-Type": "application/json" },
-        body: JSON.stringify(limits),
-      }, logout);
+      if (limitsChanged) {
+        const res = await authFetch(`/api/databases/${id}/limits`, token, {
+          method: "PUT",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify(limits),
+        }, logout);
+
+        if (!res.ok) {
+          const data = await res.json();
+          throw new Error(data.error || "Failed to update resource limits");
+        }
 
-      if (!res.ok) {
         const data = await res.json();
-        throw new Error(data.error || "Failed to update resource limits");
+        setOriginalLimits(limits);
+        setNeedsRestart(data.needs_restart || false);
       }
 
-      const data = await res.json();
-      setOriginalLimits(limits);
-      setSuccess("Resource limits updated successfully");
-      setNeedsRestart(data.needs_restart || false);
+      if (autoSuspend !== originalAutoSuspend) {
+        const res = await authFetch(`/api/databases/${id}/auto-suspend`, token, {
+          method: "PUT",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ minutes: autoSuspend }),
+        }, logout);
+
+        if (!res.ok) {
+          const data = await res.json();
+          throw new Error(data.error || "Failed to update auto-suspend");
+        }
+        setOriginalAutoSuspend(autoSuspend);
+      }
+
+      setSuccess("Settings updated successfully");
     } catch (err: any) {
       setError(err.message);
     } finally {
@@ -121,6 +155,7 @@ export default function Settings() {
 
   const handleReset = () => {
     setLimits({ ...originalLimits });
+    setAutoSuspend(originalAutoSuspend);
     setError(null);
     setSuccess(null);
   };
@@ -133,10 +168,11 @@ export default function Settings() {
     }));
   };
 
-  const hasChanges =
+  const limitsChanged =
     limits.max_cpu !== originalLimits.max_cpu ||
     limits.max_ram_mb !== originalLimits.max_ram_mb ||
     limits.max_storage_mb !== originalLimits.max_storage_mb;
+  const hasChanges = limitsChanged || autoSuspend !== originalAutoSuspend;
 
   if (loading) {
     return (
@@ -372,6 +408,62 @@ export default function Settings() {
             </div>
 
           </div>
+
+          {/* Scale to Zero */}
+          <div className="mt-12 mb-6 px-1">
+            <h2 className="text-base font-medium text-neutral-100 mb-1.5">
+              Scale to Zero
+            </h2>
+            <p className="text-sm text-neutral-500 leading-relaxed max-w-2xl">
+              Suspend this database after a period without proxy connections to free its memory. The next connection wakes it up and waits until it is ready, which takes a few seconds.
+            </p>
+          </div>
+
+          <div className="rounded-xl border shadow-sm overflow-hidden flex flex-col">
+            <div className="group flex flex-col md:flex-row md:items-start justify-between gap-6 p-6 md:p-8">
+              <div className="flex items-start gap-4 max-w-md">
+                <div className="p-2.5 rounded-lg bg-white/[0.04] border border-white/[0.06] text-neutral-300 shadow-sm">
+                  <Moon size={20} weight="duotone" />
+                </div>
+                <div className="space-y-1.5 mt-0.5">
+                  <Label className="text-sm font-medium text-neutral-200">
+                    Auto-suspend
+                  </Label>
+                  <p className="text-sm text-neutral-500 leading-relaxed">
+                    Minutes of inactivity before the database is suspended. Set to 0 to keep it running. Databases with replicas or high availability are never suspended.
+                  </p>
+                </div>
+              </div>
+
+              <div className="md:w-64 space-y-3 shrink-0">
+                <div className="relative group/input">
+                  <Input
+                    type="number"
+                    min="0"
+                    max="10080"
+                    step="5"
+                    value={autoSuspend}
+                    onChange={(e) => setAutoSuspend(Math.max(0, parseInt(e.target.value) || 0))}
+                    className="bg-[#121214] border-white/[0.08] text-neutral-200 text-sm h-10 px-3 focus:border-blue-500 focus:ring-1 focus:ring-blue-500/50 [appearance:textfield] [&::-webkit-outer-spin-button]:appearance-none [&::-webkit-inner-spin-button]:appearance-none transition-all"
+                  />
+                  <span className="absolute right-3 top-1/2 -translate-y-1/2 text-xs font-medium text-neutral-500 pointer-events-none">
+                    min
+                  </span>
+                </div>
+                <div className="flex flex-wrap gap-2">
+                  {[0, 15, 60, 1440].map((val) => (
+                    <button
+                      key={val}
+                      onClick={() => setAutoSuspend(val)}
+                      className="text-xs font-medium px-2.5 py-1.5 rounded-md bg-white/[0.03] text-neutral-400 border border-white/[0.06] hover:bg-white/[0.08] hover:text-neutral-200 transition-all active:scale-95"
+                    >
+                      {val === 0 ? "Off" : val >= 60 ? `${val / 60} h` : `${val} min`}
+                    </button>
+                  ))}
+                </div>
+              </div>
+            </div>
+          </div>
         </div>
       </div>
 
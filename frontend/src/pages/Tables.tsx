@@ -55,6 +55,13 @@ interface RelationInfo {
   referencedColumn: string;
 }
 
+// Table data is returned as typed JSON, so json/jsonb cells arrive as objects
+function formatCellValue(value: unknown): string {
+  return typeof value === "object" && value !== null
+    ? JSON.stringify(value)
+    : String(value);
+}
+
 interface RelationSubview {
   relation: RelationInfo;
   sourceValue: string;
@@ -111,11 +118,26 @@ export default function Tables() {
       console.log("Primary key:", primaryKey);
       console.log("Edited cells:", editedCells);
 
-      const updates = Object.entries(editedCells).map(([key, value]) => {
+      const updates = Object.entries(editedCells).map(([key, edited]) => {
         const [rowIndexStr, columnName] = key.split("|||");
         const rowIndex = parseInt(rowIndexStr, 10);
         const row = selectedTable.rows[rowIndex];
         const rowId = primaryKey ? row[primaryKey] : null;
+        // json/jsonb cells are edited as text but sent as JSON values
+        const columnType = selectedTable.columns
+          .find((c) => c.name === columnName)
+          ?.type.toLowerCase();
+        let value = edited;
+        if (
+          typeof edited === "string" &&
+          (columnType === "json" || columnType === "jsonb")
+        ) {
+          try {
+            value = JSON.parse(edited);
+          } catch {
+            throw new Error(`${columnName} is not valid JSON`);
+          }
+        }
         console.log(
           `Update: row=${rowIndex}, column=${columnName}, value=${value}, rowId=${rowId}`,
         );
@@ -897,7 +919,7 @@ export default function Tables() {
                                                   NULL
                                                 </span>
                                               ) : (
-                                                String(value)
+                                                formatCellValue(value)
                                               )}
                                             </button>
                                           </PopoverTrigger>
@@ -913,7 +935,7 @@ export default function Tables() {
                                                   value={
                                                     value === null
                                                       ? ""
-                                                      : String(value)
+                                                      : formatCellValue(value)
                                                   }
                                                   onChange={(e) =>
                                                     handleCellChange(
@@ -946,7 +968,7 @@ export default function Tables() {
                                                   value={
                                                     value === null
                                                       ? ""
-                                                      : String(value)
+                                                      : formatCellValue(value)
                                                   }
                                                   onChange={(e) =>
                                                     handleCellChange(
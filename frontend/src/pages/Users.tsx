@@ -62,12 +62,16 @@ export default function Users() {
                 body: JSON.stringify({ email: newEmail.trim() }),
             }, logout);
 
+            const data = await res.json();
             if (!res.ok) {
-                const data = await res.json();
                 throw new Error(data.error || "Failed to add email");
             }
 
-            setSuccess(`Email ${newEmail} whitelisted successfully`);
+            setSuccess(
+                data.invitationSent
+                    ? `Email ${newEmail} whitelisted and invitation sent`
+                    : `Email ${newEmail} whitelisted successfully`
+            );
             setNewEmail("");
             await fetchWhitelist();
         } catch (err: any) {
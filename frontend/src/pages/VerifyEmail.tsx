@@ -0,0 +1,74 @@
+import { useEffect, useRef, useState } from "react";
+import { Link, useSearchParams } from "react-router-dom";
+
+export default function VerifyEmail() {
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get("token") || "";
+  const [status, setStatus] = useState<"verifying" | "done" | "error">(
+    "verifying"
+  );
+  const [message, setMessage] = useState("");
+  // Tokens work once, so StrictMode's second effect run must not send it again
+  const sent = useRef(false);
+
+  useEffect(() => {
+    if (sent.current) return;
+    sent.current = true;
+    if (!token) {
+      setStatus("error");
+      setMessage("This link is incomplete. Open the link from the email again.");
+      return;
+    }
+
+    fetch("/api/auth/email/verify", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ token }),
+    })
+      .then(async (response) => {
+        const data = await response.json();
+        if (!response.ok) throw new Error(data.error || "Verification failed");
+        setStatus("done");
+        setMessage(data.message);
+      })
+      .catch((err) => {
+        setStatus("error");
+        setMessage(err.message);
+      });
+  }, [token]);
+
+  return (
+    <div className="space-y-8">
+      <div className="text-center">
+        <h1 className="text-3xl font-medium tracking-tight text-foreground">
+          Confirm Email
+        </h1>
+      </div>
+
+      {status === "verifying" && (
+        <p className="text-center text-sm text-muted-foreground">
+          Confirming your email address...
+        </p>
+      )}
+      {status === "done" && (
+        <div className="rounded-md border border-border bg-card p-3 text-sm text-foreground">
+          {message}
+        </div>
+      )}
+      {status === "error" && (
+        <div className="rounded-md bg-destructive/15 p-3 text-sm text-destructive">
+          {message}
+        </div>
+      )}
+
+      <p className="mt-4 text-center text-sm text-muted-foreground">
+        <Link
+          to="/login"
+          className="font-semibold text-primary hover:text-primary/80"
+        >
+          Go to login
+        </Link>
+      </p>
+    </div>
+  );
+}
@@ -164,6 +164,14 @@ if [ ! -f "$ENV_FILE" ]; then
     fi
     sed -i "s|^JWT_SECRET=.*|JWT_SECRET=$RAND_SECRET|" "$ENV_FILE"
 
+    info "Generating master encryption key..."
+    if command -v openssl >/dev/null 2>&1; then
+        MASTER_KEY=$(openssl rand -base64 32)
+    else
+        MASTER_KEY=$(head -c 32 /dev/urandom | base64)
+    fi
+    sed -i "s|^MASTER_KEY=.*|MASTER_KEY=$MASTER_KEY|" "$ENV_FILE"
+
     info "Detecting Public IP..."
     DETECTED_IP=$(curl -s -4 https://ifconfig.me 2>/dev/null || curl -s -4 https://api.ipify.org 2>/dev/null || echo "localhost")
     sed -i "s|^PUBLIC_IP=.*|PUBLIC_IP=$DETECTED_IP|" "$ENV_FILE"